@@ -0,0 +1,224 @@
+// Package activitypub has what the forum needs to federate over ActivityPub: the json documents it serves and
+// delivers, http signatures for authenticating them, and a client for talking to other servers. Which actors the forum
+// has, and what they publish, is up to server/activitypub.go.
+package activitypub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the content type of activitypub documents. ACCEPT_HEADER is sent when fetching them, as some servers only answer to
+// the longer json-ld form
+const CONTENT_TYPE = "application/activity+json"
+const ACCEPT_HEADER = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// the content type of webfinger responses
+const JRD_CONTENT_TYPE = "application/jrd+json"
+
+// addressing an activity to PUBLIC makes it public
+const PUBLIC = "https://www.w3.org/ns/activitystreams#Public"
+
+var CONTEXT = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"}
+
+// activity types
+const (
+	CREATE = "Create"
+	UPDATE = "Update"
+	DELETE = "Delete"
+	FOLLOW = "Follow"
+	ACCEPT = "Accept"
+	UNDO   = "Undo"
+)
+
+// activitypub timestamps are in utc, in the format of xsd:dateTime
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Actor struct {
+	Context           interface{} `json:"@context,omitempty"`
+	ID                string      `json:"id"`
+	Type              string      `json:"type"`
+	PreferredUsername string      `json:"preferredUsername"`
+	Name              string      `json:"name,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	// the actor's page on the web
+	URL       string     `json:"url,omitempty"`
+	Inbox     string     `json:"inbox"`
+	Outbox    string     `json:"outbox,omitempty"`
+	Followers string     `json:"followers,omitempty"`
+	Endpoints *Endpoints `json:"endpoints,omitempty"`
+	PublicKey PublicKey  `json:"publicKey"`
+
+	ManuallyApprovesFollowers bool `json:"manuallyApprovesFollowers"`
+}
+
+// a post. replies are InReplyTo the first post of their thread
+type Note struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	AttributedTo string      `json:"attributedTo"`
+	// html
+	Content   string   `json:"content"`
+	URL       string   `json:"url"`
+	InReplyTo string   `json:"inReplyTo,omitempty"`
+	Published string   `json:"published"`
+	Updated   string   `json:"updated,omitempty"`
+	To        []string `json:"to"`
+	CC        []string `json:"cc"`
+}
+
+// what is left of a deleted note
+type Tombstone struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type Activity struct {
+	Context interface{} `json:"@context,omitempty"`
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	// the object is usually a document of its own, but may be just its id
+	Object    interface{} `json:"object"`
+	Published string      `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	CC        []string    `json:"cc,omitempty"`
+}
+
+// an activity received in an inbox, whose object is yet to be decoded
+type IncomingActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// returns the id of the activity's object, whether the object is included or only referred to
+func (a IncomingActivity) ObjectID() string {
+	var id string
+	if err := json.Unmarshal(a.Object, &id); err == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(a.Object, &object)
+	return object.ID
+}
+
+// decodes the activity's object as an activity, e.g. the Follow that an Undo undoes. fails if the object is only
+// referred to by its id
+func (a IncomingActivity) ObjectActivity() (IncomingActivity, error) {
+	var object IncomingActivity
+	err := json.Unmarshal(a.Object, &object)
+	if err == nil && object.Type == "" {
+		err = errors.New("object is not an activity")
+	}
+	return object, eout.Eout(err, "decode object of %s", a.Type)
+}
+
+type OrderedCollection struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	OrderedItems []interface{} `json:"orderedItems,omitempty"`
+}
+
+func NewOrderedCollection(id string, totalItems int, items []interface{}) OrderedCollection {
+	return OrderedCollection{Context: CONTEXT[0], ID: id, Type: "OrderedCollection", TotalItems: totalItems, OrderedItems: items}
+}
+
+// a webfinger response, leading from an account like forum@example.org to the actor's id
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// generates a key pair for signing an actor's activities, returning both keys pem encoded
+func GenerateKey() (string, string, error) {
+	ed := eout.Describe("generate key")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", ed.Eout(err, "generate rsa key")
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", ed.Eout(err, "marshal private key")
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", ed.Eout(err, "marshal public key")
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+	return string(privatePEM), string(publicPEM), nil
+}
+
+func ParsePrivateKey(privatePEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("parse private key: no pem block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, eout.Eout(err, "parse private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parse private key: %T is not an rsa key", key)
+	}
+	return rsaKey, nil
+}
+
+// parses a public key as found in an actor's publicKeyPem
+func ParsePublicKey(publicPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, errors.New("parse public key: no pem block")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, eout.Eout(err, "parse public key")
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("parse public key: %T is not an rsa key", key)
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,171 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// documents and responses from other servers are read up to this size
+const MAX_DOCUMENT_SIZE = 1 << 20
+
+const REQUEST_TIMEOUT = 10 * time.Second
+
+var ErrLocalAddress = errors.New("refusing to connect to a local address")
+
+// a delivery that failed in a way that retrying won't fix, e.g. because the inbox is gone
+type PermanentError struct {
+	StatusCode int
+}
+
+func (e PermanentError) Error() string {
+	return fmt.Sprintf("inbox answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// talks to other servers on behalf of the forum. the urls it is given come from other servers, so unless allowLocal is
+// set, it only uses https and refuses to connect to loopback and private addresses
+type Client struct {
+	http       *http.Client
+	allowLocal bool
+	userAgent  string
+}
+
+func NewClient(userAgent string, allowLocal bool) *Client {
+	dialer := &net.Dialer{
+		Timeout: REQUEST_TIMEOUT,
+		// checked on connecting rather than on parsing the url, so that it also covers names resolving to local addresses
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowLocal {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return ErrLocalAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &Client{
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   REQUEST_TIMEOUT,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+		allowLocal: allowLocal,
+		userAgent:  userAgent,
+	}
+}
+
+// checks that the url is one the client may use
+func (c *Client) checkURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, eout.Eout(err, "parse url %q", rawURL)
+	}
+	if u.Scheme != "https" && !(c.allowLocal && u.Scheme == "http") {
+		return nil, fmt.Errorf("%q is not an https url", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", rawURL)
+	}
+	return u, nil
+}
+
+// fetches the actor with the given id. the request is signed with the forum's key, as servers in "authorized fetch"
+// mode only answer signed requests
+func (c *Client) FetchActor(id, keyID string, key *rsa.PrivateKey) (Actor, error) {
+	ed := eout.Describe("fetch actor")
+	u, err := c.checkURL(id)
+	if err != nil {
+		return Actor{}, ed.Eout(err, "check id")
+	}
+	req, err := http.NewRequestWithContext(context.Background(), "GET", u.String(), nil)
+	if err != nil {
+		return Actor{}, ed.Eout(err, "create request")
+	}
+	req.Header.Set("Accept", ACCEPT_HEADER)
+	req.Header.Set("User-Agent", c.userAgent)
+	if err := Sign(req, nil, keyID, key); err != nil {
+		return Actor{}, ed.Eout(err, "sign request")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Actor{}, ed.Eout(err, "get %s", id)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Actor{}, fmt.Errorf("fetch actor: %s answered %s", id, res.Status)
+	}
+	var actor Actor
+	if err := json.NewDecoder(io.LimitReader(res.Body, MAX_DOCUMENT_SIZE)).Decode(&actor); err != nil {
+		return Actor{}, ed.Eout(err, "decode %s", id)
+	}
+	// the document has to be the actor it was fetched as, and the actor's key its own
+	if actor.ID != id {
+		return Actor{}, fmt.Errorf("fetch actor: %s is the actor %q", id, actor.ID)
+	}
+	if actor.PublicKey.Owner != "" && actor.PublicKey.Owner != actor.ID {
+		return Actor{}, fmt.Errorf("fetch actor: the key of %s is owned by %q", id, actor.PublicKey.Owner)
+	}
+	if _, err := c.checkURL(actor.Inbox); err != nil {
+		return Actor{}, ed.Eout(err, "check inbox of %s", id)
+	}
+	return actor, nil
+}
+
+// posts the activity to the inbox, signed with the key known as keyID. errors that retrying won't fix are a
+// PermanentError
+func (c *Client) Deliver(inbox string, activity []byte, keyID string, key *rsa.PrivateKey) error {
+	ed := eout.Describe("deliver activity")
+	u, err := c.checkURL(inbox)
+	if err != nil {
+		return ed.Eout(err, "check inbox")
+	}
+	req, err := http.NewRequestWithContext(context.Background(), "POST", u.String(), bytes.NewReader(activity))
+	if err != nil {
+		return ed.Eout(err, "create request")
+	}
+	req.Header.Set("Content-Type", CONTENT_TYPE)
+	req.Header.Set("User-Agent", c.userAgent)
+	if err := Sign(req, activity, keyID, key); err != nil {
+		return ed.Eout(err, "sign request")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return ed.Eout(err, "post to %s", inbox)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, MAX_DOCUMENT_SIZE))
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	// the inbox may be overwhelmed or slow; anything else in the 4xx range won't get better by trying again
+	case res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusRequestTimeout:
+		return PermanentError{StatusCode: res.StatusCode}
+	default:
+		return fmt.Errorf("deliver activity: %s answered %s", inbox, res.Status)
+	}
+}
@@ -0,0 +1,152 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// activities are authenticated with http signatures, in the form mastodon and most of the fediverse use
+// (draft-cavage-http-signatures-12): a Signature header signs the request's target, its host and date, and a digest of
+// its body, with the sending actor's key
+
+// the headers that are signed, in order
+var signedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// how old a signed request may be, and how far its date may be in the future, to be accepted
+const SIGNATURE_MAX_AGE = 12 * time.Hour
+const SIGNATURE_MAX_SKEW = time.Hour
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// builds the string that is signed, from the request and the names of the signed headers. host is passed separately:
+// behind a reverse proxy, the request's Host may not be the one the sender signed
+func signingString(req *http.Request, host string, headers []string) (string, error) {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			value = host
+		default:
+			values := req.Header.Values(name)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header %s is missing", name)
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines[i] = name + ": " + value
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// signs the request, which is about to be sent with body, with the key known to others as keyID. for requests without a
+// body, such as fetching a document, body is nil
+func Sign(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", digest(body))
+	signed, err := signingString(req, req.URL.Host, signedHeaders)
+	if err != nil {
+		return eout.Eout(err, "sign request")
+	}
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return eout.Eout(err, "sign request")
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+type signature struct {
+	keyID     string
+	headers   []string
+	signature []byte
+}
+
+func parseSignature(req *http.Request) (signature, error) {
+	header := req.Header.Get("Signature")
+	if header == "" {
+		return signature{}, errors.New("request is not signed")
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		params[key] = strings.Trim(value, `"`)
+	}
+	var sig signature
+	sig.keyID = params["keyId"]
+	if sig.keyID == "" {
+		return sig, errors.New("signature has no keyId")
+	}
+	// mastodon signs with rsa-sha256, and newer servers call the same thing hs2019
+	if algorithm := params["algorithm"]; algorithm != "" && algorithm != "rsa-sha256" && algorithm != "hs2019" {
+		return sig, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	sig.headers = strings.Fields(strings.ToLower(params["headers"]))
+	if len(sig.headers) == 0 {
+		sig.headers = []string{"date"}
+	}
+	var err error
+	sig.signature, err = base64.StdEncoding.DecodeString(params["signature"])
+	return sig, eout.Eout(err, "decode signature")
+}
+
+// returns the id of the key the request claims to be signed with, for looking up the public key to pass to Verify
+func SignatureKeyID(req *http.Request) (string, error) {
+	sig, err := parseSignature(req)
+	return sig.keyID, err
+}
+
+// checks that the request, received with body, was signed by the owner of the public key. the signature has to cover the
+// request's target, host, date and body, and the date has to be recent. host is the host the request was sent to, i.e.
+// the forum's
+func Verify(req *http.Request, body []byte, host string, key *rsa.PublicKey) error {
+	ed := eout.Describe("verify signature")
+	sig, err := parseSignature(req)
+	if err != nil {
+		return ed.Eout(err, "parse signature")
+	}
+	covered := make(map[string]bool)
+	for _, name := range sig.headers {
+		covered[name] = true
+	}
+	for _, name := range signedHeaders {
+		if !covered[name] && (name != "digest" || req.Method == "POST") {
+			return fmt.Errorf("verify signature: %s is not signed", name)
+		}
+	}
+	if req.Method == "POST" && req.Header.Get("Digest") != digest(body) {
+		return errors.New("verify signature: digest does not match the body")
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return ed.Eout(err, "parse date")
+	}
+	if age := time.Since(date); age > SIGNATURE_MAX_AGE || age < -SIGNATURE_MAX_SKEW {
+		return fmt.Errorf("verify signature: signed at %s, which is too far from now", date)
+	}
+	signed, err := signingString(req, host, sig.headers)
+	if err != nil {
+		return ed.Eout(err, "build signing string")
+	}
+	hash := sha256.Sum256([]byte(signed))
+	return ed.Eout(rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig.signature), "check signature")
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+// the archive format written by `cerca export` and read by `cerca import`, see ARCHIVE.md. bump ARCHIVE_VERSION when
+// the format changes in a way older versions of cerca can't read
+const ARCHIVE_FORMAT = "cerca-archive"
+const ARCHIVE_VERSION = 1
+
+// the directories of the data dir that are stored in archives, next to the database's records
+var archiveDirs = []string{"docs", "assets"}
+
+type archiveManifest struct {
+	Format         string         `json:"format"`
+	Version        int            `json:"version"`
+	Created        time.Time      `json:"created"`
+	SchemaVersion  int            `json:"schema_version"`
+	PasswordHashes bool           `json:"password_hashes"`
+	Counts         map[string]int `json:"counts"`
+}
+
+// a file of the data dir, as stored in an archive
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+func jsonLines[T any](records []T) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func readJSONLines[T any](data []byte) ([]T, error) {
+	var records []T
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for line := 1; ; line++ {
+		var record T
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// reads the records of the archive's file <name>.jsonl
+func readRecords[T any](contents map[string][]byte, name string, records *[]T) error {
+	var err error
+	if *records, err = readJSONLines[T](contents[name+".jsonl"]); err != nil {
+		return fmt.Errorf("read %s.jsonl: %w", name, err)
+	}
+	return nil
+}
+
+func writeArchive(w io.Writer, manifest archiveManifest, a database.Archive, files []archiveFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0640, Size: int64(len(data)), ModTime: manifest.Created}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = add("manifest.json", manifestData); err != nil {
+		return err
+	}
+	lines := []struct {
+		name    string
+		records func() ([]byte, error)
+	}{
+		{"users", func() ([]byte, error) { return jsonLines(a.Users) }},
+		{"admins", func() ([]byte, error) { return jsonLines(a.Admins) }},
+		{"topics", func() ([]byte, error) { return jsonLines(a.Topics) }},
+		{"threads", func() ([]byte, error) { return jsonLines(a.Threads) }},
+		{"posts", func() ([]byte, error) { return jsonLines(a.Posts) }},
+		{"mentions", func() ([]byte, error) { return jsonLines(a.Mentions) }},
+		{"invites", func() ([]byte, error) { return jsonLines(a.Invites) }},
+		{"registrations", func() ([]byte, error) { return jsonLines(a.Registrations) }},
+		{"placeholders", func() ([]byte, error) { return jsonLines(a.Placeholders) }},
+		{"moderation_log", func() ([]byte, error) { return jsonLines(a.ModerationLog) }},
+		{"moderation_proposals", func() ([]byte, error) { return jsonLines(a.Proposals) }},
+	}
+	for _, l := range lines {
+		data, err := l.records()
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", l.name, err)
+		}
+		if err = add(l.name+".jsonl", data); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err = add(f.name, f.data); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// reads an archive, checking that it is one this version of cerca can read and that none of its records went missing
+func readArchive(r io.Reader) (archiveManifest, database.Archive, []archiveFile, error) {
+	var manifest archiveManifest
+	var a database.Archive
+	var files []archiveFile
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, a, nil, err
+	}
+	tr := tar.NewReader(gz)
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return manifest, a, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return manifest, a, nil, fmt.Errorf("read %s: %w", header.Name, err)
+		}
+		dir, _, _ := strings.Cut(header.Name, "/")
+		if dir != header.Name {
+			// only files within the archived directories are let through, so that importing can't write elsewhere
+			name := path.Clean(header.Name)
+			if !fs.ValidPath(name) || !isArchiveDir(dir) {
+				return manifest, a, nil, fmt.Errorf("unexpected file %s", header.Name)
+			}
+			files = append(files, archiveFile{name: name, data: data})
+			continue
+		}
+		contents[header.Name] = data
+	}
+
+	if err := json.Unmarshal(contents["manifest.json"], &manifest); err != nil {
+		return manifest, a, nil, fmt.Errorf("read manifest.json: %w", err)
+	}
+	if manifest.Format != ARCHIVE_FORMAT {
+		return manifest, a, nil, fmt.Errorf("not a cerca archive")
+	}
+	if manifest.Version > ARCHIVE_VERSION {
+		return manifest, a, nil, fmt.Errorf("the archive has version %d, but this version of cerca only reads archives up to version %d", manifest.Version, ARCHIVE_VERSION)
+	}
+	err = errors.Join(
+		readRecords(contents, "users", &a.Users),
+		readRecords(contents, "admins", &a.Admins),
+		readRecords(contents, "topics", &a.Topics),
+		readRecords(contents, "threads", &a.Threads),
+		readRecords(contents, "posts", &a.Posts),
+		readRecords(contents, "mentions", &a.Mentions),
+		readRecords(contents, "invites", &a.Invites),
+		readRecords(contents, "registrations", &a.Registrations),
+		readRecords(contents, "placeholders", &a.Placeholders),
+		readRecords(contents, "moderation_log", &a.ModerationLog),
+		readRecords(contents, "moderation_proposals", &a.Proposals),
+	)
+	if err != nil {
+		return manifest, a, nil, err
+	}
+	for name, count := range a.Counts() {
+		if manifest.Counts[name] != count {
+			return manifest, a, nil, fmt.Errorf("%s.jsonl has %d records, but the manifest lists %d", name, count, manifest.Counts[name])
+		}
+	}
+	return manifest, a, files, nil
+}
+
+func isArchiveDir(dir string) bool {
+	for _, d := range archiveDirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// prints the number of records of each kind, by name
+func printCounts(counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("\t%-22s%d\n", name, counts[name])
+	}
+}
+
+func export() {
+	var dataDir, out string
+	var noPasswordHashes bool
+
+	exportFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	exportFlags.StringVar(&dataDir, "data-dir", "", "the forum's data directory, containing forum.db, docs/ and assets/")
+	exportFlags.StringVar(&out, "out", "", "file to write the archive to; e.g. ./forum-archive.tar.gz")
+	exportFlags.BoolVar(&noPasswordHashes, "no-password-hashes", false, "leave out password hashes; users have to reset their passwords after an import")
+
+	help := createHelpString("export", []string{
+		`cerca export -data-dir /var/lib/cerca -out forum-archive.tar.gz`,
+		`cerca export -data-dir /var/lib/cerca -out forum-archive.tar.gz -no-password-hashes`,
+	})
+	exportFlags.Usage = func() { usage(help, exportFlags) }
+	exportFlags.Parse(os.Args[2:])
+
+	// if run without flags, print the help info
+	if exportFlags.NFlag() == 0 {
+		exportFlags.Usage()
+		return
+	}
+	if dataDir == "" || out == "" {
+		complain(help)
+	}
+	dbPath := filepath.Join(dataDir, "forum.db")
+	if !database.CheckExists(dbPath) {
+		complain("couldn't find database at %s", dbPath)
+	}
+
+	db := database.InitDB(dbPath)
+	a, err := db.ExportArchive(!noPasswordHashes)
+	if err != nil {
+		complain("couldn't read the forum: %v", err)
+	}
+
+	var files []archiveFile
+	for _, dir := range archiveDirs {
+		root := os.DirFS(dataDir)
+		err := fs.WalkDir(root, dir, func(name string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && name == dir {
+				return fs.SkipDir
+			} else if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			data, err := fs.ReadFile(root, name)
+			files = append(files, archiveFile{name: name, data: data})
+			return err
+		})
+		if err != nil {
+			complain("couldn't read %s: %v", filepath.Join(dataDir, dir), err)
+		}
+	}
+
+	manifest := archiveManifest{
+		Format:         ARCHIVE_FORMAT,
+		Version:        ARCHIVE_VERSION,
+		Created:        time.Now().UTC().Truncate(time.Second),
+		SchemaVersion:  database.SchemaVersion,
+		PasswordHashes: !noPasswordHashes,
+		Counts:         a.Counts(),
+	}
+	// write to a temporary file first, so that a failed export doesn't leave half an archive behind
+	tmp := out + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		complain("couldn't create %s: %v", tmp, err)
+	}
+	err = writeArchive(file, manifest, a, files)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		complain("couldn't write archive: %v", err)
+	}
+	inform("Exported the forum at %s to %s:", dataDir, out)
+	printCounts(manifest.Counts)
+	fmt.Printf("\t%-22s%d\n", "files", len(files))
+	if noPasswordHashes {
+		inform("The archive has no password hashes: users have to reset their passwords after it is imported")
+	}
+}
+
+func importArchive() {
+	var dataDir, in string
+	var dryRun bool
+
+	importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+	importFlags.StringVar(&dataDir, "data-dir", "", "the data directory to import into; it may hold a forum already, or be empty")
+	importFlags.StringVar(&in, "in", "", "the archive to import, as written by `cerca export`")
+	importFlags.BoolVar(&dryRun, "dry-run", false, "check the archive and report what importing it would do, without changing anything")
+
+	help := createHelpString("import", []string{
+		`cerca import -data-dir /var/lib/cerca -in forum-archive.tar.gz -dry-run`,
+		`cerca import -data-dir /var/lib/cerca -in forum-archive.tar.gz`,
+	})
+	importFlags.Usage = func() { usage(help, importFlags) }
+	importFlags.Parse(os.Args[2:])
+
+	// if run without flags, print the help info
+	if importFlags.NFlag() == 0 {
+		importFlags.Usage()
+		return
+	}
+	if dataDir == "" || in == "" {
+		complain(help)
+	}
+
+	file, err := os.Open(in)
+	if err != nil {
+		complain("couldn't open %s: %v", in, err)
+	}
+	manifest, a, files, err := readArchive(file)
+	file.Close()
+	if err != nil {
+		complain("couldn't read archive %s: %v", in, err)
+	}
+	inform("Archive %s, exported %s (schema version %d):", in, manifest.Created.Format(time.RFC3339), manifest.SchemaVersion)
+	printCounts(manifest.Counts)
+	fmt.Printf("\t%-22s%d\n", "files", len(files))
+
+	if problems := a.Validate(); len(problems) > 0 {
+		const shown = 20
+		inform("The archive is inconsistent, and was not imported. %d problems:", len(problems))
+		for i, problem := range problems {
+			if i == shown {
+				fmt.Printf("\t... and %d more\n", len(problems)-shown)
+				break
+			}
+			fmt.Printf("\t%v\n", problem)
+		}
+		os.Exit(1)
+	}
+
+	dbPath := filepath.Join(dataDir, "forum.db")
+	if dryRun && database.CheckExists(dbPath) {
+		// opening the database would migrate it
+		version, err := database.GetSchemaVersion(dbPath)
+		if err != nil {
+			complain("couldn't read schema version: %v", err)
+		}
+		if version < database.SchemaVersion {
+			complain("%s has to be migrated before a dry run, see `cerca migrate`", dbPath)
+		}
+	} else if dryRun {
+		// a dry run mustn't leave a new database behind, so it imports into a throwaway one
+		tmp, err := os.MkdirTemp("", "cerca-import")
+		if err != nil {
+			complain("couldn't create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		dbPath = filepath.Join(tmp, "forum.db")
+	} else if err := os.MkdirAll(dataDir, 0750); err != nil {
+		complain("couldn't create %s: %v", dataDir, err)
+	}
+	db := database.InitDB(dbPath)
+	report, err := db.ImportArchive(a, dryRun)
+	if err != nil {
+		inform("Couldn't import the archive, and nothing was changed: %v", err)
+		os.Exit(1)
+	}
+
+	// files that already exist in the data dir, such as the forum's own about page, are left as they are
+	var written, skipped []string
+	for _, f := range files {
+		target := filepath.Join(dataDir, filepath.FromSlash(f.name))
+		if database.CheckExists(target) {
+			skipped = append(skipped, f.name)
+			continue
+		}
+		if !dryRun {
+			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+				complain("couldn't create %s: %v", filepath.Dir(target), err)
+			}
+			if err := os.WriteFile(target, f.data, 0640); err != nil {
+				complain("couldn't write %s: %v", target, err)
+			}
+		}
+		written = append(written, f.name)
+	}
+
+	if dryRun {
+		inform("Dry run: importing into %s would add", dataDir)
+	} else {
+		inform("Imported into %s, adding", dataDir)
+	}
+	printCounts(report.Added)
+	fmt.Printf("\t%-22s%d\n", "files", len(written))
+	if len(report.MergedUsers) > 0 {
+		inform("Merged with the existing users: %s", strings.Join(report.MergedUsers, ", "))
+	}
+	if len(report.MergedTopics) > 0 {
+		inform("Merged with the existing topics: %s", strings.Join(report.MergedTopics, ", "))
+	}
+	if report.Renumbered > 0 {
+		inform("%d records were given new ids, as theirs were taken; links to them from elsewhere will not work", report.Renumbered)
+	}
+	if report.RandomPasswords > 0 {
+		inform("%d users have no password, and have to reset it before they can log in", report.RandomPasswords)
+	}
+	if len(skipped) > 0 {
+		inform("Left these files as they were, as they already exist: %s", strings.Join(skipped, ", "))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/importer"
+)
+
+func importFrom() {
+	var source, in, dbPath, topic string
+	var private, dryRun bool
+
+	sources := strings.Join(importer.SourceNames(), ", ")
+	importFlags := flag.NewFlagSet("import-from", flag.ExitOnError)
+	importFlags.StringVar(&source, "source", "", fmt.Sprintf("the kind of export to read: one of %s", sources))
+	importFlags.StringVar(&in, "in", "", "the export to read, see IMPORTING.md for what each source expects")
+	importFlags.StringVar(&dbPath, "database", "", "full path to the forum database; e.g. ./data/forum.db")
+	importFlags.StringVar(&topic, "topic", "", "put all imported threads in this topic, which is created if it doesn't exist")
+	importFlags.BoolVar(&private, "private", false, "make all imported threads private, visible only to logged in users")
+	importFlags.BoolVar(&dryRun, "dry-run", false, "read the export and report what importing it would do, without changing anything")
+
+	help := createHelpString("import-from", []string{
+		`cerca import-from -source discourse -in discourse-export.json -database "<path/to/forum.db>" -dry-run`,
+		`cerca import-from -source phpbb -in phpbb-dump.sql -database "<path/to/forum.db>"`,
+		`cerca import-from -source mbox -in mailing-list.mbox -database "<path/to/forum.db>" -topic "Mailing list"`,
+	})
+	importFlags.Usage = func() { usage(help, importFlags) }
+	importFlags.Parse(os.Args[2:])
+
+	// if run without flags, print the help info
+	if importFlags.NFlag() == 0 {
+		importFlags.Usage()
+		return
+	}
+	if source == "" || in == "" || dbPath == "" {
+		complain(help)
+	}
+	reader, ok := importer.Sources[source]
+	if !ok {
+		complain("unknown source %q; the sources are %s", source, sources)
+	}
+
+	// the threads are imported into an existing forum, so that they get its topics and admins
+	if !database.CheckExists(dbPath) {
+		complain("couldn't find database at %s", dbPath)
+	}
+	if dryRun {
+		// opening the database would migrate it
+		version, err := database.GetSchemaVersion(dbPath)
+		if err != nil {
+			complain("couldn't read schema version: %v", err)
+		}
+		if version < database.SchemaVersion {
+			complain("%s has to be migrated before a dry run, see `cerca migrate`", dbPath)
+		}
+	}
+
+	file, err := os.Open(in)
+	if err != nil {
+		complain("couldn't open %s: %v", in, err)
+	}
+	warnings := 0
+	threads, err := reader.Read(file, func(format string, args ...interface{}) {
+		warnings++
+		inform("warning: "+format, args...)
+	})
+	file.Close()
+	if err != nil {
+		complain("couldn't read %s: %v", in, err)
+	}
+	if len(threads) == 0 {
+		complain("found no threads to import in %s", in)
+	}
+	for i := range threads {
+		if topic != "" {
+			threads[i].Topic = topic
+		}
+		if private {
+			threads[i].Private = true
+		}
+	}
+
+	db := database.InitDB(dbPath)
+	report, err := db.ImportThreads(source, threads, dryRun)
+	if err != nil {
+		inform("Couldn't import %s, and nothing was changed: %v", in, err)
+		os.Exit(1)
+	}
+
+	if dryRun {
+		inform("Dry run: importing %s would add", in)
+	} else {
+		inform("Imported %s, adding", in)
+	}
+	fmt.Printf("\t%-22s%d\n", "threads", report.Threads)
+	fmt.Printf("\t%-22s%d\n", "posts", report.Posts)
+	fmt.Printf("\t%-22s%d\n", "topics", len(report.Topics))
+	fmt.Printf("\t%-22s%d\n", "placeholder accounts", len(report.Placeholders))
+	if len(report.Topics) > 0 {
+		inform("New topics: %s", strings.Join(report.Topics, ", "))
+	}
+	if len(report.Placeholders) > 0 {
+		inform("New placeholder accounts: %s", strings.Join(report.Placeholders, ", "))
+	}
+	if report.Reused > 0 {
+		inform("%d authors already had an account from an earlier import, which their posts were added to", report.Reused)
+	}
+	if warnings > 0 {
+		inform("Parts of the export couldn't be read, see the %d warnings above", warnings)
+	}
+	if !dryRun && len(report.Placeholders) > 0 {
+		inform("Admins can create links for the authors to claim their accounts at /admin/placeholders")
+	}
+}
@@ -19,6 +19,9 @@ var commandExplanations = map[string]string{
 	"genauthkey":     "generate and output an authkey for use with `cerca run`",
 	"version":        "output version information",
 	"write-defaults": "output and save a default cerca config file and associated content files",
+	"export":         "export the forum to an archive, for backups or moving to another server",
+	"import":         "import a forum archive, made with `cerca export`",
+	"import-from":    "import threads from discourse, phpbb or a mailing list archive, see IMPORTING.md",
 }
 
 func createHelpString(commandName string, usageExamples []string) string {
@@ -28,7 +31,7 @@ func createHelpString(commandName string, usageExamples []string) string {
 
 	if commandName == "run" {
 		helpString += "\nCOMMANDS:\n"
-		cmds := []string{"adduser", "makeadmin", "migrate", "resetpw", "genauthkey", "version", "write-defaults"}
+		cmds := []string{"adduser", "makeadmin", "migrate", "resetpw", "genauthkey", "version", "write-defaults", "export", "import", "import-from"}
 		for _, key := range cmds {
 			// pad first string with spaces to the right instead, set its expected width = 11
 			helpString += fmt.Sprintf("  %-15s%s\n", key, commandExplanations[key])
@@ -58,7 +61,7 @@ func inform(msg string, args ...interface{}) {
 
 func complain(msg string, args ...interface{}) {
 	if len(args) > 0 {
-		inform(msg, args)
+		inform(msg, args...)
 	} else {
 		inform(msg)
 	}
@@ -129,6 +132,12 @@ func main() {
 		version()
 	case "write-defaults":
 		writeDefaults()
+	case "export":
+		export()
+	case "import":
+		importArchive()
+	case "import-from":
+		importFrom()
 	default:
 		fmt.Printf("ERR: no such subcommand %q\n", command)
 		run()
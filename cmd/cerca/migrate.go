@@ -8,20 +8,18 @@ import (
 )
 
 func migrate() {
-	migrations := map[string]func(string) error{
-		"2024-01-password-hash-migration":  database.Migration20240116_PwhashChange,
-		"2024-02-thread-private-migration": database.Migration20240720_ThreadPrivateChange,
-	}
-
 	var dbPath, migration string
-	var listMigrations bool
+	var listMigrations, status bool
 
 	migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
 	migrateFlags.BoolVar(&listMigrations, "list", false, "list possible migrations")
-	migrateFlags.StringVar(&migration, "migration", "", "name of the migration you want to perform on the database")
+	migrateFlags.BoolVar(&status, "status", false, "report the database's schema version and which migrations are pending")
+	migrateFlags.StringVar(&migration, "migration", "", "name of a single migration you want to perform on the database again (pending migrations are applied automatically)")
 	migrateFlags.StringVar(&dbPath, "database", "", "full path to the forum database; e.g. ./data/forum.db")
 
 	help := createHelpString("migrate", []string{
+		`cerca migrate -database "<path/to/forum.db>"`,
+		`cerca migrate -status -database "<path/to/forum.db>"`,
 		`cerca migrate -migration 2024-02-thread-private-migration -database "<path/to/forum.db>"`,
 		"cerca migrate -list",
 	})
@@ -36,25 +34,64 @@ func migrate() {
 
 	if listMigrations {
 		inform("Possible migrations:")
-		for key := range migrations {
-			fmt.Println("\t", key)
+		for _, m := range database.Migrations() {
+			fmt.Printf("\t%d %s: %s\n", m.Version, m.Name, m.Description)
 		}
 		os.Exit(0)
 	}
 
-	if migration == "" || dbPath == "" {
+	if dbPath == "" {
 		complain(help)
-	} else if _, ok := migrations[migration]; !ok {
-		complain(fmt.Sprintf("chosen migration »%s» does not match one of the available migrations. see migrations with flag --list", migration))
 	}
-
 	// check if database exists! we dont wanna create a new db in this case ':)
 	if !database.CheckExists(dbPath) {
 		complain("couldn't find database at %s", dbPath)
 	}
 
+	if status {
+		version, err := database.GetSchemaVersion(dbPath)
+		if err != nil {
+			complain("couldn't read schema version: %v", err)
+		}
+		inform("Schema version of %s: %d (latest: %d)", dbPath, version, database.SchemaVersion)
+		if version > database.SchemaVersion {
+			inform("The database is newer than this version of cerca; upgrade cerca before using it")
+		}
+		for _, m := range database.Migrations() {
+			state := "pending"
+			if m.Version <= version {
+				state = "applied"
+			}
+			fmt.Printf("\t%-8s %d %s\n", state, m.Version, m.Name)
+		}
+		os.Exit(0)
+	}
+
+	if migration == "" {
+		// opening the database applies all pending migrations
+		version, err := database.GetSchemaVersion(dbPath)
+		if err != nil {
+			complain("couldn't read schema version: %v", err)
+		}
+		database.InitDB(dbPath)
+		if version >= database.SchemaVersion {
+			inform("Database is up to date (schema version %d)", database.SchemaVersion)
+		} else {
+			inform("Migrated database from schema version %d to %d", version, database.SchemaVersion)
+		}
+		return
+	}
+
+	found := false
+	for _, m := range database.Migrations() {
+		found = found || m.Name == migration
+	}
+	if !found {
+		complain(fmt.Sprintf("chosen migration »%s» does not match one of the available migrations. see migrations with flag --list", migration))
+	}
+
 	// perform migration
-	err := migrations[migration](dbPath)
+	err := database.RunMigration(dbPath, migration)
 	if err == nil {
 		inform(fmt.Sprintf("Migration »%s» completed", migration))
 	} else {
@@ -10,13 +10,16 @@ import (
 func reset() {
 	var username string
 	var dbPath string
+	var clearTwoFactor bool
 
 	resetFlags := flag.NewFlagSet("resetpw", flag.ExitOnError)
 	resetFlags.StringVar(&username, "username", "", "username whose credentials should be reset")
 	resetFlags.StringVar(&dbPath, "database", "", "full path to the forum database; e.g. ./data/forum.db")
+	resetFlags.BoolVar(&clearTwoFactor, "clear-2fa", false, "also turn off the user's two-factor authentication, for users who lost their authenticator app and recovery codes")
 
 	help := createHelpString("resetpw", []string{
 		`cerca resetpw -username "<existing username>" -database "<path/to/forum.db>"`,
+		`cerca resetpw -username "<existing username>" -database "<path/to/forum.db>" -clear-2fa`,
 	})
 	resetFlags.Usage = func() { usage(help, resetFlags) }
 	resetFlags.Parse(os.Args[2:])
@@ -40,19 +43,30 @@ func reset() {
 
 	userid, err := db.GetUserID(username)
 	if err != nil {
-		complain("reset password failed (%w)", err)
+		complain("reset password failed (%v)", err)
 	}
 	newPassword, err := db.ResetPassword(userid)
 
 	if err != nil {
-		complain("reset password failed (%w)", err)
+		complain("reset password failed (%v)", err)
 	}
 
 	// log cmd actions just as admin web-actions are logged
 	systemUserid := db.GetSystemUserID()
 	err = db.AddModerationLog(systemUserid, userid, constants.MODLOG_RESETPW)
 	if err != nil {
-		complain("adding mod log for password reset failed (%w)", err)
+		complain("adding mod log for password reset failed (%v)", err)
+	}
+
+	if clearTwoFactor {
+		if err = db.DisableTwoFactor(userid); err != nil {
+			complain("clearing two-factor authentication failed (%v)", err)
+		}
+		err = db.AddModerationLog(systemUserid, userid, constants.MODLOG_CLEAR_TWO_FACTOR)
+		if err != nil {
+			complain("adding mod log for clearing two-factor authentication failed (%v)", err)
+		}
+		inform("Turned off %s's two-factor authentication", username)
 	}
 
 	inform("Successfully updated %s's password hash", username)
@@ -15,10 +15,33 @@ const (
 	MODLOG_ADMIN_PROPOSE_REMOVE_USER
 	MODLOG_CREATE_INVITE_BATCH
 	MODLOG_DELETE_INVITE_BATCH
+	MODLOG_DELETE_THREAD     // delete a thread and all of its posts
+	MODLOG_MOVE_THREAD       // move a thread to another topic
+	MODLOG_HIDE_POST         // hide another user's post
+	MODLOG_UNHIDE_POST       // make a hidden post visible again
+	MODLOG_DELETE_POST       // delete another user's post
+	MODLOG_EDIT_POST         // edit another user's post
+	MODLOG_CLEAR_TWO_FACTOR  // turn off a locked out user's two-factor authentication
+	MODLOG_CREATE_CLAIM_LINK // create a link for claiming a placeholder account of imported posts
 	/* NOTE: when adding new values, only add them after already existing values! otherwise the existing variables will
 	* receive new values which affects the stored values in table moderation_log */
 )
 
+// the kinds of notifications, see database/notifications.go
+const (
+	NOTIFICATION_REPLY   = iota // a reply in a subscribed thread
+	NOTIFICATION_MENTION        // a post mentioning the user
+	/* NOTE: like the MODLOG values, only add new values after already existing values; they are stored in table
+	* notifications */
+)
+
+// what a token sent by email can be used for, see database/email.go
+const (
+	EMAIL_TOKEN_VERIFY         = iota // confirm an email address
+	EMAIL_TOKEN_PASSWORD_RESET        // choose a new password
+	/* NOTE: only add new values after already existing values; they are stored in table email_tokens */
+)
+
 const PROPOSAL_VETO = false
 const PROPOSAL_CONFIRM = true
 const PROPOSAL_SELF_CONFIRMATION_WAIT = time.Hour * 24 * 7 /* 1 week */
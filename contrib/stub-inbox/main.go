@@ -0,0 +1,152 @@
+// stub-inbox is a fediverse account of its own, for testing a forum's federation without a fediverse server. it serves
+// an actor, follows one of the forum's actors, and prints every activity delivered to its inbox, after checking the
+// activity's signature. the forum needs allow_local = true in its [activitypub] section to deliver to it.
+//
+//	go run ./contrib/stub-inbox -follow http://localhost:8277/ap/forum
+//
+// stopping it with ctrl-c undoes the follow
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"gomod.cblgh.org/cerca/activitypub"
+)
+
+type stub struct {
+	id     string
+	key    *rsa.PrivateKey
+	public string
+	client *activitypub.Client
+}
+
+func (s stub) keyID() string {
+	return s.id + "#main-key"
+}
+
+func (s stub) serveActor(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", activitypub.CONTENT_TYPE)
+	json.NewEncoder(res).Encode(activitypub.Actor{
+		Context:           activitypub.CONTEXT,
+		ID:                s.id,
+		Type:              "Person",
+		PreferredUsername: "stub",
+		Inbox:             s.id + "/inbox",
+		PublicKey:         activitypub.PublicKey{ID: s.keyID(), Owner: s.id, PublicKeyPem: s.public},
+	})
+}
+
+// prints the activity, and whether it was signed by the actor that sent it
+func (s stub) serveInbox(res http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, activitypub.MAX_DOCUMENT_SIZE))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	verified := "verified"
+	if err := s.verify(req, body); err != nil {
+		verified = fmt.Sprintf("NOT VERIFIED (%s)", err)
+	}
+	var indented bytes.Buffer
+	json.Indent(&indented, body, "", "  ")
+	log.Printf("received, signature %s:\n%s\n", verified, indented.String())
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (s stub) verify(req *http.Request, body []byte) error {
+	keyID, err := activitypub.SignatureKeyID(req)
+	if err != nil {
+		return err
+	}
+	owner, _, _ := strings.Cut(keyID, "#")
+	actor, err := s.client.FetchActor(owner, s.keyID(), s.key)
+	if err != nil {
+		return err
+	}
+	key, err := activitypub.ParsePublicKey(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return err
+	}
+	return activitypub.Verify(req, body, req.Host, key)
+}
+
+// sends a signed activity to the actor's inbox
+func (s stub) send(actorID string, activity activitypub.Activity) error {
+	actor, err := s.client.FetchActor(actorID, s.keyID(), s.key)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return s.client.Deliver(actor.Inbox, body, s.keyID(), s.key)
+}
+
+func main() {
+	var addr, follow string
+	flag.StringVar(&addr, "addr", "localhost:8278", "address to serve the stub actor on")
+	flag.StringVar(&follow, "follow", "", "id of the actor to follow, e.g. http://localhost:8277/ap/forum")
+	flag.Parse()
+
+	private, public, err := activitypub.GenerateKey()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	key, err := activitypub.ParsePrivateKey(private)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s := stub{
+		id:     fmt.Sprintf("http://%s/actor", addr),
+		key:    key,
+		public: public,
+		client: activitypub.NewClient("cerca stub-inbox", true),
+	}
+	http.HandleFunc("/actor", s.serveActor)
+	http.HandleFunc("/actor/inbox", s.serveInbox)
+	go func() {
+		log.Fatalln(http.ListenAndServe(addr, nil))
+	}()
+	log.Printf("serving %s\n", s.id)
+	if follow == "" {
+		select {}
+	}
+
+	followActivity := activitypub.Activity{
+		Context: activitypub.CONTEXT[0],
+		ID:      s.id + "#follow",
+		Type:    activitypub.FOLLOW,
+		Actor:   s.id,
+		Object:  follow,
+	}
+	if err := s.send(follow, followActivity); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("followed %s\n", follow)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	undo := activitypub.Activity{
+		Context: activitypub.CONTEXT[0],
+		ID:      s.id + "#undo",
+		Type:    activitypub.UNDO,
+		Actor:   s.id,
+		Object:  followActivity,
+	}
+	if err := s.send(follow, undo); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("unfollowed %s\n", follow)
+}
@@ -2,6 +2,8 @@ package crypto
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/matthewhartstonge/argon2"
 	"gomod.cblgh.org/cerca/util/eout"
 	"math/big"
@@ -46,3 +48,21 @@ func GeneratePassword() string {
 	}
 	return password.String()
 }
+
+// api tokens are prefixed, making them recognizable when they end up in logs or repositories
+const tokenPrefix = "cerca_"
+
+// generates a random api token with 256 bits of entropy
+func GenerateToken() string {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	eout.Check(err, "randomly generate token")
+	return tokenPrefix + hex.EncodeToString(buf)
+}
+
+// api tokens and session tokens are random and long, which makes a fast hash suitable (unlike for passwords): it lets a
+// token be looked up by its hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// time-based one-time passwords (rfc 6238) with the parameters every authenticator app supports: sha1, 6 digits and a
+// 30 second period
+const totpPeriod = 30
+const totpDigits = 6
+
+// codes from the previous and the next period are also accepted, to allow for clock drift and slow typing
+const totpSkew = 1
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generates a random 160 bit totp secret, base32 encoded as authenticator apps expect
+func GenerateTOTPSecret() string {
+	buf := make([]byte, 20)
+	_, err := rand.Read(buf)
+	eout.Check(err, "randomly generate totp secret")
+	return totpEncoding.EncodeToString(buf)
+}
+
+// returns the otpauth:// uri that authenticator apps read from a qr code
+func TOTPURI(secret, issuer, account string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// returns the time step a totp code is valid for
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// dynamic truncation, see rfc 4226 section 5.3
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// returns the code for the secret at the given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", eout.Eout(err, "decode totp secret")
+	}
+	return totpCode(key, TOTPStep(t)), nil
+}
+
+// checks code against the secret at the given time. it returns the time step the code was valid for, which callers
+// should store and pass as lastStep next time so that a code can't be used twice
+func ValidateTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := TOTPStep(t)
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generates one-time recovery codes, used to log in when the authenticator app is lost. like api tokens they are stored
+// hashed with HashToken
+func GenerateRecoveryCodes(n int) []string {
+	codes := make([]string, n)
+	for i := range codes {
+		buf := make([]byte, 5)
+		_, err := rand.Read(buf)
+		eout.Check(err, "randomly generate recovery code")
+		s := hex.EncodeToString(buf)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes
+}
+
+// recovery codes are compared case insensitively and without surrounding whitespace
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
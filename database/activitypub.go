@@ -0,0 +1,215 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the forum federates over activitypub through actors of its own, such as one for the whole forum and one per topic,
+// see server/activitypub.go. each actor signs what it sends with its own key, and remote actors follow it. activities
+// for its followers are queued in activitypub_deliveries until their inboxes have accepted them
+
+// returns the actor's private and public key, both pem encoded, or sql.ErrNoRows if it has none yet
+func (d DB) GetActorKey(actor string) (string, string, error) {
+	var private, public string
+	err := d.db.QueryRow(`SELECT privatekey, publickey FROM activitypub_keys WHERE actor = ?`, actor).Scan(&private, &public)
+	if err == sql.ErrNoRows {
+		return "", "", err
+	}
+	return private, public, eout.Eout(err, "get key of actor %s", actor)
+}
+
+// saves a key for the actor, unless it already has one. the key the actor ends up with is returned
+func (d DB) AddActorKey(actor, private, public string) (string, string, error) {
+	stmt := `INSERT OR IGNORE INTO activitypub_keys (actor, privatekey, publickey) VALUES (?, ?, ?)`
+	if _, err := d.Exec(stmt, actor, private, public); err != nil {
+		return "", "", eout.Eout(err, "add key of actor %s", actor)
+	}
+	return d.GetActorKey(actor)
+}
+
+// adds a remote actor, with the given inbox, as a follower of one of the forum's actors
+func (d DB) AddFollower(actor, follower, inbox string) error {
+	stmt := `INSERT INTO activitypub_followers (actor, follower, inbox, created) VALUES (?, ?, ?, ?)
+  ON CONFLICT (actor, follower) DO UPDATE SET inbox = excluded.inbox`
+	_, err := d.Exec(stmt, actor, follower, inbox, time.Now())
+	return eout.Eout(err, "add follower %s of %s", follower, actor)
+}
+
+func (d DB) RemoveFollower(actor, follower string) error {
+	_, err := d.Exec(`DELETE FROM activitypub_followers WHERE actor = ? AND follower = ?`, actor, follower)
+	return eout.Eout(err, "remove follower %s of %s", follower, actor)
+}
+
+// removes the followers whose inbox is gone for good
+func (d DB) RemoveFollowersByInbox(inbox string) error {
+	_, err := d.Exec(`DELETE FROM activitypub_followers WHERE inbox = ?`, inbox)
+	return eout.Eout(err, "remove followers with inbox %s", inbox)
+}
+
+// returns the inboxes of the actor's followers, each inbox once
+func (d DB) GetFollowerInboxes(actor string) ([]string, error) {
+	ed := eout.Describe("get follower inboxes")
+	rows, err := d.db.Query(`SELECT DISTINCT inbox FROM activitypub_followers WHERE actor = ?`, actor)
+	if err != nil {
+		return nil, ed.Eout(err, "query inboxes of %s", actor)
+	}
+	defer rows.Close()
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, ed.Eout(err, "scan inboxes of %s", actor)
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, ed.Eout(rows.Err(), "iterate inboxes of %s", actor)
+}
+
+func (d DB) CountFollowers(actor string) (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT count(*) FROM activitypub_followers WHERE actor = ?`, actor).Scan(&count)
+	return count, eout.Eout(err, "count followers of %s", actor)
+}
+
+// an activity waiting to be delivered to an inbox
+type Delivery struct {
+	ID int
+	// the forum's actor that sends the activity
+	Actor    string
+	Inbox    string
+	Activity []byte
+	// how many times delivering the activity has failed
+	Attempts int
+}
+
+// queues the activity, sent by actor, for delivery to each of the inboxes
+func (d DB) QueueDeliveries(actor string, activity []byte, inboxes []string) error {
+	ed := eout.Describe("queue deliveries")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	now := time.Now()
+	stmt := `INSERT INTO activitypub_deliveries (actor, inbox, activity, nextattempt, created) VALUES (?, ?, ?, ?, ?)`
+	for _, inbox := range inboxes {
+		if _, err = tx.Exec(stmt, actor, inbox, string(activity), now, now); err != nil {
+			_ = tx.Rollback()
+			return ed.Eout(err, "queue delivery to %s", inbox)
+		}
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// returns up to limit deliveries that are due to be attempted, oldest first
+func (d DB) GetDueDeliveries(limit int) ([]Delivery, error) {
+	ed := eout.Describe("get due deliveries")
+	stmt := `SELECT id, actor, inbox, activity, attempts FROM activitypub_deliveries WHERE nextattempt <= ? ORDER BY id LIMIT ?`
+	rows, err := d.db.Query(stmt, time.Now(), limit)
+	if err != nil {
+		return nil, ed.Eout(err, "query deliveries")
+	}
+	defer rows.Close()
+	var deliveries []Delivery
+	for rows.Next() {
+		var delivery Delivery
+		var activity string
+		if err := rows.Scan(&delivery.ID, &delivery.Actor, &delivery.Inbox, &activity, &delivery.Attempts); err != nil {
+			return nil, ed.Eout(err, "scan deliveries")
+		}
+		delivery.Activity = []byte(activity)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, ed.Eout(rows.Err(), "iterate deliveries")
+}
+
+// removes a delivery from the queue, once it has been delivered or given up on
+func (d DB) RemoveDelivery(id int) error {
+	_, err := d.Exec(`DELETE FROM activitypub_deliveries WHERE id = ?`, id)
+	return eout.Eout(err, "remove delivery %d", id)
+}
+
+// records a failed attempt at a delivery, which is attempted again at next
+func (d DB) RetryDelivery(id int, next time.Time, reason string) error {
+	stmt := `UPDATE activitypub_deliveries SET attempts = attempts + 1, nextattempt = ?, lasterror = ? WHERE id = ?`
+	_, err := d.Exec(stmt, next, reason, id)
+	return eout.Eout(err, "retry delivery %d", id)
+}
+
+// a post as it is federated
+type FederatedPost struct {
+	Post
+	TopicID int
+	Private bool
+	// the first post of the post's thread, which the thread's other posts are replies to
+	FirstPostID int
+}
+
+func (d DB) GetFederatedPost(postid int) (FederatedPost, error) {
+	posts, err := d.GetFederatedPosts([]int{postid})
+	if err == nil && len(posts) == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return FederatedPost{}, eout.Eout(err, "get federated post %d", postid)
+	}
+	return posts[0], nil
+}
+
+// returns the posts with the given ids, in the same order. posts that don't exist are left out
+func (d DB) GetFederatedPosts(postids []int) ([]FederatedPost, error) {
+	ed := eout.Describe("get federated posts")
+	if len(postids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(postids))
+	for i, postid := range postids {
+		args[i] = postid
+	}
+	stmt := fmt.Sprintf(`
+  SELECT p.id, t.id, t.title, p.content, u.name, p.authorid, p.publishtime, p.lastedit, p.hidden, t.topicid, t.private,
+    (SELECT fp.id FROM posts fp WHERE fp.threadid = t.id ORDER BY fp.publishtime, fp.id LIMIT 1)
+  FROM posts p
+  INNER JOIN users u ON u.id = p.authorid
+  INNER JOIN threads t ON t.id = p.threadid
+  WHERE p.id IN (%s)
+  `, strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "))
+	rows, err := d.db.Query(stmt, args...)
+	if err != nil {
+		return nil, ed.Eout(err, "query posts")
+	}
+	defer rows.Close()
+	found := make(map[int]FederatedPost)
+	for rows.Next() {
+		var post FederatedPost
+		var topicid sql.NullInt64
+		err := rows.Scan(&post.ID, &post.ThreadID, &post.ThreadTitle, &post.Content, &post.Author, &post.AuthorID, &post.Publish, &post.LastEdit, &post.Hidden, &topicid, &post.Private, &post.FirstPostID)
+		if err != nil {
+			return nil, ed.Eout(err, "scan posts")
+		}
+		post.TopicID = int(topicid.Int64)
+		found[post.ID] = post
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ed.Eout(err, "iterate posts")
+	}
+	var posts []FederatedPost
+	var mentioning []Post
+	for _, postid := range postids {
+		if post, ok := found[postid]; ok {
+			posts = append(posts, post)
+			mentioning = append(mentioning, post.Post)
+		}
+	}
+	if err := d.setMentions(mentioning); err != nil {
+		return nil, ed.Eout(err, "get mentions in posts")
+	}
+	for i := range posts {
+		posts[i].Mentions = mentioning[i].Mentions
+	}
+	return posts, nil
+}
@@ -0,0 +1,642 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// an archive is a forum's content and accounts, in a form that can be written to and read from a file, see ARCHIVE.md
+// and cmd/cerca/archive.go. ids are the ones used by the database the archive was exported from; an id of 0 stands for
+// none. sessions, api tokens, two-factor secrets, email tokens, notifications, subscriptions, read markers, and the
+// activitypub and webhook tables are left out: they belong to a running forum rather than to its content
+
+type ArchiveUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// empty in archives exported without password hashes
+	PasswordHash       string `json:"password_hash,omitempty"`
+	Bio                string `json:"bio,omitempty"`
+	Email              string `json:"email,omitempty"`
+	EmailVerified      bool   `json:"email_verified"`
+	EmailNotifications bool   `json:"email_notifications"`
+}
+
+type ArchiveAdmin struct {
+	UserID int `json:"user_id"`
+}
+
+type ArchiveTopic struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type ArchiveThread struct {
+	ID        int      `json:"id"`
+	Title     string   `json:"title"`
+	Published NullTime `json:"published"`
+	TopicID   int      `json:"topic_id,omitempty"`
+	AuthorID  int      `json:"author_id,omitempty"`
+	Private   bool     `json:"private"`
+}
+
+type ArchivePost struct {
+	ID           int      `json:"id"`
+	ThreadID     int      `json:"thread_id"`
+	AuthorID     int      `json:"author_id,omitempty"`
+	Content      string   `json:"content"`
+	Published    NullTime `json:"published"`
+	LastEdit     NullTime `json:"last_edit"`
+	Hidden       bool     `json:"hidden"`
+	HiddenReason string   `json:"hidden_reason,omitempty"`
+}
+
+type ArchiveMention struct {
+	PostID int `json:"post_id"`
+	UserID int `json:"user_id"`
+	// the name the post mentions the user by, see mentions.go
+	Name string `json:"name"`
+}
+
+type ArchiveInvite struct {
+	ID       int       `json:"id"`
+	BatchID  string    `json:"batch_id"`
+	Invite   string    `json:"invite"`
+	Label    string    `json:"label,omitempty"`
+	AdminID  int       `json:"admin_id"`
+	Time     time.Time `json:"time"`
+	Reusable bool      `json:"reusable"`
+}
+
+type ArchiveRegistration struct {
+	ID     int      `json:"id"`
+	UserID int      `json:"user_id,omitempty"`
+	Host   string   `json:"host,omitempty"`
+	Link   string   `json:"link,omitempty"`
+	Time   NullTime `json:"time"`
+}
+
+// a placeholder account for the author of imported posts, see placeholders.go. claim links are left out
+type ArchivePlaceholder struct {
+	UserID   int      `json:"user_id"`
+	Source   string   `json:"source"`
+	Original string   `json:"original"`
+	Claimed  NullTime `json:"claimed"`
+}
+
+type ArchiveQuorumDecision struct {
+	UserID   int  `json:"user_id"`
+	Decision bool `json:"decision"`
+}
+
+type ArchiveModerationEntry struct {
+	ID          int       `json:"id"`
+	ActingID    int       `json:"acting_id"`
+	RecipientID int       `json:"recipient_id,omitempty"`
+	Action      int       `json:"action"`
+	Time        time.Time `json:"time"`
+	// a thread or post id, depending on the action, see moderationSubjectKind
+	SubjectID int    `json:"subject_id,omitempty"`
+	Note      string `json:"note,omitempty"`
+	// the admins who confirmed or vetoed the proposal the entry is the outcome of
+	Quorum []ArchiveQuorumDecision `json:"quorum,omitempty"`
+}
+
+type ArchiveProposal struct {
+	ID          int       `json:"id"`
+	ProposerID  int       `json:"proposer_id"`
+	RecipientID int       `json:"recipient_id"`
+	Action      int       `json:"action"`
+	Time        time.Time `json:"time"`
+}
+
+type Archive struct {
+	Users         []ArchiveUser
+	Admins        []ArchiveAdmin
+	Topics        []ArchiveTopic
+	Threads       []ArchiveThread
+	Posts         []ArchivePost
+	Mentions      []ArchiveMention
+	Invites       []ArchiveInvite
+	Registrations []ArchiveRegistration
+	Placeholders  []ArchivePlaceholder
+	ModerationLog []ArchiveModerationEntry
+	Proposals     []ArchiveProposal
+}
+
+// the number of records of each kind in the archive, keyed by the name of the file they are stored in
+func (a Archive) Counts() map[string]int {
+	return map[string]int{
+		"users":                len(a.Users),
+		"admins":               len(a.Admins),
+		"topics":               len(a.Topics),
+		"threads":              len(a.Threads),
+		"posts":                len(a.Posts),
+		"mentions":             len(a.Mentions),
+		"invites":              len(a.Invites),
+		"registrations":        len(a.Registrations),
+		"placeholders":         len(a.Placeholders),
+		"moderation_log":       len(a.ModerationLog),
+		"moderation_proposals": len(a.Proposals),
+	}
+}
+
+// the kind of record a moderation log entry's subjectid refers to: "thread", "post", or "" for none
+func moderationSubjectKind(action int) string {
+	switch action {
+	case constants.MODLOG_DELETE_THREAD, constants.MODLOG_MOVE_THREAD, constants.MODLOG_DELETE_POST:
+		// a deleted post's log entry points at its thread, as the post itself is gone
+		return "thread"
+	case constants.MODLOG_HIDE_POST, constants.MODLOG_UNHIDE_POST, constants.MODLOG_EDIT_POST:
+		return "post"
+	}
+	return ""
+}
+
+// reads the whole forum into an archive. without passwordHashes, the users' password hashes are left out, and the
+// users have to reset their passwords after the archive has been imported
+func (d DB) ExportArchive(passwordHashes bool) (Archive, error) {
+	ed := eout.Describe("export archive")
+	var a Archive
+	// each query fills in one kind of record; the scan functions are given the rows of their query
+	exports := []struct {
+		what  string
+		query string
+		scan  func(*sql.Rows) error
+	}{
+		{"users", `SELECT id, name, passwordhash, bio, email, emailverified, emailnotifications FROM users ORDER BY id`, func(rows *sql.Rows) error {
+			var u ArchiveUser
+			var bio, email sql.NullString
+			err := rows.Scan(&u.ID, &u.Name, &u.PasswordHash, &bio, &email, &u.EmailVerified, &u.EmailNotifications)
+			u.Bio, u.Email = bio.String, email.String
+			if !passwordHashes {
+				u.PasswordHash = ""
+			}
+			a.Users = append(a.Users, u)
+			return err
+		}},
+		{"admins", `SELECT id FROM admins ORDER BY id`, func(rows *sql.Rows) error {
+			var admin ArchiveAdmin
+			err := rows.Scan(&admin.UserID)
+			a.Admins = append(a.Admins, admin)
+			return err
+		}},
+		{"topics", `SELECT id, name, description FROM topics ORDER BY id`, func(rows *sql.Rows) error {
+			var t ArchiveTopic
+			var description sql.NullString
+			err := rows.Scan(&t.ID, &t.Name, &description)
+			t.Description = description.String
+			a.Topics = append(a.Topics, t)
+			return err
+		}},
+		{"threads", `SELECT id, title, publishtime, topicid, authorid, private FROM threads ORDER BY id`, func(rows *sql.Rows) error {
+			var t ArchiveThread
+			var topicid, authorid sql.NullInt64
+			err := rows.Scan(&t.ID, &t.Title, &t.Published, &topicid, &authorid, &t.Private)
+			t.TopicID, t.AuthorID = int(topicid.Int64), int(authorid.Int64)
+			a.Threads = append(a.Threads, t)
+			return err
+		}},
+		{"posts", `SELECT id, threadid, authorid, content, publishtime, lastedit, hidden, hiddenreason FROM posts ORDER BY id`, func(rows *sql.Rows) error {
+			var p ArchivePost
+			var threadid, authorid sql.NullInt64
+			var reason sql.NullString
+			err := rows.Scan(&p.ID, &threadid, &authorid, &p.Content, &p.Published, &p.LastEdit, &p.Hidden, &reason)
+			p.ThreadID, p.AuthorID, p.HiddenReason = int(threadid.Int64), int(authorid.Int64), reason.String
+			a.Posts = append(a.Posts, p)
+			return err
+		}},
+		{"mentions", `SELECT postid, userid, name FROM mentions ORDER BY postid, userid`, func(rows *sql.Rows) error {
+			var m ArchiveMention
+			err := rows.Scan(&m.PostID, &m.UserID, &m.Name)
+			a.Mentions = append(a.Mentions, m)
+			return err
+		}},
+		{"invites", `SELECT id, batchid, invite, label, adminid, time, reusable FROM invites ORDER BY id`, func(rows *sql.Rows) error {
+			var i ArchiveInvite
+			var label sql.NullString
+			err := rows.Scan(&i.ID, &i.BatchID, &i.Invite, &label, &i.AdminID, &i.Time, &i.Reusable)
+			i.Label = label.String
+			a.Invites = append(a.Invites, i)
+			return err
+		}},
+		{"registrations", `SELECT id, userid, host, link, time FROM registrations ORDER BY id`, func(rows *sql.Rows) error {
+			var r ArchiveRegistration
+			var userid sql.NullInt64
+			var host, link sql.NullString
+			err := rows.Scan(&r.ID, &userid, &host, &link, &r.Time)
+			r.UserID, r.Host, r.Link = int(userid.Int64), host.String, link.String
+			a.Registrations = append(a.Registrations, r)
+			return err
+		}},
+		{"placeholders", `SELECT userid, source, original, claimed FROM placeholders ORDER BY userid`, func(rows *sql.Rows) error {
+			var p ArchivePlaceholder
+			err := rows.Scan(&p.UserID, &p.Source, &p.Original, &p.Claimed)
+			a.Placeholders = append(a.Placeholders, p)
+			return err
+		}},
+		{"moderation log", `SELECT id, actingid, recipientid, action, time, subjectid, note FROM moderation_log ORDER BY id`, func(rows *sql.Rows) error {
+			var m ArchiveModerationEntry
+			var recipientid, subjectid sql.NullInt64
+			var note sql.NullString
+			err := rows.Scan(&m.ID, &m.ActingID, &recipientid, &m.Action, &m.Time, &subjectid, &note)
+			m.RecipientID, m.SubjectID, m.Note = int(recipientid.Int64), int(subjectid.Int64), note.String
+			a.ModerationLog = append(a.ModerationLog, m)
+			return err
+		}},
+		{"moderation proposals", `SELECT id, proposerid, recipientid, action, time FROM moderation_proposals ORDER BY id`, func(rows *sql.Rows) error {
+			var p ArchiveProposal
+			err := rows.Scan(&p.ID, &p.ProposerID, &p.RecipientID, &p.Action, &p.Time)
+			a.Proposals = append(a.Proposals, p)
+			return err
+		}},
+	}
+	for _, export := range exports {
+		rows, err := d.db.Query(export.query)
+		if err != nil {
+			return Archive{}, ed.Eout(err, "query %s", export.what)
+		}
+		for rows.Next() {
+			if err = export.scan(rows); err != nil {
+				rows.Close()
+				return Archive{}, ed.Eout(err, "scan %s", export.what)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return Archive{}, ed.Eout(err, "iterate %s", export.what)
+		}
+	}
+
+	// attach the quorum decisions to the log entries they belong to
+	entries := make(map[int]*ArchiveModerationEntry)
+	for i := range a.ModerationLog {
+		entries[a.ModerationLog[i].ID] = &a.ModerationLog[i]
+	}
+	rows, err := d.db.Query(`SELECT modlogid, userid, decision FROM quorum_decisions ORDER BY rowid`)
+	if err != nil {
+		return Archive{}, ed.Eout(err, "query quorum decisions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var modlogid int
+		var decision ArchiveQuorumDecision
+		if err := rows.Scan(&modlogid, &decision.UserID, &decision.Decision); err != nil {
+			return Archive{}, ed.Eout(err, "scan quorum decisions")
+		}
+		if entry, ok := entries[modlogid]; ok {
+			entry.Quorum = append(entry.Quorum, decision)
+		}
+	}
+	return a, ed.Eout(rows.Err(), "iterate quorum decisions")
+}
+
+// checks that the archive is consistent: that ids are unique within each kind of record, and that every record only
+// refers to records that are in the archive. all problems found are returned, rather than only the first one
+func (a Archive) Validate() []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	ids := func(kind string, count int, id func(int) int) map[int]bool {
+		seen := make(map[int]bool, count)
+		for i := 0; i < count; i++ {
+			if seen[id(i)] {
+				problem("%s: id %d occurs more than once", kind, id(i))
+			}
+			if id(i) <= 0 {
+				problem("%s: invalid id %d", kind, id(i))
+			}
+			seen[id(i)] = true
+		}
+		return seen
+	}
+	users := ids("users", len(a.Users), func(i int) int { return a.Users[i].ID })
+	topics := ids("topics", len(a.Topics), func(i int) int { return a.Topics[i].ID })
+	threads := ids("threads", len(a.Threads), func(i int) int { return a.Threads[i].ID })
+	posts := ids("posts", len(a.Posts), func(i int) int { return a.Posts[i].ID })
+	ids("invites", len(a.Invites), func(i int) int { return a.Invites[i].ID })
+	ids("registrations", len(a.Registrations), func(i int) int { return a.Registrations[i].ID })
+	ids("moderation log", len(a.ModerationLog), func(i int) int { return a.ModerationLog[i].ID })
+	ids("moderation proposals", len(a.Proposals), func(i int) int { return a.Proposals[i].ID })
+
+	// checks that a reference resolves; optional references may be 0
+	ref := func(set map[int]bool, id int, optional bool, kind string, recordID int, field string) {
+		if id == 0 && optional {
+			return
+		}
+		if !set[id] {
+			problem("%s %d: %s %d is not in the archive", kind, recordID, field, id)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, u := range a.Users {
+		if u.Name == "" {
+			problem("user %d: empty name", u.ID)
+		} else if names[u.Name] {
+			problem("user %d: name %q occurs more than once", u.ID, u.Name)
+		}
+		names[u.Name] = true
+	}
+	for _, admin := range a.Admins {
+		ref(users, admin.UserID, false, "admin", admin.UserID, "user")
+	}
+	topicNames := make(map[string]bool)
+	for _, t := range a.Topics {
+		if t.Name == "" {
+			problem("topic %d: empty name", t.ID)
+		} else if topicNames[t.Name] {
+			problem("topic %d: name %q occurs more than once", t.ID, t.Name)
+		}
+		topicNames[t.Name] = true
+	}
+	for _, t := range a.Threads {
+		if t.Title == "" {
+			problem("thread %d: empty title", t.ID)
+		}
+		ref(topics, t.TopicID, true, "thread", t.ID, "topic")
+		ref(users, t.AuthorID, true, "thread", t.ID, "author")
+	}
+	for _, p := range a.Posts {
+		ref(threads, p.ThreadID, false, "post", p.ID, "thread")
+		ref(users, p.AuthorID, true, "post", p.ID, "author")
+	}
+	for _, m := range a.Mentions {
+		ref(posts, m.PostID, false, "mention", m.PostID, "post")
+		ref(users, m.UserID, false, "mention", m.PostID, "user")
+	}
+	for _, i := range a.Invites {
+		ref(users, i.AdminID, false, "invite", i.ID, "admin")
+	}
+	for _, r := range a.Registrations {
+		ref(users, r.UserID, true, "registration", r.ID, "user")
+	}
+	placeholders := make(map[int]bool)
+	for _, p := range a.Placeholders {
+		ref(users, p.UserID, false, "placeholder", p.UserID, "user")
+		if placeholders[p.UserID] {
+			problem("placeholder %d: occurs more than once", p.UserID)
+		}
+		placeholders[p.UserID] = true
+	}
+	for _, m := range a.ModerationLog {
+		ref(users, m.ActingID, false, "moderation log entry", m.ID, "acting user")
+		ref(users, m.RecipientID, true, "moderation log entry", m.ID, "recipient")
+		for _, decision := range m.Quorum {
+			ref(users, decision.UserID, false, "moderation log entry", m.ID, "quorum user")
+		}
+		// subjects are not checked: deleted threads and posts are, by their nature, no longer around
+	}
+	for _, p := range a.Proposals {
+		ref(users, p.ProposerID, false, "moderation proposal", p.ID, "proposer")
+		ref(users, p.RecipientID, false, "moderation proposal", p.ID, "recipient")
+	}
+	return problems
+}
+
+// what importing an archive did, or would have done in a dry run
+type ImportReport struct {
+	// the number of records of each kind that were added, keyed as in Archive.Counts
+	Added map[string]int
+	// users and topics of the archive that already existed by name, and were merged with the existing ones
+	MergedUsers  []string
+	MergedTopics []string
+	// records that could not keep their id, as it was taken, and were given a new one
+	Renumbered int
+	// whether users had their password set to a random one, as the archive had no password hash for them
+	RandomPasswords int
+}
+
+// adds the contents of a valid archive to the database, which may already have content of its own. records keep
+// their id when it is free, and are otherwise given a new one, with every reference to them following along. the
+// system and deleted users, and topics with the same name, are merged with the ones already in the database; any
+// other user whose name is taken fails the import. the import happens in a single transaction, and nothing is written
+// if it fails or if dryRun is set
+func (d DB) ImportArchive(a Archive, dryRun bool) (report ImportReport, finalErr error) {
+	ed := eout.Describe("import archive")
+	report.Added = make(map[string]int)
+	if problems := a.Validate(); len(problems) > 0 {
+		return report, ed.Eout(problems[0], "invalid archive (%d problems)", len(problems))
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return report, ed.Eout(err, "start transaction")
+	}
+	defer func() {
+		if finalErr != nil || dryRun {
+			_ = tx.Rollback()
+			return
+		}
+		finalErr = ed.Eout(tx.Commit(), "commit transaction")
+	}()
+
+	// inserts a row, keeping the given id if it is free in the table, and returns the id the row got
+	insert := func(table string, id int, columns string, args ...interface{}) (int, error) {
+		var taken bool
+		err := tx.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = ?)`, table), id).Scan(&taken)
+		if err != nil {
+			return -1, err
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+		if !taken {
+			columns = "id, " + columns
+			placeholders = "?, " + placeholders
+			args = append([]interface{}{id}, args...)
+		} else {
+			report.Renumbered++
+		}
+		var newid int
+		stmt := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING id`, table, columns, placeholders)
+		err = tx.QueryRow(stmt, args...).Scan(&newid)
+		return newid, err
+	}
+	// maps optional references, where 0 stands for none, to sql NULL
+	nullable := func(ids map[int]int, id int) sql.NullInt64 {
+		if newid, ok := ids[id]; ok && id != 0 {
+			return sql.NullInt64{Int64: int64(newid), Valid: true}
+		}
+		return sql.NullInt64{}
+	}
+	nullString := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: s != ""}
+	}
+
+	// users without a password hash get a hash of a random password, which no one knows. hashing is slow, so they
+	// share the one hash
+	var randomHash string
+	userids := make(map[int]int)
+	existingUsers := make(map[string]int)
+	var taken []string
+	for _, u := range a.Users {
+		var existing int
+		err := tx.QueryRow(`SELECT id FROM users WHERE name = ?`, u.Name).Scan(&existing)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return report, ed.Eout(err, "look up user %s", u.Name)
+		}
+		existingUsers[u.Name] = existing
+		if u.Name != SYSTEM_USER_NAME && u.Name != DELETED_USER_NAME {
+			taken = append(taken, fmt.Sprintf("%q", u.Name))
+		}
+	}
+	if len(taken) > 0 {
+		return report, ed.Eout(fmt.Errorf("usernames already taken: %s", strings.Join(taken, ", ")), "merge users")
+	}
+	for _, u := range a.Users {
+		if existing, ok := existingUsers[u.Name]; ok {
+			userids[u.ID] = existing
+			report.MergedUsers = append(report.MergedUsers, u.Name)
+			continue
+		}
+		hash := u.PasswordHash
+		if hash == "" {
+			if randomHash == "" {
+				randomHash, err = crypto.HashPassword(crypto.GeneratePassword())
+				if err != nil {
+					return report, ed.Eout(err, "hash random password")
+				}
+			}
+			hash = randomHash
+			report.RandomPasswords++
+		}
+		userids[u.ID], err = insert("users", u.ID, "name, passwordhash, bio, email, emailverified, emailnotifications",
+			u.Name, hash, nullString(u.Bio), nullString(u.Email), u.EmailVerified, u.EmailNotifications)
+		if err != nil {
+			return report, ed.Eout(err, "insert user %d", u.ID)
+		}
+		report.Added["users"]++
+	}
+
+	for _, admin := range a.Admins {
+		result, err := tx.Exec(`INSERT OR IGNORE INTO admins (id) VALUES (?)`, userids[admin.UserID])
+		if err != nil {
+			return report, ed.Eout(err, "insert admin %d", admin.UserID)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			report.Added["admins"]++
+		}
+	}
+
+	topicids := make(map[int]int)
+	for _, t := range a.Topics {
+		var existing int
+		err := tx.QueryRow(`SELECT id FROM topics WHERE name = ?`, t.Name).Scan(&existing)
+		if err == nil {
+			topicids[t.ID] = existing
+			report.MergedTopics = append(report.MergedTopics, t.Name)
+			continue
+		} else if err != sql.ErrNoRows {
+			return report, ed.Eout(err, "look up topic %s", t.Name)
+		}
+		topicids[t.ID], err = insert("topics", t.ID, "name, description", t.Name, nullString(t.Description))
+		if err != nil {
+			return report, ed.Eout(err, "insert topic %d", t.ID)
+		}
+		report.Added["topics"]++
+	}
+
+	threadids := make(map[int]int)
+	for _, t := range a.Threads {
+		threadids[t.ID], err = insert("threads", t.ID, "title, publishtime, topicid, authorid, private",
+			t.Title, t.Published, nullable(topicids, t.TopicID), nullable(userids, t.AuthorID), t.Private)
+		if err != nil {
+			return report, ed.Eout(err, "insert thread %d", t.ID)
+		}
+		report.Added["threads"]++
+	}
+
+	postids := make(map[int]int)
+	for _, p := range a.Posts {
+		postids[p.ID], err = insert("posts", p.ID, "content, publishtime, lastedit, authorid, threadid, hidden, hiddenreason",
+			p.Content, p.Published, p.LastEdit, nullable(userids, p.AuthorID), threadids[p.ThreadID], p.Hidden, nullString(p.HiddenReason))
+		if err != nil {
+			return report, ed.Eout(err, "insert post %d", p.ID)
+		}
+		report.Added["posts"]++
+	}
+
+	for _, m := range a.Mentions {
+		stmt := `INSERT OR IGNORE INTO mentions (postid, userid, name) VALUES (?, ?, ?)`
+		result, err := tx.Exec(stmt, postids[m.PostID], userids[m.UserID], m.Name)
+		if err != nil {
+			return report, ed.Eout(err, "insert mention of user %d in post %d", m.UserID, m.PostID)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			report.Added["mentions"]++
+		}
+	}
+
+	for _, i := range a.Invites {
+		_, err := insert("invites", i.ID, "batchid, invite, label, adminid, time, reusable",
+			i.BatchID, i.Invite, nullString(i.Label), userids[i.AdminID], i.Time, i.Reusable)
+		if err != nil {
+			return report, ed.Eout(err, "insert invite %d", i.ID)
+		}
+		report.Added["invites"]++
+	}
+
+	for _, r := range a.Registrations {
+		_, err := insert("registrations", r.ID, "userid, host, link, time",
+			nullable(userids, r.UserID), nullString(r.Host), nullString(r.Link), r.Time)
+		if err != nil {
+			return report, ed.Eout(err, "insert registration %d", r.ID)
+		}
+		report.Added["registrations"]++
+	}
+
+	// a placeholder whose source and original name are already taken by one in the forum is imported as a regular
+	// account
+	for _, p := range a.Placeholders {
+		stmt := `INSERT OR IGNORE INTO placeholders (userid, source, original, claimed) VALUES (?, ?, ?, ?)`
+		result, err := tx.Exec(stmt, userids[p.UserID], p.Source, p.Original, p.Claimed)
+		if err != nil {
+			return report, ed.Eout(err, "insert placeholder %d", p.UserID)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			report.Added["placeholders"]++
+		}
+	}
+
+	for _, m := range a.ModerationLog {
+		// subjects that were deleted before the export, or never were in the forum, are kept as none
+		var subjectid sql.NullInt64
+		switch moderationSubjectKind(m.Action) {
+		case "thread":
+			subjectid = nullable(threadids, m.SubjectID)
+		case "post":
+			subjectid = nullable(postids, m.SubjectID)
+		}
+		modlogid, err := insert("moderation_log", m.ID, "actingid, recipientid, action, time, subjectid, note",
+			userids[m.ActingID], nullable(userids, m.RecipientID), m.Action, m.Time, subjectid, nullString(m.Note))
+		if err != nil {
+			return report, ed.Eout(err, "insert moderation log entry %d", m.ID)
+		}
+		for _, decision := range m.Quorum {
+			stmt := `INSERT INTO quorum_decisions (userid, decision, modlogid) VALUES (?, ?, ?)`
+			if _, err := tx.Exec(stmt, userids[decision.UserID], decision.Decision, modlogid); err != nil {
+				return report, ed.Eout(err, "insert quorum decision of moderation log entry %d", m.ID)
+			}
+		}
+		report.Added["moderation_log"]++
+	}
+
+	for _, p := range a.Proposals {
+		_, err := insert("moderation_proposals", p.ID, "proposerid, recipientid, action, time",
+			userids[p.ProposerID], userids[p.RecipientID], p.Action, p.Time)
+		if err != nil {
+			return report, ed.Eout(err, "insert moderation proposal %d", p.ID)
+		}
+		report.Added["moderation_proposals"]++
+	}
+	return report, nil
+}
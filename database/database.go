@@ -3,12 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"gomod.cblgh.org/cerca/constants"
 	"gomod.cblgh.org/cerca/crypto"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"gomod.cblgh.org/cerca/util/eout"
@@ -19,6 +22,12 @@ import (
 
 type DB struct {
 	db *sql.DB
+	// false if cerca was built without sqlite's fts5 extension, see search.go
+	searchEnabled bool
+	// who is subscribed to threads automatically, see notifications.go
+	subscriptions SubscriptionOptions
+	// called with every entry added to the moderation log, see moderation.go
+	moderationHook func(ModerationEvent)
 }
 
 func CheckExists(filepath string) bool {
@@ -43,14 +52,40 @@ func InitDB(filepath string) DB {
 	if db == nil {
 		log.Fatalln("db is nil")
 	}
+	// databases without a users table are new, and are created with the latest schema
+	fresh, err := tableExists(db, "users")
+	eout.Check(err, "check if database %s is new", filepath)
+	fresh = !fresh
+	version := SchemaVersion
+	if !fresh {
+		version, err = getSchemaVersion(db)
+		eout.Check(err, "read schema version of %s", filepath)
+		if version > SchemaVersion {
+			log.Fatalf("database %s has schema version %d, but this version of cerca only supports up to version %d: upgrade cerca\n", filepath, version, SchemaVersion)
+		}
+	}
 	createTables(db)
-	instance := DB{db}
+	instance := DB{db: db}
+	if fresh {
+		tx, err := db.Begin()
+		eout.Check(err, "start transaction")
+		if err = setSchemaVersion(tx, SchemaVersion); err != nil {
+			_ = tx.Rollback()
+			log.Fatalln(err)
+		}
+		eout.Check(tx.Commit(), "set schema version of %s", filepath)
+	} else if err = instance.migrate(filepath, version); err != nil {
+		log.Fatalf("migrating %s failed, no changes were made: %v\n", filepath, err)
+	}
 	instance.makeSureDefaultUsersExist()
+	instance.makeSureDefaultTopicExists()
+	instance.searchEnabled = instance.createSearchIndex()
 	return instance
 }
 
 const DELETED_USER_NAME = "deleted user"
 const SYSTEM_USER_NAME = "CERCA_CMD"
+const DEFAULT_TOPIC_NAME = "general"
 
 func (d DB) makeSureDefaultUsersExist() {
 	ed := eout.Describe("create default users")
@@ -69,6 +104,22 @@ func (d DB) makeSureDefaultUsersExist() {
 	}
 }
 
+// every thread belongs to a topic, so there always needs to be at least one topic around. threads created before topics
+// were in use all have topicid 1, which is the id the default topic receives on an empty topics table
+func (d DB) makeSureDefaultTopicExists() {
+	ed := eout.Describe("create default topic")
+	topicExists, err := d.existsQuery(`SELECT 1 FROM topics`)
+	if err != nil {
+		log.Fatalln(ed.Eout(err, "check if any topic exists"))
+	}
+	if !topicExists {
+		_, err = d.CreateTopic(DEFAULT_TOPIC_NAME, "")
+		if err != nil {
+			log.Fatalln(ed.Eout(err, "create %s", DEFAULT_TOPIC_NAME))
+		}
+	}
+}
+
 func createTables(db *sql.DB) {
 	// create the table if it doesn't exist
 	queries := []string{
@@ -82,7 +133,11 @@ func createTables(db *sql.DB) {
   CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL UNIQUE,
-    passwordhash TEXT NOT NULL
+    passwordhash TEXT NOT NULL,
+    bio TEXT,
+    email TEXT,
+    emailverified INTEGER NOT NULL DEFAULT 0,
+    emailnotifications INTEGER NOT NULL DEFAULT 0
   );
   `,
 		`
@@ -98,6 +153,8 @@ func createTables(db *sql.DB) {
 		recipientid INTEGER,
 		action INTEGER NOT NULL,
     time DATE NOT NULL,
+    subjectid INTEGER,
+    note TEXT,
 
     FOREIGN KEY (actingid) REFERENCES users(id),
     FOREIGN KEY (recipientid) REFERENCES users(id)
@@ -177,10 +234,195 @@ func createTables(db *sql.DB) {
     lastedit DATE,
     authorid INTEGER,
     threadid INTEGER,
+    hidden INTEGER NOT NULL DEFAULT 0,
+    hiddenreason TEXT,
     FOREIGN KEY(authorid) REFERENCES users(id),
     FOREIGN KEY(threadid) REFERENCES threads(id)
   );
-  `}
+  `,
+		/* personal api tokens, see database/tokens.go. only the hash of a token is stored */
+		`
+  CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userid INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    tokenhash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created DATE NOT NULL,
+    lastused DATE,
+    expires DATE,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		/* logged in sessions, see database/sessions.go. like api tokens, only the hash of a session's token is stored */
+		`
+  CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    tokenhash TEXT NOT NULL UNIQUE,
+    userid INTEGER NOT NULL,
+    useragent TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    created DATE NOT NULL,
+    lastseen DATE NOT NULL,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		/* totp two-factor authentication, see database/twofactor.go */
+		`
+  CREATE TABLE IF NOT EXISTS two_factor (
+    userid INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    laststep INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    lastfailure DATE,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		/* one-time recovery codes for two-factor authentication. only the hash of a code is stored */
+		`
+  CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userid INTEGER NOT NULL,
+    codehash TEXT NOT NULL,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		/* the latest post each user has seen of each thread, see database/reads.go */
+		`
+  CREATE TABLE IF NOT EXISTS thread_reads (
+    userid INTEGER NOT NULL,
+    threadid INTEGER NOT NULL,
+    lastpostid INTEGER NOT NULL,
+    PRIMARY KEY(userid, threadid),
+    FOREIGN KEY(userid) REFERENCES users(id),
+    FOREIGN KEY(threadid) REFERENCES threads(id)
+  );
+  `,
+		/* threads users want to be notified about, see database/notifications.go */
+		`
+  CREATE TABLE IF NOT EXISTS subscriptions (
+    userid INTEGER NOT NULL,
+    threadid INTEGER NOT NULL,
+    PRIMARY KEY(userid, threadid),
+    FOREIGN KEY(userid) REFERENCES users(id),
+    FOREIGN KEY(threadid) REFERENCES threads(id)
+  );
+  `,
+		`
+  CREATE TABLE IF NOT EXISTS notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userid INTEGER NOT NULL,
+    kind INTEGER NOT NULL,
+    threadid INTEGER NOT NULL,
+    postid INTEGER NOT NULL,
+    actorid INTEGER NOT NULL,
+    created DATE NOT NULL,
+    read INTEGER NOT NULL DEFAULT 0,
+    emailed INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY(userid) REFERENCES users(id),
+    FOREIGN KEY(threadid) REFERENCES threads(id),
+    FOREIGN KEY(postid) REFERENCES posts(id),
+    FOREIGN KEY(actorid) REFERENCES users(id)
+  );
+  `,
+		`CREATE INDEX IF NOT EXISTS notifications_userid ON notifications(userid, read)`,
+		/* the users mentioned in posts, see database/mentions.go */
+		`
+  CREATE TABLE IF NOT EXISTS mentions (
+    postid INTEGER NOT NULL,
+    userid INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY(postid, userid),
+    FOREIGN KEY(postid) REFERENCES posts(id),
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		`CREATE INDEX IF NOT EXISTS mentions_userid ON mentions(userid)`,
+		/* single-use tokens sent by email, see database/email.go */
+		`
+  CREATE TABLE IF NOT EXISTS email_tokens (
+    tokenhash TEXT PRIMARY KEY,
+    userid INTEGER NOT NULL,
+    purpose INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    created DATE NOT NULL,
+    expires DATE NOT NULL,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		/* activitypub federation, see database/activitypub.go. actors are the forum's own actors, e.g. "forum" */
+		`
+  CREATE TABLE IF NOT EXISTS activitypub_keys (
+    actor TEXT PRIMARY KEY,
+    privatekey TEXT NOT NULL,
+    publickey TEXT NOT NULL
+  );
+  `,
+		`
+  CREATE TABLE IF NOT EXISTS activitypub_followers (
+    actor TEXT NOT NULL,
+    follower TEXT NOT NULL,
+    inbox TEXT NOT NULL,
+    created DATE NOT NULL,
+    PRIMARY KEY (actor, follower)
+  );
+  `,
+		`
+  CREATE TABLE IF NOT EXISTS activitypub_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor TEXT NOT NULL,
+    inbox TEXT NOT NULL,
+    activity TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    nextattempt DATE NOT NULL,
+    lasterror TEXT,
+    created DATE NOT NULL
+  );
+  `,
+		`CREATE INDEX IF NOT EXISTS activitypub_deliveries_nextattempt ON activitypub_deliveries(nextattempt)`,
+		/* outgoing webhooks, see database/webhooks.go. events is a space separated list of the events a webhook is sent */
+		`
+  CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    private INTEGER NOT NULL DEFAULT 0,
+    created DATE NOT NULL
+  );
+  `,
+		/* a delivery is pending until it has been delivered, or has failed too many times. nextattempt is only set while
+		 * it is pending, and response describes how the last attempt went */
+		`
+  CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhookid INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    nextattempt DATE,
+    response TEXT,
+    created DATE NOT NULL,
+    FOREIGN KEY(webhookid) REFERENCES webhooks(id)
+  );
+  `,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_nextattempt ON webhook_deliveries(nextattempt)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhookid ON webhook_deliveries(webhookid)`,
+		/* accounts for the authors of posts imported from other forums, see database/placeholders.go */
+		`
+  CREATE TABLE IF NOT EXISTS placeholders (
+    userid INTEGER PRIMARY KEY,
+    source TEXT NOT NULL,
+    original TEXT NOT NULL,
+    claimtokenhash TEXT,
+    claimexpires DATE,
+    claimed DATE,
+    FOREIGN KEY(userid) REFERENCES users(id)
+  );
+  `,
+		`CREATE UNIQUE INDEX IF NOT EXISTS placeholders_original ON placeholders(source, original)`}
 
 	for _, query := range queries {
 		if _, err := db.Exec(query); err != nil {
@@ -211,52 +453,172 @@ func (d DB) CreateThread(title, content string, authorid, topicid int, isPrivate
 	ed.Check(err, "start transaction")
 	// first, create the new thread
 	publish := time.Now()
-	threadStmt := `INSERT INTO threads (title, publishtime, topicid, authorid, private) VALUES (?, ?, ?, ?, ?)
-  RETURNING id`
-	replyStmt := `INSERT INTO posts (content, publishtime, threadid, authorid) VALUES (?, ?, ?, ?)`
-	var threadid int
-	private := 0
-	if isPrivate {
-		private = 1
-	}
-	err = tx.QueryRow(threadStmt, title, publish, topicid, authorid, private).Scan(&threadid)
-	if err = ed.Eout(err, "add thread %s (private: %d) by %d in topic %d", title, private, authorid, topicid); err != nil {
+	threadid, err := createThread(tx, title, topicid, authorid, isPrivate, publish)
+	if err = ed.Eout(err, "add thread %s (private: %t) by %d in topic %d", title, isPrivate, authorid, topicid); err != nil {
 		_ = tx.Rollback()
 		log.Println(err, "rolling back")
 		return -1, err
 	}
 	// then add the content as the first reply to the thread
-	_, err = tx.Exec(replyStmt, content, publish, threadid, authorid)
+	postid, err := addPost(tx, content, threadid, authorid, publish)
 	if err = ed.Eout(err, "add initial reply for thread %d", threadid); err != nil {
 		_ = tx.Rollback()
 		log.Println(err, "rolling back")
 		return -1, err
 	}
+	err = saveMentions(tx, postid, threadid, authorid, content, publish)
+	if err = ed.Eout(err, "save mentions in thread %d", threadid); err != nil {
+		_ = tx.Rollback()
+		log.Println(err, "rolling back")
+		return -1, err
+	}
+	if d.subscriptions.ThreadAuthors {
+		err = subscribe(tx, authorid, threadid)
+		if err = ed.Eout(err, "subscribe author to thread %d", threadid); err != nil {
+			_ = tx.Rollback()
+			log.Println(err, "rolling back")
+			return -1, err
+		}
+	}
 	err = tx.Commit()
 	ed.Check(err, "commit transaction")
 	// finally return the id of the created thread, so we can do a friendly redirect
 	return threadid, nil
 }
 
-// c.f.
+// adds a thread, without any posts, published at publish. see CreateThread
+func createThread(tx *sql.Tx, title string, topicid, authorid int, isPrivate bool, publish time.Time) (int, error) {
+	stmt := `INSERT INTO threads (title, publishtime, topicid, authorid, private) VALUES (?, ?, ?, ?, ?) RETURNING id`
+	private := 0
+	if isPrivate {
+		private = 1
+	}
+	var threadid int
+	err := tx.QueryRow(stmt, title, publish, topicid, authorid, private).Scan(&threadid)
+	return threadid, err
+}
+
+// a sql.NullTime which is marshalled to JSON as either null or the time, instead of as an object with the fields Time
+// and Valid. c.f.
 // https://medium.com/aubergine-solutions/how-i-handled-null-possible-values-from-database-rows-in-golang-521fb0ee267
-// type NullTime sql.NullTime
+type NullTime struct {
+	sql.NullTime
+}
+
+func (t NullTime) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time)
+}
+
+func (t *NullTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	}
+	err := json.Unmarshal(b, &t.Time)
+	t.Valid = err == nil
+	return err
+}
+
 type Post struct {
-	ID          int
-	ThreadTitle string
-	ThreadID    int
-	Content     string // markdown
-	Author      string
-	AuthorID    int
-	Publish     time.Time
-	LastEdit    sql.NullTime // TODO: handle json marshalling with custom type
+	ID          int       `json:"id"`
+	ThreadTitle string    `json:"thread_title"`
+	ThreadID    int       `json:"thread_id"`
+	Content     string    `json:"content"` // markdown
+	Author      string    `json:"author"`
+	AuthorID    int       `json:"author_id"`
+	Publish     time.Time `json:"publish"`
+	LastEdit    NullTime  `json:"last_edit"`
+	// hidden posts were removed from view by an admin, optionally stating a reason
+	Hidden       bool   `json:"hidden"`
+	HiddenReason string `json:"hidden_reason,omitempty"`
+	// the users the post mentions, from the names the post mentions them by to their current names
+	Mentions map[string]string `json:"-"`
 }
 
-func (d DB) DeleteThread() {}
-func (d DB) MoveThread()   {}
+// deletes a thread together with all of its posts
+func (d DB) DeleteThread(threadid int) (finalErr error) {
+	ed := eout.Describe("delete thread")
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			log.Println(incomingErr, "rolling back")
+			finalErr = incomingErr
+			return true
+		}
+		return false
+	}
+	_, err = tx.Exec(`DELETE FROM mentions WHERE postid IN (SELECT id FROM posts WHERE threadid = ?)`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete mentions in thread %d", threadid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM posts WHERE threadid = ?`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete posts of thread %d", threadid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM thread_reads WHERE threadid = ?`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete reads of thread %d", threadid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM notifications WHERE threadid = ?`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete notifications about thread %d", threadid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM subscriptions WHERE threadid = ?`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete subscriptions to thread %d", threadid)) {
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM threads WHERE id = ?`, threadid)
+	if rollbackOnErr(ed.Eout(err, "delete thread %d", threadid)) {
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		rollbackOnErr(fmt.Errorf("delete thread: thread %d did not exist", threadid))
+		return
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// moves a thread into another topic
+func (d DB) MoveThread(threadid, topicid int) error {
+	ed := eout.Describe("move thread")
+	exists, err := d.CheckTopicExists(topicid)
+	if err != nil {
+		return ed.Eout(err, "check topic %d exists", topicid)
+	} else if !exists {
+		return fmt.Errorf("move thread: topic %d did not exist", topicid)
+	}
+	result, err := d.Exec(`UPDATE threads SET topicid = ? WHERE id = ?`, topicid, threadid)
+	if err != nil {
+		return ed.Eout(err, "move thread %d to topic %d", threadid, topicid)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("move thread: thread %d did not exist", threadid)
+	}
+	return nil
+}
 
 // TODO(2021-12-28): return error if non-existent thread
 func (d DB) GetThread(threadid int) ([]Post, error) {
+	// a negative limit returns all of the thread's posts
+	return d.getThreadPosts(threadid, -1, 0)
+}
+
+// get the posts shown on one page of a thread. pages are numbered from 1 and contain pageSize posts each
+func (d DB) GetThreadPage(threadid, page, pageSize int) ([]Post, error) {
+	if page < 1 {
+		page = 1
+	}
+	return d.getThreadPosts(threadid, pageSize, (page-1)*pageSize)
+}
+
+func (d DB) getThreadPosts(threadid, limit, offset int) ([]Post, error) {
 	// TODO: make edit work if no edit timestamp detected e.g.
 	// (sql: Scan error on column index 3, name "lastedit": unsupported Scan, storing driver.Value type <nil> into type
 	// *time.Time)
@@ -269,59 +631,99 @@ func (d DB) GetThread(threadid int) ([]Post, error) {
 	//    users table to get user name
 	//    threads table to get thread title
 	query := `
-  SELECT p.id, t.title, content, u.name, p.authorid, p.publishtime, p.lastedit
+  SELECT p.id, t.title, content, u.name, p.authorid, p.publishtime, p.lastedit, p.hidden, p.hiddenreason
   FROM posts p 
   INNER JOIN users u ON u.id = p.authorid 
   INNER JOIN threads t ON t.id = p.threadid
   WHERE threadid = ? 
-  ORDER BY p.publishtime
+  ORDER BY p.publishtime, p.id
+  LIMIT ? OFFSET ?
   `
 	stmt, err := d.db.Prepare(query)
 	eout.Check(err, "get thread: prepare query")
 	defer stmt.Close()
 
-	rows, err := stmt.Query(threadid)
+	rows, err := stmt.Query(threadid, limit, offset)
 	eout.Check(err, "get thread: query")
 	defer rows.Close()
 
 	var data Post
 	var posts []Post
+	var hiddenReason sql.NullString
 	for rows.Next() {
-		if err := rows.Scan(&data.ID, &data.ThreadTitle, &data.Content, &data.Author, &data.AuthorID, &data.Publish, &data.LastEdit); err != nil {
+		if err := rows.Scan(&data.ID, &data.ThreadTitle, &data.Content, &data.Author, &data.AuthorID, &data.Publish, &data.LastEdit, &data.Hidden, &hiddenReason); err != nil {
 			log.Fatalln(eout.Eout(err, "get data for thread %d", threadid))
 		}
+		data.HiddenReason = hiddenReason.String
 		posts = append(posts, data)
 	}
-	return posts, nil
+	err = d.setMentions(posts)
+	return posts, eout.Eout(err, "get mentions in thread %d", threadid)
+}
+
+func (d DB) CountThreadPosts(threadid int) (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT count(*) FROM posts WHERE threadid = ?`, threadid).Scan(&count)
+	return count, eout.Eout(err, "count posts of thread %d", threadid)
+}
+
+// get the page of a thread containing the post postid, with pages of pageSize posts. pages are numbered from 1
+func (d DB) GetPostPage(threadid, postid, pageSize int) (int, error) {
+	// count the posts listed before postid, using the same order as getThreadPosts
+	stmt := `
+  SELECT count(*) FROM posts p
+  INNER JOIN posts target ON target.id = ? AND target.threadid = p.threadid
+  WHERE p.threadid = ? AND (p.publishtime < target.publishtime OR (p.publishtime = target.publishtime AND p.id < target.id))
+  `
+	var before int
+	err := d.db.QueryRow(stmt, postid, threadid).Scan(&before)
+	if err != nil {
+		return 1, eout.Eout(err, "get page of post %d in thread %d", postid, threadid)
+	}
+	return before/pageSize + 1, nil
 }
 
 func (d DB) GetPost(postid int) (Post, error) {
 	stmt := `
-  SELECT p.id, t.title, t.id, content, u.name, p.authorid, p.publishtime, p.lastedit
+  SELECT p.id, t.title, t.id, content, u.name, p.authorid, p.publishtime, p.lastedit, p.hidden, p.hiddenreason
   FROM posts p 
   INNER JOIN users u ON u.id = p.authorid 
   INNER JOIN threads t ON t.id = p.threadid
   WHERE p.id = ?
   `
 	var data Post
-	err := d.db.QueryRow(stmt, postid).Scan(&data.ID, &data.ThreadTitle, &data.ThreadID, &data.Content, &data.Author, &data.AuthorID, &data.Publish, &data.LastEdit)
-	err = eout.Eout(err, "get data for thread %d", postid)
-	return data, err
+	var hiddenReason sql.NullString
+	err := d.db.QueryRow(stmt, postid).Scan(&data.ID, &data.ThreadTitle, &data.ThreadID, &data.Content, &data.Author, &data.AuthorID, &data.Publish, &data.LastEdit, &data.Hidden, &hiddenReason)
+	data.HiddenReason = hiddenReason.String
+	if err != nil {
+		return data, eout.Eout(err, "get data for thread %d", postid)
+	}
+	posts := []Post{data}
+	err = d.setMentions(posts)
+	return posts[0], eout.Eout(err, "get mentions in post %d", postid)
 }
 
 type Thread struct {
-	Title   string
-	Author  string
-	Slug    string
-	Private bool
-	ID      int
-	Show    bool // whether to show the thread in the thread index or not
-	Publish time.Time
-	PostID  int
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	Slug      string    `json:"slug"`
+	Private   bool      `json:"private"`
+	ID        int       `json:"id"`
+	TopicID   int       `json:"topic_id"`
+	TopicName string    `json:"topic_name"`
+	Publish   time.Time `json:"publish"`
+	PostID    int       `json:"post_id"`
+	PostCount int       `json:"post_count"`
+	// posts the reader hasn't seen yet, and the first of them. only set when listing threads for a reader, see
+	// ListThreadsOptions.ReaderID
+	Unread      int `json:"unread,omitempty"`
+	FirstUnread int `json:"first_unread,omitempty"`
 }
 
 var categoryPattern = regexp.MustCompile(`\[(.*?)\]`)
 
+// categories were the precursor to topics: a thread's category was whatever was written in [brackets] in its title.
+// nowadays only used when migrating old forums over to topics
 func (t Thread) GetCategory() string {
 	matches := categoryPattern.FindStringSubmatch(t.Title)
 	if matches == nil {
@@ -330,52 +732,119 @@ func (t Thread) GetCategory() string {
 	return matches[1]
 }
 
+type ListThreadsOptions struct {
+	SortByPost     bool
+	IncludePrivate bool
+	// leave out posts hidden by admins when determining each thread's latest post
+	ExcludeHiddenPosts bool
+	// only list threads belonging to the topics in TopicIDs. if empty, threads from all topics are listed
+	TopicIDs []int
+	// list at most Limit threads, skipping the first Offset. if Limit is 0, all threads are listed
+	Limit  int
+	Offset int
+	// if set, the unread posts of each thread are counted for the user with this id
+	ReaderID int
+	// the reader is an admin, who can read hidden posts. for everyone else, hidden posts never count as unread
+	ReaderIsAdmin bool
+}
+
 // get a list of threads
 // NOTE: this query is setting thread.Author not by thread creator, but latest poster. if this becomes a problem, revert
 // its use and employ Thread.PostID to perform another query for each thread to get the post author name (wrt server.go:GenerateRSS)
-func (d DB) ListThreads(sortByPost bool, includePrivate bool) []Thread {
+func (d DB) ListThreads(options ListThreadsOptions) []Thread {
 	query := `
-  SELECT count(t.id), t.title, t.id, t.private, u.name, p.publishtime, p.id FROM threads t
+  SELECT count(t.id), t.title, t.id, t.private, t.topicid, tp.name, u.name, p.publishtime, p.id FROM threads t
   INNER JOIN users u on u.id = p.authorid
   INNER JOIN posts p ON t.id = p.threadid
+  LEFT JOIN topics tp ON tp.id = t.topicid
   %s
   GROUP BY t.id
   %s
+  LIMIT ? OFFSET ?
   `
 	orderBy := `ORDER BY t.publishtime DESC`
 	// get a list of threads by ordering them based on most recent post
-	if sortByPost {
+	if options.SortByPost {
 		orderBy = `ORDER BY max(p.id) DESC`
 	}
-	where := `WHERE t.private = 0`
-	if includePrivate {
-		where = `WHERE t.private IN (0,1)`
-	}
+	where, args := listThreadsWhere(options)
 	query = fmt.Sprintf(query, where, orderBy)
+	// a negative limit lists all threads
+	limit := -1
+	if options.Limit > 0 {
+		limit = options.Limit
+	}
+	args = append(args, limit, options.Offset)
 
 	stmt, err := d.db.Prepare(query)
 	eout.Check(err, "list threads: prepare query")
 	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.Query(args...)
 	eout.Check(err, "list threads: query")
 	defer rows.Close()
 
 	var postCount int
 	var data Thread
 	var isPrivate int
+	var topicid sql.NullInt64
+	var topicName sql.NullString
 	var threads []Thread
 	for rows.Next() {
-		if err := rows.Scan(&postCount, &data.Title, &data.ID, &isPrivate, &data.Author, &data.Publish, &data.PostID); err != nil {
+		if err := rows.Scan(&postCount, &data.Title, &data.ID, &isPrivate, &topicid, &topicName, &data.Author, &data.Publish, &data.PostID); err != nil {
 			log.Fatalln(eout.Eout(err, "list threads: read in data via scan"))
 		}
 		data.Private = (isPrivate == 1)
+		data.TopicID = int(topicid.Int64)
+		data.TopicName = topicName.String
+		data.PostCount = postCount
 		data.Slug = util.GetThreadSlug(data.ID, data.Title, postCount)
 		threads = append(threads, data)
 	}
+	if options.ReaderID > 0 {
+		err = d.countUnread(options.ReaderID, options.ReaderIsAdmin, threads)
+		eout.Check(err, "list threads: count unread posts")
+	}
 	return threads
 }
 
+// count the threads ListThreads would list for options, disregarding options.Limit and options.Offset
+func (d DB) CountThreads(options ListThreadsOptions) int {
+	query := `
+  SELECT count(*) FROM (
+    SELECT t.id FROM threads t
+    INNER JOIN posts p ON t.id = p.threadid
+    %s
+    GROUP BY t.id
+  )
+  `
+	where, args := listThreadsWhere(options)
+	var count int
+	err := d.db.QueryRow(fmt.Sprintf(query, where), args...).Scan(&count)
+	eout.Check(err, "count threads: query")
+	return count
+}
+
+func listThreadsWhere(options ListThreadsOptions) (string, []interface{}) {
+	var args []interface{}
+	where := `WHERE t.private = 0`
+	if options.IncludePrivate {
+		where = `WHERE t.private IN (0,1)`
+	}
+	if options.ExcludeHiddenPosts {
+		where += ` AND p.hidden = 0`
+	}
+	if len(options.TopicIDs) > 0 {
+		placeholders := make([]string, 0, len(options.TopicIDs))
+		for _, topicid := range options.TopicIDs {
+			placeholders = append(placeholders, "?")
+			args = append(args, topicid)
+		}
+		where += fmt.Sprintf(` AND t.topicid IN (%s)`, strings.Join(placeholders, ","))
+	}
+	return where, args
+}
+
 func (d DB) IsThreadPrivate(threadid int) (bool, error) {
 	exists, err := d.CheckThreadExists(threadid)
 
@@ -390,19 +859,55 @@ func (d DB) IsThreadPrivate(threadid int) (bool, error) {
 	return private == 1, nil
 }
 
+// adds a reply to a thread, notifying the users it mentions and the thread's subscribers
 func (d DB) AddPost(content string, threadid, authorid int) (postID int) {
-	stmt := `INSERT INTO posts (content, publishtime, threadid, authorid) VALUES (?, ?, ?, ?) RETURNING id`
+	ed := eout.Describe("add post")
+	tx, err := d.db.Begin()
+	ed.Check(err, "start transaction")
 	publish := time.Now()
-	err := d.db.QueryRow(stmt, content, publish, threadid, authorid).Scan(&postID)
-	eout.Check(err, "add post to thread %d (author %d)", threadid, authorid)
+	postID, err = addPost(tx, content, threadid, authorid, publish)
+	if err == nil {
+		err = saveMentions(tx, postID, threadid, authorid, content, publish)
+	}
+	if err == nil {
+		err = notifySubscribers(tx, constants.NOTIFICATION_REPLY, threadid, postID, authorid, publish)
+	}
+	if err == nil && d.subscriptions.Repliers {
+		err = subscribe(tx, authorid, threadid)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		ed.Check(err, "add post to thread %d (author %d)", threadid, authorid)
+	}
+	ed.Check(tx.Commit(), "commit transaction")
 	return
 }
 
+// adds a post to a thread, published at publish, without notifying anyone. see AddPost
+func addPost(tx *sql.Tx, content string, threadid, authorid int, publish time.Time) (int, error) {
+	stmt := `INSERT INTO posts (content, publishtime, threadid, authorid) VALUES (?, ?, ?, ?) RETURNING id`
+	var postid int
+	err := tx.QueryRow(stmt, content, publish, threadid, authorid).Scan(&postid)
+	return postid, err
+}
+
+// edits a post, notifying the users mentioned by the edit who weren't mentioned before
 func (d DB) EditPost(content, title string, postid, threadid int) {
-	stmt := `UPDATE posts set content = ?, lastedit = ? WHERE id = ?`
+	ed := eout.Describe("edit post")
+	tx, err := d.db.Begin()
+	ed.Check(err, "start transaction")
+	stmt := `UPDATE posts set content = ?, lastedit = ? WHERE id = ? RETURNING authorid`
 	edit := time.Now()
-	_, err := d.Exec(stmt, content, edit, postid)
-	eout.Check(err, "edit post %d", postid)
+	var authorid int
+	err = tx.QueryRow(stmt, content, edit, postid).Scan(&authorid)
+	if err == nil {
+		err = saveMentions(tx, postid, threadid, authorid, content, edit)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		ed.Check(err, "edit post %d", postid)
+	}
+	ed.Check(tx.Commit(), "commit transaction")
 
 	stmt = `UPDATE threads set title = ? WHERE id = ?`
 	edit = time.Now()
@@ -410,46 +915,204 @@ func (d DB) EditPost(content, title string, postid, threadid int) {
 	eout.Check(err, "edit post title %d", postid)
 }
 
-func (d DB) DeletePost(postid int) error {
-	stmt := `DELETE FROM posts WHERE id = ?`
+func (d DB) DeletePost(postid int) (finalErr error) {
+	ed := eout.Describe("delete post")
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			log.Println(incomingErr, "rolling back")
+			finalErr = incomingErr
+			return true
+		}
+		return false
+	}
+	// nobody should be notified about a post that no longer exists
+	_, err = tx.Exec(`DELETE FROM notifications WHERE postid = ?`, postid)
+	if rollbackOnErr(ed.Eout(err, "delete notifications about post %d", postid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM mentions WHERE postid = ?`, postid)
+	if rollbackOnErr(ed.Eout(err, "delete mentions in post %d", postid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM posts WHERE id = ?`, postid)
+	if rollbackOnErr(ed.Eout(err, "delete post %d", postid)) {
+		return
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// hides a post from everyone but admins. the post's content is kept, so that hiding can be undone
+func (d DB) HidePost(postid int, reason string) error {
+	stmt := `UPDATE posts SET hidden = 1, hiddenreason = ? WHERE id = ?`
+	_, err := d.Exec(stmt, reason, postid)
+	return eout.Eout(err, "hiding post %d", postid)
+}
+
+func (d DB) UnhidePost(postid int) error {
+	stmt := `UPDATE posts SET hidden = 0, hiddenreason = NULL WHERE id = ?`
 	_, err := d.Exec(stmt, postid)
-	return eout.Eout(err, "deleting post %d", postid)
+	return eout.Eout(err, "unhiding post %d", postid)
+}
+
+type Topic struct {
+	ID          int
+	Name        string
+	Description string
+	ThreadCount int
 }
 
-func (d DB) CreateTopic(title, description string) {
-	stmt := `INSERT INTO topics (name, description) VALUES (?, ?)`
-	_, err := d.Exec(stmt, title, description)
-	eout.Check(err, "creating topic %s", title)
+func (d DB) CreateTopic(title, description string) (int, error) {
+	stmt := `INSERT INTO topics (name, description) VALUES (?, ?) RETURNING id`
+	var topicid int
+	err := d.db.QueryRow(stmt, title, description).Scan(&topicid)
+	if err != nil {
+		return -1, eout.Eout(err, "creating topic %s", title)
+	}
+	return topicid, nil
 }
 
-func (d DB) UpdateTopicName(topicid int, newname string) {
+func (d DB) UpdateTopicName(topicid int, newname string) error {
 	stmt := `UPDATE topics SET name = ? WHERE id = ?`
 	_, err := d.Exec(stmt, newname, topicid)
-	eout.Check(err, "changing topic %d's name to %s", topicid, newname)
+	return eout.Eout(err, "changing topic %d's name to %s", topicid, newname)
 }
 
-func (d DB) UpdateTopicDescription(topicid int, newdesc string) {
+func (d DB) UpdateTopicDescription(topicid int, newdesc string) error {
 	stmt := `UPDATE topics SET description = ? WHERE id = ?`
 	_, err := d.Exec(stmt, newdesc, topicid)
-	eout.Check(err, "changing topic %d's description to %s", topicid, newdesc)
+	return eout.Eout(err, "changing topic %d's description to %s", topicid, newdesc)
+}
+
+// deletes a topic. a thread may not be without a topic, so the threads of the deleted topic are first moved to the topic
+// with id moveToTopicid
+func (d DB) DeleteTopic(topicid, moveToTopicid int) (finalErr error) {
+	ed := eout.Describe("delete topic")
+	if topicid == moveToTopicid {
+		return fmt.Errorf("delete topic: can't move threads of topic %d into itself", topicid)
+	}
+	exists, err := d.CheckTopicExists(moveToTopicid)
+	if err != nil {
+		return ed.Eout(err, "check topic exists")
+	} else if !exists {
+		return fmt.Errorf("delete topic: topic %d to move threads to did not exist", moveToTopicid)
+	}
+
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			log.Println(incomingErr, "rolling back")
+			finalErr = incomingErr
+			return true
+		}
+		return false
+	}
+	if rollbackOnErr(ed.Eout(err, "start transaction")) {
+		return
+	}
+	_, err = tx.Exec(`UPDATE threads SET topicid = ? WHERE topicid = ?`, moveToTopicid, topicid)
+	if rollbackOnErr(ed.Eout(err, "move threads from topic %d to %d", topicid, moveToTopicid)) {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM topics WHERE id = ?`, topicid)
+	if rollbackOnErr(ed.Eout(err, "deleting topic %d", topicid)) {
+		return
+	}
+	err = tx.Commit()
+	ed.Check(err, "commit transaction")
+	return nil
 }
 
-func (d DB) DeleteTopic(topicid int) {
-	stmt := `DELETE FROM topics WHERE id = ?`
-	_, err := d.Exec(stmt, topicid)
-	eout.Check(err, "deleting topic %d", topicid)
+func (d DB) GetTopic(topicid int) (Topic, error) {
+	stmt := `
+  SELECT tp.id, tp.name, tp.description, count(t.id)
+  FROM topics tp
+  LEFT JOIN threads t ON t.topicid = tp.id
+  WHERE tp.id = ?
+  GROUP BY tp.id
+  `
+	var topic Topic
+	var description sql.NullString
+	err := d.db.QueryRow(stmt, topicid).Scan(&topic.ID, &topic.Name, &description, &topic.ThreadCount)
+	if err != nil {
+		return Topic{}, eout.Eout(err, "get topic %d", topicid)
+	}
+	topic.Description = description.String
+	return topic, nil
+}
+
+// returns all topics, ordered by name
+func (d DB) GetTopics() []Topic {
+	ed := eout.Describe("get topics")
+	query := `
+  SELECT tp.id, tp.name, tp.description, count(t.id)
+  FROM topics tp
+  LEFT JOIN threads t ON t.topicid = tp.id
+  GROUP BY tp.id
+  ORDER BY tp.name
+  `
+	rows, err := d.db.Query(query)
+	ed.Check(err, "run query")
+	defer rows.Close()
+
+	var topics []Topic
+	for rows.Next() {
+		var topic Topic
+		var description sql.NullString
+		err := rows.Scan(&topic.ID, &topic.Name, &description, &topic.ThreadCount)
+		ed.Check(err, "scanning loop")
+		topic.Description = description.String
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// the default topic is the oldest topic that still exists; new threads end up here if no topic was chosen
+func (d DB) GetDefaultTopicID() int {
+	var topicid int
+	err := d.db.QueryRow(`SELECT min(id) FROM topics`).Scan(&topicid)
+	eout.Check(err, "get default topic id")
+	return topicid
+}
+
+func (d DB) GetThreadTopic(threadid int) (Topic, error) {
+	var topicid int
+	err := d.db.QueryRow(`SELECT topicid FROM threads WHERE id = ?`, threadid).Scan(&topicid)
+	if err != nil {
+		return Topic{}, eout.Eout(err, "get topic of thread %d", threadid)
+	}
+	return d.GetTopic(topicid)
 }
 
 func (d DB) CreateUser(name, hash string) (int, error) {
-	stmt := `INSERT INTO users (name, passwordhash) VALUES (?, ?) RETURNING id`
-	var userid int
-	err := d.db.QueryRow(stmt, name, hash).Scan(&userid)
+	tx, err := d.db.Begin()
 	if err != nil {
+		return -1, eout.Eout(err, "start transaction")
+	}
+	userid, err := createUser(tx, name, hash)
+	if err != nil {
+		_ = tx.Rollback()
 		return -1, eout.Eout(err, "creating user %s", name)
 	}
+	if err = tx.Commit(); err != nil {
+		return -1, eout.Eout(err, "commit transaction")
+	}
 	return userid, nil
 }
 
+// adds a user as part of a larger transaction, see CreateUser
+func createUser(tx *sql.Tx, name, hash string) (int, error) {
+	stmt := `INSERT INTO users (name, passwordhash) VALUES (?, ?) RETURNING id`
+	var userid int
+	err := tx.QueryRow(stmt, name, hash).Scan(&userid)
+	return userid, err
+}
+
 func (d DB) GetUserID(name string) (int, error) {
 	stmt := `SELECT id FROM users where name = ?`
 	var userid int
@@ -470,6 +1133,22 @@ func (d DB) GetUsername(uid int) (string, error) {
 	return username, nil
 }
 
+// counts the threads started and the posts written by a user. threads and posts in private threads are only counted if
+// includePrivate is true
+func (d DB) CountUserActivity(userid int, includePrivate bool) (int, int, error) {
+	stmt := `
+  SELECT
+    (SELECT count(*) FROM threads WHERE authorid = ? AND (? OR private = 0)),
+    (SELECT count(*) FROM posts p INNER JOIN threads t ON t.id = p.threadid WHERE p.authorid = ? AND (? OR t.private = 0))
+  `
+	var threads, posts int
+	err := d.db.QueryRow(stmt, userid, includePrivate, userid, includePrivate).Scan(&threads, &posts)
+	if err != nil {
+		return 0, 0, eout.Eout(err, "count activity of user %d", userid)
+	}
+	return threads, posts, nil
+}
+
 func (d DB) GetPasswordHash(username string) (string, int, error) {
 	stmt := `SELECT passwordhash, id FROM users where name = ?`
 	var hash string
@@ -516,6 +1195,17 @@ func (d DB) CheckThreadExists(threadid int) (bool, error) {
 	return d.existsQuery(stmt, threadid)
 }
 
+func (d DB) CheckTopicExists(topicid int) (bool, error) {
+	stmt := `SELECT 1 FROM topics WHERE id = ?`
+	return d.existsQuery(stmt, topicid)
+}
+
+func (d DB) CheckTopicNameExists(name string) (bool, error) {
+	stmt := `SELECT 1 FROM topics WHERE name = ?`
+	return d.existsQuery(stmt, name)
+}
+
+// renames a user. posts mentioning the user are left as they are, and show the new name, see mentions.go
 func (d DB) UpdateUsername(userid int, newname string) {
 	stmt := `UPDATE users SET name = ? WHERE id = ?`
 	_, err := d.Exec(stmt, newname, userid)
@@ -526,6 +1216,10 @@ func (d DB) UpdateUserPasswordHash(userid int, newhash string) {
 	stmt := `UPDATE users SET passwordhash = ? WHERE id = ?`
 	_, err := d.Exec(stmt, newhash, userid)
 	eout.Check(err, "changing user %d's description to %s", userid, newhash)
+	// reset links requested before the password changed must not be able to undo the change
+	stmt = `DELETE FROM email_tokens WHERE userid = ? AND purpose = ?`
+	_, err = d.Exec(stmt, userid, constants.EMAIL_TOKEN_PASSWORD_RESET)
+	eout.Check(err, "invalidating user %d's password reset tokens", userid)
 }
 
 func (d DB) GetSystemUserID() int {
@@ -605,5 +1299,7 @@ func (d DB) ResetPassword(userid int) (string, error) {
 		return "", ed.Eout(err, "hash password")
 	}
 	d.UpdateUserPasswordHash(userid, passwordHash)
-	return newPassword, nil
+	// whoever knew the old password should not stay logged in
+	err = d.DeleteUserSessions(userid, "")
+	return newPassword, ed.Eout(err, "revoke sessions")
 }
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// a user's email address is optional. an address is only used once the user has confirmed it, by opening the link in
+// the verification email sent to it. links like that carry a token: a single-use secret, valid for a limited time, of
+// which only a hash is stored
+
+var ErrEmailTokenInvalid = errors.New("email token does not exist, has been used or has expired")
+
+type UserEmail struct {
+	// empty if the user has not added an email address
+	Address  string
+	Verified bool
+	// whether the user wants their notifications emailed to them
+	Notifications bool
+}
+
+func (d DB) GetUserEmail(userid int) (UserEmail, error) {
+	stmt := `SELECT email, emailverified, emailnotifications FROM users WHERE id = ?`
+	var email UserEmail
+	var address sql.NullString
+	err := d.db.QueryRow(stmt, userid).Scan(&address, &email.Verified, &email.Notifications)
+	email.Address = address.String
+	return email, eout.Eout(err, "get email of user %d", userid)
+}
+
+// sets the user's email address, which has to be verified before it is used. an empty address removes the user's
+// address. either way, the tokens sent to the previous address stop working
+func (d DB) SetUserEmail(userid int, address string) error {
+	ed := eout.Describe("set user email")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	var email interface{}
+	if address != "" {
+		email = address
+	}
+	_, err = tx.Exec(`UPDATE users SET email = ?, emailverified = 0 WHERE id = ?`, email, userid)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM email_tokens WHERE userid = ?`, userid)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return ed.Eout(err, "set email of user %d", userid)
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+func (d DB) SetEmailNotifications(userid int, enabled bool) error {
+	_, err := d.Exec(`UPDATE users SET emailnotifications = ? WHERE id = ?`, enabled, userid)
+	return eout.Eout(err, "set email notifications of user %d", userid)
+}
+
+// creates a token for sending to the user's email address, to be used for purpose (one of constants.EMAIL_TOKEN_*)
+// within validFor. only the token's hash is stored
+func (d DB) CreateEmailToken(userid, purpose int, address string, validFor time.Duration) (string, error) {
+	token := crypto.GenerateToken()
+	now := time.Now()
+	stmt := `INSERT INTO email_tokens (tokenhash, userid, purpose, email, created, expires) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := d.Exec(stmt, crypto.HashToken(token), userid, purpose, address, now, now.Add(validFor))
+	if err != nil {
+		return "", eout.Eout(err, "create email token for user %d", userid)
+	}
+	return token, nil
+}
+
+// uses up the token, returning the user and email address it was created for. ErrEmailTokenInvalid is returned for
+// tokens that don't exist, have been used already, have expired or were meant for another purpose
+func useEmailToken(tx *sql.Tx, token string, purpose int) (int, string, error) {
+	var userid int
+	var address string
+	var expires time.Time
+	stmt := `DELETE FROM email_tokens WHERE tokenhash = ? AND purpose = ? RETURNING userid, email, expires`
+	err := tx.QueryRow(stmt, crypto.HashToken(token), purpose).Scan(&userid, &address, &expires)
+	if err == sql.ErrNoRows || (err == nil && !time.Now().Before(expires)) {
+		return -1, "", ErrEmailTokenInvalid
+	}
+	return userid, address, eout.Eout(err, "use email token")
+}
+
+// marks the address a verification token was sent to as verified, returning the user it belongs to. a token for an
+// address the user has since replaced is invalid
+func (d DB) VerifyUserEmail(token string) (int, error) {
+	ed := eout.Describe("verify user email")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, ed.Eout(err, "start transaction")
+	}
+	userid, address, err := useEmailToken(tx, token, constants.EMAIL_TOKEN_VERIFY)
+	var result sql.Result
+	if err == nil {
+		result, err = tx.Exec(`UPDATE users SET emailverified = 1 WHERE id = ? AND email = ?`, userid, address)
+	}
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = ErrEmailTokenInvalid
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		if err == ErrEmailTokenInvalid {
+			return -1, err
+		}
+		return -1, ed.Eout(err, "verify email")
+	}
+	return userid, ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// counts the user's unused tokens for purpose that were created after since
+func (d DB) CountEmailTokens(userid, purpose int, since time.Time) (int, error) {
+	stmt := `SELECT count(*) FROM email_tokens WHERE userid = ? AND purpose = ? AND created > ?`
+	var count int
+	err := d.db.QueryRow(stmt, userid, purpose, since).Scan(&count)
+	return count, eout.Eout(err, "count email tokens of user %d", userid)
+}
+
+// reports whether the token can be used for purpose, without using it up
+func (d DB) CheckEmailToken(token string, purpose int) (bool, error) {
+	stmt := `SELECT 1 FROM email_tokens WHERE tokenhash = ? AND purpose = ? AND expires > ?`
+	return d.existsQuery(stmt, crypto.HashToken(token), purpose, time.Now())
+}
+
+// sets the password of the user a password reset token was sent to, returning the user. the token only works while the
+// address it was sent to is still the user's verified address. all of the user's sessions are logged out, and their
+// other reset tokens stop working
+func (d DB) ResetPasswordWithToken(token, passwordHash string) (int, error) {
+	ed := eout.Describe("reset password with token")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, ed.Eout(err, "start transaction")
+	}
+	userid, address, err := useEmailToken(tx, token, constants.EMAIL_TOKEN_PASSWORD_RESET)
+	var result sql.Result
+	if err == nil {
+		stmt := `UPDATE users SET passwordhash = ? WHERE id = ? AND email = ? AND emailverified = 1`
+		result, err = tx.Exec(stmt, passwordHash, userid, address)
+	}
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = ErrEmailTokenInvalid
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM email_tokens WHERE userid = ? AND purpose = ?`, userid, constants.EMAIL_TOKEN_PASSWORD_RESET)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM sessions WHERE userid = ?`, userid)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		if err == ErrEmailTokenInvalid {
+			return -1, err
+		}
+		return -1, ed.Eout(err, "set password")
+	}
+	return userid, ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// a notification that is to be emailed
+type NotificationEmail struct {
+	Notification
+	// the recipient
+	Username string
+	Address  string
+}
+
+// returns the unread notifications created after since that haven't been emailed yet, for the users who want their
+// notifications emailed and have a verified address
+func (d DB) GetPendingNotificationEmails(since time.Time) ([]NotificationEmail, error) {
+	ed := eout.Describe("get pending notification emails")
+	query := `
+  SELECT n.id, n.kind, n.threadid, t.title, n.postid, coalesce(a.name, ?), n.created, u.name, u.email
+  FROM notifications n
+  INNER JOIN users u ON u.id = n.userid
+  INNER JOIN threads t ON t.id = n.threadid
+  LEFT JOIN users a ON a.id = n.actorid
+  WHERE n.emailed = 0 AND n.read = 0 AND n.created > ? AND u.emailverified = 1 AND u.emailnotifications = 1
+  ORDER BY n.id
+  `
+	rows, err := d.db.Query(query, DELETED_USER_NAME, since)
+	if err != nil {
+		return nil, ed.Eout(err, "query notifications")
+	}
+	defer rows.Close()
+	var emails []NotificationEmail
+	for rows.Next() {
+		var e NotificationEmail
+		err := rows.Scan(&e.ID, &e.Kind, &e.ThreadID, &e.ThreadTitle, &e.PostID, &e.Actor, &e.Created, &e.Username, &e.Address)
+		if err != nil {
+			return nil, ed.Eout(err, "scan notifications")
+		}
+		emails = append(emails, e)
+	}
+	return emails, ed.Eout(rows.Err(), "iterate notifications")
+}
+
+func (d DB) MarkNotificationEmailed(id int) error {
+	_, err := d.Exec(`UPDATE notifications SET emailed = 1 WHERE id = ?`, id)
+	return eout.Eout(err, "mark notification %d emailed", id)
+}
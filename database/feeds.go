@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// selects the posts of a feed, see server/feeds.go. posts in private threads and posts hidden by admins are never
+// listed, whatever the options
+type FeedOptions struct {
+	// list only the posts of a thread, of the threads in a topic, or of an author. ignored when 0
+	ThreadID int
+	TopicID  int
+	AuthorID int
+	// list at most Limit posts
+	Limit int
+}
+
+// lists the posts of a feed, latest first
+func (d DB) ListFeedPosts(options FeedOptions) ([]Post, error) {
+	ed := eout.Describe("list feed posts")
+	where := []string{"t.private = 0", "p.hidden = 0"}
+	var args []interface{}
+	if options.ThreadID != 0 {
+		where = append(where, "p.threadid = ?")
+		args = append(args, options.ThreadID)
+	}
+	if options.TopicID != 0 {
+		where = append(where, "t.topicid = ?")
+		args = append(args, options.TopicID)
+	}
+	if options.AuthorID != 0 {
+		where = append(where, "p.authorid = ?")
+		args = append(args, options.AuthorID)
+	}
+	query := `
+  SELECT p.id, t.id, t.title, p.content, u.name, p.authorid, p.publishtime, p.lastedit
+  FROM posts p
+  INNER JOIN users u ON u.id = p.authorid
+  INNER JOIN threads t ON t.id = p.threadid
+  WHERE %s
+  ORDER BY p.publishtime DESC, p.id DESC
+  LIMIT ?
+  `
+	args = append(args, options.Limit)
+	rows, err := d.db.Query(fmt.Sprintf(query, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return nil, ed.Eout(err, "query posts")
+	}
+	defer rows.Close()
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(&post.ID, &post.ThreadID, &post.ThreadTitle, &post.Content, &post.Author, &post.AuthorID, &post.Publish, &post.LastEdit)
+		if err != nil {
+			return nil, ed.Eout(err, "scan posts")
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ed.Eout(err, "iterate posts")
+	}
+	return posts, ed.Eout(d.setMentions(posts), "get mentions in posts")
+}
+
+// returns the title of a thread that may appear in feeds: private threads are reported as missing, like threads that
+// don't exist
+func (d DB) GetFeedThreadTitle(threadid int) (string, error) {
+	var title string
+	err := d.db.QueryRow(`SELECT title FROM threads WHERE id = ? AND private = 0`, threadid).Scan(&title)
+	return title, eout.Eout(err, "get title of thread %d", threadid)
+}
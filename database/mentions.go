@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/util"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// mentions are stored as the ids of the users a post mentions, along with the names the post mentions them by, rather
+// than by name alone, so that a mention keeps pointing at the same user after they have been renamed. posts are left as
+// they were written: a mention is shown with the current name of the user it points at, see Post.Mentions
+
+// records who a post mentions, replacing what was recorded for earlier versions of the post, and notifies users who are
+// mentioned for the first time. a name the post mentioned before keeps pointing at the same user, even if they have
+// been renamed since. mentions of users that don't exist are ignored
+func saveMentions(tx *sql.Tx, postid, threadid, authorid int, content string, created time.Time) error {
+	ed := eout.Describe("save mentions")
+	previous := make(map[int]bool)
+	previousNames := make(map[string]int)
+	rows, err := tx.Query(`SELECT userid, name FROM mentions WHERE postid = ?`, postid)
+	if err != nil {
+		return ed.Eout(err, "query mentions of post %d", postid)
+	}
+	for rows.Next() {
+		var userid int
+		var name string
+		if err := rows.Scan(&userid, &name); err != nil {
+			rows.Close()
+			return ed.Eout(err, "scan mentions of post %d", postid)
+		}
+		previous[userid] = true
+		previousNames[name] = userid
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return ed.Eout(err, "iterate mentions of post %d", postid)
+	}
+
+	_, err = tx.Exec(`DELETE FROM mentions WHERE postid = ?`, postid)
+	if err != nil {
+		return ed.Eout(err, "clear mentions of post %d", postid)
+	}
+	for _, name := range util.ParseMentions(content) {
+		if name == SYSTEM_USER_NAME || name == DELETED_USER_NAME {
+			continue
+		}
+		userid, ok := previousNames[name]
+		if !ok {
+			err := tx.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&userid)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return ed.Eout(err, "look up mentioned user %s", name)
+			}
+		}
+		_, err = tx.Exec(`INSERT OR IGNORE INTO mentions (postid, userid, name) VALUES (?, ?, ?)`, postid, userid, name)
+		if err != nil {
+			return ed.Eout(err, "add mention of user %d in post %d", userid, postid)
+		}
+		// mentioning yourself, or someone again in an edit, doesn't notify anyone
+		if userid == authorid || previous[userid] {
+			continue
+		}
+		stmt := `INSERT INTO notifications (userid, kind, threadid, postid, actorid, created) VALUES (?, ?, ?, ?, ?, ?)`
+		_, err = tx.Exec(stmt, userid, constants.NOTIFICATION_MENTION, threadid, postid, authorid, created)
+		if err != nil {
+			return ed.Eout(err, "notify user %d about mention in post %d", userid, postid)
+		}
+	}
+	return nil
+}
+
+// sets the Mentions of posts, looking up the mentions of all of them at once
+func (d DB) setMentions(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(posts))
+	for i, post := range posts {
+		args[i] = post.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	stmt := fmt.Sprintf(`
+  SELECT m.postid, m.name, u.name FROM mentions m
+  INNER JOIN users u ON u.id = m.userid
+  WHERE m.postid IN (%s)
+  `, placeholders)
+	rows, err := d.db.Query(stmt, args...)
+	if err != nil {
+		return eout.Eout(err, "query mentions of %d posts", len(posts))
+	}
+	defer rows.Close()
+	mentions := make(map[int]map[string]string)
+	for rows.Next() {
+		var postid int
+		var name, current string
+		if err := rows.Scan(&postid, &name, &current); err != nil {
+			return eout.Eout(err, "scan mentions")
+		}
+		if mentions[postid] == nil {
+			mentions[postid] = make(map[string]string)
+		}
+		mentions[postid][name] = current
+	}
+	for i := range posts {
+		posts[i].Mentions = mentions[posts[i].ID]
+	}
+	return eout.Eout(rows.Err(), "iterate mentions")
+}
+
+// returns who content would mention if it was saved as a post that used to mention previous, in the same form as
+// Post.Mentions: names from previous keep pointing at the same users, and other names are of the users with that name.
+// used for text that isn't saved as a post, such as bios and previews of edits
+func (d DB) ResolveMentions(content string, previous map[string]string) (map[string]string, error) {
+	mentions := make(map[string]string)
+	var args []interface{}
+	for _, name := range util.ParseMentions(content) {
+		if current, ok := previous[name]; ok {
+			mentions[name] = current
+		} else if name != SYSTEM_USER_NAME && name != DELETED_USER_NAME {
+			args = append(args, name)
+		}
+	}
+	if len(args) == 0 {
+		return mentions, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := d.db.Query(fmt.Sprintf(`SELECT name FROM users WHERE name IN (%s)`, placeholders), args...)
+	if err != nil {
+		return mentions, eout.Eout(err, "query mentioned users")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return mentions, eout.Eout(err, "scan mentioned users")
+		}
+		mentions[name] = name
+	}
+	return mentions, eout.Eout(rows.Err(), "iterate mentioned users")
+}
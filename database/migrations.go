@@ -1,7 +1,6 @@
 package database
 
 import (
-	"context"
 	"database/sql"
 	"encoding/base64"
 	"errors"
@@ -11,8 +10,153 @@ import (
 	"log"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// a migration changes the database schema, or the data within it, from one schema version to the next. migrations
+// must be idempotent: databases from before schema versioning was introduced may already have had some of them applied
+// by hand (using `cerca migrate -migration <name>`), and all of them are re-run for such databases.
+type Migration struct {
+	// the schema version of a database after the migration has been applied
+	Version int
+	// the name used to refer to the migration from the command line, see `cerca migrate --list`
+	Name        string
+	Description string
+	migrate     func(tx *sql.Tx) error
+}
+
+// all migrations, ordered by version. when changing the schema: add a new migration at the end of the list, and update
+// the CREATE TABLE statements in createTables so that new databases get the same schema
+var migrations = []Migration{
+	{1, "2024-01-password-hash-migration", "store password hashes in the format of the new argon2 library", Migration20240116_PwhashChange},
+	{2, "2024-02-thread-private-migration", "add column threads.private for private threads", Migration20240720_ThreadPrivateChange},
+	{3, "2026-10-topics-migration", "create topics from the [categories] in thread titles", Migration20261017_TopicsFromCategories},
+	{4, "2026-10-modlog-subject-migration", "add columns moderation_log.subjectid and moderation_log.note", Migration20261017_ModerationLogSubject},
+	{5, "2026-10-hidden-posts-migration", "add columns posts.hidden and posts.hiddenreason for hiding posts", Migration20261017_HiddenPosts},
+	{6, "2026-10-user-bio-migration", "add column users.bio for profile pages", Migration20261017_UserBio},
+	{7, "2026-10-email-migration", "add columns users.email, users.emailverified, users.emailnotifications and notifications.emailed", Migration20261017_Email},
+}
+
+// the schema version of databases created by this version of cerca
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+func Migrations() []Migration {
+	return append([]Migration{}, migrations...)
+}
+
+// databases created before schema versioning have no schemaversion stored and are at version 0
+func getSchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(`SELECT max(schemaversion) FROM meta`).Scan(&version)
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return 0, nil
+	}
+	return int(version.Int64), eout.Eout(err, "get schema version")
+}
+
+func setSchemaVersion(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec(`DELETE FROM meta`); err != nil {
+		return eout.Eout(err, "clear schema version")
+	}
+	_, err := tx.Exec(`INSERT INTO meta (schemaversion) VALUES (?)`, version)
+	return eout.Eout(err, "set schema version %d", version)
+}
+
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	return count > 0, eout.Eout(err, "check if table %s exists", table)
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	return count > 0, eout.Eout(err, "check if column %s.%s exists", table, column)
+}
+
+// adds a column to a table, unless the column already exists
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return eout.Eout(err, "add column %s.%s", table, column)
+}
+
+// brings an existing database up to SchemaVersion. a backup of the database is written next to it before any
+// migration runs, and all pending migrations are applied in a single transaction
+func (d DB) migrate(filepath string, version int) (finalErr error) {
+	ed := eout.Describe("migrate database")
+	if version >= SchemaVersion {
+		return nil
+	}
+	backup := fmt.Sprintf("%s.backup-v%d-%s", filepath, version, time.Now().Format("20060102-150405"))
+	if _, err := d.db.Exec(`VACUUM INTO ?`, backup); err != nil {
+		return ed.Eout(err, "back up database to %s", backup)
+	}
+	log.Printf("migrating database from schema version %d to %d (backup: %s)\n", version, SchemaVersion, backup)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			log.Println(incomingErr, "\nrolling back")
+			finalErr = incomingErr
+			return true
+		}
+		return false
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		log.Printf("applying migration %d: %s\n", m.Version, m.Name)
+		if rollbackOnErr(ed.Eout(m.migrate(tx), "migration %s", m.Name)) {
+			return
+		}
+	}
+	if rollbackOnErr(setSchemaVersion(tx, SchemaVersion)) {
+		return
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// runs a single migration, regardless of the database's schema version
+func RunMigration(filepath, name string) (finalErr error) {
+	d := InitDB(filepath)
+	for _, m := range migrations {
+		if m.Name != name {
+			continue
+		}
+		tx, err := d.db.Begin()
+		if err != nil {
+			return eout.Eout(err, "start transaction")
+		}
+		if err = m.migrate(tx); err != nil {
+			_ = tx.Rollback()
+			log.Println(err, "\nrolling back")
+			return err
+		}
+		return eout.Eout(tx.Commit(), "commit migration %s", name)
+	}
+	return fmt.Errorf("no migration named %q", name)
+}
+
+// returns the schema version of the database at filepath, without migrating it
+func GetSchemaVersion(filepath string) (int, error) {
+	db, err := sql.Open("sqlite3", filepath)
+	if err != nil {
+		return 0, eout.Eout(err, "open database %s", filepath)
+	}
+	defer db.Close()
+	return getSchemaVersion(db)
+}
+
 /* switched argon2 library to support 32 bit due to flaw in previous library.
 * change occurred in commits:
   68a689612547ff83225f9a2727cf0c14dfbf7ceb
@@ -59,8 +203,7 @@ import (
 	$argon2id$v=19$m=65536,t=3,p=4$222222222222222222222222222222222222222222222222222222222222222222
 */
 
-func Migration20240116_PwhashChange(filepath string) (finalErr error) {
-	d := InitDB(filepath)
+func Migration20240116_PwhashChange(tx *sql.Tx) error {
 	ed := eout.Describe("pwhash migration")
 
 	// the encoding defined in the old hashing library for string representations
@@ -87,35 +230,6 @@ func Migration20240116_PwhashChange(filepath string) (finalErr error) {
 	newRegex, err := regexp.Compile(newArgonPattern)
 	ed.Check(err, "failed to compile new argon encoding pattern")
 
-	// always perform migrations in a single transaction
-	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
-	rollbackOnErr := func(incomingErr error) bool {
-		if incomingErr != nil {
-			_ = tx.Rollback()
-			log.Println(incomingErr, "\nrolling back")
-			finalErr = incomingErr
-			return true
-		}
-		return false
-	}
-
-	// check table meta's schemaversion to see that it's empty (because i didn't set it initially X)
-	row := tx.QueryRow(`SELECT schemaversion FROM meta`)
-	placeholder := -1
-	err = row.Scan(&placeholder)
-	// we *want* to have no rows
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		if rollbackOnErr(err) {
-			return
-		}
-	}
-	// in this migration, we should *not* have any schemaversion set
-	if placeholder > 0 {
-		if rollbackOnErr(errors.New("schemaversion existed! there's a high likelihood that this migration has already been performed - exiting")) {
-			return
-		}
-	}
-
 	// alright onwards to the beesknees
 	// data struct to keep passwords and ids together - dont wanna mix things up now do we
 	type HashRecord struct {
@@ -129,20 +243,19 @@ func Migration20240116_PwhashChange(filepath string) (finalErr error) {
 	// get all password hashes and the id of their row
 	query := `SELECT id, passwordhash FROM users`
 	rows, err := tx.Query(query)
-	if rollbackOnErr(err) {
-		return
+	if err != nil {
+		return ed.Eout(err, "query password hashes")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var record HashRecord
 		err = rows.Scan(&record.id, &record.oldFormat)
-		if rollbackOnErr(err) {
-			return
+		if err != nil {
+			return ed.Eout(err, "scan password hash")
 		}
 		if record.id == 0 {
-			if rollbackOnErr(errors.New("record id was not changed during scanning")) {
-				return
-			}
+			return errors.New("record id was not changed during scanning")
 		}
 		records = append(records, record)
 	}
@@ -154,16 +267,22 @@ func Migration20240116_PwhashChange(filepath string) (finalErr error) {
 		matches := oldRegex.FindAllStringSubmatch(records[i].oldFormat, -1)
 		if len(matches) > 0 {
 			time, err := strconv.Atoi(matches[0][TIME_INDEX])
-			rollbackOnErr(err)
+			if err != nil {
+				return ed.Eout(err, "parse time parameter")
+			}
 			salt := matches[0][SALT_INDEX]
 			hash := matches[0][HASH_INDEX]
 
 			// decode the old format's had a custom encoding t
 			// the correctly access the underlying buffers
 			saltBuf, err := encoding.DecodeString(salt)
-			eout.Check(err, "decode salt using old format encoding")
+			if err != nil {
+				return ed.Eout(err, "decode salt using old format encoding")
+			}
 			hashBuf, err := encoding.DecodeString(hash)
-			eout.Check(err, "decode hash using old format encoding")
+			if err != nil {
+				return ed.Eout(err, "decode hash using old format encoding")
+			}
 
 			config.TimeCost = uint32(time) // note this change, to match old time cost (necessary!)
 			raw := argon2.Raw{Config: config, Salt: saltBuf, Hash: hashBuf}
@@ -171,9 +290,7 @@ func Migration20240116_PwhashChange(filepath string) (finalErr error) {
 			newFormatEncoded := raw.Encode()
 			ok := newRegex.Match(newFormatEncoded)
 			if !ok {
-				if rollbackOnErr(errors.New("newly formed format doesn't match regex for new pattern")) {
-					return
-				}
+				return errors.New("newly formed format doesn't match regex for new pattern")
 			}
 			records[i].newFormat = string(newFormatEncoded)
 			records[i].valid = true
@@ -183,65 +300,137 @@ func Migration20240116_PwhashChange(filepath string) (finalErr error) {
 			ok := newRegex.MatchString(records[i].oldFormat)
 			if !ok {
 				// can't parse with regex matching old format or the new format
-				if rollbackOnErr(errors.New(fmt.Sprintf("unknown record format: %s", records[i].oldFormat))) {
-					return
-				}
+				return errors.New(fmt.Sprintf("unknown record format for user %d", records[i].id))
 			}
 		}
 	}
 
-	fmt.Println(records)
-
-	fmt.Println("parsed and re-encoded all valid records from the old to the new format. proceeding to update database records")
 	for _, record := range records {
 		if !record.valid {
 			continue
 		}
 		// update each row with the password hash in the new format
-		stmt, err := tx.Prepare("UPDATE users SET passwordhash = ? WHERE id = ?")
-		defer stmt.Close()
-		_, err = stmt.Exec(record.newFormat, record.id)
-		if rollbackOnErr(err) {
-			return
+		_, err = tx.Exec("UPDATE users SET passwordhash = ? WHERE id = ?", record.newFormat, record.id)
+		if err != nil {
+			return ed.Eout(err, "update password hash of user %d", record.id)
 		}
 	}
-	fmt.Println("all records were updated without any error")
-	// when everything is done and dudsted insert schemaversion and set its value to 1
-	// _, err = tx.Exec(`INSERT INTO meta (schemaversion) VALUES (1)`)
-	// if rollbackOnErr(err) {
-	// 	return
-	// }
-	_ = tx.Commit()
-	return
+	return nil
 }
 
-func Migration20240720_ThreadPrivateChange(filepath string) (finalErr error) {
-	d := InitDB(filepath)
+func Migration20240720_ThreadPrivateChange(tx *sql.Tx) error {
+	return addColumn(tx, "threads", "private", "INTEGER NOT NULL DEFAULT 0")
+}
 
-	// always perform migrations in a single transaction
-	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
-	rollbackOnErr := func(incomingErr error) bool {
-		if incomingErr != nil {
-			_ = tx.Rollback()
-			log.Println(incomingErr, "\nrolling back")
-			finalErr = incomingErr
-			return true
-		}
-		return false
+// before topics were in use, threads were grouped into categories by writing the category in [brackets] as part of the
+// thread title. this migration creates a topic for every such category and moves the categorized threads into it.
+// threads without a category remain in the default topic. thread titles are left as they are
+func Migration20261017_TopicsFromCategories(tx *sql.Tx) error {
+	ed := eout.Describe("topics migration")
+	// make sure the default topic exists; it receives all threads without a category
+	_, err := tx.Exec(`INSERT INTO topics (name, description) SELECT ?, '' WHERE NOT EXISTS (SELECT 1 FROM topics)`, DEFAULT_TOPIC_NAME)
+	if err != nil {
+		return ed.Eout(err, "create default topic")
+	}
+	var defaultTopicid int
+	if err = tx.QueryRow(`SELECT min(id) FROM topics`).Scan(&defaultTopicid); err != nil {
+		return ed.Eout(err, "get default topic")
 	}
 
-	stmt := `ALTER TABLE threads
-  ADD COLUMN private INTEGER NOT NULL DEFAULT 0
-	`
-
-	_, err = tx.Exec(stmt)
+	// the thread index compared categories in lowercase, so we do the same when creating topics
+	topics := make(map[string]int)
+	rows, err := tx.Query(`SELECT id, name FROM topics`)
 	if err != nil {
-		if rollbackOnErr(err) {
-			return
+		return ed.Eout(err, "query topics")
+	}
+	for rows.Next() {
+		var topicid int
+		var name string
+		if err = rows.Scan(&topicid, &name); err != nil {
+			rows.Close()
+			return ed.Eout(err, "scan topics")
 		}
+		topics[strings.ToLower(name)] = topicid
 	}
+	rows.Close()
 
-	_ = tx.Commit()
+	// threads which were already moved to a topic other than the default one are left where they are
+	var threads []Thread
+	rows, err = tx.Query(`SELECT id, title FROM threads WHERE topicid IS NULL OR topicid = ? OR topicid NOT IN (SELECT id FROM topics)`, defaultTopicid)
+	if err != nil {
+		return ed.Eout(err, "query threads")
+	}
+	for rows.Next() {
+		var thread Thread
+		if err = rows.Scan(&thread.ID, &thread.Title); err != nil {
+			rows.Close()
+			return ed.Eout(err, "scan threads")
+		}
+		threads = append(threads, thread)
+	}
+	rows.Close()
 
+	for _, thread := range threads {
+		topicid := defaultTopicid
+		category := ""
+		if categoryPattern.MatchString(thread.Title) {
+			category = strings.ToLower(strings.TrimSpace(thread.GetCategory()))
+		}
+		if category != "" {
+			var exists bool
+			if topicid, exists = topics[category]; !exists {
+				err = tx.QueryRow(`INSERT INTO topics (name, description) VALUES (?, ?) RETURNING id`, category, "").Scan(&topicid)
+				if err != nil {
+					return ed.Eout(err, "create topic %q", category)
+				}
+				topics[category] = topicid
+				log.Printf("created topic %q (id %d)\n", category, topicid)
+			}
+		}
+		_, err = tx.Exec(`UPDATE threads SET topicid = ? WHERE id = ?`, topicid, thread.ID)
+		if err != nil {
+			return ed.Eout(err, "set topic of thread %d", thread.ID)
+		}
+	}
+	log.Printf("assigned topics to %d threads\n", len(threads))
 	return nil
 }
+
+// moderation log entries can now refer to something other than a user, such as a deleted or moved thread. the
+// subjectid column stores the id of the affected item and the note column a short description of it
+func Migration20261017_ModerationLogSubject(tx *sql.Tx) error {
+	if err := addColumn(tx, "moderation_log", "subjectid", "INTEGER"); err != nil {
+		return err
+	}
+	return addColumn(tx, "moderation_log", "note", "TEXT")
+}
+
+// admins can hide posts, replacing them with a placeholder for everyone else. this adds the columns keeping track of
+// hidden posts and the reason they were hidden
+func Migration20261017_HiddenPosts(tx *sql.Tx) error {
+	if err := addColumn(tx, "posts", "hidden", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumn(tx, "posts", "hiddenreason", "TEXT")
+}
+
+// users can describe themselves with a short bio shown on their profile page
+func Migration20261017_UserBio(tx *sql.Tx) error {
+	return addColumn(tx, "users", "bio", "TEXT")
+}
+
+// users can add an email address to their account, and have their notifications emailed to them once the address is
+// confirmed. notifications.emailed keeps track of which notifications have been emailed
+func Migration20261017_Email(tx *sql.Tx) error {
+	userColumns := [][2]string{
+		{"email", "TEXT"},
+		{"emailverified", "INTEGER NOT NULL DEFAULT 0"},
+		{"emailnotifications", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, column := range userColumns {
+		if err := addColumn(tx, "users", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+	return addColumn(tx, "notifications", "emailed", "INTEGER NOT NULL DEFAULT 0")
+}
@@ -97,9 +97,22 @@ func (d DB) RemoveUser(userid int, options RemoveUserOptions) (finalErr error) {
 		rawTriples = append(rawTriples, Triplet{"modlog stmt#1", "UPDATE moderation_log SET recipientid = ? WHERE recipientid = ?", []any{deletedUserID, userid}})
 		rawTriples = append(rawTriples, Triplet{"modlog stmt#2", "UPDATE moderation_log SET actingid= ? WHERE actingid = ?", []any{deletedUserID, userid}})
 		rawTriples = append(rawTriples, Triplet{"registrations stmt", "DELETE FROM registrations where userid = ?", []any{userid}})
+		rawTriples = append(rawTriples, Triplet{"notifications stmt#1", "UPDATE notifications SET actorid = ? WHERE actorid = ?", []any{deletedUserID, userid}})
 	}
 
+	/* REMOVING READING HISTORY, NOTIFICATIONS AND MENTIONS */
+	rawTriples = append(rawTriples, Triplet{"thread reads stmt", "DELETE FROM thread_reads WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"subscriptions stmt", "DELETE FROM subscriptions WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"notifications stmt#2", "DELETE FROM notifications WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"mentions stmt", "DELETE FROM mentions WHERE userid = ?", []any{userid}})
+
 	/* REMOVING CREDENTIALS */
+	rawTriples = append(rawTriples, Triplet{"api tokens stmt", "DELETE FROM api_tokens WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"sessions stmt", "DELETE FROM sessions WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"two-factor stmt", "DELETE FROM two_factor WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"recovery codes stmt", "DELETE FROM recovery_codes WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"email tokens stmt", "DELETE FROM email_tokens WHERE userid = ?", []any{userid}})
+	rawTriples = append(rawTriples, Triplet{"placeholders stmt", "DELETE FROM placeholders WHERE userid = ?", []any{userid}})
 	if !keepUsername {
 		// remove the account entirely
 		rawTriples = append(rawTriples, Triplet{"delete user stmt", "DELETE FROM users where id = ?", []any{userid}})
@@ -110,6 +123,7 @@ func (d DB) RemoveUser(userid int, options RemoveUserOptions) (finalErr error) {
 			return
 		}
 		rawTriples = append(rawTriples, Triplet{"nullify logins by replacing user password", "UPDATE users SET passwordhash = ? where id = ?", []any{throwawayPasswordHash, userid}})
+		rawTriples = append(rawTriples, Triplet{"forget email address", "UPDATE users SET email = NULL, emailverified = 0, emailnotifications = 0 where id = ?", []any{userid}})
 	}
 
 	var preparedStmts []*sql.Stmt
@@ -143,35 +157,79 @@ func (d DB) RemoveUser(userid int, options RemoveUserOptions) (finalErr error) {
 	return
 }
 
+// an entry that was just added to the moderation log, as passed to the function set with SetModerationHook
+type ModerationEvent struct {
+	ActingID int
+	// 0 if the action has no recipient
+	RecipientID int
+	Action      int // one of constants.MODLOG_*
+	// see AddModerationLogWithSubject; 0 if the action has no subject
+	SubjectID int
+	Note      string
+	// the admin who confirmed or vetoed a proposed action, and whether they confirmed it, see FinalizeProposedAction
+	QuorumID       int
+	QuorumDecision bool
+	Time           time.Time
+}
+
+// sets a function that is called with every entry added to the moderation log, once it has been added. the function
+// should return quickly, as it is called before the moderation action has been completed
+func (d *DB) SetModerationHook(hook func(ModerationEvent)) {
+	d.moderationHook = hook
+}
+
+func (d DB) moderationLogged(event ModerationEvent) {
+	if d.moderationHook != nil {
+		d.moderationHook(event)
+	}
+}
+
 func (d DB) AddModerationLog(actingid, recipientid, action int) error {
+	return d.AddModerationLogWithSubject(actingid, recipientid, action, -1, "")
+}
+
+// adds a moderation log entry for an action taken on something other than a user, such as a thread. subjectid is the
+// id of the affected item and note describes it, e.g. with a thread's title, so that the entry stays readable after
+// the item has been removed
+func (d DB) AddModerationLogWithSubject(actingid, recipientid, action, subjectid int, note string) error {
 	ed := eout.Describe("add moderation log")
 	t := time.Now()
+	var subject sql.NullInt64
+	if subjectid > 0 {
+		subject = sql.NullInt64{Int64: int64(subjectid), Valid: true}
+	}
 	// we have a recipient
 	var err error
 	if recipientid > 0 {
-		insert := `INSERT INTO moderation_log (actingid, recipientid, action, time) VALUES (?, ?, ?, ?)`
-		_, err = d.Exec(insert, actingid, recipientid, action, t)
+		insert := `INSERT INTO moderation_log (actingid, recipientid, action, time, subjectid, note) VALUES (?, ?, ?, ?, ?, ?)`
+		_, err = d.Exec(insert, actingid, recipientid, action, t, subject, note)
 	} else {
 		// we are not listing a recipient
-		insert := `INSERT INTO moderation_log (actingid, action, time) VALUES (?, ?, ?)`
-		_, err = d.Exec(insert, actingid, action, t)
+		insert := `INSERT INTO moderation_log (actingid, action, time, subjectid, note) VALUES (?, ?, ?, ?, ?)`
+		_, err = d.Exec(insert, actingid, action, t, subject, note)
 	}
 	if err = ed.Eout(err, "exec prepared statement"); err != nil {
 		return err
 	}
+	d.moderationLogged(ModerationEvent{ActingID: actingid, RecipientID: max(recipientid, 0), Action: action, SubjectID: max(subjectid, 0), Note: note, Time: t})
 	return nil
 }
 
 type ModerationEntry struct {
-	ActingUsername, RecipientUsername, QuorumUsername string
-	QuorumDecision                                    bool
-	Action                                            int
-	Time                                              time.Time
+	ActingUsername    string    `json:"acting_username"`
+	RecipientUsername string    `json:"recipient_username"`
+	QuorumUsername    string    `json:"quorum_username"`
+	QuorumDecision    bool      `json:"quorum_decision"`
+	Action            int       `json:"action"` // one of constants.MODLOG_*
+	Time              time.Time `json:"time"`
+	// SubjectID and Note are set for actions that concern something other than a user, see AddModerationLogWithSubject
+	SubjectID int    `json:"subject_id,omitempty"`
+	Note      string `json:"note,omitempty"`
 }
 
 func (d DB) GetModerationLogs() []ModerationEntry {
 	ed := eout.Describe("moderation log")
-	query := `SELECT uact.name, urecp.name, uquorum.name, q.decision, m.action, m.time 
+	query := `SELECT uact.name, urecp.name, uquorum.name, q.decision, m.action, m.time, m.subjectid, m.note
 	FROM moderation_LOG m 
 
 	LEFT JOIN users uact ON uact.id = m.actingid
@@ -195,7 +253,9 @@ func (d DB) GetModerationLogs() []ModerationEntry {
 		var entry ModerationEntry
 		var actingUsername, recipientUsername, quorumUsername sql.NullString
 		var quorumDecision sql.NullBool
-		if err := rows.Scan(&actingUsername, &recipientUsername, &quorumUsername, &quorumDecision, &entry.Action, &entry.Time); err != nil {
+		var subjectid sql.NullInt64
+		var note sql.NullString
+		if err := rows.Scan(&actingUsername, &recipientUsername, &quorumUsername, &quorumDecision, &entry.Action, &entry.Time, &subjectid, &note); err != nil {
 			ed.Check(err, "scanning loop")
 		}
 		if actingUsername.Valid {
@@ -210,6 +270,8 @@ func (d DB) GetModerationLogs() []ModerationEntry {
 		if quorumDecision.Valid {
 			entry.QuorumDecision = quorumDecision.Bool
 		}
+		entry.SubjectID = int(subjectid.Int64)
+		entry.Note = note.String
 		logs = append(logs, entry)
 	}
 	return logs
@@ -272,6 +334,7 @@ func (d DB) ProposeModerationAction(proposerid, recipientid, action int) (finalE
 
 	err = tx.Commit()
 	ed.Check(err, "commit transaction")
+	d.moderationLogged(ModerationEvent{ActingID: proposerid, RecipientID: recipientid, Action: action, Time: t})
 	return
 }
 
@@ -416,6 +479,7 @@ func (d DB) FinalizeProposedAction(proposalid, adminid int, decision bool) (fina
 
 	err = tx.Commit()
 	ed.Check(err, "commit transaction")
+	d.moderationLogged(ModerationEvent{ActingID: proposerid, RecipientID: recipientid, Action: action, QuorumID: adminid, QuorumDecision: decision, Time: t})
 
 	// the decision was to veto the proposal: there's nothing more to do! except return outta this function ofc ofc
 	if decision == constants.PROPOSAL_VETO {
@@ -535,12 +599,12 @@ func (d DB) GetAdmins() []User {
 }
 
 type InviteBatch struct {
-	BatchId          string
-	ActingUsername   string
-	UnclaimedInvites []string
-	Label            string
-	Time             time.Time
-	Reusable         bool
+	BatchId          string    `json:"batch_id"`
+	ActingUsername   string    `json:"acting_username"`
+	UnclaimedInvites []string  `json:"unclaimed_invites"`
+	Label            string    `json:"label"`
+	Time             time.Time `json:"time"`
+	Reusable         bool      `json:"reusable"`
 }
 
 func (d DB) ClaimInvite(invite string) (bool, string, error) {
@@ -616,20 +680,21 @@ func (d DB) ClaimInvite(invite string) (bool, string, error) {
 const maxBatchAmount = 100
 const maxUnclaimedAmount = 500
 
-func (d DB) CreateInvites(adminid int, amount int, label string, reusable bool) error {
+// creates a batch of invites, returning the invite codes
+func (d DB) CreateInvites(adminid int, amount int, label string, reusable bool) ([]string, error) {
 	ed := eout.Describe("create invites")
 	isAdmin, err := d.IsUserAdmin(adminid)
 	if err != nil {
-		return ed.Eout(err, "IsUserAdmin")
+		return nil, ed.Eout(err, "IsUserAdmin")
 	}
 
 	if !isAdmin {
-		return fmt.Errorf("userid %d was not an admin, they can't create an invite", adminid)
+		return nil, fmt.Errorf("userid %d was not an admin, they can't create an invite", adminid)
 	}
 
 	// check that amount is within reasonable range
 	if amount > maxBatchAmount {
-		return fmt.Errorf("batch amount should not exceed %d but was %d; not creating invites ", maxBatchAmount, amount)
+		return nil, fmt.Errorf("batch amount should not exceed %d but was %d; not creating invites ", maxBatchAmount, amount)
 	}
 
 	// check that already existing unclaimed invites is within a reasonable range
@@ -639,7 +704,7 @@ func (d DB) CreateInvites(adminid int, amount int, label string, reusable bool)
 	ed.Check(err, "querying for number of unclaimed invites")
 	if unclaimed > maxUnclaimedAmount {
 		msgstr := "number of unclaimed invites amount should not exceed %d but was %d; ceasing invite creation"
-		return fmt.Errorf(msgstr, maxUnclaimedAmount, unclaimed)
+		return nil, fmt.Errorf(msgstr, maxUnclaimedAmount, unclaimed)
 	}
 
 	// all cleared!
@@ -653,7 +718,7 @@ func (d DB) CreateInvites(adminid int, amount int, label string, reusable bool)
 	}
 
 	if amount <= 0 {
-		return fmt.Errorf("number of unclaimed invites amount %d has been reached; not creating invites ", maxUnclaimedAmount)
+		return nil, fmt.Errorf("number of unclaimed invites amount %d has been reached; not creating invites ", maxUnclaimedAmount)
 	}
 
 	// this id identifies all invites from this batch
@@ -667,7 +732,7 @@ func (d DB) CreateInvites(adminid int, amount int, label string, reusable bool)
 		_, err := preparedStmt.Exec(batchid, adminid, invite, label, creationTime, reusable)
 		ed.Check(err, "inserting invite into database")
 	}
-	return nil
+	return invites, nil
 }
 
 func (d DB) DestroyInvites(invites []string) {
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// users subscribe to threads to be notified of new posts in them. notifications are created when a post is added, one
+// for each subscriber except the post's author, and collect in the subscriber's inbox until marked as read. users are
+// also notified when a post mentions them, see mentions.go
+type Notification struct {
+	ID int
+	// one of the constants.NOTIFICATION_* values
+	Kind        int
+	ThreadID    int
+	ThreadTitle string
+	PostID      int
+	// the user whose post caused the notification
+	Actor   string
+	Created time.Time
+	Read    bool
+}
+
+func (n Notification) IsMention() bool {
+	return n.Kind == constants.NOTIFICATION_MENTION
+}
+
+// which users are subscribed to a thread without asking for it
+type SubscriptionOptions struct {
+	// the user who started the thread
+	ThreadAuthors bool
+	// everyone who replies to the thread
+	Repliers bool
+}
+
+func (d *DB) SetSubscriptionOptions(options SubscriptionOptions) {
+	d.subscriptions = options
+}
+
+func subscribe(tx *sql.Tx, userid, threadid int) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO subscriptions (userid, threadid) VALUES (?, ?)`, userid, threadid)
+	return eout.Eout(err, "subscribe user %d to thread %d", userid, threadid)
+}
+
+// notifies everyone subscribed to the thread, except the post's author, about the post. subscribers who have already
+// been notified about the post, e.g. because it mentions them, are not notified twice
+func notifySubscribers(tx *sql.Tx, kind, threadid, postid, authorid int, created time.Time) error {
+	stmt := `INSERT INTO notifications (userid, kind, threadid, postid, actorid, created)
+  SELECT userid, ?, ?, ?, ?, ? FROM subscriptions
+  WHERE threadid = ? AND userid != ? AND userid NOT IN (SELECT userid FROM notifications WHERE postid = ?)`
+	_, err := tx.Exec(stmt, kind, threadid, postid, authorid, created, threadid, authorid, postid)
+	return eout.Eout(err, "notify subscribers of thread %d about post %d", threadid, postid)
+}
+
+func (d DB) Subscribe(userid, threadid int) error {
+	_, err := d.Exec(`INSERT OR IGNORE INTO subscriptions (userid, threadid) VALUES (?, ?)`, userid, threadid)
+	return eout.Eout(err, "subscribe user %d to thread %d", userid, threadid)
+}
+
+func (d DB) Unsubscribe(userid, threadid int) error {
+	_, err := d.Exec(`DELETE FROM subscriptions WHERE userid = ? AND threadid = ?`, userid, threadid)
+	return eout.Eout(err, "unsubscribe user %d from thread %d", userid, threadid)
+}
+
+func (d DB) IsSubscribed(userid, threadid int) (bool, error) {
+	stmt := `SELECT 1 FROM subscriptions WHERE userid = ? AND threadid = ?`
+	return d.existsQuery(stmt, userid, threadid)
+}
+
+// returns the user's notifications, latest first. at most limit notifications are returned, skipping the first offset
+func (d DB) GetNotifications(userid, limit, offset int) ([]Notification, error) {
+	ed := eout.Describe("get notifications")
+	query := `
+  SELECT n.id, n.kind, n.threadid, t.title, n.postid, coalesce(u.name, ?), n.created, n.read
+  FROM notifications n
+  INNER JOIN threads t ON t.id = n.threadid
+  LEFT JOIN users u ON u.id = n.actorid
+  WHERE n.userid = ?
+  ORDER BY n.id DESC
+  LIMIT ? OFFSET ?
+  `
+	rows, err := d.db.Query(query, DELETED_USER_NAME, userid, limit, offset)
+	if err != nil {
+		return nil, ed.Eout(err, "query notifications of user %d", userid)
+	}
+	defer rows.Close()
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.ThreadID, &n.ThreadTitle, &n.PostID, &n.Actor, &n.Created, &n.Read); err != nil {
+			return nil, ed.Eout(err, "scan notifications of user %d", userid)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, ed.Eout(rows.Err(), "iterate notifications of user %d", userid)
+}
+
+// returns how many notifications the user has, and how many of them are unread
+func (d DB) CountNotifications(userid int) (int, int, error) {
+	stmt := `SELECT count(*), coalesce(sum(read = 0), 0) FROM notifications WHERE userid = ?`
+	var total, unread int
+	err := d.db.QueryRow(stmt, userid).Scan(&total, &unread)
+	return total, unread, eout.Eout(err, "count notifications of user %d", userid)
+}
+
+func (d DB) CountUnreadNotifications(userid int) (int, error) {
+	var unread int
+	err := d.db.QueryRow(`SELECT count(*) FROM notifications WHERE userid = ? AND read = 0`, userid).Scan(&unread)
+	return unread, eout.Eout(err, "count unread notifications of user %d", userid)
+}
+
+// marks the user's notifications with the given ids as read. if ids is empty, all of the user's notifications are
+func (d DB) MarkNotificationsRead(userid int, ids []int) error {
+	stmt := `UPDATE notifications SET read = 1 WHERE userid = ? AND read = 0`
+	args := []interface{}{userid}
+	if len(ids) > 0 {
+		placeholders := make([]string, 0, len(ids))
+		for _, id := range ids {
+			placeholders = append(placeholders, "?")
+			args = append(args, id)
+		}
+		stmt += fmt.Sprintf(` AND id IN (%s)`, strings.Join(placeholders, ","))
+	}
+	_, err := d.Exec(stmt, args...)
+	return eout.Eout(err, "mark notifications of user %d read", userid)
+}
+
+// marks the notifications about the thread's posts up to and including postid as read, once the user has seen them
+func (d DB) MarkThreadNotificationsRead(userid, threadid, postid int) error {
+	stmt := `UPDATE notifications SET read = 1 WHERE userid = ? AND threadid = ? AND postid <= ? AND read = 0`
+	_, err := d.Exec(stmt, userid, threadid, postid)
+	return eout.Eout(err, "mark notifications of user %d about thread %d read", userid, threadid)
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// threads imported from other forum software, see cmd/cerca/import-from.go, are written by placeholders: accounts that
+// no one can log in to, one for each author. an admin can create a claim link for a placeholder and hand it to the
+// author, who uses it to choose a name and password and so take over the account with all of its posts. a placeholder
+// remembers who it stands in for after it has been claimed, so that importing more of the same source again attributes
+// the posts to the claimed account
+
+var ErrClaimTokenInvalid = errors.New("claim token does not exist, has been used or has expired")
+
+// the author of imported posts
+type ImportedAuthor struct {
+	// how the source identifies the author, e.g. their username or email address. never shown outside of the admin pages
+	Original string
+	// the name the author's placeholder gets, unless it is taken
+	Name string
+}
+
+type ImportedPost struct {
+	Author    ImportedAuthor
+	Content   string
+	Published time.Time
+}
+
+type ImportedThread struct {
+	Title string
+	// the name of the topic the thread goes in, which is created if it doesn't exist. empty for the default topic
+	Topic   string
+	Private bool
+	// the first post opens the thread
+	Posts []ImportedPost
+}
+
+// what importing threads did, or would have done in a dry run
+type ImportThreadsReport struct {
+	Threads, Posts int
+	// the topics that were created for the threads
+	Topics []string
+	// the placeholders that were created, and the number of authors that already had an account from an earlier import
+	Placeholders []string
+	Reused       int
+}
+
+// adds threads from another forum, source (e.g. "discourse"), keeping the times their posts were published. authors
+// get a placeholder, with the name they went by unless it is taken, in which case a number is added to it. mentions of
+// other imported authors are recorded, and shown with the names of their placeholders, but no one is notified of
+// anything.
+// the import happens in a single transaction, and nothing is written if it fails or if dryRun is set
+func (d DB) ImportThreads(source string, threads []ImportedThread, dryRun bool) (report ImportThreadsReport, finalErr error) {
+	ed := eout.Describe("import threads")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return report, ed.Eout(err, "start transaction")
+	}
+	defer func() {
+		if finalErr != nil || dryRun {
+			_ = tx.Rollback()
+			return
+		}
+		finalErr = ed.Eout(tx.Commit(), "commit transaction")
+	}()
+
+	type account struct {
+		id   int
+		name string
+	}
+	// the accounts of the authors, by their original names, and by the names they went by, which posts mention them by
+	accounts := make(map[string]account)
+	mentionable := make(map[string]account)
+	// placeholders can't be logged in to, and share the hash of a random password that no one knows
+	var hash string
+	author := func(a ImportedAuthor) (account, error) {
+		if acc, ok := accounts[a.Original]; ok {
+			return acc, nil
+		}
+		wentBy := a.Name
+		if wentBy == "" {
+			wentBy = a.Original
+		}
+		var acc account
+		stmt := `SELECT u.id, u.name FROM placeholders p INNER JOIN users u ON u.id = p.userid WHERE p.source = ? AND p.original = ?`
+		err := tx.QueryRow(stmt, source, a.Original).Scan(&acc.id, &acc.name)
+		if err == nil {
+			accounts[a.Original] = acc
+			mentionable[wentBy] = acc
+			report.Reused++
+			return acc, nil
+		} else if err != sql.ErrNoRows {
+			return acc, err
+		}
+		if hash == "" {
+			if hash, err = crypto.HashPassword(crypto.GeneratePassword()); err != nil {
+				return acc, err
+			}
+		}
+		acc.name = wentBy
+		base := acc.name
+		for n := 2; ; n++ {
+			var taken bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE name = ?)`, acc.name).Scan(&taken)
+			if err != nil {
+				return acc, err
+			}
+			if !taken && acc.name != SYSTEM_USER_NAME && acc.name != DELETED_USER_NAME {
+				break
+			}
+			acc.name = fmt.Sprintf("%s-%d", base, n)
+		}
+		acc.id, err = createUser(tx, acc.name, hash)
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO placeholders (userid, source, original) VALUES (?, ?, ?)`, acc.id, source, a.Original)
+		}
+		if err == nil {
+			stmt := `INSERT INTO registrations (userid, link, time) VALUES (?, ?, ?)`
+			_, err = tx.Exec(stmt, acc.id, fmt.Sprintf("imported from %s", source), time.Now())
+		}
+		accounts[a.Original] = acc
+		mentionable[wentBy] = acc
+		report.Placeholders = append(report.Placeholders, acc.name)
+		return acc, err
+	}
+
+	topics := make(map[string]int)
+	topic := func(name string) (int, error) {
+		if name == "" {
+			name = DEFAULT_TOPIC_NAME
+		}
+		if id, ok := topics[name]; ok {
+			return id, nil
+		}
+		var id int
+		err := tx.QueryRow(`SELECT id FROM topics WHERE name = ?`, name).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(`INSERT INTO topics (name, description) VALUES (?, ?) RETURNING id`, name, "").Scan(&id)
+			report.Topics = append(report.Topics, name)
+		}
+		topics[name] = id
+		return id, err
+	}
+
+	// every author is given an account first, so that posts can mention authors who only post later on
+	for _, thread := range threads {
+		for _, post := range thread.Posts {
+			if _, err := author(post.Author); err != nil {
+				return report, ed.Eout(err, "create placeholder for %s", post.Author.Original)
+			}
+		}
+	}
+
+	for _, thread := range threads {
+		if len(thread.Posts) == 0 {
+			continue
+		}
+		posts := append([]ImportedPost{}, thread.Posts...)
+		sort.SliceStable(posts, func(i, j int) bool { return posts[i].Published.Before(posts[j].Published) })
+		topicid, err := topic(thread.Topic)
+		if err != nil {
+			return report, ed.Eout(err, "find or create topic %s", thread.Topic)
+		}
+		opener := accounts[posts[0].Author.Original]
+		threadid, err := createThread(tx, thread.Title, topicid, opener.id, thread.Private, posts[0].Published)
+		if err != nil {
+			return report, ed.Eout(err, "add thread %s", thread.Title)
+		}
+		report.Threads++
+		for _, post := range posts {
+			postid, err := addPost(tx, post.Content, threadid, accounts[post.Author.Original].id, post.Published)
+			if err != nil {
+				return report, ed.Eout(err, "add post to thread %s", thread.Title)
+			}
+			for _, name := range util.ParseMentions(post.Content) {
+				acc, ok := mentionable[name]
+				if !ok {
+					continue
+				}
+				stmt := `INSERT OR IGNORE INTO mentions (postid, userid, name) VALUES (?, ?, ?)`
+				if _, err := tx.Exec(stmt, postid, acc.id, name); err != nil {
+					return report, ed.Eout(err, "add mention of user %d in post %d", acc.id, postid)
+				}
+			}
+			report.Posts++
+		}
+	}
+	return report, nil
+}
+
+type Placeholder struct {
+	UserID int
+	// the name of the placeholder's account
+	Name string
+	// where the placeholder's posts were imported from, and how the author was known there
+	Source   string
+	Original string
+	Posts    int
+	// when the latest claim link stops working; not valid if there is none
+	ClaimExpires NullTime
+}
+
+// returns the placeholders that have yet to be claimed, ordered by name
+func (d DB) GetPlaceholders() ([]Placeholder, error) {
+	ed := eout.Describe("get placeholders")
+	query := `
+  SELECT p.userid, u.name, p.source, p.original, p.claimexpires, (SELECT count(*) FROM posts WHERE authorid = p.userid)
+  FROM placeholders p
+  INNER JOIN users u ON u.id = p.userid
+  WHERE p.claimed IS NULL
+  ORDER BY u.name
+  `
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, ed.Eout(err, "query placeholders")
+	}
+	defer rows.Close()
+	var placeholders []Placeholder
+	for rows.Next() {
+		var p Placeholder
+		if err := rows.Scan(&p.UserID, &p.Name, &p.Source, &p.Original, &p.ClaimExpires, &p.Posts); err != nil {
+			return nil, ed.Eout(err, "scan placeholders")
+		}
+		placeholders = append(placeholders, p)
+	}
+	return placeholders, ed.Eout(rows.Err(), "iterate placeholders")
+}
+
+// creates a link token for claiming the placeholder, valid for validFor. a placeholder has at most one working claim
+// link: creating a new one replaces the previous one. only the token's hash is stored
+func (d DB) CreateClaimToken(userid int, validFor time.Duration) (string, error) {
+	token := crypto.GenerateToken()
+	stmt := `UPDATE placeholders SET claimtokenhash = ?, claimexpires = ? WHERE userid = ? AND claimed IS NULL`
+	result, err := d.Exec(stmt, crypto.HashToken(token), time.Now().Add(validFor), userid)
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = fmt.Errorf("user %d is not an unclaimed placeholder", userid)
+		}
+	}
+	if err != nil {
+		return "", eout.Eout(err, "create claim token for user %d", userid)
+	}
+	return token, nil
+}
+
+// returns the placeholder a claim token is for, or ErrClaimTokenInvalid
+func (d DB) GetPlaceholderByClaimToken(token string) (Placeholder, error) {
+	stmt := `
+  SELECT p.userid, u.name, p.source, p.original, p.claimexpires
+  FROM placeholders p
+  INNER JOIN users u ON u.id = p.userid
+  WHERE p.claimtokenhash = ? AND p.claimed IS NULL AND p.claimexpires > ?
+  `
+	var p Placeholder
+	err := d.db.QueryRow(stmt, crypto.HashToken(token), time.Now()).Scan(&p.UserID, &p.Name, &p.Source, &p.Original, &p.ClaimExpires)
+	if err == sql.ErrNoRows {
+		return p, ErrClaimTokenInvalid
+	}
+	return p, eout.Eout(err, "get placeholder by claim token")
+}
+
+// turns the placeholder a claim token is for into a regular account, with the given name and password, and returns the
+// account's id. the token stops working, and the account keeps its posts. posts mentioning the placeholder are left as
+// they are, and show the new name. ErrClaimTokenInvalid is returned for tokens that don't exist, have been used already
+// or have expired
+func (d DB) ClaimPlaceholder(token, name, passwordHash string) (int, error) {
+	ed := eout.Describe("claim placeholder")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return -1, ed.Eout(err, "start transaction")
+	}
+	var userid int
+	now := time.Now()
+	stmt := `
+  UPDATE placeholders SET claimed = ?, claimtokenhash = NULL, claimexpires = NULL
+  WHERE claimtokenhash = ? AND claimed IS NULL AND claimexpires > ?
+  RETURNING userid
+  `
+	err = tx.QueryRow(stmt, now, crypto.HashToken(token), now).Scan(&userid)
+	if err == sql.ErrNoRows {
+		err = ErrClaimTokenInvalid
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE users SET name = ?, passwordhash = ? WHERE id = ?`, name, passwordHash, userid)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		if err == ErrClaimTokenInvalid {
+			return -1, err
+		}
+		return -1, ed.Eout(err, "claim placeholder %d", userid)
+	}
+	return userid, ed.Eout(tx.Commit(), "commit transaction")
+}
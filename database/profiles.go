@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// what a user's profile page shows about them, see also CountUserActivity and ListUserPosts
+type UserProfile struct {
+	ID   int
+	Name string
+	// markdown, written by the user, and the users it mentions in the form of Post.Mentions
+	Bio         string
+	BioMentions map[string]string
+	// when the user registered. unknown for users who were added before registrations were recorded
+	Joined  NullTime
+	IsAdmin bool
+}
+
+func (d DB) GetUserProfile(name string) (UserProfile, error) {
+	// joining registrations (rather than using a subquery) keeps the type of r.time, so that it is scanned as a time
+	stmt := `
+  SELECT u.id, u.name, u.bio, r.time, EXISTS (SELECT 1 FROM admins a WHERE a.id = u.id)
+  FROM users u
+  LEFT JOIN registrations r ON r.userid = u.id
+  WHERE u.name = ?
+  ORDER BY r.time
+  LIMIT 1
+  `
+	var profile UserProfile
+	var bio sql.NullString
+	err := d.db.QueryRow(stmt, name).Scan(&profile.ID, &profile.Name, &bio, &profile.Joined, &profile.IsAdmin)
+	if err != nil {
+		return UserProfile{}, eout.Eout(err, "get profile of %s", name)
+	}
+	profile.Bio = bio.String
+	profile.BioMentions, err = d.ResolveMentions(profile.Bio, nil)
+	return profile, eout.Eout(err, "get mentions in bio of %s", name)
+}
+
+func (d DB) UpdateUserBio(userid int, bio string) error {
+	_, err := d.Exec(`UPDATE users SET bio = ? WHERE id = ?`, bio, userid)
+	return eout.Eout(err, "update bio of user %d", userid)
+}
+
+// a post listed on its author's profile
+type ProfilePost struct {
+	Post
+	Private bool
+}
+
+type UserPostsOptions struct {
+	IncludePrivate bool
+	// posts hidden by admins are left out unless IncludeHidden is set
+	IncludeHidden bool
+	// list at most Limit posts, skipping the first Offset. if Limit is 0, all posts are listed
+	Limit  int
+	Offset int
+}
+
+func userPostsWhere(options UserPostsOptions) string {
+	where := `WHERE p.authorid = ?`
+	if !options.IncludePrivate {
+		where += ` AND t.private = 0`
+	}
+	if !options.IncludeHidden {
+		where += ` AND p.hidden = 0`
+	}
+	return where
+}
+
+// lists the posts written by a user, latest first
+func (d DB) ListUserPosts(userid int, options UserPostsOptions) ([]ProfilePost, error) {
+	ed := eout.Describe("list user posts")
+	query := `
+  SELECT p.id, t.id, t.title, t.private, p.content, u.name, p.authorid, p.publishtime, p.lastedit, p.hidden, p.hiddenreason
+  FROM posts p
+  INNER JOIN users u ON u.id = p.authorid
+  INNER JOIN threads t ON t.id = p.threadid
+  %s
+  ORDER BY p.publishtime DESC, p.id DESC
+  LIMIT ? OFFSET ?
+  `
+	// a negative limit lists all posts
+	limit := -1
+	if options.Limit > 0 {
+		limit = options.Limit
+	}
+	rows, err := d.db.Query(fmt.Sprintf(query, userPostsWhere(options)), userid, limit, options.Offset)
+	if err != nil {
+		return nil, ed.Eout(err, "query posts of user %d", userid)
+	}
+	defer rows.Close()
+
+	var posts []ProfilePost
+	for rows.Next() {
+		var post ProfilePost
+		var hiddenReason sql.NullString
+		err := rows.Scan(&post.ID, &post.ThreadID, &post.ThreadTitle, &post.Private, &post.Content, &post.Author, &post.AuthorID, &post.Publish, &post.LastEdit, &post.Hidden, &hiddenReason)
+		if err != nil {
+			return nil, ed.Eout(err, "scan posts of user %d", userid)
+		}
+		post.HiddenReason = hiddenReason.String
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ed.Eout(err, "iterate posts of user %d", userid)
+	}
+	mentioning := make([]Post, len(posts))
+	for i, post := range posts {
+		mentioning[i] = post.Post
+	}
+	if err := d.setMentions(mentioning); err != nil {
+		return nil, ed.Eout(err, "get mentions in posts of user %d", userid)
+	}
+	for i := range posts {
+		posts[i].Mentions = mentioning[i].Mentions
+	}
+	return posts, nil
+}
+
+// count the posts ListUserPosts would list for options, disregarding options.Limit and options.Offset
+func (d DB) CountUserPosts(userid int, options UserPostsOptions) (int, error) {
+	query := `
+  SELECT count(*) FROM posts p
+  INNER JOIN threads t ON t.id = p.threadid
+  %s
+  `
+	var count int
+	err := d.db.QueryRow(fmt.Sprintf(query, userPostsWhere(options)), userid).Scan(&count)
+	return count, eout.Eout(err, "count posts of user %d", userid)
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// which posts a user has read is tracked per thread, as the id of the latest post they have seen. posts with a higher
+// id are unread, except for the user's own posts. threads the user has never opened are unread in their entirety
+
+// records that the user has seen the thread up to and including the post postid. reading an earlier page of a thread
+// doesn't make the later pages unread again
+func (d DB) MarkThreadRead(userid, threadid, postid int) error {
+	stmt := `INSERT INTO thread_reads (userid, threadid, lastpostid) VALUES (?, ?, ?)
+  ON CONFLICT(userid, threadid) DO UPDATE SET lastpostid = max(lastpostid, excluded.lastpostid)`
+	_, err := d.Exec(stmt, userid, threadid, postid)
+	return eout.Eout(err, "mark thread %d read for user %d", threadid, userid)
+}
+
+// marks every post of the threads in the given topics as read. if topicIDs is empty, threads from all topics are marked
+func (d DB) MarkAllRead(userid int, topicIDs []int) error {
+	args := []interface{}{userid}
+	where := ""
+	if len(topicIDs) > 0 {
+		placeholders := make([]string, 0, len(topicIDs))
+		for _, topicid := range topicIDs {
+			placeholders = append(placeholders, "?")
+			args = append(args, topicid)
+		}
+		where = fmt.Sprintf(`AND t.topicid IN (%s)`, strings.Join(placeholders, ","))
+	}
+	// the WHERE true resolves sqlite's parsing ambiguity between an upsert's ON CONFLICT and a join's ON clause
+	stmt := `INSERT INTO thread_reads (userid, threadid, lastpostid)
+  SELECT ?, t.id, max(p.id) FROM threads t
+  INNER JOIN posts p ON p.threadid = t.id
+  WHERE true %s
+  GROUP BY t.id
+  ON CONFLICT(userid, threadid) DO UPDATE SET lastpostid = max(lastpostid, excluded.lastpostid)`
+	_, err := d.Exec(fmt.Sprintf(stmt, where), args...)
+	return eout.Eout(err, "mark all threads read for user %d", userid)
+}
+
+// fills in Unread and FirstUnread of threads, as seen by the user. posts hidden by admins are only counted if
+// includeHidden is set, as nobody else can read them
+func (d DB) countUnread(userid int, includeHidden bool, threads []Thread) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	args := []interface{}{userid}
+	placeholders := make([]string, 0, len(threads))
+	index := make(map[int]int, len(threads))
+	for i, thread := range threads {
+		placeholders = append(placeholders, "?")
+		args = append(args, thread.ID)
+		index[thread.ID] = i
+	}
+	args = append(args, userid)
+	hidden := ""
+	if !includeHidden {
+		hidden = `AND p.hidden = 0`
+	}
+	query := `
+  SELECT p.threadid, count(*), min(p.id) FROM posts p
+  LEFT JOIN thread_reads r ON r.threadid = p.threadid AND r.userid = ?
+  WHERE p.threadid IN (%s) AND p.id > coalesce(r.lastpostid, 0) AND p.authorid != ? %s
+  GROUP BY p.threadid
+  `
+	rows, err := d.db.Query(fmt.Sprintf(query, strings.Join(placeholders, ","), hidden), args...)
+	if err != nil {
+		return eout.Eout(err, "query unread posts of user %d", userid)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var threadid, unread, firstUnread int
+		if err := rows.Scan(&threadid, &unread, &firstUnread); err != nil {
+			return eout.Eout(err, "scan unread posts")
+		}
+		threads[index[threadid]].Unread = unread
+		threads[index[threadid]].FirstUnread = firstUnread
+	}
+	return eout.Eout(rows.Err(), "iterate unread posts")
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the search index is an fts5 virtual table with one row per post, where the row's rowid is the post id. a thread's
+// title is only indexed alongside the thread's opening post, so that a matching title yields one result and not one
+// result per reply.
+//
+// NOTE: fts5 is only available if cerca is built with the sqlite_fts5 build tag (go build -tags sqlite_fts5 ./cmd/cerca)
+const searchIndexTable = `
+  CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+    title,
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+  );
+`
+
+// the triggers keep the search index in sync with the posts and threads tables, covering AddPost, CreateThread,
+// EditPost and DeletePost
+var searchIndexTriggers = map[string]string{
+	"search_index_post_insert": `
+  CREATE TRIGGER IF NOT EXISTS search_index_post_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO search_index (rowid, title, content) VALUES (
+      new.id,
+      CASE WHEN EXISTS (SELECT 1 FROM posts WHERE threadid = new.threadid AND id < new.id) THEN ''
+      ELSE (SELECT title FROM threads WHERE id = new.threadid) END,
+      new.content
+    );
+  END;
+  `,
+	"search_index_post_update": `
+  CREATE TRIGGER IF NOT EXISTS search_index_post_update AFTER UPDATE OF content ON posts BEGIN
+    UPDATE search_index SET content = new.content WHERE rowid = old.id;
+  END;
+  `,
+	// if a thread's opening post is deleted, its title moves on to the post that now opens the thread
+	"search_index_post_delete": `
+  CREATE TRIGGER IF NOT EXISTS search_index_post_delete AFTER DELETE ON posts BEGIN
+    DELETE FROM search_index WHERE rowid = old.id;
+    UPDATE search_index SET title = (SELECT title FROM threads WHERE id = old.threadid)
+    WHERE rowid = (SELECT min(id) FROM posts WHERE threadid = old.threadid);
+  END;
+  `,
+	"search_index_thread_update": `
+  CREATE TRIGGER IF NOT EXISTS search_index_thread_update AFTER UPDATE OF title ON threads BEGIN
+    UPDATE search_index SET title = new.title
+    WHERE rowid = (SELECT min(id) FROM posts WHERE threadid = new.id);
+  END;
+  `,
+}
+
+// the markers surrounding matched terms in SearchResult's TitleSnippet and Snippet. they are control characters so that
+// they can't be confused with markup, allowing the renderer to escape the snippet and then replace the markers
+const SearchHighlightStart = "\x02"
+const SearchHighlightEnd = "\x03"
+
+// creates the search index and the triggers keeping it up to date, indexing existing posts if the index or its triggers
+// were missing. returns false if sqlite was built without fts5, in which case search is disabled
+func (d DB) createSearchIndex() bool {
+	ed := eout.Describe("create search index")
+	var fts5 bool
+	err := d.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	ed.Check(err, "check for fts5")
+	if !fts5 {
+		// the triggers could have been created by a binary built with fts5: remove them, otherwise they would make
+		// every new post fail
+		for name := range searchIndexTriggers {
+			_, err = d.db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s`, name))
+			ed.Check(err, "drop trigger %s", name)
+		}
+		log.Println("search is disabled: cerca was built without the sqlite_fts5 build tag")
+		return false
+	}
+	// posts written while the index or its triggers were missing (e.g. by a binary built without fts5) are only found
+	// after rebuilding the index
+	var existing int
+	err = d.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name IN ('search_index', 'search_index_post_insert')`).Scan(&existing)
+	ed.Check(err, "check for existing search index")
+	_, err = d.db.Exec(searchIndexTable)
+	ed.Check(err, "create virtual table")
+	for name, trigger := range searchIndexTriggers {
+		_, err = d.db.Exec(trigger)
+		ed.Check(err, "create trigger %s", name)
+	}
+	d.searchEnabled = true
+	if existing < 2 {
+		ed.Check(d.RebuildSearchIndex(), "index existing posts")
+	}
+	return true
+}
+
+// reports whether search is available, i.e. whether cerca was built with the sqlite_fts5 build tag
+func (d DB) SearchEnabled() bool {
+	return d.searchEnabled
+}
+
+// clears the search index and indexes every post anew
+func (d DB) RebuildSearchIndex() (finalErr error) {
+	ed := eout.Describe("rebuild search index")
+	if !d.searchEnabled {
+		return ed.Eout(fmt.Errorf("search is disabled"), "cerca was built without the sqlite_fts5 build tag")
+	}
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{})
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			log.Println(incomingErr, "\nrolling back")
+			finalErr = incomingErr
+			return true
+		}
+		return false
+	}
+	_, err = tx.Exec(`DELETE FROM search_index`)
+	if rollbackOnErr(ed.Eout(err, "clear index")) {
+		return
+	}
+	stmt := `
+  INSERT INTO search_index (rowid, title, content)
+  SELECT p.id, CASE WHEN p.id = (SELECT min(id) FROM posts WHERE threadid = p.threadid) THEN t.title ELSE '' END, p.content
+  FROM posts p
+  INNER JOIN threads t ON t.id = p.threadid
+  `
+	_, err = tx.Exec(stmt)
+	if rollbackOnErr(ed.Eout(err, "index posts")) {
+		return
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+type SearchOptions struct {
+	// the words to search for; each word has to be present in a post or its thread's title. a trailing * matches
+	// prefixes
+	Query string
+	// if set, only return posts written by the user with this name
+	Author string
+	// if non-zero, only return posts published at or after From, or before To
+	From time.Time
+	To   time.Time
+	// posts in private threads are only returned if IncludePrivate is true
+	IncludePrivate bool
+	// zero-indexed page of results, with PageSize results per page
+	Page     int
+	PageSize int
+}
+
+type SearchResult struct {
+	PostID      int
+	ThreadID    int
+	ThreadTitle string
+	// TitleSnippet is empty unless the thread title matched
+	TitleSnippet string
+	Snippet      string
+	Author       string
+	Publish      time.Time
+	Private      bool
+}
+
+// turns user input into an fts5 query by quoting every word, which prevents fts5 syntax errors and keeps words like
+// AND, OR and NOT from being interpreted as operators
+func searchQuery(input string) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := fmt.Sprintf(`"%s"`, strings.ReplaceAll(word, `"`, `""`))
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// search the forum's posts and thread titles, best matches first. the returned bool signals whether there are more
+// results after the requested page
+func (d DB) Search(options SearchOptions) ([]SearchResult, bool, error) {
+	ed := eout.Describe("search")
+	if !d.searchEnabled {
+		return nil, false, ed.Eout(fmt.Errorf("search is disabled"), "cerca was built without the sqlite_fts5 build tag")
+	}
+	match := searchQuery(options.Query)
+	if match == "" {
+		return nil, false, nil
+	}
+	query := `
+  SELECT p.id, t.id, t.title, u.name, p.publishtime, t.private,
+    highlight(search_index, 0, ?, ?),
+    snippet(search_index, 1, ?, ?, '…', 24)
+  FROM search_index
+  INNER JOIN posts p ON p.id = search_index.rowid
+  INNER JOIN threads t ON t.id = p.threadid
+  INNER JOIN users u ON u.id = p.authorid
+  WHERE search_index MATCH ?
+  %s
+  ORDER BY search_index.rank
+  LIMIT ? OFFSET ?
+  `
+	args := []interface{}{SearchHighlightStart, SearchHighlightEnd, SearchHighlightStart, SearchHighlightEnd, match}
+	// posts hidden by admins are never returned
+	where := []string{`AND p.hidden = 0`}
+	if !options.IncludePrivate {
+		where = append(where, `AND t.private = 0`)
+	}
+	if options.Author != "" {
+		where = append(where, `AND u.name = ?`)
+		args = append(args, options.Author)
+	}
+	if !options.From.IsZero() {
+		where = append(where, `AND p.publishtime >= ?`)
+		args = append(args, options.From)
+	}
+	if !options.To.IsZero() {
+		where = append(where, `AND p.publishtime < ?`)
+		args = append(args, options.To)
+	}
+	pageSize := options.PageSize
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	// fetch one extra result to know if there is a next page
+	args = append(args, pageSize+1, options.Page*pageSize)
+	query = fmt.Sprintf(query, strings.Join(where, "\n  "))
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, false, ed.Eout(err, "query %q", match)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		var private int
+		if err := rows.Scan(&result.PostID, &result.ThreadID, &result.ThreadTitle, &result.Author, &result.Publish, &private, &result.TitleSnippet, &result.Snippet); err != nil {
+			return nil, false, ed.Eout(err, "scan results")
+		}
+		result.Private = private == 1
+		// titles are only indexed with the opening post; only keep the title snippet if the title matched
+		if !strings.Contains(result.TitleSnippet, SearchHighlightStart) {
+			result.TitleSnippet = ""
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, ed.Eout(err, "iterate results")
+	}
+	hasMore := len(results) > pageSize
+	if hasMore {
+		results = results[:pageSize]
+	}
+	return results, hasMore, nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the sessions of logged in users. the session cookie carries a random token identifying the session, of which only the
+// hash is stored. deleting a session logs out whoever is using it
+type UserSession struct {
+	ID        int
+	UserID    int
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+	// true for the session making the request that listed the sessions
+	Current bool
+}
+
+// sessions that have not been used for this long have expired, like their cookie
+const SESSION_LIFETIME = 30 * 24 * time.Hour
+
+// last seen times are only updated once in this interval, to avoid a write for every request
+const sessionLastSeenInterval = time.Minute
+
+var ErrSessionInvalid = errors.New("session does not exist or has expired")
+
+// creates a session for the user and returns its token
+func (d DB) CreateSession(userid int, userAgent, ip string) (string, error) {
+	ed := eout.Describe("create session")
+	now := time.Now()
+	// take the opportunity to forget about expired sessions
+	if _, err := d.Exec(`DELETE FROM sessions WHERE lastseen < ?`, now.Add(-SESSION_LIFETIME)); err != nil {
+		return "", ed.Eout(err, "delete expired sessions")
+	}
+	token := crypto.GenerateToken()
+	stmt := `INSERT INTO sessions (tokenhash, userid, useragent, ip, created, lastseen) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := d.Exec(stmt, crypto.HashToken(token), userid, userAgent, ip, now, now); err != nil {
+		return "", ed.Eout(err, "insert session for user %d", userid)
+	}
+	return token, nil
+}
+
+// returns the user the session belongs to, or ErrSessionInvalid if the session was revoked or has expired. using a
+// session updates its last seen time
+func (d DB) CheckSession(token string) (int, error) {
+	ed := eout.Describe("check session")
+	hash := crypto.HashToken(token)
+	var userid int
+	var lastSeen time.Time
+	err := d.db.QueryRow(`SELECT userid, lastseen FROM sessions WHERE tokenhash = ?`, hash).Scan(&userid, &lastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, ErrSessionInvalid
+	} else if err != nil {
+		return -1, ed.Eout(err, "query session")
+	}
+	now := time.Now()
+	if now.Sub(lastSeen) > SESSION_LIFETIME {
+		return -1, ErrSessionInvalid
+	}
+	if now.Sub(lastSeen) > sessionLastSeenInterval {
+		if _, err = d.Exec(`UPDATE sessions SET lastseen = ? WHERE tokenhash = ?`, now, hash); err != nil {
+			return -1, ed.Eout(err, "update last seen")
+		}
+	}
+	return userid, nil
+}
+
+// deletes the session identified by the token, e.g. when logging out
+func (d DB) DeleteSession(token string) error {
+	_, err := d.Exec(`DELETE FROM sessions WHERE tokenhash = ?`, crypto.HashToken(token))
+	return eout.Eout(err, "delete session")
+}
+
+// lists a user's sessions, most recently seen first. currentToken marks the session making the request
+func (d DB) GetSessions(userid int, currentToken string) ([]UserSession, error) {
+	ed := eout.Describe("get sessions")
+	stmt := `SELECT id, userid, useragent, ip, created, lastseen, tokenhash = ? FROM sessions WHERE userid = ? AND lastseen >= ? ORDER BY lastseen DESC`
+	rows, err := d.db.Query(stmt, crypto.HashToken(currentToken), userid, time.Now().Add(-SESSION_LIFETIME))
+	if err != nil {
+		return nil, ed.Eout(err, "query sessions of user %d", userid)
+	}
+	defer rows.Close()
+	var sessions []UserSession
+	for rows.Next() {
+		var s UserSession
+		if err = rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Current); err != nil {
+			return nil, ed.Eout(err, "scan session")
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, ed.Eout(rows.Err(), "iterate sessions")
+}
+
+// revokes one of the user's sessions
+func (d DB) DeleteUserSession(userid, sessionid int) error {
+	result, err := d.Exec(`DELETE FROM sessions WHERE id = ? AND userid = ?`, sessionid, userid)
+	if err != nil {
+		return eout.Eout(err, "delete session %d", sessionid)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("delete session: session %d of user %d did not exist", sessionid, userid)
+	}
+	return nil
+}
+
+// revokes all of the user's sessions except the one identified by keepToken, logging them out everywhere else. an empty
+// keepToken revokes every session
+func (d DB) DeleteUserSessions(userid int, keepToken string) error {
+	_, err := d.Exec(`DELETE FROM sessions WHERE userid = ? AND tokenhash <> ?`, userid, crypto.HashToken(keepToken))
+	return eout.Eout(err, "delete sessions of user %d", userid)
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the scopes an api token can be granted. a token can only be used for what its scopes allow
+const (
+	// read private threads through the api
+	TOKEN_SCOPE_READ = "read"
+	// create threads and reply to them
+	TOKEN_SCOPE_POST = "post"
+	// list, create and delete invites; only usable while the token's owner is an admin
+	TOKEN_SCOPE_ADMIN_INVITES = "admin-invites"
+)
+
+var TokenScopes = []string{TOKEN_SCOPE_READ, TOKEN_SCOPE_POST, TOKEN_SCOPE_ADMIN_INVITES}
+
+var ErrTokenInvalid = errors.New("api token does not exist or has expired")
+
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	LastUsed NullTime
+	// tokens without an expiry are valid until revoked
+	Expires NullTime
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t APIToken) Expired() bool {
+	return t.Expires.Valid && !time.Now().Before(t.Expires.Time)
+}
+
+// creates an api token for the user and returns it. only the token's hash is stored, so the returned token can't be
+// recovered later
+func (d DB) CreateAPIToken(userid int, name string, scopes []string, expires NullTime) (string, error) {
+	ed := eout.Describe("create api token")
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("create api token: no scopes given")
+	}
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range TokenScopes {
+			valid = valid || s == scope
+		}
+		if !valid {
+			return "", fmt.Errorf("create api token: unknown scope %q", scope)
+		}
+	}
+	token := crypto.GenerateToken()
+	stmt := `INSERT INTO api_tokens (userid, name, tokenhash, scopes, created, expires) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := d.Exec(stmt, userid, name, crypto.HashToken(token), strings.Join(scopes, ","), time.Now(), expires)
+	if err != nil {
+		return "", ed.Eout(err, "insert token %q for user %d", name, userid)
+	}
+	return token, nil
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &t.LastUsed, &t.Expires)
+	t.Scopes = strings.Split(scopes, ",")
+	return t, err
+}
+
+// lists a user's api tokens, most recently created first
+func (d DB) GetAPITokens(userid int) ([]APIToken, error) {
+	ed := eout.Describe("get api tokens")
+	stmt := `SELECT id, userid, name, scopes, created, lastused, expires FROM api_tokens WHERE userid = ? ORDER BY id DESC`
+	rows, err := d.db.Query(stmt, userid)
+	if err != nil {
+		return nil, ed.Eout(err, "query tokens of user %d", userid)
+	}
+	defer rows.Close()
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, ed.Eout(err, "scan token")
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, ed.Eout(rows.Err(), "iterate tokens")
+}
+
+// revokes one of the user's api tokens
+func (d DB) DeleteAPIToken(userid, tokenid int) error {
+	result, err := d.Exec(`DELETE FROM api_tokens WHERE id = ? AND userid = ?`, tokenid, userid)
+	if err != nil {
+		return eout.Eout(err, "delete api token %d", tokenid)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("delete api token: token %d of user %d did not exist", tokenid, userid)
+	}
+	return nil
+}
+
+// looks up the api token, returning ErrTokenInvalid if it does not exist or has expired. using a token updates its
+// last used time
+func (d DB) UseAPIToken(token string) (APIToken, error) {
+	ed := eout.Describe("use api token")
+	stmt := `SELECT id, userid, name, scopes, created, lastused, expires FROM api_tokens WHERE tokenhash = ?`
+	t, err := scanAPIToken(d.db.QueryRow(stmt, crypto.HashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrTokenInvalid
+	} else if err != nil {
+		return t, ed.Eout(err, "query token")
+	}
+	if t.Expired() {
+		return t, ErrTokenInvalid
+	}
+	now := time.Now()
+	if _, err = d.Exec(`UPDATE api_tokens SET lastused = ? WHERE id = ?`, now, t.ID); err != nil {
+		return t, ed.Eout(err, "update last use of token %d", t.ID)
+	}
+	t.LastUsed = NullTime{sql.NullTime{Time: now, Valid: true}}
+	return t, nil
+}
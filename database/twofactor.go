@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// how many recovery codes a user receives when enabling two-factor authentication
+const RECOVERY_CODE_COUNT = 10
+
+// after this many wrong codes in a row, the second login step is locked for TWO_FACTOR_LOCKOUT
+const TWO_FACTOR_MAX_FAILURES = 5
+const TWO_FACTOR_LOCKOUT = 15 * time.Minute
+
+var ErrTwoFactorLocked = errors.New("too many incorrect two-factor codes, try again later")
+
+// a user's totp enrolment. the secret is stored before it is confirmed, while the user sets up their authenticator app,
+// but two-factor authentication is only required once Enabled is set
+type TwoFactor struct {
+	Secret  string
+	Enabled bool
+	// the time step of the last accepted code, so that a code can't be reused
+	LastStep    int64
+	Failures    int
+	LastFailure NullTime
+}
+
+// returns the user's enrolment; ok is false if the user has never started enrolling
+func (d DB) GetTwoFactor(userid int) (TwoFactor, bool, error) {
+	var tf TwoFactor
+	stmt := `SELECT secret, enabled, laststep, failures, lastfailure FROM two_factor WHERE userid = ?`
+	err := d.db.QueryRow(stmt, userid).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.Failures, &tf.LastFailure)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tf, false, nil
+	} else if err != nil {
+		return tf, false, eout.Eout(err, "get two-factor of user %d", userid)
+	}
+	return tf, true, nil
+}
+
+func (d DB) HasTwoFactor(userid int) (bool, error) {
+	tf, ok, err := d.GetTwoFactor(userid)
+	return ok && tf.Enabled, err
+}
+
+// starts enrolment with a new secret, replacing any unconfirmed one
+func (d DB) BeginTwoFactor(userid int, secret string) error {
+	stmt := `INSERT INTO two_factor (userid, secret, enabled, laststep, failures) VALUES (?, ?, 0, 0, 0)
+  ON CONFLICT(userid) DO UPDATE SET secret = excluded.secret, laststep = 0, failures = 0 WHERE enabled = 0`
+	_, err := d.Exec(stmt, userid, secret)
+	return eout.Eout(err, "begin two-factor for user %d", userid)
+}
+
+// finishes enrolment once the user has entered a valid code, and returns a fresh set of recovery codes
+func (d DB) EnableTwoFactor(userid int, step int64) ([]string, error) {
+	ed := eout.Describe("enable two-factor")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, ed.Eout(err, "start transaction")
+	}
+	rollbackOnErr := func(incomingErr error) bool {
+		if incomingErr != nil {
+			_ = tx.Rollback()
+			return true
+		}
+		return false
+	}
+	_, err = tx.Exec(`UPDATE two_factor SET enabled = 1, laststep = ?, failures = 0 WHERE userid = ?`, step, userid)
+	if rollbackOnErr(err) {
+		return nil, ed.Eout(err, "enable for user %d", userid)
+	}
+	codes, err := replaceRecoveryCodes(tx, userid)
+	if rollbackOnErr(err) {
+		return nil, ed.Eout(err, "replace recovery codes")
+	}
+	return codes, ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// replaces the user's recovery codes with a fresh set, e.g. when they have used up most of them
+func (d DB) RegenerateRecoveryCodes(userid int) ([]string, error) {
+	ed := eout.Describe("regenerate recovery codes")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, ed.Eout(err, "start transaction")
+	}
+	codes, err := replaceRecoveryCodes(tx, userid)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, ed.Eout(err, "replace recovery codes")
+	}
+	return codes, ed.Eout(tx.Commit(), "commit transaction")
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userid int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE userid = ?`, userid); err != nil {
+		return nil, eout.Eout(err, "delete old recovery codes")
+	}
+	codes := crypto.GenerateRecoveryCodes(RECOVERY_CODE_COUNT)
+	for _, code := range codes {
+		_, err := tx.Exec(`INSERT INTO recovery_codes (userid, codehash) VALUES (?, ?)`, userid, crypto.HashToken(code))
+		if err != nil {
+			return nil, eout.Eout(err, "insert recovery code")
+		}
+	}
+	return codes, nil
+}
+
+// turns off two-factor authentication for the user and forgets their secret and recovery codes
+func (d DB) DisableTwoFactor(userid int) error {
+	ed := eout.Describe("disable two-factor")
+	if _, err := d.Exec(`DELETE FROM recovery_codes WHERE userid = ?`, userid); err != nil {
+		return ed.Eout(err, "delete recovery codes of user %d", userid)
+	}
+	_, err := d.Exec(`DELETE FROM two_factor WHERE userid = ?`, userid)
+	return ed.Eout(err, "delete two-factor of user %d", userid)
+}
+
+func (d DB) CountRecoveryCodes(userid int) (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE userid = ?`, userid).Scan(&count)
+	return count, eout.Eout(err, "count recovery codes of user %d", userid)
+}
+
+// checks a code from the user's authenticator app, or one of their recovery codes, which is then used up. repeated
+// failures lock the check for a while, returning ErrTwoFactorLocked
+func (d DB) VerifyTwoFactor(userid int, code string) (bool, error) {
+	ed := eout.Describe("verify two-factor")
+	tf, ok, err := d.GetTwoFactor(userid)
+	if err != nil {
+		return false, ed.Eout(err, "get two-factor")
+	} else if !ok || !tf.Enabled {
+		return false, nil
+	}
+	now := time.Now()
+	if tf.Failures >= TWO_FACTOR_MAX_FAILURES && tf.LastFailure.Valid && now.Sub(tf.LastFailure.Time) < TWO_FACTOR_LOCKOUT {
+		return false, ErrTwoFactorLocked
+	}
+	if step, valid := crypto.ValidateTOTP(tf.Secret, code, now, tf.LastStep); valid {
+		// another request may have used the same code since it was read above; only one of them gets to record it
+		stmt := `UPDATE two_factor SET laststep = ?, failures = 0 WHERE userid = ? AND laststep < ?`
+		result, err := d.Exec(stmt, step, userid, step)
+		if err != nil {
+			return false, ed.Eout(err, "record accepted code")
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return false, ed.Eout(err, "record accepted code")
+		}
+		// no rows were changed if the code was replayed
+		return affected > 0, nil
+	}
+	hash := crypto.HashToken(crypto.NormalizeRecoveryCode(code))
+	result, err := d.Exec(`DELETE FROM recovery_codes WHERE userid = ? AND codehash = ?`, userid, hash)
+	if err != nil {
+		return false, ed.Eout(err, "use recovery code")
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		_, err = d.Exec(`UPDATE two_factor SET failures = 0 WHERE userid = ?`, userid)
+		return err == nil, ed.Eout(err, "reset failures")
+	}
+	// a lockout that has run out starts over from zero
+	failures := tf.Failures + 1
+	if tf.Failures >= TWO_FACTOR_MAX_FAILURES {
+		failures = 1
+	}
+	_, err = d.Exec(`UPDATE two_factor SET failures = ?, lastfailure = ? WHERE userid = ?`, failures, now, userid)
+	return false, ed.Eout(err, "record failure")
+}
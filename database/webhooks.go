@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// webhooks let other programs, such as chat bridges and bots, react to what happens on the forum: each webhook is sent
+// the events it has chosen, as json posted to its url, see server/webhooks.go. every event sent to a webhook is a row
+// in webhook_deliveries, which is both the queue of deliveries still to be made and the webhook's delivery log
+
+// the status of a delivery
+const (
+	WEBHOOK_PENDING   = "pending"
+	WEBHOOK_DELIVERED = "delivered"
+	WEBHOOK_FAILED    = "failed"
+)
+
+type Webhook struct {
+	ID     int
+	URL    string
+	Secret string
+	// the events the webhook is sent
+	Events []string
+	// whether the webhook is also sent events in private threads
+	Private bool
+	Created time.Time
+}
+
+// whether the webhook is sent the event
+func (w Webhook) Wants(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d DB) CreateWebhook(url, secret string, events []string, private bool) (int, error) {
+	stmt := `INSERT INTO webhooks (url, secret, events, private, created) VALUES (?, ?, ?, ?, ?) RETURNING id`
+	var id int
+	err := d.db.QueryRow(stmt, url, secret, strings.Join(events, " "), private, time.Now()).Scan(&id)
+	return id, eout.Eout(err, "create webhook for %s", url)
+}
+
+func (d DB) UpdateWebhook(id int, url string, events []string, private bool) error {
+	stmt := `UPDATE webhooks SET url = ?, events = ?, private = ? WHERE id = ?`
+	_, err := d.Exec(stmt, url, strings.Join(events, " "), private, id)
+	return eout.Eout(err, "update webhook %d", id)
+}
+
+func (d DB) UpdateWebhookSecret(id int, secret string) error {
+	_, err := d.Exec(`UPDATE webhooks SET secret = ? WHERE id = ?`, secret, id)
+	return eout.Eout(err, "update secret of webhook %d", id)
+}
+
+// deletes a webhook together with its deliveries, including those that are still pending
+func (d DB) DeleteWebhook(id int) error {
+	ed := eout.Describe("delete webhook")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	if _, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhookid = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return ed.Eout(err, "delete deliveries of webhook %d", id)
+	}
+	if _, err = tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return ed.Eout(err, "delete webhook %d", id)
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &events, &webhook.Private, &webhook.Created)
+	webhook.Events = strings.Fields(events)
+	return webhook, err
+}
+
+func (d DB) GetWebhook(id int) (Webhook, error) {
+	row := d.db.QueryRow(`SELECT id, url, secret, events, private, created FROM webhooks WHERE id = ?`, id)
+	webhook, err := scanWebhook(row)
+	return webhook, eout.Eout(err, "get webhook %d", id)
+}
+
+// returns every webhook, oldest first
+func (d DB) GetWebhooks() ([]Webhook, error) {
+	ed := eout.Describe("get webhooks")
+	rows, err := d.db.Query(`SELECT id, url, secret, events, private, created FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, ed.Eout(err, "query webhooks")
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, ed.Eout(err, "scan webhooks")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, ed.Eout(rows.Err(), "iterate webhooks")
+}
+
+type WebhookDelivery struct {
+	ID        int
+	WebhookID int
+	Event     string
+	Payload   []byte
+	Status    string
+	// how many times delivering the event has been attempted
+	Attempts    int
+	NextAttempt NullTime
+	// how the last attempt went, e.g. "200 OK"
+	Response string
+	Created  time.Time
+	// the webhook's url and secret, set by GetDueWebhookDeliveries
+	URL    string
+	Secret string
+}
+
+// queues the event's payload for delivery to each of the webhooks
+func (d DB) QueueWebhookDeliveries(event string, payload []byte, webhookids []int) error {
+	ed := eout.Describe("queue webhook deliveries")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return ed.Eout(err, "start transaction")
+	}
+	now := time.Now()
+	stmt := `INSERT INTO webhook_deliveries (webhookid, event, payload, status, nextattempt, created) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, id := range webhookids {
+		if _, err = tx.Exec(stmt, id, event, string(payload), WEBHOOK_PENDING, now, now); err != nil {
+			_ = tx.Rollback()
+			return ed.Eout(err, "queue %s for webhook %d", event, id)
+		}
+	}
+	return ed.Eout(tx.Commit(), "commit transaction")
+}
+
+// returns up to limit pending deliveries that are due to be attempted, oldest first
+func (d DB) GetDueWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	ed := eout.Describe("get due webhook deliveries")
+	stmt := `
+  SELECT d.id, d.webhookid, d.event, d.payload, d.attempts, w.url, w.secret
+  FROM webhook_deliveries d
+  INNER JOIN webhooks w ON w.id = d.webhookid
+  WHERE d.status = ? AND d.nextattempt <= ?
+  ORDER BY d.id
+  LIMIT ?
+  `
+	rows, err := d.db.Query(stmt, WEBHOOK_PENDING, time.Now(), limit)
+	if err != nil {
+		return nil, ed.Eout(err, "query deliveries")
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload string
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Attempts, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, ed.Eout(err, "scan deliveries")
+		}
+		delivery.Payload = []byte(payload)
+		delivery.Status = WEBHOOK_PENDING
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, ed.Eout(rows.Err(), "iterate deliveries")
+}
+
+// records an attempt at a delivery. a delivery that is to be attempted again is given the time to do so in next, and
+// stays pending; otherwise status is WEBHOOK_DELIVERED or WEBHOOK_FAILED, and next is ignored
+func (d DB) RecordWebhookAttempt(id int, status string, next time.Time, response string) error {
+	var nextattempt sql.NullTime
+	if status == WEBHOOK_PENDING {
+		nextattempt = sql.NullTime{Time: next, Valid: true}
+	}
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, nextattempt = ?, response = ? WHERE id = ?`
+	_, err := d.Exec(stmt, status, nextattempt, response, id)
+	return eout.Eout(err, "record attempt at webhook delivery %d", id)
+}
+
+// returns the webhook's latest deliveries, latest first, without their payloads
+func (d DB) GetWebhookDeliveries(webhookid, limit int) ([]WebhookDelivery, error) {
+	ed := eout.Describe("get webhook deliveries")
+	stmt := `
+  SELECT id, webhookid, event, status, attempts, nextattempt, response, created
+  FROM webhook_deliveries
+  WHERE webhookid = ?
+  ORDER BY id DESC
+  LIMIT ?
+  `
+	rows, err := d.db.Query(stmt, webhookid, limit)
+	if err != nil {
+		return nil, ed.Eout(err, "query deliveries of webhook %d", webhookid)
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var response sql.NullString
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Status, &delivery.Attempts, &delivery.NextAttempt, &response, &delivery.Created); err != nil {
+			return nil, ed.Eout(err, "scan deliveries of webhook %d", webhookid)
+		}
+		delivery.Response = response.String
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, ed.Eout(rows.Err(), "iterate deliveries of webhook %d", webhookid)
+}
+
+// removes the deliveries that were made, or given up on, before the given time from the delivery log
+func (d DB) PruneWebhookDeliveries(before time.Time) error {
+	_, err := d.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created < ?`, WEBHOOK_PENDING, before)
+	return eout.Eout(err, "prune webhook deliveries")
+}
@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"log"
 	"strings"
+	texttemplate "text/template"
 )
 
 const toolURL = "https://github.com/cblgh/cerca/releases/tag/pwtool-v1"
@@ -161,6 +162,136 @@ var English = map[string]string{
 
 	"NewPassword":    "new password",
 	"ChangePassword": "change password",
+
+	"Topic":              "topic",
+	"Topics":             "topics",
+	"TopicIn":            "in",
+	"TopicViewEmpty":     "There are currently no threads in this topic.",
+	"AdminTopics":        "Topics",
+	"AdminWebhooks":      "Webhooks",
+	"AdminPlaceholders":  "Placeholder accounts",
+	"ErrTopic404":        "Topic not found",
+	"ErrTopic404Message": "The topic does not exist (anymore?)",
+
+	"Search":               "search",
+	"SearchQuery":          "Search for",
+	"SearchFilters":        "Filters",
+	"SearchFrom":           "Posted from",
+	"SearchTo":             "Posted until",
+	"SearchNoResults":      "No posts matched your search.",
+	"SearchPages":          "Search result pages",
+	"SearchPrevious":       "Previous page",
+	"SearchNext":           "Next page",
+	"SearchUnavailable":    "Search is not available on this forum.",
+	"SearchErrInvalidDate": "Dates have to be written as year-month-day, e.g. 2026-10-17.",
+	"SearchErrGeneric":     "Something went wrong while searching, please try again.",
+
+	"AdminModerateThread":       "Moderate thread",
+	"AdminMoveThreadTo":         "Topic",
+	"AdminMoveThread":           "Move thread",
+	"AdminDeleteThread":         "Delete thread",
+	"AdminDeleteThreadQuestion": "Delete this thread and all of its posts?",
+	"AdminDeleteThreadSuccess":  "The thread and all of its posts have been deleted.",
+
+	"modlogDeleteThread": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted {{ if .Data.Note }}the thread <i>{{ .Data.Note }}</i>{{ else }}a thread{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogMoveThread":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> moved {{ if .Data.SubjectID }}<a href="/thread/{{ .Data.SubjectID }}/">a thread</a>{{ else }}a thread{{ end }} to the topic <b>{{ .Data.Note }}</b>`,
+
+	"AdminHidePost":       "hide",
+	"AdminUnhidePost":     "unhide",
+	"AdminHidePostReason": "Reason (optional)",
+	"PostHidden":          "This post was removed by a moderator.",
+	"PostHiddenReason":    "Reason",
+	"PostHiddenShow":      "Show the hidden post",
+
+	"modlogHidePost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> hid {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>{{ if .Data.Note }} (reason: <i>{{ .Data.Note }}</i>){{ end }}`,
+	"modlogUnhidePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> made {{ if .Data.Link }}<a href="{{ .Data.Link }}">a hidden post</a>{{ else }}a hidden post{{ end }} by <b>{{ .Data.RecipientUsername }}</b> visible again`,
+	"modlogEditPost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> edited {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogDeletePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted a post by <b>{{ .Data.RecipientUsername }}</b> in {{ if .Data.Link }}the thread <a href="{{ .Data.Link }}">{{ .Data.Note }}</a>{{ else }}a thread{{ end }}`,
+
+	"Page":         "page",
+	"Pages":        "Pages",
+	"PagePrevious": "Previous page",
+	"PageNext":     "Next page",
+
+	"ErrCSRF":        "Form submission refused",
+	"ErrCSRFMessage": "The form was sent without a valid security token. This happens when a page was open for a long time, or after logging out in another tab. Go back, reload the page and try again.",
+
+	"TwoFactor":            "two-factor authentication",
+	"TwoFactorCode":        "code",
+	"TwoFactorLoginPrompt": "Enter the code shown by your authenticator app, or one of your recovery codes.",
+	"TwoFactorFailure":     "<b>Failed login attempt:</b> the code was incorrect or has already been used.",
+	"TwoFactorLocked":      "<b>Too many incorrect codes.</b> Wait a while before trying again.",
+
+	"modlogClearTwoFactor":      `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off a user's two-factor authentication`,
+	"modlogClearTwoFactorAdmin": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off <b>{{ .Data.RecipientUsername }}</b>'s two-factor authentication`,
+	"modlogCreateClaimLink":     `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> created a link for claiming the imported account <b>{{ .Data.RecipientUsername }}</b>`,
+
+	"LoginLockedOut": "Too many failed attempts. Try again in {{ .Data }} minutes.",
+
+	"Profile":            "profile",
+	"ProfileAdmin":       "This user is an admin of the forum.",
+	"ProfileJoined":      "Joined",
+	"ProfileThreadCount": "Threads started",
+	"ProfilePostCount":   "Posts written",
+	"ProfileRecentPosts": "Recent posts",
+	"ProfileNoPosts":     "There are no posts to show.",
+	"ProfileDeleted":     "This is where the posts of deleted accounts end up, when their authors chose to keep them. The posts are not by any one person.",
+	"ErrUser404":         "User not found",
+	"ErrUser404Message":  "The user does not exist (anymore?)",
+
+	"Unread":                "new",
+	"UnreadJump":            "Jump to the first unread post",
+	"MarkAllRead":           "Mark all as read",
+	"ErrMarkAllRead":        "Could not mark threads as read",
+	"ErrMarkAllReadMessage": "Something went wrong while marking the threads as read, please try again.",
+
+	"Notifications":             "notifications",
+	"NotificationsEmpty":        "You have no notifications. Subscribe to a thread to be notified of new posts in it.",
+	"NotificationReply":         "replied in",
+	"NotificationsMarkSelected": "Mark selected as read",
+	"NotificationsMarkAll":      "Mark all as read",
+	"Subscribe":                 "Subscribe",
+	"Unsubscribe":               "Unsubscribe",
+	"SubscribeHint":             "Get notified of new posts in this thread",
+	"SubscribedHint":            "You are notified of new posts in this thread",
+
+	"NotificationMention": "mentioned you in",
+
+	"EmailFooter":         "{{ .Data.Forum }}\n{{ .Data.URL }}",
+	"EmailVerifySubject":  "Confirm your email address for {{ .Data.Forum }}",
+	"EmailVerifyBody":     "Hi {{ .Data.Username }},\n\nthis email address was added to your account on {{ .Data.Forum }}. To confirm that it is yours, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used for {{ .Data.ValidHours }} hours. If you didn't add this address, you can ignore this email.",
+	"EmailReplySubject":   "{{ .Data.Actor }} replied in {{ .Data.Thread }}",
+	"EmailReplyBody":      "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} replied in {{ .Data.Thread }}, a thread you are subscribed to. Read the reply here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailMentionSubject": "{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}",
+	"EmailMentionBody":    "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}. Read the post here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailInviteSubject":  "You are invited to {{ .Data.Forum }}",
+	"EmailInviteBody":     "Hi,\n\n{{ .Data.Actor }} invited you to join {{ .Data.Forum }}. Register an account using the link below:\n\n{{ .Data.Link }}\n\nThe invite can only be used once.",
+
+	"EmailPasswordResetSubject": "Reset your password on {{ .Data.Forum }}",
+	"EmailPasswordResetBody":    "Hi {{ .Data.Username }},\n\nsomeone, hopefully you, asked to reset the password of your account on {{ .Data.Forum }}. To choose a new password, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used once, for {{ .Data.ValidHours }} hours. If you didn't ask for it, you can ignore this email: your password stays the same.",
+
+	"PasswordResetEmailDescription": "Enter your username. If your account has a verified email address, a link for choosing a new password is emailed to it.",
+	"PasswordResetSend":             "Send reset link",
+	"PasswordResetSent":             "If the account has a verified email address, a reset link has been emailed to it. The link can be used once, for a limited time.",
+	"PasswordResetNoEmail":          "Without a verified email address, ask an admin to reset your password for you.",
+	"PasswordResetTooMany":          "Too many reset links have been requested. Try again later.",
+	"PasswordResetChoose":           "Choose a new password for your account. Once it is saved, you are logged out everywhere.",
+	"PasswordResetRepeat":           "Repeat the new password",
+	"PasswordResetInvalid":          "The reset link is invalid: it has already been used, has expired, or your password or email address has changed since it was sent. Request a new link below.",
+	"PasswordResetTooShort":         "The new password needs to be at least 9 characters long.",
+	"PasswordResetMismatch":         "The new password was incorrectly repeated.",
+	"PasswordResetNotSaved":         "The new password could not be saved; try again.",
+
+	"ClaimAccount":            "Claim your account",
+	"ClaimDescription":        "The posts you wrote before the forum moved here have been imported, and are waiting for you in this account. Choose the name you want to go by and a password to take it over.",
+	"ClaimRepeat":             "Repeat the password",
+	"ClaimSubmit":             "Claim account",
+	"ClaimInvalid":            "The claim link is invalid: it has already been used, has expired, or has been replaced by a newer link. Ask an admin for a new one.",
+	"ClaimUsernameTaken":      "That name is already taken, please pick another one.",
+	"ClaimSuccess":            "Account claimed",
+	"ClaimSuccessMessage":     "The account and its posts are yours, and you are logged in to it.",
+	"ClaimSuccessLinkMessage": "See your posts on",
+	"ClaimSuccessLinkText":    "your profile",
 }
 
 var Swedish = map[string]string{
@@ -313,6 +444,138 @@ var Swedish = map[string]string{
 	"PasswordResetUsernameQuestion": "För de första: hur löd användarnamnet?",
 	"NewPassword":                   "nytt lösenord",
 	"ChangePassword":                "ändra lösenord",
+
+	/* begin 2026-10-17: to translate to swedish */
+	"Topic":              "topic",
+	"Topics":             "topics",
+	"TopicIn":            "in",
+	"TopicViewEmpty":     "There are currently no threads in this topic.",
+	"AdminTopics":        "Topics",
+	"AdminWebhooks":      "Webhooks",
+	"AdminPlaceholders":  "Placeholder accounts",
+	"ErrTopic404":        "Topic not found",
+	"ErrTopic404Message": "The topic does not exist (anymore?)",
+
+	"Search":               "search",
+	"SearchQuery":          "Search for",
+	"SearchFilters":        "Filters",
+	"SearchFrom":           "Posted from",
+	"SearchTo":             "Posted until",
+	"SearchNoResults":      "No posts matched your search.",
+	"SearchPages":          "Search result pages",
+	"SearchPrevious":       "Previous page",
+	"SearchNext":           "Next page",
+	"SearchUnavailable":    "Search is not available on this forum.",
+	"SearchErrInvalidDate": "Dates have to be written as year-month-day, e.g. 2026-10-17.",
+	"SearchErrGeneric":     "Something went wrong while searching, please try again.",
+
+	"AdminModerateThread":       "Moderate thread",
+	"AdminMoveThreadTo":         "Topic",
+	"AdminMoveThread":           "Move thread",
+	"AdminDeleteThread":         "Delete thread",
+	"AdminDeleteThreadQuestion": "Delete this thread and all of its posts?",
+	"AdminDeleteThreadSuccess":  "The thread and all of its posts have been deleted.",
+
+	"modlogDeleteThread": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted {{ if .Data.Note }}the thread <i>{{ .Data.Note }}</i>{{ else }}a thread{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogMoveThread":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> moved {{ if .Data.SubjectID }}<a href="/thread/{{ .Data.SubjectID }}/">a thread</a>{{ else }}a thread{{ end }} to the topic <b>{{ .Data.Note }}</b>`,
+
+	"AdminHidePost":       "hide",
+	"AdminUnhidePost":     "unhide",
+	"AdminHidePostReason": "Reason (optional)",
+	"PostHidden":          "This post was removed by a moderator.",
+	"PostHiddenReason":    "Reason",
+	"PostHiddenShow":      "Show the hidden post",
+
+	"modlogHidePost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> hid {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>{{ if .Data.Note }} (reason: <i>{{ .Data.Note }}</i>){{ end }}`,
+	"modlogUnhidePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> made {{ if .Data.Link }}<a href="{{ .Data.Link }}">a hidden post</a>{{ else }}a hidden post{{ end }} by <b>{{ .Data.RecipientUsername }}</b> visible again`,
+	"modlogEditPost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> edited {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogDeletePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted a post by <b>{{ .Data.RecipientUsername }}</b> in {{ if .Data.Link }}the thread <a href="{{ .Data.Link }}">{{ .Data.Note }}</a>{{ else }}a thread{{ end }}`,
+
+	"Page":         "page",
+	"Pages":        "Pages",
+	"PagePrevious": "Previous page",
+	"PageNext":     "Next page",
+
+	"ErrCSRF":        "Form submission refused",
+	"ErrCSRFMessage": "The form was sent without a valid security token. This happens when a page was open for a long time, or after logging out in another tab. Go back, reload the page and try again.",
+
+	"TwoFactor":            "two-factor authentication",
+	"TwoFactorCode":        "code",
+	"TwoFactorLoginPrompt": "Enter the code shown by your authenticator app, or one of your recovery codes.",
+	"TwoFactorFailure":     "<b>Failed login attempt:</b> the code was incorrect or has already been used.",
+	"TwoFactorLocked":      "<b>Too many incorrect codes.</b> Wait a while before trying again.",
+
+	"modlogClearTwoFactor":      `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off a user's two-factor authentication`,
+	"modlogClearTwoFactorAdmin": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off <b>{{ .Data.RecipientUsername }}</b>'s two-factor authentication`,
+	"modlogCreateClaimLink":     `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> created a link for claiming the imported account <b>{{ .Data.RecipientUsername }}</b>`,
+
+	"LoginLockedOut": "Too many failed attempts. Try again in {{ .Data }} minutes.",
+
+	"Profile":            "profile",
+	"ProfileAdmin":       "This user is an admin of the forum.",
+	"ProfileJoined":      "Joined",
+	"ProfileThreadCount": "Threads started",
+	"ProfilePostCount":   "Posts written",
+	"ProfileRecentPosts": "Recent posts",
+	"ProfileNoPosts":     "There are no posts to show.",
+	"ProfileDeleted":     "This is where the posts of deleted accounts end up, when their authors chose to keep them. The posts are not by any one person.",
+	"ErrUser404":         "User not found",
+	"ErrUser404Message":  "The user does not exist (anymore?)",
+
+	"Unread":                "new",
+	"UnreadJump":            "Jump to the first unread post",
+	"MarkAllRead":           "Mark all as read",
+	"ErrMarkAllRead":        "Could not mark threads as read",
+	"ErrMarkAllReadMessage": "Something went wrong while marking the threads as read, please try again.",
+
+	"Notifications":             "notifications",
+	"NotificationsEmpty":        "You have no notifications. Subscribe to a thread to be notified of new posts in it.",
+	"NotificationReply":         "replied in",
+	"NotificationsMarkSelected": "Mark selected as read",
+	"NotificationsMarkAll":      "Mark all as read",
+	"Subscribe":                 "Subscribe",
+	"Unsubscribe":               "Unsubscribe",
+	"SubscribeHint":             "Get notified of new posts in this thread",
+	"SubscribedHint":            "You are notified of new posts in this thread",
+
+	"NotificationMention": "mentioned you in",
+
+	"EmailFooter":         "{{ .Data.Forum }}\n{{ .Data.URL }}",
+	"EmailVerifySubject":  "Confirm your email address for {{ .Data.Forum }}",
+	"EmailVerifyBody":     "Hi {{ .Data.Username }},\n\nthis email address was added to your account on {{ .Data.Forum }}. To confirm that it is yours, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used for {{ .Data.ValidHours }} hours. If you didn't add this address, you can ignore this email.",
+	"EmailReplySubject":   "{{ .Data.Actor }} replied in {{ .Data.Thread }}",
+	"EmailReplyBody":      "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} replied in {{ .Data.Thread }}, a thread you are subscribed to. Read the reply here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailMentionSubject": "{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}",
+	"EmailMentionBody":    "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}. Read the post here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailInviteSubject":  "You are invited to {{ .Data.Forum }}",
+	"EmailInviteBody":     "Hi,\n\n{{ .Data.Actor }} invited you to join {{ .Data.Forum }}. Register an account using the link below:\n\n{{ .Data.Link }}\n\nThe invite can only be used once.",
+
+	"EmailPasswordResetSubject": "Reset your password on {{ .Data.Forum }}",
+	"EmailPasswordResetBody":    "Hi {{ .Data.Username }},\n\nsomeone, hopefully you, asked to reset the password of your account on {{ .Data.Forum }}. To choose a new password, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used once, for {{ .Data.ValidHours }} hours. If you didn't ask for it, you can ignore this email: your password stays the same.",
+
+	"PasswordResetEmailDescription": "Enter your username. If your account has a verified email address, a link for choosing a new password is emailed to it.",
+	"PasswordResetSend":             "Send reset link",
+	"PasswordResetSent":             "If the account has a verified email address, a reset link has been emailed to it. The link can be used once, for a limited time.",
+	"PasswordResetNoEmail":          "Without a verified email address, ask an admin to reset your password for you.",
+	"PasswordResetTooMany":          "Too many reset links have been requested. Try again later.",
+	"PasswordResetChoose":           "Choose a new password for your account. Once it is saved, you are logged out everywhere.",
+	"PasswordResetRepeat":           "Repeat the new password",
+	"PasswordResetInvalid":          "The reset link is invalid: it has already been used, has expired, or your password or email address has changed since it was sent. Request a new link below.",
+	"PasswordResetTooShort":         "The new password needs to be at least 9 characters long.",
+	"PasswordResetMismatch":         "The new password was incorrectly repeated.",
+	"PasswordResetNotSaved":         "The new password could not be saved; try again.",
+
+	"ClaimAccount":            "Claim your account",
+	"ClaimDescription":        "The posts you wrote before the forum moved here have been imported, and are waiting for you in this account. Choose the name you want to go by and a password to take it over.",
+	"ClaimRepeat":             "Repeat the password",
+	"ClaimSubmit":             "Claim account",
+	"ClaimInvalid":            "The claim link is invalid: it has already been used, has expired, or has been replaced by a newer link. Ask an admin for a new one.",
+	"ClaimUsernameTaken":      "That name is already taken, please pick another one.",
+	"ClaimSuccess":            "Account claimed",
+	"ClaimSuccessMessage":     "The account and its posts are yours, and you are logged in to it.",
+	"ClaimSuccessLinkMessage": "See your posts on",
+	"ClaimSuccessLinkText":    "your profile",
+	/* end 2026-10-17: to translate to swedish */
 }
 
 var Danish = map[string]string{
@@ -464,6 +727,138 @@ var Danish = map[string]string{
 	"PasswordResetUsernameQuestion": "For det første: hvad er dit brugernavn?",
 	"NewPassword":                   "nyt password",
 	"ChangePassword":                "ændre password",
+
+	/* begin 2026-10-17: to translate to danish */
+	"Topic":              "topic",
+	"Topics":             "topics",
+	"TopicIn":            "in",
+	"TopicViewEmpty":     "There are currently no threads in this topic.",
+	"AdminTopics":        "Topics",
+	"AdminWebhooks":      "Webhooks",
+	"AdminPlaceholders":  "Placeholder accounts",
+	"ErrTopic404":        "Topic not found",
+	"ErrTopic404Message": "The topic does not exist (anymore?)",
+
+	"Search":               "search",
+	"SearchQuery":          "Search for",
+	"SearchFilters":        "Filters",
+	"SearchFrom":           "Posted from",
+	"SearchTo":             "Posted until",
+	"SearchNoResults":      "No posts matched your search.",
+	"SearchPages":          "Search result pages",
+	"SearchPrevious":       "Previous page",
+	"SearchNext":           "Next page",
+	"SearchUnavailable":    "Search is not available on this forum.",
+	"SearchErrInvalidDate": "Dates have to be written as year-month-day, e.g. 2026-10-17.",
+	"SearchErrGeneric":     "Something went wrong while searching, please try again.",
+
+	"AdminModerateThread":       "Moderate thread",
+	"AdminMoveThreadTo":         "Topic",
+	"AdminMoveThread":           "Move thread",
+	"AdminDeleteThread":         "Delete thread",
+	"AdminDeleteThreadQuestion": "Delete this thread and all of its posts?",
+	"AdminDeleteThreadSuccess":  "The thread and all of its posts have been deleted.",
+
+	"modlogDeleteThread": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted {{ if .Data.Note }}the thread <i>{{ .Data.Note }}</i>{{ else }}a thread{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogMoveThread":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> moved {{ if .Data.SubjectID }}<a href="/thread/{{ .Data.SubjectID }}/">a thread</a>{{ else }}a thread{{ end }} to the topic <b>{{ .Data.Note }}</b>`,
+
+	"AdminHidePost":       "hide",
+	"AdminUnhidePost":     "unhide",
+	"AdminHidePostReason": "Reason (optional)",
+	"PostHidden":          "This post was removed by a moderator.",
+	"PostHiddenReason":    "Reason",
+	"PostHiddenShow":      "Show the hidden post",
+
+	"modlogHidePost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> hid {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>{{ if .Data.Note }} (reason: <i>{{ .Data.Note }}</i>){{ end }}`,
+	"modlogUnhidePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> made {{ if .Data.Link }}<a href="{{ .Data.Link }}">a hidden post</a>{{ else }}a hidden post{{ end }} by <b>{{ .Data.RecipientUsername }}</b> visible again`,
+	"modlogEditPost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> edited {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogDeletePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted a post by <b>{{ .Data.RecipientUsername }}</b> in {{ if .Data.Link }}the thread <a href="{{ .Data.Link }}">{{ .Data.Note }}</a>{{ else }}a thread{{ end }}`,
+
+	"Page":         "page",
+	"Pages":        "Pages",
+	"PagePrevious": "Previous page",
+	"PageNext":     "Next page",
+
+	"ErrCSRF":        "Form submission refused",
+	"ErrCSRFMessage": "The form was sent without a valid security token. This happens when a page was open for a long time, or after logging out in another tab. Go back, reload the page and try again.",
+
+	"TwoFactor":            "two-factor authentication",
+	"TwoFactorCode":        "code",
+	"TwoFactorLoginPrompt": "Enter the code shown by your authenticator app, or one of your recovery codes.",
+	"TwoFactorFailure":     "<b>Failed login attempt:</b> the code was incorrect or has already been used.",
+	"TwoFactorLocked":      "<b>Too many incorrect codes.</b> Wait a while before trying again.",
+
+	"modlogClearTwoFactor":      `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off a user's two-factor authentication`,
+	"modlogClearTwoFactorAdmin": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off <b>{{ .Data.RecipientUsername }}</b>'s two-factor authentication`,
+	"modlogCreateClaimLink":     `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> created a link for claiming the imported account <b>{{ .Data.RecipientUsername }}</b>`,
+
+	"LoginLockedOut": "Too many failed attempts. Try again in {{ .Data }} minutes.",
+
+	"Profile":            "profile",
+	"ProfileAdmin":       "This user is an admin of the forum.",
+	"ProfileJoined":      "Joined",
+	"ProfileThreadCount": "Threads started",
+	"ProfilePostCount":   "Posts written",
+	"ProfileRecentPosts": "Recent posts",
+	"ProfileNoPosts":     "There are no posts to show.",
+	"ProfileDeleted":     "This is where the posts of deleted accounts end up, when their authors chose to keep them. The posts are not by any one person.",
+	"ErrUser404":         "User not found",
+	"ErrUser404Message":  "The user does not exist (anymore?)",
+
+	"Unread":                "new",
+	"UnreadJump":            "Jump to the first unread post",
+	"MarkAllRead":           "Mark all as read",
+	"ErrMarkAllRead":        "Could not mark threads as read",
+	"ErrMarkAllReadMessage": "Something went wrong while marking the threads as read, please try again.",
+
+	"Notifications":             "notifications",
+	"NotificationsEmpty":        "You have no notifications. Subscribe to a thread to be notified of new posts in it.",
+	"NotificationReply":         "replied in",
+	"NotificationsMarkSelected": "Mark selected as read",
+	"NotificationsMarkAll":      "Mark all as read",
+	"Subscribe":                 "Subscribe",
+	"Unsubscribe":               "Unsubscribe",
+	"SubscribeHint":             "Get notified of new posts in this thread",
+	"SubscribedHint":            "You are notified of new posts in this thread",
+
+	"NotificationMention": "mentioned you in",
+
+	"EmailFooter":         "{{ .Data.Forum }}\n{{ .Data.URL }}",
+	"EmailVerifySubject":  "Confirm your email address for {{ .Data.Forum }}",
+	"EmailVerifyBody":     "Hi {{ .Data.Username }},\n\nthis email address was added to your account on {{ .Data.Forum }}. To confirm that it is yours, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used for {{ .Data.ValidHours }} hours. If you didn't add this address, you can ignore this email.",
+	"EmailReplySubject":   "{{ .Data.Actor }} replied in {{ .Data.Thread }}",
+	"EmailReplyBody":      "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} replied in {{ .Data.Thread }}, a thread you are subscribed to. Read the reply here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailMentionSubject": "{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}",
+	"EmailMentionBody":    "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}. Read the post here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailInviteSubject":  "You are invited to {{ .Data.Forum }}",
+	"EmailInviteBody":     "Hi,\n\n{{ .Data.Actor }} invited you to join {{ .Data.Forum }}. Register an account using the link below:\n\n{{ .Data.Link }}\n\nThe invite can only be used once.",
+
+	"EmailPasswordResetSubject": "Reset your password on {{ .Data.Forum }}",
+	"EmailPasswordResetBody":    "Hi {{ .Data.Username }},\n\nsomeone, hopefully you, asked to reset the password of your account on {{ .Data.Forum }}. To choose a new password, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used once, for {{ .Data.ValidHours }} hours. If you didn't ask for it, you can ignore this email: your password stays the same.",
+
+	"PasswordResetEmailDescription": "Enter your username. If your account has a verified email address, a link for choosing a new password is emailed to it.",
+	"PasswordResetSend":             "Send reset link",
+	"PasswordResetSent":             "If the account has a verified email address, a reset link has been emailed to it. The link can be used once, for a limited time.",
+	"PasswordResetNoEmail":          "Without a verified email address, ask an admin to reset your password for you.",
+	"PasswordResetTooMany":          "Too many reset links have been requested. Try again later.",
+	"PasswordResetChoose":           "Choose a new password for your account. Once it is saved, you are logged out everywhere.",
+	"PasswordResetRepeat":           "Repeat the new password",
+	"PasswordResetInvalid":          "The reset link is invalid: it has already been used, has expired, or your password or email address has changed since it was sent. Request a new link below.",
+	"PasswordResetTooShort":         "The new password needs to be at least 9 characters long.",
+	"PasswordResetMismatch":         "The new password was incorrectly repeated.",
+	"PasswordResetNotSaved":         "The new password could not be saved; try again.",
+
+	"ClaimAccount":            "Claim your account",
+	"ClaimDescription":        "The posts you wrote before the forum moved here have been imported, and are waiting for you in this account. Choose the name you want to go by and a password to take it over.",
+	"ClaimRepeat":             "Repeat the password",
+	"ClaimSubmit":             "Claim account",
+	"ClaimInvalid":            "The claim link is invalid: it has already been used, has expired, or has been replaced by a newer link. Ask an admin for a new one.",
+	"ClaimUsernameTaken":      "That name is already taken, please pick another one.",
+	"ClaimSuccess":            "Account claimed",
+	"ClaimSuccessMessage":     "The account and its posts are yours, and you are logged in to it.",
+	"ClaimSuccessLinkMessage": "See your posts on",
+	"ClaimSuccessLinkText":    "your profile",
+	/* end 2026-10-17: to translate to danish */
 }
 
 var EspanolLATAM = map[string]string{
@@ -617,6 +1012,138 @@ var EspanolLATAM = map[string]string{
 
 	"NewPassword":    "Nueva contraseña",
 	"ChangePassword": "Cambiar contraseña",
+
+	/* begin 2026-10-17: to translate to spanish */
+	"Topic":              "topic",
+	"Topics":             "topics",
+	"TopicIn":            "in",
+	"TopicViewEmpty":     "There are currently no threads in this topic.",
+	"AdminTopics":        "Topics",
+	"AdminWebhooks":      "Webhooks",
+	"AdminPlaceholders":  "Placeholder accounts",
+	"ErrTopic404":        "Topic not found",
+	"ErrTopic404Message": "The topic does not exist (anymore?)",
+
+	"Search":               "search",
+	"SearchQuery":          "Search for",
+	"SearchFilters":        "Filters",
+	"SearchFrom":           "Posted from",
+	"SearchTo":             "Posted until",
+	"SearchNoResults":      "No posts matched your search.",
+	"SearchPages":          "Search result pages",
+	"SearchPrevious":       "Previous page",
+	"SearchNext":           "Next page",
+	"SearchUnavailable":    "Search is not available on this forum.",
+	"SearchErrInvalidDate": "Dates have to be written as year-month-day, e.g. 2026-10-17.",
+	"SearchErrGeneric":     "Something went wrong while searching, please try again.",
+
+	"AdminModerateThread":       "Moderate thread",
+	"AdminMoveThreadTo":         "Topic",
+	"AdminMoveThread":           "Move thread",
+	"AdminDeleteThread":         "Delete thread",
+	"AdminDeleteThreadQuestion": "Delete this thread and all of its posts?",
+	"AdminDeleteThreadSuccess":  "The thread and all of its posts have been deleted.",
+
+	"modlogDeleteThread": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted {{ if .Data.Note }}the thread <i>{{ .Data.Note }}</i>{{ else }}a thread{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogMoveThread":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> moved {{ if .Data.SubjectID }}<a href="/thread/{{ .Data.SubjectID }}/">a thread</a>{{ else }}a thread{{ end }} to the topic <b>{{ .Data.Note }}</b>`,
+
+	"AdminHidePost":       "hide",
+	"AdminUnhidePost":     "unhide",
+	"AdminHidePostReason": "Reason (optional)",
+	"PostHidden":          "This post was removed by a moderator.",
+	"PostHiddenReason":    "Reason",
+	"PostHiddenShow":      "Show the hidden post",
+
+	"modlogHidePost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> hid {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>{{ if .Data.Note }} (reason: <i>{{ .Data.Note }}</i>){{ end }}`,
+	"modlogUnhidePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> made {{ if .Data.Link }}<a href="{{ .Data.Link }}">a hidden post</a>{{ else }}a hidden post{{ end }} by <b>{{ .Data.RecipientUsername }}</b> visible again`,
+	"modlogEditPost":   `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> edited {{ if .Data.Link }}<a href="{{ .Data.Link }}">a post</a>{{ else }}a post{{ end }} by <b>{{ .Data.RecipientUsername }}</b>`,
+	"modlogDeletePost": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> deleted a post by <b>{{ .Data.RecipientUsername }}</b> in {{ if .Data.Link }}the thread <a href="{{ .Data.Link }}">{{ .Data.Note }}</a>{{ else }}a thread{{ end }}`,
+
+	"Page":         "page",
+	"Pages":        "Pages",
+	"PagePrevious": "Previous page",
+	"PageNext":     "Next page",
+
+	"ErrCSRF":        "Form submission refused",
+	"ErrCSRFMessage": "The form was sent without a valid security token. This happens when a page was open for a long time, or after logging out in another tab. Go back, reload the page and try again.",
+
+	"TwoFactor":            "two-factor authentication",
+	"TwoFactorCode":        "code",
+	"TwoFactorLoginPrompt": "Enter the code shown by your authenticator app, or one of your recovery codes.",
+	"TwoFactorFailure":     "<b>Failed login attempt:</b> the code was incorrect or has already been used.",
+	"TwoFactorLocked":      "<b>Too many incorrect codes.</b> Wait a while before trying again.",
+
+	"modlogClearTwoFactor":      `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off a user's two-factor authentication`,
+	"modlogClearTwoFactorAdmin": `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> turned off <b>{{ .Data.RecipientUsername }}</b>'s two-factor authentication`,
+	"modlogCreateClaimLink":     `<code>{{ .Data.Time }}</code> <b>{{ .Data.ActingUsername }}</b> created a link for claiming the imported account <b>{{ .Data.RecipientUsername }}</b>`,
+
+	"LoginLockedOut": "Too many failed attempts. Try again in {{ .Data }} minutes.",
+
+	"Profile":            "profile",
+	"ProfileAdmin":       "This user is an admin of the forum.",
+	"ProfileJoined":      "Joined",
+	"ProfileThreadCount": "Threads started",
+	"ProfilePostCount":   "Posts written",
+	"ProfileRecentPosts": "Recent posts",
+	"ProfileNoPosts":     "There are no posts to show.",
+	"ProfileDeleted":     "This is where the posts of deleted accounts end up, when their authors chose to keep them. The posts are not by any one person.",
+	"ErrUser404":         "User not found",
+	"ErrUser404Message":  "The user does not exist (anymore?)",
+
+	"Unread":                "new",
+	"UnreadJump":            "Jump to the first unread post",
+	"MarkAllRead":           "Mark all as read",
+	"ErrMarkAllRead":        "Could not mark threads as read",
+	"ErrMarkAllReadMessage": "Something went wrong while marking the threads as read, please try again.",
+
+	"Notifications":             "notifications",
+	"NotificationsEmpty":        "You have no notifications. Subscribe to a thread to be notified of new posts in it.",
+	"NotificationReply":         "replied in",
+	"NotificationsMarkSelected": "Mark selected as read",
+	"NotificationsMarkAll":      "Mark all as read",
+	"Subscribe":                 "Subscribe",
+	"Unsubscribe":               "Unsubscribe",
+	"SubscribeHint":             "Get notified of new posts in this thread",
+	"SubscribedHint":            "You are notified of new posts in this thread",
+
+	"NotificationMention": "mentioned you in",
+
+	"EmailFooter":         "{{ .Data.Forum }}\n{{ .Data.URL }}",
+	"EmailVerifySubject":  "Confirm your email address for {{ .Data.Forum }}",
+	"EmailVerifyBody":     "Hi {{ .Data.Username }},\n\nthis email address was added to your account on {{ .Data.Forum }}. To confirm that it is yours, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used for {{ .Data.ValidHours }} hours. If you didn't add this address, you can ignore this email.",
+	"EmailReplySubject":   "{{ .Data.Actor }} replied in {{ .Data.Thread }}",
+	"EmailReplyBody":      "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} replied in {{ .Data.Thread }}, a thread you are subscribed to. Read the reply here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailMentionSubject": "{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}",
+	"EmailMentionBody":    "Hi {{ .Data.Username }},\n\n{{ .Data.Actor }} mentioned you in {{ .Data.Thread }}. Read the post here:\n\n{{ .Data.Link }}\n\nYou receive emails about your notifications because you asked for them on your account page, {{ .Data.URL }}/account, where you can also turn them off.",
+	"EmailInviteSubject":  "You are invited to {{ .Data.Forum }}",
+	"EmailInviteBody":     "Hi,\n\n{{ .Data.Actor }} invited you to join {{ .Data.Forum }}. Register an account using the link below:\n\n{{ .Data.Link }}\n\nThe invite can only be used once.",
+
+	"EmailPasswordResetSubject": "Reset your password on {{ .Data.Forum }}",
+	"EmailPasswordResetBody":    "Hi {{ .Data.Username }},\n\nsomeone, hopefully you, asked to reset the password of your account on {{ .Data.Forum }}. To choose a new password, open the link below:\n\n{{ .Data.Link }}\n\nThe link can be used once, for {{ .Data.ValidHours }} hours. If you didn't ask for it, you can ignore this email: your password stays the same.",
+
+	"PasswordResetEmailDescription": "Enter your username. If your account has a verified email address, a link for choosing a new password is emailed to it.",
+	"PasswordResetSend":             "Send reset link",
+	"PasswordResetSent":             "If the account has a verified email address, a reset link has been emailed to it. The link can be used once, for a limited time.",
+	"PasswordResetNoEmail":          "Without a verified email address, ask an admin to reset your password for you.",
+	"PasswordResetTooMany":          "Too many reset links have been requested. Try again later.",
+	"PasswordResetChoose":           "Choose a new password for your account. Once it is saved, you are logged out everywhere.",
+	"PasswordResetRepeat":           "Repeat the new password",
+	"PasswordResetInvalid":          "The reset link is invalid: it has already been used, has expired, or your password or email address has changed since it was sent. Request a new link below.",
+	"PasswordResetTooShort":         "The new password needs to be at least 9 characters long.",
+	"PasswordResetMismatch":         "The new password was incorrectly repeated.",
+	"PasswordResetNotSaved":         "The new password could not be saved; try again.",
+
+	"ClaimAccount":            "Claim your account",
+	"ClaimDescription":        "The posts you wrote before the forum moved here have been imported, and are waiting for you in this account. Choose the name you want to go by and a password to take it over.",
+	"ClaimRepeat":             "Repeat the password",
+	"ClaimSubmit":             "Claim account",
+	"ClaimInvalid":            "The claim link is invalid: it has already been used, has expired, or has been replaced by a newer link. Ask an admin for a new one.",
+	"ClaimUsernameTaken":      "That name is already taken, please pick another one.",
+	"ClaimSuccess":            "Account claimed",
+	"ClaimSuccessMessage":     "The account and its posts are yours, and you are logged in to it.",
+	"ClaimSuccessLinkMessage": "See your posts on",
+	"ClaimSuccessLinkText":    "your profile",
+	/* end 2026-10-17: to translate to spanish */
 }
 
 var translations = map[string]map[string]string{
@@ -641,6 +1168,18 @@ func (tr *Translator) TranslateWithData(key string, data TranslationData) string
 	return sb.String()
 }
 
+// like TranslateWithData, but for plain text such as emails: the data is not html escaped
+func (tr *Translator) TranslateText(key string, data TranslationData) string {
+	phrase := translations[tr.Language][key]
+	t, err := texttemplate.New(key).Parse(phrase)
+	ed := eout.Describe("i18n text translation")
+	ed.Check(err, "parse translation phrase")
+	sb := new(strings.Builder)
+	err = t.Execute(sb, data)
+	ed.Check(err, "execute template with data")
+	return sb.String()
+}
+
 func (tr *Translator) Translate(key string) string {
 	var empty TranslationData
 	return tr.TranslateWithData(key, empty)
@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+// Discourse reads a json export of a discourse forum: a single object with the arrays "users", "categories", "topics"
+// and "posts", whose entries have the fields of the same objects in discourse's api, see IMPORTING.md. private
+// messages, whispers, small actions ("closed the topic"), and deleted topics and posts are left out. topics in
+// categories with restricted access become private threads
+type Discourse struct{}
+
+type discourseExport struct {
+	Users []struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"users"`
+	Categories []struct {
+		ID             int    `json:"id"`
+		Name           string `json:"name"`
+		ReadRestricted bool   `json:"read_restricted"`
+	} `json:"categories"`
+	Topics []struct {
+		ID         int        `json:"id"`
+		Title      string     `json:"title"`
+		CategoryID int        `json:"category_id"`
+		Archetype  string     `json:"archetype"`
+		DeletedAt  *time.Time `json:"deleted_at"`
+	} `json:"topics"`
+	Posts []struct {
+		ID         int    `json:"id"`
+		TopicID    int    `json:"topic_id"`
+		UserID     int    `json:"user_id"`
+		Username   string `json:"username"`
+		PostNumber int    `json:"post_number"`
+		// 1 is a regular post, 2 a moderator's, 3 a small action and 4 a whisper
+		PostType int `json:"post_type"`
+		// the markdown the post was written in, and the html it was rendered to
+		Raw       string     `json:"raw"`
+		Cooked    string     `json:"cooked"`
+		CreatedAt time.Time  `json:"created_at"`
+		DeletedAt *time.Time `json:"deleted_at"`
+	} `json:"posts"`
+}
+
+func (Discourse) Read(r io.Reader, warn func(format string, args ...interface{})) ([]database.ImportedThread, error) {
+	var export discourseExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("read discourse export: %w", err)
+	}
+	usernames := make(map[int]string)
+	for _, user := range export.Users {
+		usernames[user.ID] = user.Username
+	}
+
+	type category struct {
+		name    string
+		private bool
+	}
+	categories := make(map[int]category)
+	for _, c := range export.Categories {
+		categories[c.ID] = category{c.Name, c.ReadRestricted}
+	}
+
+	threads := make(map[int]*database.ImportedThread)
+	var order []int
+	for _, topic := range export.Topics {
+		if topic.DeletedAt != nil || topic.Archetype == "private_message" {
+			continue
+		}
+		c, ok := categories[topic.CategoryID]
+		if !ok && topic.CategoryID != 0 {
+			warn("topic %d: category %d is not in the export, the thread goes in the default topic", topic.ID, topic.CategoryID)
+		}
+		threads[topic.ID] = &database.ImportedThread{Title: topic.Title, Topic: c.name, Private: c.private}
+		order = append(order, topic.ID)
+	}
+
+	posts := export.Posts
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].PostNumber < posts[j].PostNumber })
+	for _, post := range posts {
+		if post.DeletedAt != nil || (post.PostType != 0 && post.PostType != 1 && post.PostType != 2) {
+			continue
+		}
+		thread, ok := threads[post.TopicID]
+		if !ok {
+			// posts in private messages and deleted topics end up here too, so this isn't worth a warning
+			continue
+		}
+		username := post.Username
+		if username == "" {
+			username = usernames[post.UserID]
+		}
+		if username == "" {
+			warn("post %d: user %d is not in the export, the post is left out", post.ID, post.UserID)
+			continue
+		}
+		content := post.Raw
+		if content == "" {
+			content = post.Cooked
+		}
+		thread.Posts = append(thread.Posts, database.ImportedPost{
+			Author:    database.ImportedAuthor{Original: username, Name: username},
+			Content:   content,
+			Published: post.CreatedAt,
+		})
+	}
+
+	var imported []database.ImportedThread
+	for _, id := range order {
+		if len(threads[id].Posts) == 0 {
+			warn("topic %d (%s) has no posts, and is left out", id, threads[id].Title)
+			continue
+		}
+		imported = append(imported, *threads[id])
+	}
+	return imported, nil
+}
@@ -0,0 +1,34 @@
+// package importer reads the threads of other forum software, and of mailing lists, from their exports, for `cerca
+// import-from` to add to a forum with database.ImportThreads
+package importer
+
+import (
+	"io"
+	"sort"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+// a source reads the threads of one kind of export. problems that only affect part of the export, such as a post that
+// can't be read, are passed to warn, and the rest of the export is read as well as it can be
+type Source interface {
+	Read(r io.Reader, warn func(format string, args ...interface{})) ([]database.ImportedThread, error)
+}
+
+// the sources `cerca import-from` can read, by the name that is given to -source. the name is also stored with the
+// placeholder accounts of the authors, so that importing from the same source again finds their accounts
+var Sources = map[string]Source{
+	"discourse": Discourse{},
+	"phpbb":     PhpBB{},
+	"mbox":      Mbox{},
+}
+
+// the names of the sources, sorted
+func SourceNames() []string {
+	var names []string
+	for name := range Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
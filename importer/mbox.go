@@ -0,0 +1,277 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+// Mbox reads a mailing list archive in the mbox format. replies are found through their In-Reply-To and References
+// headers, and a message that isn't a reply to any message in the archive starts a thread. a message's text is its
+// first text/plain part, without its signature. authors are told apart by their email address, which is only shown
+// to admins, and are named after the name they gave in their From header
+type Mbox struct{}
+
+type mboxMessage struct {
+	id         string
+	references []string
+	subject    string
+	author     database.ImportedAuthor
+	date       time.Time
+	content    string
+	// the order of the message in the archive
+	index int
+}
+
+var (
+	// the line that separates messages, e.g. "From alice@example.org Mon Jan  2 15:04:05 2006"
+	mboxSeparator = regexp.MustCompile(`^From \S+ +(.*)$`)
+	// a line starting with "From " in a message is stored with a > in front of it
+	mboxEscaped = regexp.MustCompile(`^>+From `)
+	// the prefixes of the subjects of replies and forwarded messages
+	mboxReplyPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv|vs)(\[\d+\])?:\s*)+`)
+	mboxMessageID   = regexp.MustCompile(`<[^<>\s]+>`)
+)
+
+func (Mbox) Read(r io.Reader, warn func(format string, args ...interface{})) ([]database.ImportedThread, error) {
+	var messages []mboxMessage
+	var raw bytes.Buffer
+	var separatorDate time.Time
+	// the number of messages seen so far
+	index := 0
+	previousBlank := true
+	// parses the message read so far
+	flush := func() {
+		if index == 0 {
+			return
+		}
+		message, err := readMboxMessage(raw.Bytes(), separatorDate)
+		if err != nil {
+			warn("message %d: %v, it is left out", index, err)
+		} else {
+			message.index = index
+			messages = append(messages, message)
+		}
+		raw.Reset()
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if previousBlank && strings.HasPrefix(line, "From ") {
+			flush()
+			index++
+			separatorDate = time.Time{}
+			if match := mboxSeparator.FindStringSubmatch(line); match != nil {
+				separatorDate, _ = time.Parse(time.ANSIC, strings.Join(strings.Fields(match[1]), " "))
+			}
+			previousBlank = false
+			continue
+		}
+		previousBlank = line == ""
+		if mboxEscaped.MatchString(line) {
+			line = line[1:]
+		}
+		raw.WriteString(line)
+		raw.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read mbox: %w", err)
+	}
+	flush()
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages found; is it an mbox file?")
+	}
+
+	byID := make(map[string]*mboxMessage)
+	for i := range messages {
+		if id := messages[i].id; id != "" {
+			if _, ok := byID[id]; ok {
+				warn("message %d: duplicate Message-ID %s", messages[i].index, id)
+				continue
+			}
+			byID[id] = &messages[i]
+		}
+	}
+	// the parent of a message is the latest message it refers to that is in the archive
+	parent := func(m *mboxMessage) *mboxMessage {
+		for i := len(m.references) - 1; i >= 0; i-- {
+			if p, ok := byID[m.references[i]]; ok && p != m {
+				return p
+			}
+		}
+		return nil
+	}
+	root := func(m *mboxMessage) *mboxMessage {
+		seen := map[*mboxMessage]bool{m: true}
+		for p := parent(m); p != nil && !seen[p]; p = parent(p) {
+			seen[p] = true
+			m = p
+		}
+		return m
+	}
+
+	threads := make(map[*mboxMessage][]*mboxMessage)
+	var roots []*mboxMessage
+	for i := range messages {
+		m := &messages[i]
+		r := root(m)
+		if _, ok := threads[r]; !ok {
+			roots = append(roots, r)
+		}
+		threads[r] = append(threads[r], m)
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].date.Before(roots[j].date) })
+
+	var imported []database.ImportedThread
+	for _, r := range roots {
+		title := strings.TrimSpace(mboxReplyPrefix.ReplaceAllString(r.subject, ""))
+		if title == "" {
+			title = "(no subject)"
+		}
+		thread := database.ImportedThread{Title: title}
+		replies := threads[r]
+		sort.SliceStable(replies, func(i, j int) bool { return replies[i].date.Before(replies[j].date) })
+		for _, m := range replies {
+			thread.Posts = append(thread.Posts, database.ImportedPost{Author: m.author, Content: m.content, Published: m.date})
+		}
+		imported = append(imported, thread)
+	}
+	return imported, nil
+}
+
+func readMboxMessage(raw []byte, separatorDate time.Time) (mboxMessage, error) {
+	var m mboxMessage
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return m, err
+	}
+	decoder := mime.WordDecoder{CharsetReader: charsetReader}
+	header := func(name string) string {
+		value := msg.Header.Get(name)
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+
+	m.id = mboxMessageID.FindString(msg.Header.Get("Message-ID"))
+	m.references = mboxMessageID.FindAllString(msg.Header.Get("References"), -1)
+	if inReplyTo := mboxMessageID.FindString(msg.Header.Get("In-Reply-To")); inReplyTo != "" {
+		m.references = append(m.references, inReplyTo)
+	}
+	m.subject = header("Subject")
+
+	parser := mail.AddressParser{WordDecoder: &decoder}
+	from, err := parser.Parse(msg.Header.Get("From"))
+	if err != nil {
+		return m, fmt.Errorf("unreadable From header %q", msg.Header.Get("From"))
+	}
+	name := strings.TrimSpace(from.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(from.Address, "@")
+	}
+	m.author = database.ImportedAuthor{Original: strings.ToLower(from.Address), Name: name}
+
+	m.date, err = msg.Header.Date()
+	if err != nil {
+		if separatorDate.IsZero() {
+			return m, fmt.Errorf("no date")
+		}
+		m.date = separatorDate
+	}
+
+	body, err := mboxText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return m, err
+	}
+	m.content = mboxWithoutSignature(body)
+	if m.content == "" {
+		return m, fmt.Errorf("no text")
+	}
+	return m, nil
+}
+
+// returns the text of the first text/plain part of a message, or of a part of it
+func mboxText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// messages without a content type are plain text
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				return "", fmt.Errorf("no text/plain part")
+			} else if err != nil {
+				return "", err
+			}
+			text, err := mboxText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("no text/plain part")
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	reader, err := charsetReader(params["charset"], body)
+	if err != nil {
+		return "", err
+	}
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(text), "�"), nil
+}
+
+// reads text in the charsets that are common in mailing list archives as utf-8; other charsets are read as if they
+// were utf-8, which works for text that is mostly ascii
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "iso-8859-15", "windows-1252", "cp1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return input, nil
+}
+
+// cuts off the signature, which starts with a line that is "-- ", and trims the text
+func mboxWithoutSignature(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if strings.HasPrefix(text, "-- \n") {
+		return ""
+	}
+	if i := strings.Index(text, "\n-- \n"); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
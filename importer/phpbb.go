@@ -0,0 +1,287 @@
+package importer
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+// PhpBB reads the users, forums, topics and posts tables of a phpBB 3 database from a mysql dump of it, see IMPORTING.md.
+// the tables' prefix, usually phpbb_, is found by looking for the posts table. forums become topics, and posts are
+// converted from bbcode to markdown. posts and topics awaiting approval or deleted with a reason are left out, as are
+// the shadow topics left behind by moved topics
+type PhpBB struct{}
+
+// the user_id of phpBB's anonymous user, who writes the posts of guests
+const phpbbAnonymous = "1"
+
+func (PhpBB) Read(r io.Reader, warn func(format string, args ...interface{})) ([]database.ImportedThread, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	kinds := []string{"users", "forums", "topics", "posts"}
+	tables, err := readSQLTables(data, func(table string) bool {
+		for _, kind := range kinds {
+			if strings.HasSuffix(table, kind) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read sql dump: %w", err)
+	}
+	var prefix string
+	found := false
+	for name, table := range tables {
+		for _, column := range table.columns {
+			if column == "post_text" && strings.HasSuffix(name, "posts") {
+				prefix, found = strings.TrimSuffix(name, "posts"), true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("the dump has no phpBB posts table")
+	}
+	rows := func(kind string) []map[string]string {
+		if table, ok := tables[prefix+kind]; ok {
+			return table.rows
+		}
+		warn("the dump has no %s%s table", prefix, kind)
+		return nil
+	}
+
+	usernames := make(map[string]string)
+	for _, user := range rows("users") {
+		usernames[user["user_id"]] = html.UnescapeString(user["username"])
+	}
+	forums := make(map[string]string)
+	for _, forum := range rows("forums") {
+		forums[forum["forum_id"]] = html.UnescapeString(forum["forum_name"])
+	}
+
+	threads := make(map[string]*database.ImportedThread)
+	var order []string
+	for _, topic := range rows("topics") {
+		if moved := topic["topic_moved_id"]; moved != "" && moved != "0" {
+			continue
+		}
+		if !phpbbVisible(topic, "topic") {
+			continue
+		}
+		id := topic["topic_id"]
+		forum, ok := forums[topic["forum_id"]]
+		if !ok && topic["forum_id"] != "0" {
+			warn("topic %s: forum %s is not in the dump, the thread goes in the default topic", id, topic["forum_id"])
+		}
+		threads[id] = &database.ImportedThread{Title: html.UnescapeString(topic["topic_title"]), Topic: forum}
+		order = append(order, id)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return phpbbNumber(order[i]) < phpbbNumber(order[j]) })
+
+	posts := rows("posts")
+	sort.SliceStable(posts, func(i, j int) bool { return phpbbNumber(posts[i]["post_id"]) < phpbbNumber(posts[j]["post_id"]) })
+	for _, post := range posts {
+		thread, ok := threads[post["topic_id"]]
+		if !ok || !phpbbVisible(post, "post") {
+			continue
+		}
+		// guests have no account, only the name they gave with their post
+		var author database.ImportedAuthor
+		if name, ok := usernames[post["poster_id"]]; ok && post["poster_id"] != phpbbAnonymous {
+			author = database.ImportedAuthor{Original: name, Name: name}
+		} else {
+			name := html.UnescapeString(post["post_username"])
+			if name == "" {
+				name = "Anonymous"
+			}
+			author = database.ImportedAuthor{Original: "guest " + name, Name: name}
+		}
+		seconds, err := strconv.ParseInt(post["post_time"], 10, 64)
+		if err != nil {
+			warn("post %s: invalid post_time %q, the post is left out", post["post_id"], post["post_time"])
+			continue
+		}
+		thread.Posts = append(thread.Posts, database.ImportedPost{
+			Author:    author,
+			Content:   phpbbMarkdown(post["post_text"], post["bbcode_uid"]),
+			Published: time.Unix(seconds, 0),
+		})
+	}
+
+	var imported []database.ImportedThread
+	for _, id := range order {
+		if len(threads[id].Posts) == 0 {
+			warn("topic %s (%s) has no posts, and is left out", id, threads[id].Title)
+			continue
+		}
+		imported = append(imported, *threads[id])
+	}
+	return imported, nil
+}
+
+// whether a topic or post has been approved and not deleted: phpBB 3.0 has the column <kind>_approved, and later
+// versions <kind>_visibility, which is 1 for visible items
+func phpbbVisible(row map[string]string, kind string) bool {
+	if visibility, ok := row[kind+"_visibility"]; ok {
+		return visibility == "1"
+	}
+	if approved, ok := row[kind+"_approved"]; ok {
+		return approved != "0"
+	}
+	return true
+}
+
+func phpbbNumber(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+var (
+	// phpBB 3.0 and 3.1 mark bbcode tags with the post's bbcode_uid, e.g. [b:1x2y3z], and lists with their kind
+	phpbbListTag = regexp.MustCompile(`\[(/?(?:list|\*)):[muo]\]`)
+	// smilies and automatically linked urls are stored as html, surrounded by comments
+	phpbbSmiley = regexp.MustCompile(`<!-- s(\S+) -->.*?<!-- s\S+ -->`)
+	phpbbLink   = regexp.MustCompile(`<!-- [mlwe] --><a [^>]*href="([^"]*)"[^>]*>.*?</a><!-- [mlwe] -->`)
+	// phpBB 3.2 and later store posts as xml, with the bbcode as it was typed kept in the text
+	phpbbBreak = regexp.MustCompile(`<br\s*/?>`)
+	phpbbTag   = regexp.MustCompile(`<[^>]+>`)
+)
+
+// converts the text of a post, as stored by phpBB, to markdown
+func phpbbMarkdown(text, uid string) string {
+	if strings.HasPrefix(text, "<r>") || strings.HasPrefix(text, "<t>") {
+		text = phpbbBreak.ReplaceAllString(text, "\n")
+		text = phpbbTag.ReplaceAllString(text, "")
+	} else {
+		if uid != "" {
+			text = strings.ReplaceAll(text, ":"+uid, "")
+		}
+		text = phpbbListTag.ReplaceAllString(text, "[$1]")
+		text = phpbbSmiley.ReplaceAllString(text, "$1")
+		text = phpbbLink.ReplaceAllStringFunc(text, func(link string) string {
+			return strings.TrimPrefix(phpbbLink.FindStringSubmatch(link)[1], "mailto:")
+		})
+	}
+	return bbcodeMarkdown(html.UnescapeString(text))
+}
+
+var (
+	bbcodeCode    = regexp.MustCompile(`(?is)\[code(?:=[^\]]*)?\](.*?)\[/code\]`)
+	bbcodeReplace = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`(?is)\[b\](.*?)\[/b\]`), "**$1**"},
+		{regexp.MustCompile(`(?is)\[i\](.*?)\[/i\]`), "*$1*"},
+		{regexp.MustCompile(`(?is)\[s\](.*?)\[/s\]`), "~~$1~~"},
+		{regexp.MustCompile(`(?is)\[url\](.*?)\[/url\]`), "<$1>"},
+		{regexp.MustCompile(`(?is)\[url=([^\]]+)\](.*?)\[/url\]`), "[$2]($1)"},
+		{regexp.MustCompile(`(?is)\[img\](.*?)\[/img\]`), "![]($1)"},
+		{regexp.MustCompile(`(?is)\[email\](.*?)\[/email\]`), "$1"},
+		{regexp.MustCompile(`(?is)\[email=([^\]]+)\](.*?)\[/email\]`), "$2 ($1)"},
+		{regexp.MustCompile(`(?is)\[attachment=\d+\](.*?)\[/attachment\]`), "(attachment: $1)"},
+		// formatting that markdown has no equivalent for is dropped, keeping the text
+		{regexp.MustCompile(`(?i)\[/?(?:u|color|size|font|align|center|left|right|justify|sup|sub|highlight)(?:=[^\]]*)?\]`), ""},
+	}
+	bbcodeQuoteOpen  = regexp.MustCompile(`(?i)\[quote(?:=[^\]]*)?\]`)
+	bbcodeQuoteClose = regexp.MustCompile(`(?i)\[/quote\]`)
+	bbcodeListOpen   = regexp.MustCompile(`(?i)\[list(?:=[^\]]*)?\]`)
+	bbcodeListClose  = regexp.MustCompile(`(?i)\[/list\]`)
+	bbcodeListItem   = regexp.MustCompile(`(?i)\[\*\]`)
+)
+
+// converts the common bbcode tags to markdown
+func bbcodeMarkdown(text string) string {
+	// code is kept as it is, so it's set aside until the rest has been converted
+	var code []string
+	text = bbcodeCode.ReplaceAllStringFunc(text, func(block string) string {
+		code = append(code, bbcodeCode.FindStringSubmatch(block)[1])
+		return fmt.Sprintf("\x00%d\x00", len(code)-1)
+	})
+	for _, r := range bbcodeReplace {
+		text = r.pattern.ReplaceAllString(text, r.replacement)
+	}
+	text = bbcodeNested(text, bbcodeQuoteOpen, bbcodeQuoteClose, func(tag, body string) string {
+		var b strings.Builder
+		if author := bbcodeQuoteAuthor(tag); author != "" {
+			fmt.Fprintf(&b, "%s wrote:\n\n", author)
+		}
+		b.WriteString(strings.TrimSpace(body))
+		lines := strings.Split(b.String(), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	})
+	text = bbcodeNested(text, bbcodeListOpen, bbcodeListClose, func(tag, body string) string {
+		marker := "-"
+		if strings.Contains(tag, "=") {
+			marker = "1."
+		}
+		var b strings.Builder
+		b.WriteString("\n\n")
+		for _, item := range bbcodeListItem.Split(body, -1) {
+			item = strings.TrimSpace(strings.ReplaceAll(item, "[/*]", ""))
+			if item != "" {
+				fmt.Fprintf(&b, "%s %s\n", marker, item)
+			}
+		}
+		return b.String() + "\n"
+	})
+	for i, block := range code {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), "\n\n```\n"+strings.Trim(block, "\n")+"\n```\n\n", 1)
+	}
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	return strings.TrimSpace(text)
+}
+
+// converts nested tags, such as quotes within quotes, innermost first. an opening tag without a closing tag is left as
+// it is
+func bbcodeNested(text string, open, close *regexp.Regexp, convert func(tag, body string) string) string {
+	for {
+		opens := open.FindAllStringIndex(text, -1)
+		if len(opens) == 0 {
+			return text
+		}
+		// the last opening tag that is closed is an innermost one
+		converted := false
+		for i := len(opens) - 1; i >= 0 && !converted; i-- {
+			start, end := opens[i][0], opens[i][1]
+			closing := close.FindStringIndex(text[end:])
+			if closing == nil {
+				continue
+			}
+			body := text[end : end+closing[0]]
+			text = text[:start] + convert(text[start:end], body) + text[end+closing[1]:]
+			converted = true
+		}
+		if !converted {
+			return text
+		}
+	}
+}
+
+// the name in [quote="name"] or [quote=name post_id=1 time=2 user_id=3]
+func bbcodeQuoteAuthor(tag string) string {
+	_, author, found := strings.Cut(strings.TrimSuffix(tag, "]"), "=")
+	if !found {
+		return ""
+	}
+	if strings.HasPrefix(author, `"`) {
+		author, _, _ = strings.Cut(author[1:], `"`)
+		return author
+	}
+	author, _, _ = strings.Cut(author, " ")
+	return author
+}
@@ -0,0 +1,276 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// reading the tables of an sql dump, as written by mysqldump or phpMyAdmin: the column names come from the CREATE TABLE
+// statements, or from the INSERT statements themselves, and the rows from the INSERT statements. everything else in the
+// dump is skipped
+
+// a token of an sql statement
+type sqlToken struct {
+	// 'w' for words, such as keywords, unquoted names and numbers; 'q' for `quoted` names; 's' for strings; and 'p' for
+	// punctuation
+	kind byte
+	text string
+}
+
+func (t sqlToken) is(kind byte, text string) bool {
+	return t.kind == kind && strings.EqualFold(t.text, text)
+}
+
+// a name, quoted or not
+func (t sqlToken) isName() bool {
+	return t.kind == 'w' || t.kind == 'q' || t.kind == 's'
+}
+
+// numbers may contain a decimal point, names may not: in `forum`.`posts`, the point separates two names
+func isWordByte(c byte, number bool) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || (number && c == '.')
+}
+
+// splits the dump into statements, passing the tokens of each to fn
+func readSQLStatements(data []byte, fn func([]sqlToken) error) error {
+	var tokens []sqlToken
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && i+1 < len(data) && data[i+1] == '-'):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			// mysqldump's /*!40101 ... */ statements are comments too, as far as reading the tables goes
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return fmt.Errorf("unterminated comment")
+			}
+			i += 2 + end + 2
+		case c == ';':
+			if len(tokens) > 0 {
+				if err := fn(tokens); err != nil {
+					return err
+				}
+			}
+			tokens = nil
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			var b strings.Builder
+			terminated := false
+			for i++; i < len(data); i++ {
+				if data[i] == '\\' && c != '`' && i+1 < len(data) {
+					i++
+					switch data[i] {
+					case '0':
+						b.WriteByte(0)
+					case 'n':
+						b.WriteByte('\n')
+					case 'r':
+						b.WriteByte('\r')
+					case 't':
+						b.WriteByte('\t')
+					case 'b':
+						b.WriteByte('\b')
+					case 'Z':
+						b.WriteByte(0x1a)
+					case '%', '_':
+						b.WriteByte('\\')
+						b.WriteByte(data[i])
+					default:
+						b.WriteByte(data[i])
+					}
+					continue
+				}
+				if data[i] == c {
+					// a doubled quote stands for the quote itself
+					if i+1 < len(data) && data[i+1] == c {
+						b.WriteByte(c)
+						i++
+						continue
+					}
+					terminated = true
+					i++
+					break
+				}
+				b.WriteByte(data[i])
+			}
+			if !terminated {
+				return fmt.Errorf("unterminated string")
+			}
+			kind := byte('s')
+			if c == '`' {
+				kind = 'q'
+			}
+			tokens = append(tokens, sqlToken{kind, b.String()})
+		case isWordByte(c, false):
+			start := i
+			number := c >= '0' && c <= '9'
+			for i < len(data) && isWordByte(data[i], number) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{'w', string(data[start:i])})
+		default:
+			tokens = append(tokens, sqlToken{'p', string(c)})
+			i++
+		}
+	}
+	if len(tokens) > 0 {
+		return fn(tokens)
+	}
+	return nil
+}
+
+// the column names of a table, and its rows as maps from column names to values. NULL values are left out of the rows
+type sqlTable struct {
+	columns []string
+	rows    []map[string]string
+}
+
+// reads the tables of the dump for which want returns true
+func readSQLTables(data []byte, want func(table string) bool) (map[string]*sqlTable, error) {
+	tables := make(map[string]*sqlTable)
+	table := func(name string) *sqlTable {
+		if tables[name] == nil {
+			tables[name] = &sqlTable{}
+		}
+		return tables[name]
+	}
+	// reads a possibly qualified name, e.g. `forum`.`phpbb_posts`, starting at tokens[i], and returns the name without
+	// its database and the index of the token after it
+	name := func(tokens []sqlToken, i int) (string, int) {
+		var n string
+		for i < len(tokens) && tokens[i].isName() {
+			n = tokens[i].text
+			i++
+			if i < len(tokens) && tokens[i].is('p', ".") {
+				i++
+				continue
+			}
+			break
+		}
+		return n, i
+	}
+
+	statement := 0
+	err := readSQLStatements(data, func(tokens []sqlToken) error {
+		statement++
+		i := 0
+		switch {
+		case tokens[0].is('w', "CREATE"):
+			for i < len(tokens) && !tokens[i].is('w', "TABLE") {
+				if tokens[i].is('p', "(") {
+					return nil
+				}
+				i++
+			}
+			i++
+			if i+2 < len(tokens) && tokens[i].is('w', "IF") {
+				i += 3
+			}
+			var n string
+			n, i = name(tokens, i)
+			if !want(n) {
+				return nil
+			}
+			t := table(n)
+			t.columns = nil
+			// the first word of each item of the table's definition is a column name, unless it starts a key
+			depth := 0
+			itemStart := false
+			for ; i < len(tokens); i++ {
+				switch {
+				case tokens[i].is('p', "("):
+					depth++
+					itemStart = depth == 1
+					continue
+				case tokens[i].is('p', ")"):
+					depth--
+				case depth == 1 && tokens[i].is('p', ","):
+					itemStart = true
+					continue
+				case itemStart && tokens[i].isName():
+					keyword := strings.ToUpper(tokens[i].text)
+					if tokens[i].kind != 'w' || (keyword != "PRIMARY" && keyword != "KEY" && keyword != "UNIQUE" &&
+						keyword != "INDEX" && keyword != "FULLTEXT" && keyword != "SPATIAL" && keyword != "CONSTRAINT" &&
+						keyword != "FOREIGN" && keyword != "CHECK") {
+						t.columns = append(t.columns, tokens[i].text)
+					}
+				}
+				itemStart = false
+			}
+		case tokens[0].is('w', "INSERT") || tokens[0].is('w', "REPLACE"):
+			for i < len(tokens) && !tokens[i].is('w', "INTO") {
+				i++
+			}
+			var n string
+			n, i = name(tokens, i+1)
+			if !want(n) {
+				return nil
+			}
+			t := table(n)
+			columns := t.columns
+			if i < len(tokens) && tokens[i].is('p', "(") {
+				columns = nil
+				for i++; i < len(tokens) && !tokens[i].is('p', ")"); i++ {
+					if tokens[i].isName() {
+						columns = append(columns, tokens[i].text)
+					}
+				}
+				i++
+				if len(t.columns) == 0 {
+					t.columns = columns
+				}
+			}
+			if len(columns) == 0 {
+				return fmt.Errorf("statement %d: the columns of %s are unknown, as the dump has no CREATE TABLE statement for it", statement, n)
+			}
+			if i >= len(tokens) || !(tokens[i].is('w', "VALUES") || tokens[i].is('w', "VALUE")) {
+				return fmt.Errorf("statement %d: expected VALUES after INSERT INTO %s", statement, n)
+			}
+			i++
+			// the rows, each a parenthesized list of values
+			for i < len(tokens) {
+				if !tokens[i].is('p', "(") {
+					return fmt.Errorf("statement %d: expected ( in the values of %s", statement, n)
+				}
+				row := make(map[string]string)
+				column := 0
+				negative := false
+				for i++; i < len(tokens) && !tokens[i].is('p', ")"); i++ {
+					token := tokens[i]
+					switch {
+					case token.is('p', ","):
+						column++
+						continue
+					case token.is('p', "-"):
+						negative = true
+						continue
+					case token.kind == 'w' && strings.HasPrefix(token.text, "_"):
+						// a character set introducer, e.g. _binary 'data'
+						continue
+					case column >= len(columns):
+						return fmt.Errorf("statement %d: a row of %s has more values than it has columns", statement, n)
+					case token.is('w', "NULL"):
+					case negative:
+						row[columns[column]] = "-" + token.text
+					default:
+						row[columns[column]] = token.text
+					}
+					negative = false
+				}
+				t.rows = append(t.rows, row)
+				i++
+				if i < len(tokens) && tokens[i].is('p', ",") {
+					i++
+				}
+			}
+		}
+		return nil
+	})
+	return tables, err
+}
@@ -1,138 +1,215 @@
 package limiter
 
 import (
+	"container/list"
 	"context"
-	"golang.org/x/time/rate"
 	"sync"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
-// TODO (2025-11-17): wrap all manipulation of timers / identifiers in a mutex :) 
-// c.f. https://github.com/cespare/pastedown/blob/main/lru/lru.go
+// how many identifiers are remembered by default, before the least recently seen ones are evicted
+const DEFAULT_MAX_IDENTIFIERS = 10000
+
+// a class of routes sharing the same rate: an access token is refilled every Refresh, and up to Burst tokens can be
+// saved up. each identifier gets its own bucket of tokens per class
+type Class struct {
+	Refresh time.Duration
+	Burst   int
+}
+
+// the class of the routes passed to NewTimedRateLimiter, and of all routes when limiting all routes
+const defaultClass = ""
+
+type Stats struct {
+	// identifier and class pairs currently remembered
+	Identifiers int
+	// forgotten because the limiter was full
+	Evictions uint64
+	// forgotten because they were not seen for the time to remember
+	Expirations uint64
+}
+
+type entry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// TimedRateLimiter rate limits identifiers, such as ip addresses, per class of routes. it remembers identifiers in a
+// least recently used list: identifiers that haven't been seen for timeToRemember are forgotten, as is the least
+// recently seen identifier when more than maxIdentifiers are remembered. it is safe for concurrent use
 type TimedRateLimiter struct {
-	// periodic forgetting of identifiers that have been seen & assigned a rate limiter to prevent bloat over time
-	timers map[string]*time.Timer
-	// buckets of access tokens, refreshing over time
-	limiters map[string]*rate.Limiter
-	// routes that are rate limited
-	routes         map[string]bool
+	mu sync.Mutex
+	// class name + identifier -> element of lru, which holds an *entry
+	entries map[string]*list.Element
+	// front is the most recently seen
+	lru *list.List
+	// route -> class name
+	routes         map[string]string
+	classes        map[string]Class
 	limitAllRoutes bool
-	refreshPeriod  time.Duration
 	timeToRemember time.Duration
-	burst          int
-	rwmu sync.RWMutex
+	maxIdentifiers int
+	evictions      uint64
+	expirations    uint64
+	// returns the current time; replaced in tests
+	now func() time.Time
 }
 
 func NewTimedRateLimiter(limitedRoutes []string, refresh, remember time.Duration) *TimedRateLimiter {
-	rl := TimedRateLimiter{}
-	rl.timers = make(map[string]*time.Timer)
-	rl.limiters = make(map[string]*rate.Limiter)
-	rl.routes = make(map[string]bool)
+	rl := TimedRateLimiter{
+		entries:        make(map[string]*list.Element),
+		lru:            list.New(),
+		routes:         make(map[string]string),
+		classes:        make(map[string]Class),
+		timeToRemember: remember,
+		maxIdentifiers: DEFAULT_MAX_IDENTIFIERS,
+		now:            time.Now,
+	}
 	for _, route := range limitedRoutes {
-		rl.routes[route] = true
+		rl.routes[route] = defaultClass
 	}
-	rl.refreshPeriod = refresh
-	rl.timeToRemember = remember
-	rl.burst = 15 /* default value, use rl.SetBurstAllowance to change */
+	rl.classes[defaultClass] = Class{Refresh: refresh, Burst: 15 /* default value, use rl.SetBurstAllowance to change */}
 	return &rl
 }
 
-// amount of accesses allowed ~concurrently, before needing to wait for a rl.refreshPeriod
+// amount of accesses allowed ~concurrently, before needing to wait for a refresh period. it only affects identifiers
+// seen after the call
 func (rl *TimedRateLimiter) SetBurstAllowance(burst int) {
-	if burst >= 1 {
-		rl.burst = burst
+	if burst < 1 {
+		return
 	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	class := rl.classes[defaultClass]
+	class.Burst = burst
+	rl.classes[defaultClass] = class
 }
 
+// limits routes that aren't part of any class at the rate given to NewTimedRateLimiter
 func (rl *TimedRateLimiter) SetLimitAllRoutes(limitAll bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 	rl.limitAllRoutes = limitAll
 }
 
+// caps how many identifiers are remembered; when exceeded, the least recently seen identifier is forgotten
+func (rl *TimedRateLimiter) SetMaxIdentifiers(max int) {
+	if max < 1 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.maxIdentifiers = max
+	rl.evict()
+}
+
+// limits the routes at their own rate, separately from other routes: an identifier exhausting its tokens for one class
+// can still access the routes of other classes
+func (rl *TimedRateLimiter) AddClass(name string, class Class, routes ...string) {
+	if class.Burst < 1 {
+		class.Burst = 1
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.classes[name] = class
+	for _, route := range routes {
+		rl.routes[route] = name
+	}
+}
+
 // find out if resource access is allowed or not: calling consumes a rate limit token
 func (rl *TimedRateLimiter) IsLimited(identifier, route string) bool {
-	if !rl.limitAllRoutes {
+	limiter := rl.getLimiter(identifier, route)
+	if limiter == nil {
 		// route isn't rate limited
-		if _, exists := rl.routes[route]; !exists {
-			return false
-		}
+		return false
 	}
-	// route is designated to be rate limited, try the limiter to see if we can access it
-	ret := !rl.access(identifier)
-	return ret
+	// consumes one token from the rate limiter bucket
+	return !limiter.Allow()
 }
 
 func (rl *TimedRateLimiter) BlockUntilAllowed(identifier, route string, ctx context.Context) error {
-	// route isn't rate limited
-	if !rl.limitAllRoutes {
-		if _, exists := rl.routes[route]; !exists {
-			return nil
-		}
-	}
-	limiter := rl.getLimiter(identifier)
-	err := limiter.Wait(ctx)
-	if err != nil {
-		return err
+	limiter := rl.getLimiter(identifier, route)
+	if limiter == nil {
+		// route isn't rate limited
+		return nil
 	}
-	return nil
+	return limiter.Wait(ctx)
 }
 
-func (rl *TimedRateLimiter) getLimiter(identifier string) *rate.Limiter {
-	// limiter doesn't yet exist for this identifier
-	rl.rwmu.RLock()
-	_, exists := rl.limiters[identifier] 
-	rl.rwmu.RUnlock()
-	if !exists {
-		// create a rate limit for it
-		rl.createRateLimit(identifier)
-		// remember this identifier (remote ip) for rl.timeToRemember before forgetting
-		rl.rememberIdentifier(identifier)
+// forget the identifier's rate limiters, restoring its full burst allowance on every route
+func (rl *TimedRateLimiter) Forget(identifier string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for name := range rl.classes {
+		if elem, exists := rl.entries[entryKey(name, identifier)]; exists {
+			rl.remove(elem)
+		}
 	}
-	rl.rwmu.RLock()
-	limiter := rl.limiters[identifier]
-	rl.rwmu.RUnlock()
-	return limiter
 }
 
-// returns true if identifier currently allowed to access the resource
-func (rl *TimedRateLimiter) access(identifier string) bool {
-	limiter := rl.getLimiter(identifier)
-	// consumes one token from the rate limiter bucket
-	allowed := limiter.Allow()
-	return allowed
+func (rl *TimedRateLimiter) Stats() Stats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return Stats{Identifiers: rl.lru.Len(), Evictions: rl.evictions, Expirations: rl.expirations}
 }
 
-func (rl *TimedRateLimiter) createRateLimit(identifier string) {
-	accessRate := rate.Every(rl.refreshPeriod)
-	limit := rate.NewLimiter(accessRate, rl.burst)
-	rl.rwmu.Lock()
-	rl.limiters[identifier] = limit
-	rl.rwmu.Unlock()
+func entryKey(class, identifier string) string {
+	return class + "\x00" + identifier
 }
 
-func (rl *TimedRateLimiter) rememberIdentifier(identifier string) {
-	// timer already exists; refresh it
-	rl.rwmu.RLock()
-	timer, exists := rl.timers[identifier]
-	rl.rwmu.RUnlock()
-	if exists {
-		timer.Reset(rl.timeToRemember)
-		return
+// returns the identifier's limiter for the route's class, creating it if needed, or nil if the route isn't limited.
+// the returned limiter is safe to use without holding rl.mu
+func (rl *TimedRateLimiter) getLimiter(identifier, route string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	name, exists := rl.routes[route]
+	if !exists {
+		if !rl.limitAllRoutes {
+			return nil
+		}
+		name = defaultClass
+	}
+	now := rl.now()
+	rl.expire(now)
+	key := entryKey(name, identifier)
+	if elem, exists := rl.entries[key]; exists {
+		e := elem.Value.(*entry)
+		e.lastSeen = now
+		rl.lru.MoveToFront(elem)
+		return e.limiter
+	}
+	class := rl.classes[name]
+	e := &entry{key: key, limiter: rate.NewLimiter(rate.Every(class.Refresh), class.Burst), lastSeen: now}
+	rl.entries[key] = rl.lru.PushFront(e)
+	rl.evict()
+	return e.limiter
+}
+
+// forgets identifiers that haven't been seen for rl.timeToRemember (to prevent memory growth over time). the least
+// recently seen identifiers are at the back, so it stops at the first one that is still remembered
+func (rl *TimedRateLimiter) expire(now time.Time) {
+	for elem := rl.lru.Back(); elem != nil; elem = rl.lru.Back() {
+		if now.Sub(elem.Value.(*entry).lastSeen) < rl.timeToRemember {
+			return
+		}
+		rl.remove(elem)
+		rl.expirations++
 	}
-	// new timer
-	timer = time.AfterFunc(rl.timeToRemember, func() {
-		rl.forgetLimiter(identifier)
-	})
-	// map timer to its identifier
-	rl.rwmu.Lock()
-	rl.timers[identifier] = timer
-	rl.rwmu.Unlock()
-}
-
-// forget the rate limiter associated for this identifier (to prevent memory growth over time)
-func (rl *TimedRateLimiter) forgetLimiter(identifier string) {
-	rl.rwmu.Lock()
-	if _, exists := rl.limiters[identifier]; exists {
-		delete(rl.limiters, identifier)
+}
+
+// forgets the least recently seen identifiers while there are too many
+func (rl *TimedRateLimiter) evict() {
+	for rl.lru.Len() > rl.maxIdentifiers {
+		rl.remove(rl.lru.Back())
+		rl.evictions++
 	}
-	rl.rwmu.Unlock()
+}
+
+func (rl *TimedRateLimiter) remove(elem *list.Element) {
+	rl.lru.Remove(elem)
+	delete(rl.entries, elem.Value.(*entry).key)
 }
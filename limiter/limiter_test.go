@@ -0,0 +1,188 @@
+package limiter
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a refresh period long enough that no tokens are refilled while a test runs
+const noRefresh = time.Hour
+
+func allowed(rl *TimedRateLimiter, identifier, route string, attempts int) int {
+	count := 0
+	for i := 0; i < attempts; i++ {
+		if !rl.IsLimited(identifier, route) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBurst(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/limited"}, noRefresh, time.Hour)
+	rl.SetBurstAllowance(3)
+	if got := allowed(rl, "a", "/limited", 10); got != 3 {
+		t.Errorf("allowed %d accesses, want 3", got)
+	}
+	// identifiers have their own buckets
+	if got := allowed(rl, "b", "/limited", 10); got != 3 {
+		t.Errorf("allowed %d accesses for another identifier, want 3", got)
+	}
+}
+
+func TestUnlimitedRoutes(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/limited"}, noRefresh, time.Hour)
+	rl.SetBurstAllowance(1)
+	if got := allowed(rl, "a", "/other", 10); got != 10 {
+		t.Errorf("allowed %d accesses to a route that isn't limited, want 10", got)
+	}
+	if stats := rl.Stats(); stats.Identifiers != 0 {
+		t.Errorf("remembered %d identifiers for a route that isn't limited, want 0", stats.Identifiers)
+	}
+	rl.SetLimitAllRoutes(true)
+	if got := allowed(rl, "a", "/other", 10); got != 1 {
+		t.Errorf("allowed %d accesses when limiting all routes, want 1", got)
+	}
+}
+
+func TestClasses(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/default"}, noRefresh, time.Hour)
+	rl.SetBurstAllowance(2)
+	rl.AddClass("strict", Class{Refresh: noRefresh, Burst: 1}, "/login", "/register")
+	rl.AddClass("lenient", Class{Refresh: noRefresh, Burst: 5}, "/api")
+	if got := allowed(rl, "a", "/login", 10); got != 1 {
+		t.Errorf("allowed %d accesses to the strict class, want 1", got)
+	}
+	// routes in a class share their bucket
+	if got := allowed(rl, "a", "/register", 10); got != 0 {
+		t.Errorf("allowed %d accesses to another route of the exhausted class, want 0", got)
+	}
+	if got := allowed(rl, "a", "/api", 10); got != 5 {
+		t.Errorf("allowed %d accesses to the lenient class, want 5", got)
+	}
+	if got := allowed(rl, "a", "/default", 10); got != 2 {
+		t.Errorf("allowed %d accesses to the default class, want 2", got)
+	}
+}
+
+func TestForget(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/a"}, noRefresh, time.Hour)
+	rl.SetBurstAllowance(2)
+	rl.AddClass("b", Class{Refresh: noRefresh, Burst: 2}, "/b")
+	allowed(rl, "x", "/a", 10)
+	allowed(rl, "x", "/b", 10)
+	rl.Forget("x")
+	if stats := rl.Stats(); stats.Identifiers != 0 {
+		t.Errorf("remembered %d identifiers after forgetting, want 0", stats.Identifiers)
+	}
+	if got := allowed(rl, "x", "/a", 10); got != 2 {
+		t.Errorf("allowed %d accesses after forgetting, want 2", got)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/limited"}, noRefresh, time.Minute)
+	rl.SetBurstAllowance(1)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	allowed(rl, "old", "/limited", 1)
+	now = now.Add(30 * time.Second)
+	allowed(rl, "recent", "/limited", 1)
+	now = now.Add(45 * time.Second)
+	// seeing any identifier expires those not seen for a minute
+	allowed(rl, "new", "/limited", 1)
+	stats := rl.Stats()
+	if stats.Expirations != 1 || stats.Identifiers != 2 {
+		t.Errorf("got %d expirations and %d identifiers, want 1 and 2", stats.Expirations, stats.Identifiers)
+	}
+	if got := allowed(rl, "old", "/limited", 1); got != 1 {
+		t.Errorf("expired identifier was still limited")
+	}
+	if got := allowed(rl, "recent", "/limited", 1); got != 0 {
+		t.Errorf("identifier that hadn't expired was not limited")
+	}
+}
+
+func TestMaxIdentifiers(t *testing.T) {
+	rl := NewTimedRateLimiter([]string{"/limited"}, noRefresh, time.Hour)
+	rl.SetBurstAllowance(1)
+	rl.SetMaxIdentifiers(2)
+	allowed(rl, "a", "/limited", 1)
+	allowed(rl, "b", "/limited", 1)
+	// a becomes the most recently seen, so b is the one evicted for c
+	allowed(rl, "a", "/limited", 1)
+	allowed(rl, "c", "/limited", 1)
+	stats := rl.Stats()
+	if stats.Identifiers != 2 || stats.Evictions != 1 {
+		t.Errorf("got %d identifiers and %d evictions, want 2 and 1", stats.Identifiers, stats.Evictions)
+	}
+	if got := allowed(rl, "b", "/limited", 1); got != 1 {
+		t.Errorf("evicted identifier was still limited")
+	}
+	// remembering b again evicted a, the least recently seen, and kept c
+	if got := allowed(rl, "c", "/limited", 1); got != 0 {
+		t.Errorf("most recently seen identifier was evicted")
+	}
+}
+
+// many goroutines racing to create the same identifier's limiter must end up sharing one, so that exactly the burst
+// is allowed in total. run with -race
+func TestConcurrentSharedIdentifier(t *testing.T) {
+	const burst = 50
+	rl := NewTimedRateLimiter(nil, noRefresh, time.Hour)
+	rl.SetLimitAllRoutes(true)
+	rl.SetBurstAllowance(burst)
+	var count atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if !rl.IsLimited("shared", "/") {
+					count.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := count.Load(); got != burst {
+		t.Errorf("allowed %d concurrent accesses, want exactly the burst of %d", got, burst)
+	}
+}
+
+// churning through more identifiers than are remembered, while forgetting and reading stats concurrently, must keep
+// the limiter within its bound. run with -race
+func TestConcurrentChurn(t *testing.T) {
+	const max = 100
+	rl := NewTimedRateLimiter([]string{"/a"}, noRefresh, time.Hour)
+	rl.AddClass("b", Class{Refresh: noRefresh, Burst: 2}, "/b")
+	rl.SetMaxIdentifiers(max)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				identifier := fmt.Sprintf("%d-%d", i, j)
+				rl.IsLimited(identifier, "/a")
+				rl.IsLimited(identifier, "/b")
+				if j%5 == 0 {
+					rl.Forget(identifier)
+					rl.Stats()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	stats := rl.Stats()
+	if stats.Identifiers > max {
+		t.Errorf("remembered %d identifiers, more than the maximum of %d", stats.Identifiers, max)
+	}
+	if stats.Evictions == 0 {
+		t.Errorf("no identifiers were evicted")
+	}
+}
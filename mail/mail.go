@@ -0,0 +1,195 @@
+// Package mail sends the forum's emails. Which transport delivers them, and from which address, is set in the [email]
+// section of the config; the emails themselves are written in the forum's language, see the Email* keys in i18n.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/i18n"
+	"gomod.cblgh.org/cerca/types"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+const (
+	TRANSPORT_SMTP    = "smtp"
+	TRANSPORT_MAILDIR = "maildir"
+)
+
+// a plain text email
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// formats the message as it is sent, headers included
+func (m Message) Bytes() ([]byte, error) {
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return nil, eout.Eout(err, "parse sender %q", m.From)
+	}
+	to, err := netmail.ParseAddress(m.To)
+	if err != nil {
+		return nil, eout.Eout(err, "parse recipient %q", m.To)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, eout.Eout(err, "generate message id")
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var b bytes.Buffer
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-Id", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)},
+		{"Mime-Version", "1.0"},
+		{"Content-Type", `text/plain; charset="utf-8"`},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+		// the emails are sent by the forum rather than by a person, and shouldn't trigger vacation replies
+		{"Auto-Submitted", "auto-generated"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", header[0], header[1])
+	}
+	b.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&b)
+	body := strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, eout.Eout(err, "encode body")
+	}
+	if err := w.Close(); err != nil {
+		return nil, eout.Eout(err, "encode body")
+	}
+	return b.Bytes(), nil
+}
+
+// a transport delivers messages
+type Transport interface {
+	Send(msg Message) error
+}
+
+// composes the forum's emails and hands them to a transport. a nil *Mailer is valid, and stands for email being turned
+// off: check Enabled before offering anything that needs email
+type Mailer struct {
+	from       string
+	transport  Transport
+	translator i18n.Translator
+	forumName  string
+	// links in emails are absolute, starting with the forum's url
+	forumURL string
+}
+
+// returns the mailer described by the config's [email] section, or nil if the config turns email off
+func New(config types.Config, translator i18n.Translator) (*Mailer, error) {
+	ed := eout.Describe("set up email")
+	var transport Transport
+	switch config.Email.Transport {
+	case "":
+		return nil, nil
+	case TRANSPORT_SMTP:
+		smtp, err := newSMTPTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		transport = smtp
+	case TRANSPORT_MAILDIR:
+		maildir, err := newMaildirTransport(config.Email.Maildir)
+		if err != nil {
+			return nil, err
+		}
+		transport = maildir
+	default:
+		return nil, fmt.Errorf("transport must be %s or %s; was %q", TRANSPORT_SMTP, TRANSPORT_MAILDIR, config.Email.Transport)
+	}
+	if _, err := netmail.ParseAddress(config.Email.From); err != nil {
+		return nil, ed.Eout(err, "parse from address %q", config.Email.From)
+	}
+	if config.RSS.URL == "" {
+		return nil, fmt.Errorf("emails contain links to the forum, which requires [rss] forum_url to be set")
+	}
+	return &Mailer{
+		from:       config.Email.From,
+		transport:  transport,
+		translator: translator,
+		forumName:  config.General.Name,
+		forumURL:   strings.TrimSuffix(config.RSS.URL, "/"),
+	}, nil
+}
+
+func (m *Mailer) Enabled() bool {
+	return m != nil
+}
+
+// turns a path on the forum, such as "/account", into an absolute url
+func (m *Mailer) URL(path string) string {
+	return m.forumURL + path
+}
+
+// what the translations of the emails can refer to. every email can use Forum and URL; the other fields are only set
+// by the emails that need them
+type templateData struct {
+	Forum string
+	URL   string
+	// the recipient's username
+	Username string
+	// the user who did whatever the email is about
+	Actor  string
+	Thread string
+	Link   string
+	// how many hours Link can be used for
+	ValidHours int
+}
+
+// translates the email with the given key: the subject is translated from key+"Subject" and the body from key+"Body"
+func (m *Mailer) send(to, key string, data templateData) error {
+	if !m.Enabled() {
+		return fmt.Errorf("send %s: email is turned off", key)
+	}
+	data.Forum = m.forumName
+	data.URL = m.forumURL
+	translation := i18n.TranslationData{Data: data}
+	msg := Message{
+		From:    m.from,
+		To:      to,
+		Subject: m.translator.TranslateText(key+"Subject", translation),
+		Body:    m.translator.TranslateText(key+"Body", translation) + "\n\n-- \n" + m.translator.TranslateText("EmailFooter", translation),
+	}
+	return eout.Eout(m.transport.Send(msg), "send %s to %s", key, to)
+}
+
+// asks the user to confirm that they own the email address they added to their account
+func (m *Mailer) SendEmailVerification(to, username, link string, validFor time.Duration) error {
+	return m.send(to, "EmailVerify", templateData{Username: username, Link: link, ValidHours: int(validFor.Hours())})
+}
+
+// tells the user about a notification in their inbox. mention selects the email for being mentioned, rather than for a
+// reply in a subscribed thread
+func (m *Mailer) SendNotification(to, username, actor, thread, link string, mention bool) error {
+	key := "EmailReply"
+	if mention {
+		key = "EmailMention"
+	}
+	return m.send(to, key, templateData{Username: username, Actor: actor, Thread: thread, Link: link})
+}
+
+// sends an invite: link registers an account with an invite code
+func (m *Mailer) SendInvite(to, inviter, link string) error {
+	return m.send(to, "EmailInvite", templateData{Actor: inviter, Link: link})
+}
+
+// sends a link for choosing a new password, requested by someone who forgot theirs
+func (m *Mailer) SendPasswordReset(to, username, link string, validFor time.Duration) error {
+	return m.send(to, "EmailPasswordReset", templateData{Username: username, Link: link, ValidHours: int(validFor.Hours())})
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// writes each email as a file into a maildir instead of sending it, which is handy for testing and for trying out the
+// forum's emails. any mail client that reads maildirs, e.g. mutt -f <dir>, shows them
+type maildirTransport struct {
+	dir string
+}
+
+func newMaildirTransport(dir string) (*maildirTransport, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the maildir transport needs maildir to be set")
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return nil, eout.Eout(err, "create maildir %s", dir)
+		}
+	}
+	return &maildirTransport{dir: dir}, nil
+}
+
+// delivers the message the way maildirs expect: written to tmp/ first, and then moved into new/ in one go
+func (t *maildirTransport) Send(msg Message) error {
+	ed := eout.Describe("maildir")
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return ed.Eout(err, "generate file name")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(unique), hostname)
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return ed.Eout(err, "write %s", tmp)
+	}
+	if err := os.Rename(tmp, filepath.Join(t.dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return ed.Eout(err, "move %s into new", name)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"gomod.cblgh.org/cerca/types"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+const (
+	SMTP_SECURITY_STARTTLS = "starttls"
+	SMTP_SECURITY_TLS      = "tls"
+	SMTP_SECURITY_NONE     = "none"
+)
+
+const SMTP_TIMEOUT = 30 * time.Second
+
+// sends emails through a smtp server, e.g. the one of the forum's email provider
+type smtpTransport struct {
+	host     string
+	address  string
+	security string
+	auth     smtp.Auth
+}
+
+func newSMTPTransport(config types.Config) (*smtpTransport, error) {
+	email := config.Email
+	switch email.SMTPSecurity {
+	case SMTP_SECURITY_STARTTLS, SMTP_SECURITY_TLS, SMTP_SECURITY_NONE:
+	default:
+		return nil, fmt.Errorf("smtp_security must be one of %s, %s or %s; was %q", SMTP_SECURITY_STARTTLS, SMTP_SECURITY_TLS, SMTP_SECURITY_NONE, email.SMTPSecurity)
+	}
+	if email.SMTPHost == "" {
+		return nil, fmt.Errorf("the smtp transport needs smtp_host to be set")
+	}
+	t := &smtpTransport{
+		host:     email.SMTPHost,
+		address:  net.JoinHostPort(email.SMTPHost, strconv.Itoa(email.SMTPPort)),
+		security: email.SMTPSecurity,
+	}
+	// net/smtp refuses to send the credentials over an unencrypted connection, unless the server is on the same machine
+	if email.SMTPUsername != "" {
+		t.auth = smtp.PlainAuth("", email.SMTPUsername, email.SMTPPassword, email.SMTPHost)
+	}
+	return t, nil
+}
+
+func (t *smtpTransport) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: SMTP_TIMEOUT}
+	tlsConfig := &tls.Config{ServerName: t.host}
+	var conn net.Conn
+	var err error
+	if t.security == SMTP_SECURITY_TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", t.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT))
+	client, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if t.security == SMTP_SECURITY_STARTTLS {
+		// unlike smtp.SendMail, refuse to continue unencrypted when the server doesn't offer starttls
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("%s does not support starttls", t.address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (t *smtpTransport) Send(msg Message) error {
+	ed := eout.Describe("smtp")
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	from, _ := netmail.ParseAddress(msg.From)
+	to, _ := netmail.ParseAddress(msg.To)
+	client, err := t.dial()
+	if err != nil {
+		return ed.Eout(err, "connect to %s", t.address)
+	}
+	defer client.Close()
+	if t.auth != nil {
+		if err := client.Auth(t.auth); err != nil {
+			return ed.Eout(err, "authenticate")
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return ed.Eout(err, "MAIL FROM")
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return ed.Eout(err, "RCPT TO")
+	}
+	w, err := client.Data()
+	if err != nil {
+		return ed.Eout(err, "DATA")
+	}
+	if _, err := w.Write(data); err != nil {
+		return ed.Eout(err, "write message")
+	}
+	if err := w.Close(); err != nil {
+		return ed.Eout(err, "finish message")
+	}
+	return ed.Eout(client.Quit(), "QUIT")
+}
@@ -1,18 +1,87 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"gomod.cblgh.org/cerca/activitypub"
 	"gomod.cblgh.org/cerca/crypto"
 	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/util"
 )
 
+// collects what the account page shows for the user
+func (h RequestHandler) accountData(userid int) AccountData {
+	username, _ := h.db.GetUsername(userid)
+	isAdmin, _ := h.db.IsUserAdmin(userid)
+	data := AccountData{LoggedInUsername: username, IsAdmin: isAdmin, DeleteAccountRoute: ACCOUNT_DELETE_ROUTE, ChangeUsernameRoute: ACCOUNT_CHANGE_USERNAME_ROUTE, ChangePasswordRoute: ACCOUNT_CHANGE_PASSWORD_ROUTE, CreateTokenRoute: ACCOUNT_TOKENS_CREATE_ROUTE, RevokeTokenRoute: ACCOUNT_TOKENS_REVOKE_ROUTE, SessionsRoute: ACCOUNT_SESSIONS_ROUTE}
+	// only admins can use the admin scopes, so only offer them to admins
+	for _, scope := range database.TokenScopes {
+		if isAdmin || !strings.HasPrefix(scope, "admin") {
+			data.TokenScopes = append(data.TokenScopes, scope)
+		}
+	}
+	if userid >= 0 {
+		tokens, err := h.db.GetAPITokens(userid)
+		if err != nil {
+			dump(err)
+		}
+		data.Tokens = tokens
+	}
+	data.ChangeBioRoute = ACCOUNT_BIO_ROUTE
+	if username != "" {
+		data.ProfileURL = profileURL(username)
+		profile, err := h.db.GetUserProfile(username)
+		if err != nil {
+			dump(err)
+		}
+		data.Bio = profile.Bio
+	}
+	data.TwoFactorSetupRoute = ACCOUNT_TWO_FACTOR_SETUP_ROUTE
+	data.TwoFactorEnableRoute = ACCOUNT_TWO_FACTOR_ENABLE_ROUTE
+	data.TwoFactorDisableRoute = ACCOUNT_TWO_FACTOR_DISABLE_ROUTE
+	data.TwoFactorRecoveryRoute = ACCOUNT_TWO_FACTOR_RECOVERY_ROUTE
+	data.TwoFactorRequired = isAdmin && h.config.Security.RequireAdmin2FA
+	data.EmailEnabled = h.mailer.Enabled()
+	data.ChangeEmailRoute = ACCOUNT_EMAIL_ROUTE
+	data.EmailNotificationsRoute = ACCOUNT_EMAIL_NOTIFICATIONS_ROUTE
+	if data.EmailEnabled && userid >= 0 {
+		email, err := h.db.GetUserEmail(userid)
+		if err != nil {
+			dump(err)
+		}
+		data.Email = email
+	}
+	if userid >= 0 {
+		tf, ok, err := h.db.GetTwoFactor(userid)
+		if err != nil {
+			dump(err)
+		}
+		if ok && tf.Enabled {
+			data.TwoFactorEnabled = true
+			data.RecoveryCodesLeft, err = h.db.CountRecoveryCodes(userid)
+			if err != nil {
+				dump(err)
+			}
+		} else if ok {
+			data.TwoFactorSecret = tf.Secret
+			data.TwoFactorURI = crypto.TOTPURI(tf.Secret, h.config.General.Name, username)
+		}
+	}
+	return data
+}
+
 func renderMsgAccountView(h *RequestHandler, res http.ResponseWriter, req *http.Request, caller, errInput string) {
 	errMessage := fmt.Sprintf("%s: %s", caller, errInput)
 	loggedIn, userid := h.IsLoggedIn(req)
-	username, _ := h.db.GetUsername(userid)
-	h.renderView(res, "account", TemplateData{Data: AccountData{ErrorMessage: errMessage, LoggedInUsername: username, DeleteAccountRoute: ACCOUNT_DELETE_ROUTE, ChangeUsernameRoute: ACCOUNT_CHANGE_USERNAME_ROUTE, ChangePasswordRoute: ACCOUNT_CHANGE_PASSWORD_ROUTE}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
+	data := h.accountData(userid)
+	data.ErrorMessage = errMessage
+	h.renderView(res, req, "account", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
 }
 
 func (h *RequestHandler) AccountChangePassword(res http.ResponseWriter, req *http.Request) {
@@ -60,7 +129,12 @@ func (h *RequestHandler) AccountChangePassword(res http.ResponseWriter, req *htt
 				renderErr("Critical failure - password hashing failed. Contact admin")
 			}
 			h.db.UpdateUserPasswordHash(userid, passwordHash)
-			renderSuccess("Password has been updated!")
+			// stay logged in here, but log out every other session
+			token, _ := h.session.GetToken(req)
+			if err = h.db.DeleteUserSessions(userid, token); err != nil {
+				dump(err)
+			}
+			renderSuccess("Password has been updated! You have been logged out everywhere else.")
 		}
 	}
 }
@@ -101,6 +175,42 @@ func (h *RequestHandler) AccountChangeUsername(res http.ResponseWriter, req *htt
 	}
 }
 
+// how long a bio may be, in characters
+const MAX_BIO_LENGTH = 1000
+
+func (h *RequestHandler) AccountChangeBio(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Profile"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	renderSuccess := renderErr
+	if req.Method == "GET" {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	} else if req.Method == "POST" {
+		if !loggedIn {
+			renderErr("You need to be logged in to change your bio")
+			return
+		}
+		bio := strings.TrimSpace(req.PostFormValue("bio"))
+		if length := utf8.RuneCountInString(bio); length > MAX_BIO_LENGTH {
+			renderErr(fmt.Sprintf("Your bio is too long (%d characters, at most %d are allowed)", length, MAX_BIO_LENGTH))
+			return
+		}
+		if err := h.db.UpdateUserBio(userid, bio); err != nil {
+			dump(err)
+			renderErr("Database had a problem when saving your bio")
+			return
+		}
+		renderSuccess("Your bio has been updated!")
+	}
+}
+
 func (h *RequestHandler) AccountSelfServiceDelete(res http.ResponseWriter, req *http.Request) {
 	loggedIn, userid := h.IsLoggedIn(req)
 	sectionTitle := "Delete account"
@@ -192,12 +302,305 @@ func (h *RequestHandler) AccountSelfServiceDelete(res http.ResponseWriter, req *
 
 		// all our checks have passed and it looks like we're in for some deleting!
 		fmt.Println("deleting user with userid", userid)
+		// the user's posts stay, but their contents or author may change
+		var federated []int
+		if !deleteOpts.KeepContent || !deleteOpts.KeepUsername {
+			federated = h.federatedUserPosts(userid)
+		}
 		err = h.db.RemoveUser(userid, deleteOpts)
 		if err != nil {
 			renderErr(fmt.Sprintf(delErrMsg, 3))
 			return
 		}
+		for _, postid := range federated {
+			h.federatePost(activitypub.UPDATE, postid)
+		}
 		// log the user out
 		http.Redirect(res, req, "/logout", http.StatusSeeOther)
 	}
 }
+
+func (h *RequestHandler) AccountCreateToken(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Create API token"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	// verify existing credentials
+	currentPassword := req.PostFormValue("current-password")
+	if err := h.checkPasswordIsCorrect(userid, currentPassword); err != nil {
+		renderErr("Current password did not match up with the hash stored in database")
+		return
+	}
+	name := strings.TrimSpace(req.PostFormValue("name"))
+	if name == "" {
+		renderErr("The token needs a name")
+		return
+	}
+	data := h.accountData(userid)
+	var scopes []string
+	for _, scope := range req.PostForm["scope"] {
+		if util.Contains(data.TokenScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		renderErr("Choose at least one scope for the token")
+		return
+	}
+	var expires database.NullTime
+	if days := req.PostFormValue("expires"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			renderErr("The expiry needs to be a number of days, 1 or more")
+			return
+		}
+		expires.Time, expires.Valid = time.Now().AddDate(0, 0, n), true
+	}
+	token, err := h.db.CreateAPIToken(userid, name, scopes, expires)
+	if err != nil {
+		dump(err)
+		renderErr("Database had a problem when creating the token")
+		return
+	}
+	data = h.accountData(userid)
+	data.NewToken = token
+	data.ErrorMessage = fmt.Sprintf("%s: created token %s", sectionTitle, name)
+	h.renderView(res, req, "account", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
+}
+
+func (h *RequestHandler) AccountRevokeToken(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	tokenid, err := strconv.Atoi(req.PostFormValue("tokenid"))
+	if err == nil {
+		err = h.db.DeleteAPIToken(userid, tokenid)
+	}
+	if err != nil {
+		dump(err)
+		renderMsgAccountView(h, res, req, "Revoke API token", "The token could not be revoked")
+		return
+	}
+	http.Redirect(res, req, "/account#api-tokens", http.StatusSeeOther)
+}
+
+func (h *RequestHandler) renderSessionsView(res http.ResponseWriter, req *http.Request, userid int, message string) {
+	token, _ := h.session.GetToken(req)
+	sessions, err := h.db.GetSessions(userid, token)
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	data := SessionsData{Sessions: sessions, RevokeRoute: ACCOUNT_SESSIONS_REVOKE_ROUTE, Message: message}
+	h.renderView(res, req, "sessions", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: true, Title: "Sessions"})
+}
+
+// lists the devices the user is logged in on
+func (h *RequestHandler) AccountSessionsRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if !loggedIn {
+		IndexRedirect(res, req)
+		return
+	}
+	h.renderSessionsView(res, req, userid, "")
+}
+
+// revokes one of the user's sessions, given by the sessionid form value, or all sessions except the current one if
+// the form value all is set
+func (h *RequestHandler) AccountRevokeSessions(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, ACCOUNT_SESSIONS_ROUTE, http.StatusSeeOther)
+		return
+	}
+	token, _ := h.session.GetToken(req)
+	if req.PostFormValue("all") == "true" {
+		if err := h.db.DeleteUserSessions(userid, token); err != nil {
+			dump(err)
+			h.renderSessionsView(res, req, userid, "The sessions could not be revoked")
+			return
+		}
+		h.renderSessionsView(res, req, userid, "You have been logged out everywhere else")
+		return
+	}
+	sessionid, err := strconv.Atoi(req.PostFormValue("sessionid"))
+	if err != nil {
+		h.renderSessionsView(res, req, userid, "No session was given")
+		return
+	}
+	if err = h.db.DeleteUserSession(userid, sessionid); err != nil {
+		dump(err)
+		h.renderSessionsView(res, req, userid, "The session could not be revoked")
+		return
+	}
+	h.renderSessionsView(res, req, userid, "The session has been revoked")
+}
+
+// starts setting up two-factor authentication by generating a secret, which the account page then shows as a qr code
+func (h *RequestHandler) AccountTwoFactorSetup(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Two-factor authentication"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	currentPassword := req.PostFormValue("current-password")
+	if err := h.checkPasswordIsCorrect(userid, currentPassword); err != nil {
+		renderErr("Current password did not match up with the hash stored in database")
+		return
+	}
+	if enabled, err := h.db.HasTwoFactor(userid); err != nil || enabled {
+		renderErr("Two-factor authentication is already enabled")
+		return
+	}
+	if err := h.db.BeginTwoFactor(userid, crypto.GenerateTOTPSecret()); err != nil {
+		dump(err)
+		renderErr("Database had a problem when setting up two-factor authentication")
+		return
+	}
+	renderErr("Scan the QR code with your authenticator app, then enter the code it shows to finish")
+}
+
+// finishes setting up two-factor authentication, once the user has shown that their authenticator app works
+func (h *RequestHandler) AccountTwoFactorEnable(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Two-factor authentication"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	tf, ok, err := h.db.GetTwoFactor(userid)
+	if err != nil || !ok || tf.Enabled {
+		renderErr("There is no two-factor authentication setup to finish")
+		return
+	}
+	step, valid := crypto.ValidateTOTP(tf.Secret, req.PostFormValue("code"), time.Now(), tf.LastStep)
+	if !valid {
+		renderErr("The code was incorrect. Check that your device's clock is correct and try again")
+		return
+	}
+	codes, err := h.db.EnableTwoFactor(userid, step)
+	if err != nil {
+		dump(err)
+		renderErr("Database had a problem when enabling two-factor authentication")
+		return
+	}
+	data := h.accountData(userid)
+	data.ErrorMessage = fmt.Sprintf("%s: %s", sectionTitle, "Enabled! From now on you will be asked for a code when logging in")
+	data.NewRecoveryCodes = codes
+	h.renderView(res, req, "account", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
+}
+
+// checks the password and a current two-factor code, as required for changing an enabled two-factor setup
+func (h *RequestHandler) checkTwoFactorChange(req *http.Request, userid int) string {
+	if err := h.checkPasswordIsCorrect(userid, req.PostFormValue("current-password")); err != nil {
+		return "Current password did not match up with the hash stored in database"
+	}
+	valid, err := h.db.VerifyTwoFactor(userid, req.PostFormValue("code"))
+	if errors.Is(err, database.ErrTwoFactorLocked) {
+		return "Too many incorrect codes, try again later"
+	} else if err != nil {
+		dump(err)
+	}
+	if !valid {
+		return "The code was incorrect"
+	}
+	return ""
+}
+
+func (h *RequestHandler) AccountTwoFactorDisable(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Two-factor authentication"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	renderSuccess := renderErr
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	// an unfinished setup can be cancelled with just the password
+	if enabled, _ := h.db.HasTwoFactor(userid); enabled {
+		if msg := h.checkTwoFactorChange(req, userid); msg != "" {
+			renderErr(msg)
+			return
+		}
+	} else if err := h.checkPasswordIsCorrect(userid, req.PostFormValue("current-password")); err != nil {
+		renderErr("Current password did not match up with the hash stored in database")
+		return
+	}
+	if err := h.db.DisableTwoFactor(userid); err != nil {
+		dump(err)
+		renderErr("Database had a problem when disabling two-factor authentication")
+		return
+	}
+	renderSuccess("Two-factor authentication has been disabled")
+}
+
+func (h *RequestHandler) AccountTwoFactorRecoveryCodes(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Recovery codes"
+	renderErr := func(errMsg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, errMsg)
+	}
+	if req.Method != "POST" || !loggedIn {
+		if !loggedIn {
+			IndexRedirect(res, req)
+			return
+		}
+		http.Redirect(res, req, "/account", http.StatusSeeOther)
+		return
+	}
+	if msg := h.checkTwoFactorChange(req, userid); msg != "" {
+		renderErr(msg)
+		return
+	}
+	codes, err := h.db.RegenerateRecoveryCodes(userid)
+	if err != nil {
+		dump(err)
+		renderErr("Database had a problem when creating recovery codes")
+		return
+	}
+	data := h.accountData(userid)
+	data.ErrorMessage = fmt.Sprintf("%s: %s", sectionTitle, "New recovery codes have been created, and the old ones no longer work")
+	data.NewRecoveryCodes = codes
+	h.renderView(res, req, "account", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
+}
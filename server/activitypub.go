@@ -0,0 +1,616 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/activitypub"
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/types"
+	"gomod.cblgh.org/cerca/util"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the forum federates over activitypub through an actor for the whole forum, forum@<host>, and one per topic,
+// topic-<id>@<host>. fediverse accounts follow them to receive the posts made in the forum's public threads, or in one
+// of its topics, as notes: the first post of a thread starts a conversation, and the other posts are replies to it.
+// creating, editing and deleting posts sends Create, Update and Delete activities to the followers, through a queue in
+// the database that retries failed deliveries. posts in private threads are never federated
+//
+// everything activitypub lives under ACTIVITYPUB_ROUTE:
+//
+//	/ap/<actor>                the actor
+//	/ap/<actor>/inbox          where follows, and undoing them, are received
+//	/ap/<actor>/outbox         the actor's latest posts
+//	/ap/<actor>/followers      how many followers the actor has
+//	/ap/<actor>/posts/<postid> a post, as the actor federates it
+const ACTIVITYPUB_ROUTE = "/ap/"
+const WEBFINGER_ROUTE = "/.well-known/webfinger"
+
+const FORUM_ACTOR = "forum"
+const TOPIC_ACTOR_PREFIX = "topic-"
+
+// how many of the latest posts an actor's outbox lists
+const OUTBOX_POST_LIMIT = 20
+
+// queued deliveries are attempted every DELIVERY_INTERVAL, or as soon as something is queued. a delivery that fails is
+// retried after DELIVERY_RETRY_BASE, and then after twice as long every time it fails again, up to DELIVERY_RETRY_MAX.
+// after DELIVERY_MAX_ATTEMPTS failures, about three days, it is given up on
+const DELIVERY_INTERVAL = time.Minute
+const DELIVERY_BATCH_SIZE = 50
+const DELIVERY_RETRY_BASE = time.Minute
+const DELIVERY_RETRY_MAX = 6 * time.Hour
+const DELIVERY_MAX_ATTEMPTS = 20
+
+type federation struct {
+	client *activitypub.Client
+	// the forum's url, without a trailing slash, and its host, which the actors' webfinger addresses are at
+	base string
+	host string
+	// wakes up deliverActivities, see wakeDeliveries
+	wake chan struct{}
+}
+
+// returns the federation described by the config's [activitypub] section, or nil if the config turns it off
+func newFederation(config types.Config) (*federation, error) {
+	if !config.ActivityPub.Enabled {
+		return nil, nil
+	}
+	if config.RSS.URL == "" {
+		return nil, errors.New("activitypub ids are urls on the forum, which requires [rss] forum_url to be set")
+	}
+	u, err := url.Parse(config.RSS.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("[rss] forum_url %q is not a url", config.RSS.URL)
+	}
+	base := strings.TrimSuffix(config.RSS.URL, "/")
+	return &federation{
+		client: activitypub.NewClient(fmt.Sprintf("cerca (+%s)", base), config.ActivityPub.AllowLocal),
+		base:   base,
+		host:   u.Host,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+func topicActor(topicid int) string {
+	return fmt.Sprintf("%s%d", TOPIC_ACTOR_PREFIX, topicid)
+}
+
+func (f *federation) actorID(actor string) string {
+	return f.base + ACTIVITYPUB_ROUTE + actor
+}
+
+func (f *federation) keyID(actor string) string {
+	return f.actorID(actor) + "#main-key"
+}
+
+func (f *federation) followersID(actor string) string {
+	return f.actorID(actor) + "/followers"
+}
+
+func (f *federation) noteID(actor string, postid int) string {
+	return fmt.Sprintf("%s/posts/%d", f.actorID(actor), postid)
+}
+
+// makes links in rendered posts, such as to mentioned users, absolute
+func (f *federation) absoluteLinks(html string) string {
+	html = strings.ReplaceAll(html, `href="/`, `href="`+f.base+"/")
+	return strings.ReplaceAll(html, `src="/`, `src="`+f.base+"/")
+}
+
+// one of the forum's actors
+type localActor struct {
+	Name    string
+	Title   string
+	Summary string
+	// the actor's page on the forum
+	Path string
+	// 0 for the forum's actor
+	TopicID int
+}
+
+// looks up the forum's actor with the given name
+func (h RequestHandler) lookupActor(name string) (localActor, bool) {
+	if name == FORUM_ACTOR {
+		return localActor{Name: name, Title: h.config.General.Name, Summary: h.config.RSS.Description, Path: "/"}, true
+	}
+	topicid, err := strconv.Atoi(strings.TrimPrefix(name, TOPIC_ACTOR_PREFIX))
+	if !strings.HasPrefix(name, TOPIC_ACTOR_PREFIX) || err != nil || topicActor(topicid) != name {
+		return localActor{}, false
+	}
+	topic, err := h.db.GetTopic(topicid)
+	if err != nil {
+		return localActor{}, false
+	}
+	title := fmt.Sprintf("%s — %s", topic.Name, h.config.General.Name)
+	return localActor{Name: name, Title: title, Summary: topic.Description, Path: fmt.Sprintf("/topic/%d/", topicid), TopicID: topicid}, true
+}
+
+// returns the actor's key pair, pem encoded, generating it the first time the actor needs one
+func (h RequestHandler) actorKey(actor string) (string, string, error) {
+	private, public, err := h.db.GetActorKey(actor)
+	if err != sql.ErrNoRows {
+		return private, public, err
+	}
+	private, public, err = activitypub.GenerateKey()
+	if err != nil {
+		return "", "", err
+	}
+	return h.db.AddActorKey(actor, private, public)
+}
+
+func (h RequestHandler) actorPrivateKey(actor string) (*rsa.PrivateKey, error) {
+	private, _, err := h.actorKey(actor)
+	if err != nil {
+		return nil, err
+	}
+	return activitypub.ParsePrivateKey(private)
+}
+
+func writeActivityJSON(res http.ResponseWriter, status int, data interface{}) {
+	res.Header().Set("Content-Type", activitypub.CONTENT_TYPE)
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(data); err != nil {
+		dump(eout.Eout(err, "encode activitypub response"))
+	}
+}
+
+// serves everything under ACTIVITYPUB_ROUTE
+func (h *RequestHandler) ActivityPubRoute(res http.ResponseWriter, req *http.Request) {
+	if h.fed == nil {
+		http.NotFound(res, req)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, ACTIVITYPUB_ROUTE), "/")
+	actor, ok := h.lookupActor(parts[0])
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "inbox" {
+		if req.Method != "POST" {
+			http.Error(res, "inboxes only accept POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h.activityPubInbox(res, req, actor)
+		return
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		h.activityPubActor(res, actor)
+	case len(parts) == 2 && parts[1] == "outbox":
+		h.activityPubOutbox(res, actor)
+	case len(parts) == 2 && parts[1] == "followers":
+		count, err := h.db.CountFollowers(actor.Name)
+		if err != nil {
+			dump(err)
+		}
+		// only the number of followers is public, not who they are
+		writeActivityJSON(res, http.StatusOK, activitypub.NewOrderedCollection(h.fed.followersID(actor.Name), count, nil))
+	case len(parts) == 3 && parts[1] == "posts":
+		postid, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.NotFound(res, req)
+			return
+		}
+		post, err := h.db.GetFederatedPost(postid)
+		// a topic's actor only federates the posts in the topic
+		if err != nil || post.Private || post.Hidden || (actor.TopicID != 0 && actor.TopicID != post.TopicID) {
+			http.NotFound(res, req)
+			return
+		}
+		note := h.federatedNote(actor.Name, post)
+		note.Context = activitypub.CONTEXT[0]
+		writeActivityJSON(res, http.StatusOK, note)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+func (h RequestHandler) activityPubActor(res http.ResponseWriter, actor localActor) {
+	_, public, err := h.actorKey(actor.Name)
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	id := h.fed.actorID(actor.Name)
+	writeActivityJSON(res, http.StatusOK, activitypub.Actor{
+		Context:           activitypub.CONTEXT,
+		ID:                id,
+		Type:              "Service",
+		PreferredUsername: actor.Name,
+		Name:              actor.Title,
+		Summary:           template.HTMLEscapeString(actor.Summary),
+		URL:               joinPath(h.fed.base, actor.Path),
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         h.fed.followersID(actor.Name),
+		PublicKey:         activitypub.PublicKey{ID: h.fed.keyID(actor.Name), Owner: id, PublicKeyPem: public},
+	})
+}
+
+// lists the latest posts the actor has federated, as the activities that created them
+func (h RequestHandler) activityPubOutbox(res http.ResponseWriter, actor localActor) {
+	posts, err := h.db.ListFeedPosts(database.FeedOptions{TopicID: actor.TopicID, Limit: OUTBOX_POST_LIMIT})
+	if err != nil {
+		dump(err)
+	}
+	postids := make([]int, len(posts))
+	for i, p := range posts {
+		postids[i] = p.ID
+	}
+	federated, err := h.db.GetFederatedPosts(postids)
+	if err != nil {
+		dump(err)
+	}
+	var items []interface{}
+	for _, post := range federated {
+		items = append(items, h.noteActivity(activitypub.CREATE, actor.Name, post))
+	}
+	id := h.fed.actorID(actor.Name) + "/outbox"
+	writeActivityJSON(res, http.StatusOK, activitypub.NewOrderedCollection(id, len(items), items))
+}
+
+// handles an activity sent to the actor's inbox. only follows, and undoing them, are acted on
+func (h *RequestHandler) activityPubInbox(res http.ResponseWriter, req *http.Request, actor localActor) {
+	ed := eout.Describe("activitypub inbox")
+	body, err := io.ReadAll(io.LimitReader(req.Body, activitypub.MAX_DOCUMENT_SIZE+1))
+	if err != nil || len(body) > activitypub.MAX_DOCUMENT_SIZE {
+		http.Error(res, "could not read the activity", http.StatusBadRequest)
+		return
+	}
+	var activity activitypub.IncomingActivity
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		http.Error(res, "could not decode the activity", http.StatusBadRequest)
+		return
+	}
+	// accounts that are deleted tell everyone they have interacted with; as the account can't be fetched anymore to
+	// check their signature, and the forum has no use for them, they are ignored
+	if activity.Type == activitypub.DELETE && activity.ObjectID() == activity.Actor {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// the activity has to be signed by its actor
+	sender, err := h.verifyActivity(req, body, actor.Name)
+	if err != nil {
+		dump(ed.Eout(err, "verify %s from %s", activity.Type, activity.Actor))
+		http.Error(res, "the activity's signature could not be verified", http.StatusUnauthorized)
+		return
+	}
+	if sender.ID != activity.Actor {
+		http.Error(res, "the activity was not signed by its actor", http.StatusUnauthorized)
+		return
+	}
+
+	id := h.fed.actorID(actor.Name)
+	switch activity.Type {
+	case activitypub.FOLLOW:
+		if activity.ObjectID() != id {
+			http.Error(res, "the follow is for another actor", http.StatusBadRequest)
+			return
+		}
+		// a server's followers share an inbox if it has one, so that each activity is delivered to it once
+		inbox := sender.Inbox
+		if sender.Endpoints != nil && sender.Endpoints.SharedInbox != "" {
+			inbox = sender.Endpoints.SharedInbox
+		}
+		if err := h.db.AddFollower(actor.Name, sender.ID, inbox); err != nil {
+			dump(err)
+			http.Error(res, "An error occured", http.StatusInternalServerError)
+			return
+		}
+		accept := activitypub.Activity{
+			Context: activitypub.CONTEXT[0],
+			ID:      fmt.Sprintf("%s#accepts/%s", id, randomID()),
+			Type:    activitypub.ACCEPT,
+			Actor:   id,
+			Object:  json.RawMessage(body),
+		}
+		h.queueActivity(actor.Name, accept, []string{sender.Inbox})
+	case activitypub.UNDO:
+		follow, err := activity.ObjectActivity()
+		if err == nil && follow.Type == activitypub.FOLLOW && follow.Actor == sender.ID && follow.ObjectID() == id {
+			if err := h.db.RemoveFollower(actor.Name, sender.ID); err != nil {
+				dump(err)
+			}
+		}
+	}
+	res.WriteHeader(http.StatusAccepted)
+}
+
+// checks the http signature of an activity sent to one of the forum's actors, returning the actor that signed it
+func (h RequestHandler) verifyActivity(req *http.Request, body []byte, actor string) (activitypub.Actor, error) {
+	ed := eout.Describe("verify activity")
+	keyID, err := activitypub.SignatureKeyID(req)
+	if err != nil {
+		return activitypub.Actor{}, ed.Eout(err, "get key id")
+	}
+	// the key's id is its owner's id, with a fragment
+	ownerID, _, _ := strings.Cut(keyID, "#")
+	key, err := h.actorPrivateKey(actor)
+	if err != nil {
+		return activitypub.Actor{}, ed.Eout(err, "get key of %s", actor)
+	}
+	owner, err := h.fed.client.FetchActor(ownerID, h.fed.keyID(actor), key)
+	if err != nil {
+		return activitypub.Actor{}, ed.Eout(err, "fetch owner of key %s", keyID)
+	}
+	if owner.PublicKey.ID != keyID {
+		return activitypub.Actor{}, fmt.Errorf("verify activity: %s has no key %s", ownerID, keyID)
+	}
+	public, err := activitypub.ParsePublicKey(owner.PublicKey.PublicKeyPem)
+	if err != nil {
+		return activitypub.Actor{}, ed.Eout(err, "parse key of %s", ownerID)
+	}
+	return owner, activitypub.Verify(req, body, h.fed.host, public)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// answers webfinger lookups of the forum's actors, e.g. acct:forum@example.org
+func (h RequestHandler) WebFingerRoute(res http.ResponseWriter, req *http.Request) {
+	if h.fed == nil {
+		http.NotFound(res, req)
+		return
+	}
+	resource := req.URL.Query().Get("resource")
+	var name string
+	if account, found := strings.CutPrefix(resource, "acct:"); found {
+		user, host, _ := strings.Cut(account, "@")
+		if !strings.EqualFold(host, h.fed.host) {
+			http.NotFound(res, req)
+			return
+		}
+		name = user
+	} else {
+		// an actor can also be looked up by its id
+		name = strings.TrimPrefix(resource, h.fed.base+ACTIVITYPUB_ROUTE)
+	}
+	actor, ok := h.lookupActor(name)
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	id := h.fed.actorID(actor.Name)
+	page := joinPath(h.fed.base, actor.Path)
+	res.Header().Set("Content-Type", activitypub.JRD_CONTENT_TYPE)
+	err := json.NewEncoder(res).Encode(activitypub.WebFinger{
+		Subject: fmt.Sprintf("acct:%s@%s", actor.Name, h.fed.host),
+		Aliases: []string{id, page},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.CONTENT_TYPE, Href: id},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: page},
+		},
+	})
+	if err != nil {
+		dump(eout.Eout(err, "encode webfinger response"))
+	}
+}
+
+// the post as the actor federates it. the note starts with a link to the post's thread, and who wrote the post
+func (h RequestHandler) federatedNote(actor string, post database.FederatedPost) activitypub.Note {
+	threadURL := joinPath(h.fed.base, fmt.Sprintf("/thread/%d/", post.ThreadID))
+	heading := fmt.Sprintf(`<p><a href="%s">%s</a> · %s</p>`, template.HTMLEscapeString(threadURL), template.HTMLEscapeString(post.ThreadTitle), template.HTMLEscapeString(post.Author))
+	note := activitypub.Note{
+		ID:           h.fed.noteID(actor, post.ID),
+		Type:         "Note",
+		AttributedTo: h.fed.actorID(actor),
+		Content:      heading + h.fed.absoluteLinks(string(util.MarkupMentions(post.Content, post.Mentions))),
+		URL:          joinPath(h.fed.base, postURL(post.ThreadID, post.ID)),
+		Published:    activitypub.FormatTime(post.Publish),
+		To:           []string{activitypub.PUBLIC},
+		CC:           []string{h.fed.followersID(actor)},
+	}
+	if post.ID != post.FirstPostID {
+		note.InReplyTo = h.fed.noteID(actor, post.FirstPostID)
+	}
+	if post.LastEdit.Valid {
+		note.Updated = activitypub.FormatTime(post.LastEdit.Time)
+	}
+	return note
+}
+
+// wraps the post's note in an activity of the given kind: Create, Update or Delete
+func (h RequestHandler) noteActivity(kind, actor string, post database.FederatedPost) activitypub.Activity {
+	note := h.federatedNote(actor, post)
+	var object interface{} = note
+	if kind == activitypub.DELETE {
+		object = activitypub.Tombstone{ID: note.ID, Type: "Tombstone"}
+	}
+	id := fmt.Sprintf("%s/%s", note.ID, strings.ToLower(kind))
+	if kind != activitypub.CREATE {
+		// a post can be updated many times, and every update needs an id of its own
+		id = fmt.Sprintf("%s/%d", id, time.Now().UnixNano())
+	}
+	return activitypub.Activity{
+		Context:   activitypub.CONTEXT[0],
+		ID:        id,
+		Type:      kind,
+		Actor:     h.fed.actorID(actor),
+		Object:    object,
+		Published: activitypub.FormatTime(time.Now()),
+		To:        note.To,
+		CC:        note.CC,
+	}
+}
+
+// queues the activity for delivery to the inboxes, signed by actor
+func (h *RequestHandler) queueActivity(actor string, activity activitypub.Activity, inboxes []string) {
+	body, err := json.Marshal(activity)
+	if err == nil {
+		err = h.db.QueueDeliveries(actor, body, inboxes)
+	}
+	if err != nil {
+		log.Println(eout.Eout(err, "queue %s of %s", activity.Type, actor))
+		return
+	}
+	select {
+	case h.fed.wake <- struct{}{}:
+	default:
+		// deliverActivities has already been woken up
+	}
+}
+
+// looks up a post for federate. it has to be looked up before it is deleted, as federating its deletion needs to
+// know where the post was. ok is false if federation is turned off
+func (h *RequestHandler) federatedPost(postid int) (database.FederatedPost, bool) {
+	if h.fed == nil {
+		return database.FederatedPost{}, false
+	}
+	post, err := h.db.GetFederatedPost(postid)
+	if err != nil {
+		log.Println(err)
+		return post, false
+	}
+	return post, true
+}
+
+// sends the followers of the forum, and of the post's topic, an activity about the post: Create when it is made,
+// Update when it is edited and Delete when it is deleted. posts in private threads are never federated, and posts
+// hidden by an admin only as their deletion
+func (h *RequestHandler) federate(kind string, post database.FederatedPost) {
+	if h.fed == nil || post.Private || (post.Hidden && kind != activitypub.DELETE) {
+		return
+	}
+	actors := []string{FORUM_ACTOR}
+	if post.TopicID > 0 {
+		actors = append(actors, topicActor(post.TopicID))
+	}
+	for _, actor := range actors {
+		inboxes, err := h.db.GetFollowerInboxes(actor)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(inboxes) > 0 {
+			h.queueActivity(actor, h.noteActivity(kind, actor, post), inboxes)
+		}
+	}
+}
+
+// federates an activity about a post that still exists
+func (h *RequestHandler) federatePost(kind string, postid int) {
+	if post, ok := h.federatedPost(postid); ok {
+		h.federate(kind, post)
+	}
+}
+
+// federates the first post of a thread that was just created
+func (h *RequestHandler) federateThread(threadid int) {
+	if h.fed == nil {
+		return
+	}
+	posts, err := h.db.GetThread(threadid)
+	if err != nil || len(posts) == 0 {
+		log.Println(eout.Eout(err, "federate thread %d", threadid))
+		return
+	}
+	h.federatePost(activitypub.CREATE, posts[0].ID)
+}
+
+// returns the ids of the user's federated posts, to federate what happens to them when the user's account is deleted
+func (h *RequestHandler) federatedUserPosts(userid int) []int {
+	if h.fed == nil {
+		return nil
+	}
+	// sqlite takes a negative limit as no limit
+	posts, err := h.db.ListFeedPosts(database.FeedOptions{AuthorID: userid, Limit: -1})
+	if err != nil {
+		log.Println(err)
+	}
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+// delivers the queued activities, whenever woken up by queueActivity and every DELIVERY_INTERVAL. runs for as long as
+// the forum does
+func (h *RequestHandler) deliverActivities() {
+	ed := eout.Describe("deliver activities")
+	ticker := time.NewTicker(DELIVERY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.fed.wake:
+		case <-ticker.C:
+		}
+		for {
+			deliveries, err := h.db.GetDueDeliveries(DELIVERY_BATCH_SIZE)
+			if err != nil {
+				log.Println(ed.Eout(err, "get due deliveries"))
+				break
+			}
+			keys := make(map[string]*rsa.PrivateKey)
+			for _, delivery := range deliveries {
+				key, exists := keys[delivery.Actor]
+				if !exists {
+					if key, err = h.actorPrivateKey(delivery.Actor); err != nil {
+						log.Println(ed.Eout(err, "get key of %s", delivery.Actor))
+						continue
+					}
+					keys[delivery.Actor] = key
+				}
+				h.deliver(delivery, key)
+			}
+			if len(deliveries) < DELIVERY_BATCH_SIZE {
+				break
+			}
+		}
+	}
+}
+
+func (h *RequestHandler) deliver(delivery database.Delivery, key *rsa.PrivateKey) {
+	ed := eout.Describe("deliver activity")
+	err := h.fed.client.Deliver(delivery.Inbox, delivery.Activity, h.fed.keyID(delivery.Actor), key)
+	var permanent activitypub.PermanentError
+	switch {
+	case err == nil:
+	case errors.As(err, &permanent):
+		log.Println(ed.Eout(err, "giving up on delivery %d to %s", delivery.ID, delivery.Inbox))
+		// the followers behind an inbox that is gone won't be back
+		if permanent.StatusCode == http.StatusGone {
+			if err := h.db.RemoveFollowersByInbox(delivery.Inbox); err != nil {
+				log.Println(err)
+			}
+		}
+	case delivery.Attempts+1 >= DELIVERY_MAX_ATTEMPTS:
+		log.Println(ed.Eout(err, "giving up on delivery %d to %s after %d attempts", delivery.ID, delivery.Inbox, delivery.Attempts+1))
+	default:
+		wait := DELIVERY_RETRY_BASE << delivery.Attempts
+		if wait > DELIVERY_RETRY_MAX || wait <= 0 {
+			wait = DELIVERY_RETRY_MAX
+		}
+		if err := h.db.RetryDelivery(delivery.ID, time.Now().Add(wait), err.Error()); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if err := h.db.RemoveDelivery(delivery.ID); err != nil {
+		log.Println(err)
+	}
+}
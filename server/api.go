@@ -0,0 +1,500 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/activitypub"
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/limiter"
+	"gomod.cblgh.org/cerca/util"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the json api mirrors the html routes, applying the same visibility rules: private threads are only visible when logged
+// in, and the content of hidden posts only to admins. reading works with the session cookie or an api token with the
+// read scope. writing always requires an api token (see database/tokens.go), sent as "Authorization: Bearer <token>"
+const API_ROUTE = "/api/v1/"
+const API_THREADS_ROUTE = "/api/v1/threads"
+const API_THREAD_ROUTE = "/api/v1/thread/"
+const API_POST_ROUTE = "/api/v1/post/"
+const API_USERS_ROUTE = "/api/v1/users/"
+const API_MODERATIONS_ROUTE = "/api/v1/moderations"
+const API_INVITES_ROUTE = "/api/v1/invites"
+
+type APIError struct {
+	Error string `json:"error"`
+}
+
+type APIThreads struct {
+	Threads   []database.Thread `json:"threads"`
+	Page      int               `json:"page"`
+	PageCount int               `json:"page_count"`
+}
+
+type APIThread struct {
+	ID        int             `json:"id"`
+	Title     string          `json:"title"`
+	Slug      string          `json:"slug"`
+	Private   bool            `json:"private"`
+	Posts     []database.Post `json:"posts"`
+	Page      int             `json:"page"`
+	PageCount int             `json:"page_count"`
+}
+
+type APIUser struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Admin   bool   `json:"admin"`
+	Threads int    `json:"threads"`
+	Posts   int    `json:"posts"`
+}
+
+// api clients make more requests than feed readers, so they get a more generous rate limit than the one set up in Serve
+func NewAPIRateLimitingWare() *RateLimitingWare {
+	ware := RateLimitingWare{}
+	// refresh one access every second. forget about the requester after 24h of non-activity
+	ware.limiter = limiter.NewTimedRateLimiter(nil, time.Second, 24*time.Hour)
+	ware.limiter.SetLimitAllRoutes(true)
+	ware.limiter.SetBurstAllowance(30)
+	return &ware
+}
+
+// returns a mux serving every api route
+func (h *RequestHandler) apiMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(API_THREADS_ROUTE, h.APIThreadsRoute)
+	mux.HandleFunc(API_THREAD_ROUTE, h.APIThreadRoute)
+	mux.HandleFunc(API_POST_ROUTE, h.APIPostRoute)
+	mux.HandleFunc(API_USERS_ROUTE, h.APIUserRoute)
+	mux.HandleFunc(API_MODERATIONS_ROUTE, h.APIModerationsRoute)
+	mux.HandleFunc(API_INVITES_ROUTE, h.APIInvitesRoute)
+	mux.HandleFunc(API_INVITES_ROUTE+"/", h.APIInvitesRoute)
+	mux.HandleFunc(API_ROUTE, func(res http.ResponseWriter, req *http.Request) {
+		writeAPIError(res, http.StatusNotFound, "no such route")
+	})
+	return mux
+}
+
+func writeJSON(res http.ResponseWriter, status int, data interface{}) {
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.WriteHeader(status)
+	err := json.NewEncoder(res).Encode(data)
+	if err != nil {
+		dump(eout.Eout(err, "encode json response"))
+	}
+}
+
+func writeAPIError(res http.ResponseWriter, status int, message string) {
+	writeJSON(res, status, APIError{Error: message})
+}
+
+// returns false, after responding, if the request's method is not one of the allowed methods
+func checkAPIMethod(res http.ResponseWriter, req *http.Request, methods ...string) bool {
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD"}
+	}
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	res.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(res, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// decodes the json request body into v. returns false, after responding, if the body could not be decoded
+func decodeAPIRequest(res http.ResponseWriter, req *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(res, req.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(res, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// who is making an api request
+type apiCaller struct {
+	LoggedIn bool
+	IsAdmin  bool
+	UserID   int
+}
+
+// authenticates a request reading from the api. requests with an api token are authenticated by the token: tokens
+// with the read scope see what their owner would see, other tokens what a visitor would see. requests without a token
+// are authenticated by their session cookie. returns false, after responding, if the request has an invalid token
+func (h *RequestHandler) apiReader(res http.ResponseWriter, req *http.Request) (apiCaller, bool) {
+	var caller apiCaller
+	if _, hasToken := bearerToken(req); !hasToken {
+		caller.LoggedIn, caller.UserID = h.IsLoggedIn(req)
+		caller.IsAdmin, _ = h.IsAdmin(req)
+		return caller, true
+	}
+	authorized, token := h.IsTokenAuthorized(req, database.TOKEN_SCOPE_READ)
+	if token.ID == 0 || token.Expired() {
+		writeAPIUnauthorized(res)
+		return caller, false
+	}
+	if authorized {
+		caller.LoggedIn, caller.UserID = true, token.UserID
+		caller.IsAdmin, _ = h.db.IsUserAdmin(token.UserID)
+	}
+	return caller, true
+}
+
+// authenticates a request writing through the api, which requires an api token with the given scope. returns false,
+// after responding, if the request lacks such a token
+func (h *RequestHandler) apiWriter(res http.ResponseWriter, req *http.Request, scope string) (database.APIToken, bool) {
+	authorized, token := h.IsTokenAuthorized(req, scope)
+	if token.ID == 0 || token.Expired() {
+		writeAPIUnauthorized(res)
+		return token, false
+	} else if !authorized {
+		writeAPIError(res, http.StatusForbidden, fmt.Sprintf("token lacks the %s scope", scope))
+		return token, false
+	}
+	return token, true
+}
+
+func writeAPIUnauthorized(res http.ResponseWriter) {
+	res.Header().Set("WWW-Authenticate", `Bearer realm="cerca"`)
+	writeAPIError(res, http.StatusUnauthorized, "missing, invalid or expired api token")
+}
+
+// blanks the content of hidden posts for everyone but admins, like the thread view does
+func redactHiddenPost(post *database.Post, isAdmin bool) {
+	if post.Hidden && !isAdmin {
+		post.Content = ""
+	}
+}
+
+// lists threads like the index, most recent thread first. the parameter sort=posts sorts by most recent post instead,
+// and one or more topic=<id> parameters only list the threads of those topics. POST creates a new thread
+func (h *RequestHandler) APIThreadsRoute(res http.ResponseWriter, req *http.Request) {
+	if !checkAPIMethod(res, req, "GET", "HEAD", "POST") {
+		return
+	}
+	if req.URL.Path != API_THREADS_ROUTE {
+		writeAPIError(res, http.StatusNotFound, "no such route")
+		return
+	}
+	if req.Method == "POST" {
+		h.apiCreateThread(res, req)
+		return
+	}
+	caller, ok := h.apiReader(res, req)
+	if !ok {
+		return
+	}
+	params := req.URL.Query()
+	options := database.ListThreadsOptions{SortByPost: params.Get("sort") == "posts", IncludePrivate: caller.LoggedIn}
+	for _, param := range params["topic"] {
+		topicid, err := strconv.Atoi(param)
+		if err != nil {
+			writeAPIError(res, http.StatusBadRequest, fmt.Sprintf("invalid topic %q", param))
+			return
+		}
+		options.TopicIDs = append(options.TopicIDs, topicid)
+	}
+	pagination := h.paginateThreads(req, &options, API_THREADS_ROUTE)
+	threads := h.db.ListThreads(options)
+	if threads == nil {
+		threads = []database.Thread{}
+	}
+	writeJSON(res, http.StatusOK, APIThreads{Threads: threads, Page: pagination.Page, PageCount: pagination.PageCount})
+}
+
+type APINewThread struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// the thread is put in the default topic if TopicID is left out
+	TopicID int  `json:"topic_id"`
+	Private bool `json:"private"`
+}
+
+func (h *RequestHandler) apiCreateThread(res http.ResponseWriter, req *http.Request) {
+	token, ok := h.apiWriter(res, req, database.TOKEN_SCOPE_POST)
+	if !ok {
+		return
+	}
+	var thread APINewThread
+	if !decodeAPIRequest(res, req, &thread) {
+		return
+	}
+	if strings.TrimSpace(thread.Title) == "" || strings.TrimSpace(thread.Content) == "" {
+		writeAPIError(res, http.StatusBadRequest, "a thread needs a title and content")
+		return
+	}
+	if thread.TopicID == 0 {
+		thread.TopicID = h.db.GetDefaultTopicID()
+	} else if exists, err := h.db.CheckTopicExists(thread.TopicID); err != nil || !exists {
+		writeAPIError(res, http.StatusBadRequest, fmt.Sprintf("topic %d does not exist", thread.TopicID))
+		return
+	}
+	threadid, err := h.db.CreateThread(thread.Title, thread.Content, token.UserID, thread.TopicID, thread.Private)
+	if err != nil {
+		dump(eout.Eout(err, "apiCreateThread"))
+		writeAPIError(res, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	h.emailNotifications()
+	h.federateThread(threadid)
+	h.threadWebhooks(threadid)
+	// update the rss feed
+	h.rssFeed = GenerateRSS(h.db, h.config)
+	h.writeAPIThread(res, http.StatusCreated, threadid, 1, false)
+}
+
+// returns a page of a thread's posts, paginated like ThreadRoute. POST replies to the thread
+func (h *RequestHandler) APIThreadRoute(res http.ResponseWriter, req *http.Request) {
+	if !checkAPIMethod(res, req, "GET", "HEAD", "POST") {
+		return
+	}
+	threadid, ok := util.GetURLPortion(req, 4)
+	if !ok {
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	if req.Method == "POST" {
+		h.apiReply(res, req, threadid)
+		return
+	}
+	caller, ok := h.apiReader(res, req)
+	if !ok {
+		return
+	}
+	// private threads are reported as missing to anyone not logged in, like in ThreadRoute
+	isPrivate, err := h.db.IsThreadPrivate(threadid)
+	if err != nil || (isPrivate && !caller.LoggedIn) {
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	h.writeAPIThread(res, http.StatusOK, threadid, getRequestedPage(req), caller.IsAdmin)
+}
+
+func (h *RequestHandler) writeAPIThread(res http.ResponseWriter, status, threadid, page int, isAdmin bool) {
+	isPrivate, err := h.db.IsThreadPrivate(threadid)
+	if err != nil {
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	postCount, err := h.db.CountThreadPosts(threadid)
+	if err != nil || postCount == 0 {
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	pageSize := h.config.Pagination.PostsPerPage
+	pages := pageCount(postCount, pageSize)
+	if page > pages {
+		page = pages
+	}
+	posts, err := h.db.GetThreadPage(threadid, page, pageSize)
+	if err != nil || len(posts) == 0 {
+		dump(eout.Eout(err, "writeAPIThread"))
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	for i := range posts {
+		posts[i].ThreadID = threadid
+		redactHiddenPost(&posts[i], isAdmin)
+	}
+	title := posts[0].ThreadTitle
+	writeJSON(res, status, APIThread{
+		ID:        threadid,
+		Title:     title,
+		Slug:      util.GetThreadSlug(threadid, title, postCount),
+		Private:   isPrivate,
+		Posts:     posts,
+		Page:      page,
+		PageCount: pages,
+	})
+}
+
+type APINewPost struct {
+	Content string `json:"content"`
+}
+
+func (h *RequestHandler) apiReply(res http.ResponseWriter, req *http.Request, threadid int) {
+	token, ok := h.apiWriter(res, req, database.TOKEN_SCOPE_POST)
+	if !ok {
+		return
+	}
+	if _, err := h.db.IsThreadPrivate(threadid); err != nil {
+		writeAPIError(res, http.StatusNotFound, "thread not found")
+		return
+	}
+	var post APINewPost
+	if !decodeAPIRequest(res, req, &post) {
+		return
+	}
+	if strings.TrimSpace(post.Content) == "" {
+		writeAPIError(res, http.StatusBadRequest, "a post needs content")
+		return
+	}
+	postid := h.db.AddPost(post.Content, threadid, token.UserID)
+	h.emailNotifications()
+	h.federatePost(activitypub.CREATE, postid)
+	h.postWebhooks(WEBHOOK_POST_ADDED, postid)
+	// update the rss feed
+	h.rssFeed = GenerateRSS(h.db, h.config)
+	created, err := h.db.GetPost(postid)
+	if err != nil {
+		dump(eout.Eout(err, "apiReply"))
+		writeAPIError(res, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	writeJSON(res, http.StatusCreated, created)
+}
+
+func (h *RequestHandler) APIPostRoute(res http.ResponseWriter, req *http.Request) {
+	if !checkAPIMethod(res, req) {
+		return
+	}
+	caller, ok := h.apiReader(res, req)
+	if !ok {
+		return
+	}
+	postid, ok := util.GetURLPortion(req, 4)
+	if !ok {
+		writeAPIError(res, http.StatusNotFound, "post not found")
+		return
+	}
+	post, err := h.db.GetPost(postid)
+	if err != nil {
+		writeAPIError(res, http.StatusNotFound, "post not found")
+		return
+	}
+	isPrivate, err := h.db.IsThreadPrivate(post.ThreadID)
+	if err != nil || (isPrivate && !caller.LoggedIn) {
+		writeAPIError(res, http.StatusNotFound, "post not found")
+		return
+	}
+	redactHiddenPost(&post, caller.IsAdmin)
+	writeJSON(res, http.StatusOK, post)
+}
+
+// returns a user's name and how many threads and posts they have written. activity in private threads is only
+// counted when logged in
+func (h *RequestHandler) APIUserRoute(res http.ResponseWriter, req *http.Request) {
+	if !checkAPIMethod(res, req) {
+		return
+	}
+	caller, ok := h.apiReader(res, req)
+	if !ok {
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, API_USERS_ROUTE), "/")
+	if name == "" || name == database.SYSTEM_USER_NAME {
+		writeAPIError(res, http.StatusNotFound, "user not found")
+		return
+	}
+	userid, err := h.db.GetUserID(name)
+	if err != nil {
+		writeAPIError(res, http.StatusNotFound, "user not found")
+		return
+	}
+	isAdmin, err := h.db.IsUserAdmin(userid)
+	if err != nil {
+		dump(eout.Eout(err, "APIUserRoute"))
+		writeAPIError(res, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	threads, posts, err := h.db.CountUserActivity(userid, caller.LoggedIn)
+	if err != nil {
+		dump(eout.Eout(err, "APIUserRoute"))
+		writeAPIError(res, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	writeJSON(res, http.StatusOK, APIUser{ID: userid, Name: name, Admin: isAdmin, Threads: threads, Posts: posts})
+}
+
+// returns the moderation log, latest entry first. like on the moderation log page, only admins see who had their
+// password reset or an account registered for them, and visitors who aren't logged in don't see private threads
+func (h *RequestHandler) APIModerationsRoute(res http.ResponseWriter, req *http.Request) {
+	if !checkAPIMethod(res, req) {
+		return
+	}
+	caller, ok := h.apiReader(res, req)
+	if !ok {
+		return
+	}
+	logs := h.db.GetModerationLogs()
+	if logs == nil {
+		logs = []database.ModerationEntry{}
+	}
+	for i, entry := range logs {
+		switch entry.Action {
+		case constants.MODLOG_RESETPW, constants.MODLOG_ADMIN_ADD_USER, constants.MODLOG_CLEAR_TWO_FACTOR:
+			if !caller.IsAdmin {
+				logs[i].RecipientUsername = ""
+			}
+		}
+		// callers who can't read private threads aren't told which private thread an action was taken in
+		if !caller.LoggedIn && h.modlogSubjectPrivate(entry.Action, entry.SubjectID) {
+			logs[i].Note, logs[i].SubjectID = "", 0
+		}
+	}
+	writeJSON(res, http.StatusOK, logs)
+}
+
+type APINewInvites struct {
+	Amount   int    `json:"amount"`
+	Label    string `json:"label"`
+	Reusable bool   `json:"reusable"`
+}
+
+// manages invites like the admin invites page, with an api token with the admin-invites scope: GET lists the invite
+// batches, POST creates a batch and DELETE /api/v1/invites/<batchid> deletes one. changes are recorded in the
+// moderation log, noting the token that was used
+func (h *RequestHandler) APIInvitesRoute(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("APIInvitesRoute")
+	batchid := strings.Trim(strings.TrimPrefix(req.URL.Path, API_INVITES_ROUTE), "/")
+	methods := []string{"GET", "HEAD", "POST"}
+	if batchid != "" {
+		methods = []string{"DELETE"}
+	}
+	if !checkAPIMethod(res, req, methods...) {
+		return
+	}
+	token, ok := h.apiWriter(res, req, database.TOKEN_SCOPE_ADMIN_INVITES)
+	if !ok {
+		return
+	}
+	note := fmt.Sprintf("api token %q", token.Name)
+	switch req.Method {
+	case "POST":
+		var invites APINewInvites
+		if !decodeAPIRequest(res, req, &invites) {
+			return
+		}
+		if invites.Amount < 1 {
+			writeAPIError(res, http.StatusBadRequest, "amount needs to be 1 or more")
+			return
+		}
+		if _, err := h.db.CreateInvites(token.UserID, invites.Amount, invites.Label, invites.Reusable); err != nil {
+			writeAPIError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		err := h.db.AddModerationLogWithSubject(token.UserID, -1, constants.MODLOG_CREATE_INVITE_BATCH, -1, note)
+		if err != nil {
+			dump(ed.Eout(err, "error adding moderation log"))
+		}
+		writeJSON(res, http.StatusCreated, h.db.GetAllInvites())
+	case "DELETE":
+		h.db.DeleteInvitesBatch(batchid)
+		err := h.db.AddModerationLogWithSubject(token.UserID, -1, constants.MODLOG_DELETE_INVITE_BATCH, -1, note)
+		if err != nil {
+			dump(ed.Eout(err, "error adding moderation log"))
+		}
+		writeJSON(res, http.StatusOK, h.db.GetAllInvites())
+	default:
+		writeJSON(res, http.StatusOK, h.db.GetAllInvites())
+	}
+}
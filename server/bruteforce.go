@@ -0,0 +1,165 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"gomod.cblgh.org/cerca/i18n"
+	"gomod.cblgh.org/cerca/limiter"
+	"gomod.cblgh.org/cerca/types"
+)
+
+// how many lockout events are kept for the admin page
+const maxLockoutEvents = 100
+
+// a temporary lockout of an ip address or a username, after too many failed login or registration attempts
+type LockoutEvent struct {
+	Time time.Time
+	// "IP address" or "username"
+	Kind       string
+	Identifier string
+	Route      string
+	Until      time.Time
+}
+
+type lockout struct {
+	until time.Time
+	// how many times the identifier has been locked out recently; each lockout lasts twice as long as the previous
+	count int
+}
+
+// loginGuard protects /login and /register against guessing passwords and invite codes. every failed attempt consumes
+// a token from the rate limiter of the client's ip address and of the username that was tried from that address. when
+// either runs out of tokens, that identifier is locked out for a while. usernames are only locked out per ip address,
+// so that nobody can lock a user out of their own account by failing to log in as them
+type loginGuard struct {
+	ips        *limiter.TimedRateLimiter
+	usernames  *limiter.TimedRateLimiter
+	lockout    time.Duration
+	maxLockout time.Duration
+
+	mu     sync.Mutex
+	locks  map[string]*lockout
+	events []LockoutEvent
+}
+
+func newLoginGuard(config types.Config) *loginGuard {
+	refill := time.Duration(config.Security.LoginAttemptRefillMinutes) * time.Minute
+	newLimiter := func() *limiter.TimedRateLimiter {
+		rl := limiter.NewTimedRateLimiter(nil, refill, 24*time.Hour)
+		rl.SetLimitAllRoutes(true)
+		rl.SetBurstAllowance(config.Security.LoginAttempts)
+		return rl
+	}
+	return &loginGuard{
+		ips:        newLimiter(),
+		usernames:  newLimiter(),
+		lockout:    time.Duration(config.Security.LockoutMinutes) * time.Minute,
+		maxLockout: time.Duration(config.Security.MaxLockoutMinutes) * time.Minute,
+		locks:      make(map[string]*lockout),
+	}
+}
+
+func lockKey(kind, identifier string) string {
+	return kind + " " + identifier
+}
+
+// usernames are compared case insensitively, so that varying the case doesn't give an attacker more attempts
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// identifies the username as tried from the ip address
+func usernameFrom(ip, username string) string {
+	return normalizeUsername(username) + " from " + ip
+}
+
+// returns how long the ip address or the username (which may be empty) remains locked out; 0 if neither is
+func (g *loginGuard) lockedFor(ip, username string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	keys := []string{lockKey("IP address", ip)}
+	if username != "" {
+		keys = append(keys, lockKey("username", usernameFrom(ip, username)))
+	}
+	for _, key := range keys {
+		if l, exists := g.locks[key]; exists && l.until.Sub(now) > wait {
+			wait = l.until.Sub(now)
+		}
+	}
+	return wait
+}
+
+// records a failed attempt from the ip address on the username (which may be empty), locking either out if it has
+// made too many
+func (g *loginGuard) fail(ip, username, route string) {
+	if g.ips.IsLimited(ip, route) {
+		g.lock("IP address", ip, route)
+	}
+	if normalizeUsername(username) == "" {
+		return
+	}
+	if identifier := usernameFrom(ip, username); g.usernames.IsLimited(identifier, route) {
+		g.lock("username", identifier, route)
+	}
+}
+
+// forgets the failed attempts made on a username from the ip address once its owner has logged in from there
+func (g *loginGuard) succeed(ip, username string) {
+	identifier := usernameFrom(ip, username)
+	g.usernames.Forget(identifier)
+	g.mu.Lock()
+	delete(g.locks, lockKey("username", identifier))
+	g.mu.Unlock()
+}
+
+func (g *loginGuard) lock(kind, identifier, route string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	// forget lockouts that ended long ago, so that the backoff starts over and the map doesn't grow forever
+	for key, l := range g.locks {
+		if now.Sub(l.until) > g.maxLockout {
+			delete(g.locks, key)
+		}
+	}
+	key := lockKey(kind, identifier)
+	l, exists := g.locks[key]
+	if !exists {
+		l = &lockout{}
+		g.locks[key] = l
+	} else if now.Before(l.until) {
+		// already locked out
+		return
+	}
+	l.count++
+	duration := time.Duration(float64(g.lockout) * math.Pow(2, float64(l.count-1)))
+	if duration > g.maxLockout || duration <= 0 {
+		duration = g.maxLockout
+	}
+	l.until = now.Add(duration)
+	event := LockoutEvent{Time: now, Kind: kind, Identifier: identifier, Route: route, Until: l.until}
+	g.events = append([]LockoutEvent{event}, g.events...)
+	if len(g.events) > maxLockoutEvents {
+		g.events = g.events[:maxLockoutEvents]
+	}
+}
+
+// returns the most recent lockouts, latest first
+func (g *loginGuard) recentLockouts() []LockoutEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	events := make([]LockoutEvent, len(g.events))
+	copy(events, g.events)
+	return events
+}
+
+// the message shown to a locked out client
+func lockedOutMessage(translator i18n.Translator, wait time.Duration) string {
+	minutes := int(math.Ceil(wait.Minutes()))
+	return translator.TranslateWithData("LoginLockedOut", i18n.TranslationData{Data: minutes})
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the name of the form field carrying the csrf token, see html/csrf.html
+const CSRF_FIELD = "csrf"
+
+type csrfContextKey struct{}
+
+// returns the csrf token the middleware issued for the request
+func getCSRFToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// protects against cross-site request forgery: every request other than GET, HEAD and OPTIONS needs to carry the
+// session's csrf token in the form field CSRF_FIELD. the token is issued through the session and passed on to the
+// templates by renderView. the api is exempt, as it authenticates writes with api tokens instead of the session cookie,
+// and so are activitypub inboxes, which authenticate activities with http signatures
+func (h *RequestHandler) csrfProtection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, API_ROUTE) || strings.HasPrefix(req.URL.Path, ACTIVITYPUB_ROUTE) || strings.HasPrefix(req.URL.Path, "/assets/") {
+			next.ServeHTTP(res, req)
+			return
+		}
+		safe := req.Method == "GET" || req.Method == "HEAD" || req.Method == "OPTIONS"
+		valid := safe || h.session.ValidCSRFToken(req, req.PostFormValue(CSRF_FIELD))
+		// issue the token before anything is written, as it may need to be saved to the session cookie
+		token, err := h.session.CSRFToken(req, res)
+		if err != nil {
+			dump(eout.Eout(err, "csrfProtection"))
+		}
+		req = req.WithContext(context.WithValue(req.Context(), csrfContextKey{}, token))
+		if !valid {
+			h.renderCSRFError(res, req)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+func (h RequestHandler) renderCSRFError(res http.ResponseWriter, req *http.Request) {
+	res.WriteHeader(http.StatusForbidden)
+	h.renderGenericMessage(res, req, GenericMessageData{
+		Title:   h.translator.Translate("ErrCSRF"),
+		Message: h.translator.Translate("ErrCSRFMessage"),
+	})
+}
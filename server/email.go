@@ -0,0 +1,320 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/limiter"
+	"gomod.cblgh.org/cerca/util"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// how long the link in an email verification can be used
+const EMAIL_VERIFY_VALID_FOR = 48 * time.Hour
+
+// notifications are emailed shortly after they are created. emails that couldn't be sent are retried this often, until
+// the notification is older than NOTIFICATION_EMAIL_MAX_AGE
+const NOTIFICATION_EMAIL_INTERVAL = 5 * time.Minute
+const NOTIFICATION_EMAIL_MAX_AGE = 24 * time.Hour
+
+// a loose check that address looks like an email address, such as ParseAddress would accept without a display name
+func validEmailAddress(address string) bool {
+	parsed, err := netmail.ParseAddress(address)
+	return err == nil && parsed.Address == address
+}
+
+// sends the verification email for the user's address
+func (h *RequestHandler) sendEmailVerification(userid int, username, address string) error {
+	token, err := h.db.CreateEmailToken(userid, constants.EMAIL_TOKEN_VERIFY, address, EMAIL_VERIFY_VALID_FOR)
+	if err != nil {
+		return err
+	}
+	link := h.mailer.URL(ACCOUNT_EMAIL_VERIFY_ROUTE + "?token=" + url.QueryEscape(token))
+	return h.mailer.SendEmailVerification(address, username, link, EMAIL_VERIFY_VALID_FOR)
+}
+
+// sets or removes the logged in user's email address. a new address is sent a verification email, and is only used
+// once it has been verified
+func (h *RequestHandler) AccountChangeEmail(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	sectionTitle := "Email"
+	renderMsg := func(msg string) {
+		renderMsgAccountView(h, res, req, sectionTitle, msg)
+	}
+	if req.Method != "POST" || !loggedIn || !h.mailer.Enabled() {
+		IndexRedirect(res, req)
+		return
+	}
+	// the address receives password reset links, so changing it takes the password
+	if err := h.checkPasswordIsCorrect(userid, req.PostFormValue("current-password")); err != nil {
+		renderMsg("Current password did not match up with the hash stored in database")
+		return
+	}
+	address := req.PostFormValue("email")
+	if address != "" && !validEmailAddress(address) {
+		renderMsg(fmt.Sprintf("%q does not look like an email address", address))
+		return
+	}
+	if err := h.db.SetUserEmail(userid, address); err != nil {
+		dump(err)
+		renderMsg("Database had a problem when saving the email address")
+		return
+	}
+	if address == "" {
+		renderMsg("Your email address has been removed")
+		return
+	}
+	username, _ := h.db.GetUsername(userid)
+	if err := h.sendEmailVerification(userid, username, address); err != nil {
+		log.Println(eout.Eout(err, "send email verification"))
+		renderMsg("Your email address was saved, but sending the verification email failed. Try again later, or contact an admin")
+		return
+	}
+	renderMsg(fmt.Sprintf("A verification email has been sent to %s. Open the link in it to start using the address", address))
+}
+
+// the link in the verification email leads here. it works whether or not the user is logged in
+func (h *RequestHandler) AccountVerifyEmail(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" || !h.mailer.Enabled() {
+		IndexRedirect(res, req)
+		return
+	}
+	_, err := h.db.VerifyUserEmail(req.URL.Query().Get("token"))
+	if err != nil {
+		if err != database.ErrEmailTokenInvalid {
+			dump(err)
+		}
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:   "Email verification failed",
+			Message: "The verification link is invalid: it has already been used, has expired, or was sent to an address that has since been changed. You can get a new link by saving your email address again on your account page.",
+		})
+		return
+	}
+	h.renderGenericMessage(res, req, GenericMessageData{
+		Title:       "Email verified",
+		Message:     "Your email address has been verified.",
+		LinkMessage: "Go to",
+		Link:        "/account",
+		LinkText:    "your account",
+	})
+}
+
+// turns emailing the logged in user their notifications on or off
+func (h *RequestHandler) AccountEmailNotifications(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn || !h.mailer.Enabled() {
+		IndexRedirect(res, req)
+		return
+	}
+	enabled := req.PostFormValue("notifications") == "true"
+	if err := h.db.SetEmailNotifications(userid, enabled); err != nil {
+		dump(err)
+		renderMsgAccountView(h, res, req, "Email", "Database had a problem when saving your choice")
+		return
+	}
+	http.Redirect(res, req, "/account#email", http.StatusSeeOther)
+}
+
+// creates a single invite and emails it to the given address
+func (h *RequestHandler) AdminInvitesEmail(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("server: admin email invite")
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method != "POST" || !loggedIn || !isAdmin || !h.mailer.Enabled() {
+		IndexRedirect(res, req)
+		return
+	}
+	address := req.PostFormValue("email")
+	if !validEmailAddress(address) {
+		h.renderAdminInvites(res, req, fmt.Sprintf("%q does not look like an email address", address), "")
+		return
+	}
+	label := req.PostFormValue("label")
+	if label == "" {
+		label = fmt.Sprintf("emailed to %s", address)
+	}
+	invites, err := h.db.CreateInvites(adminUserId, 1, label, false)
+	if err != nil {
+		fmt.Printf("%v\n", ed.Eout(err, "create invite"))
+		h.renderAdminInvites(res, req, "The invite could not be created", "")
+		return
+	}
+	modlogErr := h.db.AddModerationLog(adminUserId, -1, constants.MODLOG_CREATE_INVITE_BATCH)
+	if modlogErr != nil {
+		fmt.Println(ed.Eout(modlogErr, "error adding moderation log"))
+	}
+	inviter, _ := h.db.GetUsername(adminUserId)
+	link := h.mailer.URL("/register?invite=" + url.QueryEscape(invites[0]))
+	if err := h.mailer.SendInvite(address, inviter, link); err != nil {
+		log.Println(ed.Eout(err, "send invite"))
+		// the invite exists regardless, and is listed below for handing out some other way
+		h.renderAdminInvites(res, req, fmt.Sprintf("The invite was created but could not be emailed to %s", address), "")
+		return
+	}
+	h.renderAdminInvites(res, req, "", fmt.Sprintf("An invite was emailed to %s", address))
+}
+
+// wakes the goroutine emailing notifications, after something that may have created notifications
+func (h *RequestHandler) emailNotifications() {
+	if !h.mailer.Enabled() {
+		return
+	}
+	select {
+	case h.mailWake <- struct{}{}:
+	default:
+		// it has already been woken up
+	}
+}
+
+// emails the users who want them their new notifications, whenever woken up by emailNotifications and every
+// NOTIFICATION_EMAIL_INTERVAL. runs for as long as the forum does
+func (h *RequestHandler) mailNotifications() {
+	ed := eout.Describe("email notifications")
+	ticker := time.NewTicker(NOTIFICATION_EMAIL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.mailWake:
+		case <-ticker.C:
+		}
+		pending, err := h.db.GetPendingNotificationEmails(time.Now().Add(-NOTIFICATION_EMAIL_MAX_AGE))
+		if err != nil {
+			log.Println(ed.Eout(err, "get pending emails"))
+			continue
+		}
+		for _, n := range pending {
+			link := h.mailer.URL(postURL(n.ThreadID, n.PostID))
+			err := h.mailer.SendNotification(n.Address, n.Username, n.Actor, n.ThreadTitle, link, n.IsMention())
+			if err != nil {
+				log.Println(ed.Eout(err, "send notification %d", n.ID))
+				continue
+			}
+			if err := h.db.MarkNotificationEmailed(n.ID); err != nil {
+				log.Println(ed.Eout(err, "mark notification %d emailed", n.ID))
+			}
+		}
+	}
+}
+
+// how long a password reset link can be used, and how many links a user can be sent per hour. an ip address can request
+// PASSWORD_RESET_BURST links at once, and then one every PASSWORD_RESET_REFILL
+const PASSWORD_RESET_VALID_FOR = 2 * time.Hour
+const PASSWORD_RESETS_PER_HOUR = 3
+const PASSWORD_RESET_BURST = 5
+const PASSWORD_RESET_REFILL = 15 * time.Minute
+
+func newPasswordResetLimiter() *limiter.TimedRateLimiter {
+	rl := limiter.NewTimedRateLimiter(nil, PASSWORD_RESET_REFILL, 24*time.Hour)
+	rl.SetLimitAllRoutes(true)
+	rl.SetBurstAllowance(PASSWORD_RESET_BURST)
+	return rl
+}
+
+// lets users who forgot their password choose a new one, through a link emailed to their verified address. users
+// without one are told to ask an admin, as they are when email is turned off
+func (h *RequestHandler) handlePasswordResetEmail(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("password reset by email")
+	title := util.Capitalize(h.translator.Translate("PasswordReset"))
+	render := func(data PasswordResetData) {
+		data.RequestRoute = PASSWORD_RESET_REQUEST_ROUTE
+		data.ConfirmRoute = PASSWORD_RESET_CONFIRM_ROUTE
+		h.renderView(res, req, "password-reset", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", Title: title})
+	}
+
+	switch {
+	case req.Method == "GET" && req.URL.Path == PASSWORD_RESET_CONFIRM_ROUTE:
+		token := req.URL.Query().Get("token")
+		valid, err := h.db.CheckEmailToken(token, constants.EMAIL_TOKEN_PASSWORD_RESET)
+		if err != nil {
+			dump(ed.Eout(err, "check token"))
+		}
+		if !valid {
+			render(PasswordResetData{ErrorMessage: h.translator.Translate("PasswordResetInvalid")})
+			return
+		}
+		render(PasswordResetData{Token: token})
+	case req.Method == "GET":
+		render(PasswordResetData{})
+	case req.Method == "POST" && req.URL.Path == PASSWORD_RESET_REQUEST_ROUTE:
+		if h.resets.IsLimited(util.ClientIP(req), PASSWORD_RESET_REQUEST_ROUTE) {
+			render(PasswordResetData{ErrorMessage: h.translator.Translate("PasswordResetTooMany")})
+			return
+		}
+		if err := h.sendPasswordReset(req.PostFormValue("username")); err != nil {
+			log.Println(ed.Eout(err, "send reset link"))
+		}
+		// the same answer whether or not a link was sent, so that the form doesn't reveal which accounts exist or have
+		// an email address
+		render(PasswordResetData{Message: h.translator.Translate("PasswordResetSent")})
+	case req.Method == "POST" && req.URL.Path == PASSWORD_RESET_CONFIRM_ROUTE:
+		token := req.PostFormValue("token")
+		newPassword := req.PostFormValue("password-new")
+		if len(newPassword) < 9 {
+			render(PasswordResetData{Token: token, ErrorMessage: h.translator.Translate("PasswordResetTooShort")})
+			return
+		}
+		if newPassword != req.PostFormValue("password-new-copy") {
+			render(PasswordResetData{Token: token, ErrorMessage: h.translator.Translate("PasswordResetMismatch")})
+			return
+		}
+		passwordHash, err := crypto.HashPassword(newPassword)
+		if err != nil {
+			dump(ed.Eout(err, "hash new password"))
+			render(PasswordResetData{Token: token, ErrorMessage: h.translator.Translate("PasswordResetNotSaved")})
+			return
+		}
+		if _, err := h.db.ResetPasswordWithToken(token, passwordHash); err != nil {
+			if err != database.ErrEmailTokenInvalid {
+				dump(err)
+			}
+			render(PasswordResetData{ErrorMessage: h.translator.Translate("PasswordResetInvalid")})
+			return
+		}
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:       h.translator.Translate("PasswordResetSuccess"),
+			Message:     h.translator.Translate("PasswordResetSuccessMessage"),
+			LinkMessage: h.translator.Translate("PasswordResetSuccessLinkMessage"),
+			Link:        "/login",
+			LinkText:    h.translator.Translate("Login"),
+		})
+	default:
+		IndexRedirect(res, req)
+	}
+}
+
+// emails a password reset link to the user's verified address. a user without one, a user who doesn't exist and a user
+// who has been sent PASSWORD_RESETS_PER_HOUR links in the last hour are all silently skipped
+func (h *RequestHandler) sendPasswordReset(username string) error {
+	userid, err := h.db.GetUserID(username)
+	if err != nil {
+		// no such user
+		return nil
+	}
+	email, err := h.db.GetUserEmail(userid)
+	if err != nil {
+		return err
+	}
+	if email.Address == "" || !email.Verified {
+		return nil
+	}
+	count, err := h.db.CountEmailTokens(userid, constants.EMAIL_TOKEN_PASSWORD_RESET, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if count >= PASSWORD_RESETS_PER_HOUR {
+		return nil
+	}
+	token, err := h.db.CreateEmailToken(userid, constants.EMAIL_TOKEN_PASSWORD_RESET, email.Address, PASSWORD_RESET_VALID_FOR)
+	if err != nil {
+		return err
+	}
+	link := h.mailer.URL(PASSWORD_RESET_CONFIRM_ROUTE + "?token=" + url.QueryEscape(token))
+	return h.mailer.SendPasswordReset(email.Address, username, link, PASSWORD_RESET_VALID_FOR)
+}
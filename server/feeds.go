@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/util"
+)
+
+// every thread, topic and user has a feed of its latest posts at its page's path followed by FEED_SUFFIX, e.g.
+// /thread/4/feed. feeds are atom, or rss with ?format=rss. unlike the forum-wide feed at /rss.xml, which lists threads,
+// they contain the posts themselves. posts in private threads are never part of any feed, even for logged in users
+const FEED_SUFFIX = "/feed"
+const FEED_FORMAT_RSS = "rss"
+
+// how many of the latest posts a feed contains
+const FEED_POST_LIMIT = 50
+
+// returns the part of the request path between prefix and FEED_SUFFIX, unescaped, if the request is for a feed. the
+// escaped path is used so that a username ending in "/feed" isn't mistaken for a feed, see profileURL
+func feedPath(req *http.Request, prefix string) (string, bool) {
+	escaped := req.URL.EscapedPath()
+	if len(escaped) <= len(prefix)+len(FEED_SUFFIX) || !strings.HasPrefix(escaped, prefix) || !strings.HasSuffix(escaped, FEED_SUFFIX) {
+		return "", false
+	}
+	portion, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(escaped, prefix), FEED_SUFFIX))
+	if err != nil || portion == "" {
+		return "", false
+	}
+	return portion, true
+}
+
+// returns the feed of the page at path, for linking to from the page. empty if feeds aren't configured
+func (h RequestHandler) feedURL(path string) string {
+	if h.config.RSS.URL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path, "/") + FEED_SUFFIX
+}
+
+// a feed before it is rendered as atom or rss
+type feed struct {
+	Title string
+	// the page the feed is about, relative to the forum's url
+	Path    string
+	Updated time.Time
+	Posts   []database.Post
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Link      atomLink   `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	// relative links in post contents, e.g. to mentioned users, are resolved against the forum's url
+	Base    string      `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Updated     string  `xml:"atom:updated"`
+	Creator     string  `xml:"dc:creator"`
+	Description string  `xml:"description"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// the link to a post, which is also its id: it stays the same when the post is edited, or its thread renamed
+func (h RequestHandler) feedPostURL(post database.Post) string {
+	return joinPath(h.config.RSS.URL, postURL(post.ThreadID, post.ID))
+}
+
+func feedPostUpdated(post database.Post) time.Time {
+	if post.LastEdit.Valid {
+		return post.LastEdit.Time
+	}
+	return post.Publish
+}
+
+// collects the posts for a feed, which was last updated when its latest post was published or edited
+func (h RequestHandler) newFeed(title, path string, options database.FeedOptions) (feed, error) {
+	options.Limit = FEED_POST_LIMIT
+	posts, err := h.db.ListFeedPosts(options)
+	if err != nil {
+		return feed{}, err
+	}
+	// a feed without posts has never been updated; the unix epoch also keeps http.ServeContent from setting
+	// Last-Modified
+	f := feed{Title: fmt.Sprintf("%s — %s", title, h.config.General.Name), Path: path, Updated: time.Unix(0, 0), Posts: posts}
+	for _, post := range posts {
+		if updated := feedPostUpdated(post); updated.After(f.Updated) {
+			f.Updated = updated
+		}
+	}
+	return f, nil
+}
+
+func (h RequestHandler) renderAtom(f feed, self string) ([]byte, error) {
+	atom := atomFeed{
+		Base:    strings.TrimSuffix(h.config.RSS.URL, "/") + "/",
+		Title:   f.Title,
+		ID:      self,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: joinPath(h.config.RSS.URL, f.Path)},
+		},
+	}
+	for _, post := range f.Posts {
+		link := h.feedPostURL(post)
+		author := atomAuthor{Name: post.Author}
+		if post.Author != database.DELETED_USER_NAME {
+			author.URI = joinPath(h.config.RSS.URL, profileURL(post.Author))
+		}
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:     post.ThreadTitle,
+			ID:        link,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+			Published: post.Publish.UTC().Format(time.RFC3339),
+			Updated:   feedPostUpdated(post).UTC().Format(time.RFC3339),
+			Author:    author,
+			Content:   atomText{Type: "html", Body: string(util.MarkupMentions(post.Content, post.Mentions))},
+		})
+	}
+	return xml.MarshalIndent(atom, "", "  ")
+}
+
+func (h RequestHandler) renderRSS(f feed, self string) ([]byte, error) {
+	rss := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          joinPath(h.config.RSS.URL, f.Path),
+			Description:   f.Title,
+			LastBuildDate: f.Updated.Format(rfc822RSS),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: self},
+		},
+	}
+	for _, post := range f.Posts {
+		link := h.feedPostURL(post)
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       post.ThreadTitle,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     post.Publish.Format(rfc822RSS),
+			Updated:     feedPostUpdated(post).UTC().Format(time.RFC3339),
+			Creator:     post.Author,
+			Description: string(util.MarkupMentions(post.Content, post.Mentions)),
+		})
+	}
+	return xml.MarshalIndent(rss, "", "  ")
+}
+
+// renders the feed as atom or rss. the response carries an ETag and a Last-Modified header, so that readers polling the
+// feed with If-None-Match or If-Modified-Since are told when nothing has changed
+func (h RequestHandler) serveFeed(res http.ResponseWriter, req *http.Request, f feed) {
+	self := joinPath(h.config.RSS.URL, req.URL.Path)
+	render, contentType := h.renderAtom, "application/atom+xml; charset=utf-8"
+	if req.URL.Query().Get("format") == FEED_FORMAT_RSS {
+		render, contentType = h.renderRSS, "application/rss+xml; charset=utf-8"
+		self += "?format=" + FEED_FORMAT_RSS
+	}
+	body, err := render(f, self)
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+	// the etag covers everything in the feed, including posts that were deleted or hidden since it was last fetched
+	hash := sha256.Sum256(body)
+	res.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(hash[:16])))
+	res.Header().Set("Content-Type", contentType)
+	http.ServeContent(res, req, "", f.Updated, bytes.NewReader(body))
+}
+
+func (h RequestHandler) feedNotFound(res http.ResponseWriter) {
+	http.Error(res, "Feed Not Found", http.StatusNotFound)
+}
+
+// the feeds need the forum's url for their links, like the forum-wide feed
+func (h RequestHandler) feedsConfigured(res http.ResponseWriter) bool {
+	if h.config.RSS.URL == "" {
+		http.Error(res, "Feed Not Configured", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// the latest posts of a thread. private threads have no feed
+func (h RequestHandler) ThreadFeedRoute(res http.ResponseWriter, req *http.Request) {
+	if !h.feedsConfigured(res) {
+		return
+	}
+	portion, _ := feedPath(req, "/thread/")
+	threadid, err := strconv.Atoi(portion)
+	if err != nil {
+		h.feedNotFound(res)
+		return
+	}
+	title, err := h.db.GetFeedThreadTitle(threadid)
+	if err != nil {
+		h.feedNotFound(res)
+		return
+	}
+	f, err := h.newFeed(title, fmt.Sprintf("/thread/%d/", threadid), database.FeedOptions{ThreadID: threadid})
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	h.serveFeed(res, req, f)
+}
+
+// the latest posts in the public threads of a topic
+func (h RequestHandler) TopicFeedRoute(res http.ResponseWriter, req *http.Request) {
+	if !h.feedsConfigured(res) {
+		return
+	}
+	portion, _ := feedPath(req, "/topic/")
+	topicid, err := strconv.Atoi(portion)
+	if err != nil {
+		h.feedNotFound(res)
+		return
+	}
+	topic, err := h.db.GetTopic(topicid)
+	if err != nil {
+		h.feedNotFound(res)
+		return
+	}
+	f, err := h.newFeed(topic.Name, fmt.Sprintf("/topic/%d/", topic.ID), database.FeedOptions{TopicID: topic.ID})
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	h.serveFeed(res, req, f)
+}
+
+// the latest posts a user has written in public threads
+func (h RequestHandler) UserFeedRoute(res http.ResponseWriter, req *http.Request) {
+	if !h.feedsConfigured(res) {
+		return
+	}
+	name, _ := feedPath(req, USER_ROUTE)
+	// like their profiles, the system user and the deleted user have no feed
+	if name == database.SYSTEM_USER_NAME || name == database.DELETED_USER_NAME {
+		h.feedNotFound(res)
+		return
+	}
+	profile, err := h.db.GetUserProfile(name)
+	if err != nil {
+		h.feedNotFound(res)
+		return
+	}
+	f, err := h.newFeed(profile.Name, profileURL(profile.Name), database.FeedOptions{AuthorID: profile.ID})
+	if err != nil {
+		dump(err)
+		http.Error(res, "An error occured", http.StatusInternalServerError)
+		return
+	}
+	h.serveFeed(res, req, f)
+}
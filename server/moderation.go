@@ -5,8 +5,10 @@ import (
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"gomod.cblgh.org/cerca/activitypub"
 	"gomod.cblgh.org/cerca/constants"
 	"gomod.cblgh.org/cerca/crypto"
 	"gomod.cblgh.org/cerca/database"
@@ -21,6 +23,7 @@ type AdminData struct {
 	Proposals     []PendingProposal
 	Registrations []database.RegisteredInvite
 	IsAdmin       bool
+	Lockouts      []LockoutEvent
 }
 
 type ModerationData struct {
@@ -55,6 +58,22 @@ func (h RequestHandler) displaySuccess(res http.ResponseWriter, req *http.Reques
 	h.renderGenericMessage(res, req, data)
 }
 
+// reports whether the forum requires two-factor authentication for admins and the admin has not enabled it yet
+func (h RequestHandler) adminNeedsTwoFactor(userid int) bool {
+	if !h.config.Security.RequireAdmin2FA {
+		return false
+	}
+	if isAdmin, err := h.db.IsUserAdmin(userid); err != nil || !isAdmin {
+		return false
+	}
+	enabled, err := h.db.HasTwoFactor(userid)
+	if err != nil {
+		dump(err)
+		return true
+	}
+	return !enabled
+}
+
 // TODO (2023-12-10): any vulns with this approach? could a user forge a session cookie with the user id of an admin?
 func (h RequestHandler) IsAdmin(req *http.Request) (bool, int) {
 	ed := eout.Describe("IsAdmin")
@@ -81,6 +100,10 @@ func (h RequestHandler) IsAdmin(req *http.Request) (bool, int) {
 	} else if !userIsAdmin {
 		return false, -1
 	}
+	// admins without two-factor authentication are treated as normal users, when the forum requires it of admins
+	if h.adminNeedsTwoFactor(userid) {
+		return false, -1
+	}
 	return true, userid
 }
 
@@ -224,7 +247,7 @@ func (h *RequestHandler) AdminManualAddUserRoute(res http.ResponseWriter, req *h
 	view := TemplateData{Title: h.translator.Translate("AdminAddNewUser"), Data: &data, HasRSS: h.config.RSS.URL != "", IsAdmin: isAdmin, LoggedIn: loggedIn}
 
 	if req.Method == "GET" {
-		h.renderView(res, "admin-add-user", view)
+		h.renderView(res, req, "admin-add-user", view)
 		return
 	}
 
@@ -238,7 +261,7 @@ func (h *RequestHandler) AdminManualAddUserRoute(res http.ResponseWriter, req *h
 
 		if existed {
 			data.ErrorMessage = fmt.Sprintf("Username (%s) is already registered", username)
-			h.renderView(res, "admin-add-user", view)
+			h.renderView(res, req, "admin-add-user", view)
 			return
 		}
 
@@ -321,6 +344,26 @@ func (h *RequestHandler) HandleProposal(res http.ResponseWriter, req *http.Reque
 	IndexRedirect(res, req)
 }
 
+// whether the thread or post a moderation log entry is about is in a private thread. a thread that can't be found
+// anymore, such as a deleted one, may have been private, and is treated as if it was. entries that aren't about a
+// thread or post are not private
+func (h *RequestHandler) modlogSubjectPrivate(action, subjectid int) bool {
+	threadid := subjectid
+	switch action {
+	case constants.MODLOG_DELETE_THREAD, constants.MODLOG_MOVE_THREAD, constants.MODLOG_DELETE_POST:
+	case constants.MODLOG_HIDE_POST, constants.MODLOG_UNHIDE_POST, constants.MODLOG_EDIT_POST:
+		post, err := h.db.GetPost(subjectid)
+		if err != nil {
+			return true
+		}
+		threadid = post.ThreadID
+	default:
+		return false
+	}
+	private, err := h.db.IsThreadPrivate(threadid)
+	return err != nil || private
+}
+
 // Note: this route by definition contains user generated content, so we escape all usernames with
 // html.EscapeString(username)
 func (h *RequestHandler) ModerationLogRoute(res http.ResponseWriter, req *http.Request) {
@@ -333,6 +376,11 @@ func (h *RequestHandler) ModerationLogRoute(res http.ResponseWriter, req *http.R
 	type translationData struct {
 		Time, ActingUsername, RecipientUsername string
 		Action                                  template.HTML
+		// describes the subject of the action, e.g. a thread title; escaped by the translation template
+		Note      string
+		SubjectID int
+		// links to the affected post, if it still exists
+		Link string
 	}
 
 	for _, entry := range logs {
@@ -341,12 +389,32 @@ func (h *RequestHandler) ModerationLogRoute(res http.ResponseWriter, req *http.R
 		tdata.Time = entry.Time.Format("2006-01-02 15:04:05")
 		tdata.ActingUsername = template.HTMLEscapeString(entry.ActingUsername)
 		tdata.RecipientUsername = template.HTMLEscapeString(entry.RecipientUsername)
+		tdata.Note = entry.Note
+		tdata.SubjectID = entry.SubjectID
+		// the moderation log is public, so visitors who aren't logged in aren't told which private thread an action
+		// was taken in
+		redacted := false
+		switch entry.Action {
+		case constants.MODLOG_DELETE_THREAD, constants.MODLOG_MOVE_THREAD, constants.MODLOG_DELETE_POST,
+			constants.MODLOG_HIDE_POST, constants.MODLOG_UNHIDE_POST, constants.MODLOG_EDIT_POST:
+			redacted = !loggedIn && h.modlogSubjectPrivate(entry.Action, entry.SubjectID)
+		}
+		if redacted {
+			tdata.Note, tdata.SubjectID = "", 0
+		}
 		switch entry.Action {
 		case constants.MODLOG_RESETPW:
 			translationString = "modlogResetPassword"
 			if isAdmin {
 				translationString += "Admin"
 			}
+		case constants.MODLOG_CLEAR_TWO_FACTOR:
+			translationString = "modlogClearTwoFactor"
+			if isAdmin {
+				translationString += "Admin"
+			}
+		case constants.MODLOG_CREATE_CLAIM_LINK:
+			translationString = "modlogCreateClaimLink"
 		case constants.MODLOG_ADMIN_MAKE:
 			translationString = "modlogMakeAdmin"
 		case constants.MODLOG_REMOVE_USER:
@@ -368,6 +436,26 @@ func (h *RequestHandler) ModerationLogRoute(res http.ResponseWriter, req *http.R
 			translationString = "modlogCreateInvites"
 		case constants.MODLOG_DELETE_INVITE_BATCH:
 			translationString = "modlogDeleteInvites"
+		case constants.MODLOG_DELETE_THREAD:
+			translationString = "modlogDeleteThread"
+		case constants.MODLOG_MOVE_THREAD:
+			translationString = "modlogMoveThread"
+		case constants.MODLOG_HIDE_POST, constants.MODLOG_UNHIDE_POST, constants.MODLOG_EDIT_POST:
+			translationString = "modlogHidePost"
+			if entry.Action == constants.MODLOG_UNHIDE_POST {
+				translationString = "modlogUnhidePost"
+			} else if entry.Action == constants.MODLOG_EDIT_POST {
+				translationString = "modlogEditPost"
+			}
+			if post, err := h.db.GetPost(entry.SubjectID); err == nil && !redacted {
+				tdata.Link = postURL(post.ThreadID, post.ID)
+			}
+		case constants.MODLOG_DELETE_POST:
+			translationString = "modlogDeletePost"
+			// a deleted post's log entry refers to the thread the post was in
+			if !redacted {
+				tdata.Link = fmt.Sprintf("/thread/%d/", entry.SubjectID)
+			}
 		}
 
 		actionString := h.translator.TranslateWithData(translationString, i18n.TranslationData{Data: tdata})
@@ -395,7 +483,7 @@ func (h *RequestHandler) ModerationLogRoute(res http.ResponseWriter, req *http.R
 		}
 	}
 	view := TemplateData{Title: h.translator.Translate("ModerationLog"), IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Data: viewData}
-	h.renderView(res, "moderation-log", view)
+	h.renderView(res, req, "moderation-log", view)
 }
 
 // used for rendering /admin's pending proposals
@@ -421,6 +509,17 @@ func (h *RequestHandler) AdminRoute(res http.ResponseWriter, req *http.Request)
 	}
 
 	if req.Method == "GET" {
+		if loggedIn && h.adminNeedsTwoFactor(userid) {
+			data := GenericMessageData{
+				Title:       "Two-factor authentication required",
+				Message:     "This forum requires admins to use two-factor authentication. Enable it on your account page to use the admin panel.",
+				Link:        "/account#two-factor",
+				LinkText:    "account page",
+				LinkMessage: "Go to your",
+			}
+			h.renderGenericMessage(res, req, data)
+			return
+		}
 		if !loggedIn || !isAdmin {
 			// non-admin users get a different view
 			h.ListAdmins(res, req)
@@ -452,15 +551,13 @@ func (h *RequestHandler) AdminRoute(res http.ResponseWriter, req *http.Request)
 			proposalString := h.translator.TranslateWithData(str, i18n.TranslationData{Data: prop})
 			pendingProposals[i] = PendingProposal{ID: prop.ProposalID, ProposerID: prop.ActingID, Action: proposalString, Time: t, TimePassed: now.After(t)}
 		}
-		data := AdminData{Admins: admins, Users: normalUsers, Proposals: pendingProposals, Registrations: registrations}
+		data := AdminData{Admins: admins, Users: normalUsers, Proposals: pendingProposals, Registrations: registrations, Lockouts: h.guard.recentLockouts()}
 		view := TemplateData{Title: h.translator.Translate("AdminForumAdministration"), Data: &data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, LoggedInID: userid}
-		h.renderView(res, "admin", view)
+		h.renderView(res, req, "admin", view)
 	}
 }
 
 func (h *RequestHandler) AdminInvitesRoute(res http.ResponseWriter, req *http.Request) {
-	// ed := eout.Describe("admin invites route")
-	loggedIn, _ := h.IsLoggedIn(req)
 	isAdmin, _ := h.IsAdmin(req)
 
 	if !isAdmin {
@@ -468,19 +565,41 @@ func (h *RequestHandler) AdminInvitesRoute(res http.ResponseWriter, req *http.Re
 		return
 	}
 
+	if req.Method == "GET" {
+		h.renderAdminInvites(res, req, "", "")
+		return
+	} else {
+		fmt.Println(INVITES_ROUTE, "received request of type other than GET")
+		IndexRedirect(res, req)
+	}
+}
+
+func (h *RequestHandler) renderAdminInvites(res http.ResponseWriter, req *http.Request, errMessage, message string) {
+	// ed := eout.Describe("admin invites route")
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+
 	batches := h.db.GetAllInvites()
 
 	type Invites struct {
 		ErrorMessage string
+		Message      string
 		CreateRoute  string
 		DeleteRoute  string
+		// empty unless email is turned on
+		EmailRoute   string
 		ForumRootURL string
 		Batches      []database.InviteBatch
 	}
 
 	var data Invites
+	data.ErrorMessage = errMessage
+	data.Message = message
 	data.CreateRoute = INVITES_CREATE_ROUTE
 	data.DeleteRoute = INVITES_DELETE_ROUTE
+	if h.mailer.Enabled() {
+		data.EmailRoute = INVITES_EMAIL_ROUTE
+	}
 	data.Batches = batches
 
 	// reuse the root url to better display registration links on the invites panel
@@ -489,14 +608,7 @@ func (h *RequestHandler) AdminInvitesRoute(res http.ResponseWriter, req *http.Re
 	}
 
 	view := TemplateData{Title: "Invites", Data: &data, HasRSS: h.config.RSS.URL != "", IsAdmin: isAdmin, LoggedIn: loggedIn}
-
-	if req.Method == "GET" {
-		h.renderView(res, "admin-invites", view)
-		return
-	} else {
-		fmt.Println(INVITES_ROUTE, "received request of type other than GET")
-		IndexRedirect(res, req)
-	}
+	h.renderView(res, req, "admin-invites", view)
 }
 
 func (h *RequestHandler) AdminInvitesCreateBatch(res http.ResponseWriter, req *http.Request) {
@@ -512,7 +624,7 @@ func (h *RequestHandler) AdminInvitesCreateBatch(res http.ResponseWriter, req *h
 	var label string
 	label = req.PostFormValue("label")
 	reusable := (req.PostFormValue("reusable") == "true")
-	err = h.db.CreateInvites(adminUserId, amount, label, reusable)
+	_, err = h.db.CreateInvites(adminUserId, amount, label, reusable)
 	if err != nil {
 		fmt.Printf("%v\n", ed.Eout(err, "create invites"))
 		return
@@ -545,12 +657,279 @@ func (h *RequestHandler) AdminInvitesDeleteBatch(res http.ResponseWriter, req *h
 	http.Redirect(res, req, fmt.Sprintf("%s%s", INVITES_ROUTE, "#create-invites"), http.StatusFound)
 }
 
+type AdminTopicsData struct {
+	ErrorMessage string
+	CreateRoute  string
+	UpdateRoute  string
+	DeleteRoute  string
+	Topics       []database.Topic
+}
+
+func (h *RequestHandler) renderAdminTopics(res http.ResponseWriter, req *http.Request, errMessage string) {
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+	data := AdminTopicsData{
+		ErrorMessage: errMessage,
+		CreateRoute:  ADMIN_TOPICS_CREATE_ROUTE,
+		UpdateRoute:  ADMIN_TOPICS_UPDATE_ROUTE,
+		DeleteRoute:  ADMIN_TOPICS_DELETE_ROUTE,
+		Topics:       h.db.GetTopics(),
+	}
+	view := TemplateData{Title: h.translator.Translate("AdminTopics"), Data: &data, HasRSS: h.config.RSS.URL != "", IsAdmin: isAdmin, LoggedIn: loggedIn}
+	h.renderView(res, req, "admin-topics", view)
+}
+
+func (h *RequestHandler) AdminTopicsRoute(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if !isAdmin || req.Method != "GET" {
+		IndexRedirect(res, req)
+		return
+	}
+	h.renderAdminTopics(res, req, "")
+}
+
+func (h *RequestHandler) AdminTopicsCreate(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	name := strings.TrimSpace(req.PostFormValue("name"))
+	description := strings.TrimSpace(req.PostFormValue("description"))
+	if name == "" {
+		h.renderAdminTopics(res, req, "A topic needs a name")
+		return
+	}
+	exists, err := h.db.CheckTopicNameExists(name)
+	if err != nil {
+		h.renderAdminTopics(res, req, "Database had a problem when checking the topic name")
+		return
+	} else if exists {
+		h.renderAdminTopics(res, req, fmt.Sprintf("A topic named %s already exists", name))
+		return
+	}
+	if _, err = h.db.CreateTopic(name, description); err != nil {
+		dump(err)
+		h.renderAdminTopics(res, req, "Database had a problem when creating the topic")
+		return
+	}
+	http.Redirect(res, req, ADMIN_TOPICS_ROUTE, http.StatusFound)
+}
+
+// renames a topic and sets its description
+func (h *RequestHandler) AdminTopicsUpdate(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	topicid, err := strconv.Atoi(req.PostFormValue("topicid"))
+	if err != nil {
+		h.renderAdminTopics(res, req, "Invalid topic id")
+		return
+	}
+	topic, err := h.db.GetTopic(topicid)
+	if err != nil {
+		h.renderAdminTopics(res, req, "The topic to update was not found")
+		return
+	}
+	name := strings.TrimSpace(req.PostFormValue("name"))
+	description := strings.TrimSpace(req.PostFormValue("description"))
+	if name == "" {
+		h.renderAdminTopics(res, req, "A topic needs a name")
+		return
+	}
+	if name != topic.Name {
+		exists, err := h.db.CheckTopicNameExists(name)
+		if err != nil {
+			h.renderAdminTopics(res, req, "Database had a problem when checking the topic name")
+			return
+		} else if exists {
+			h.renderAdminTopics(res, req, fmt.Sprintf("A topic named %s already exists", name))
+			return
+		}
+		if err = h.db.UpdateTopicName(topicid, name); err != nil {
+			dump(err)
+			h.renderAdminTopics(res, req, "Database had a problem when renaming the topic")
+			return
+		}
+	}
+	if description != topic.Description {
+		if err = h.db.UpdateTopicDescription(topicid, description); err != nil {
+			dump(err)
+			h.renderAdminTopics(res, req, "Database had a problem when changing the topic description")
+			return
+		}
+	}
+	http.Redirect(res, req, ADMIN_TOPICS_ROUTE, http.StatusFound)
+}
+
+// deletes a topic, moving its threads into another topic chosen by the admin
+func (h *RequestHandler) AdminTopicsDelete(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	topicid, err := strconv.Atoi(req.PostFormValue("topicid"))
+	if err != nil {
+		h.renderAdminTopics(res, req, "Invalid topic id")
+		return
+	}
+	moveToTopicid, err := strconv.Atoi(req.PostFormValue("move-to"))
+	if err != nil {
+		h.renderAdminTopics(res, req, "Choose a topic to move the deleted topic's threads to")
+		return
+	}
+	if err = h.db.DeleteTopic(topicid, moveToTopicid); err != nil {
+		dump(err)
+		h.renderAdminTopics(res, req, "Database had a problem when deleting the topic")
+		return
+	}
+	http.Redirect(res, req, ADMIN_TOPICS_ROUTE, http.StatusFound)
+}
+
+// deletes a thread and all of its posts. reached from the thread view
+func (h *RequestHandler) AdminThreadDelete(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("server: admin delete thread")
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	title := h.translator.Translate("AdminDeleteThread")
+	threadid, err := strconv.Atoi(req.PostFormValue("threadid"))
+	if err != nil {
+		h.displayErr(res, req, ed.Eout(err, "parse thread id"), title)
+		return
+	}
+	thread, err := h.db.GetThread(threadid)
+	if err != nil || len(thread) == 0 {
+		h.displayErr(res, req, ed.Eout(err, "get thread %d", threadid), title)
+		return
+	}
+	// federating the deletion of the thread's posts needs to know where they were
+	var federated []database.FederatedPost
+	for _, post := range thread {
+		if p, ok := h.federatedPost(post.ID); ok {
+			federated = append(federated, p)
+		}
+	}
+	if err = h.db.DeleteThread(threadid); err != nil {
+		h.displayErr(res, req, err, title)
+		return
+	}
+	for _, post := range federated {
+		h.federate(activitypub.DELETE, post)
+	}
+	// the thread is gone, so the log entry keeps its title around
+	modlogErr := h.db.AddModerationLogWithSubject(adminUserId, thread[0].AuthorID, constants.MODLOG_DELETE_THREAD, threadid, thread[0].ThreadTitle)
+	if modlogErr != nil {
+		fmt.Println(ed.Eout(modlogErr, "error adding moderation log"))
+	}
+	// update the rss feed, in case the deleted thread was present in feed
+	h.rssFeed = GenerateRSS(h.db, h.config)
+	h.displaySuccess(res, req, title, h.translator.Translate("AdminDeleteThreadSuccess"), "/")
+}
+
+// moves a thread to another topic. reached from the thread view
+func (h *RequestHandler) AdminThreadMove(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("server: admin move thread")
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	title := h.translator.Translate("AdminMoveThread")
+	threadid, err := strconv.Atoi(req.PostFormValue("threadid"))
+	if err != nil {
+		h.displayErr(res, req, ed.Eout(err, "parse thread id"), title)
+		return
+	}
+	topicid, err := strconv.Atoi(req.PostFormValue("topicid"))
+	if err != nil {
+		h.displayErr(res, req, ed.Eout(err, "parse topic id"), title)
+		return
+	}
+	topic, err := h.db.GetTopic(topicid)
+	if err != nil {
+		h.displayErr(res, req, err, title)
+		return
+	}
+	if err = h.db.MoveThread(threadid, topicid); err != nil {
+		h.displayErr(res, req, err, title)
+		return
+	}
+	modlogErr := h.db.AddModerationLogWithSubject(adminUserId, -1, constants.MODLOG_MOVE_THREAD, threadid, topic.Name)
+	if modlogErr != nil {
+		fmt.Println(ed.Eout(modlogErr, "error adding moderation log"))
+	}
+	http.Redirect(res, req, fmt.Sprintf("/thread/%d/", threadid), http.StatusFound)
+}
+
+// hides a post behind a "removed by a moderator" placeholder, with an optional reason. reached from the thread view
+func (h *RequestHandler) AdminPostHide(res http.ResponseWriter, req *http.Request) {
+	h.adminSetPostHidden(res, req, true)
+}
+
+func (h *RequestHandler) AdminPostUnhide(res http.ResponseWriter, req *http.Request) {
+	h.adminSetPostHidden(res, req, false)
+}
+
+func (h *RequestHandler) adminSetPostHidden(res http.ResponseWriter, req *http.Request, hide bool) {
+	ed := eout.Describe("server: admin hide post")
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	title := h.translator.Translate("AdminHidePost")
+	action := constants.MODLOG_HIDE_POST
+	if !hide {
+		title = h.translator.Translate("AdminUnhidePost")
+		action = constants.MODLOG_UNHIDE_POST
+	}
+	postid, err := strconv.Atoi(req.PostFormValue("postid"))
+	if err != nil {
+		h.displayErr(res, req, ed.Eout(err, "parse post id"), title)
+		return
+	}
+	post, err := h.db.GetPost(postid)
+	if err != nil {
+		h.displayErr(res, req, err, title)
+		return
+	}
+	reason := strings.TrimSpace(req.PostFormValue("reason"))
+	if hide {
+		err = h.db.HidePost(postid, reason)
+	} else {
+		err = h.db.UnhidePost(postid)
+	}
+	if err != nil {
+		h.displayErr(res, req, err, title)
+		return
+	}
+	modlogErr := h.db.AddModerationLogWithSubject(adminUserId, post.AuthorID, action, postid, reason)
+	if modlogErr != nil {
+		fmt.Println(ed.Eout(modlogErr, "error adding moderation log"))
+	}
+	// followers see a hidden post as deleted, and an unhidden one as new
+	if hide {
+		h.federatePost(activitypub.DELETE, postid)
+	} else {
+		h.federatePost(activitypub.CREATE, postid)
+	}
+	// update the rss feed, in case the post was present in feed
+	h.rssFeed = GenerateRSS(h.db, h.config)
+	http.Redirect(res, req, postURL(post.ThreadID, postid), http.StatusFound)
+}
+
 // view of /admin for non-admin users (contains less information)
 func (h *RequestHandler) ListAdmins(res http.ResponseWriter, req *http.Request) {
 	loggedIn, _ := h.IsLoggedIn(req)
 	admins := h.db.GetAdmins()
 	data := AdminData{Admins: admins}
 	view := TemplateData{Title: h.translator.Translate("AdminForumAdministration"), Data: &data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn}
-	h.renderView(res, "admins-list", view)
+	h.renderView(res, req, "admins-list", view)
 	return
 }
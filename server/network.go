@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gomod.cblgh.org/cerca/types"
+	"gomod.cblgh.org/cerca/util"
+)
+
+// the headers a reverse proxy can use to pass on the address of the client it is forwarding a request for
+const (
+	HEADER_X_REAL_IP       = "X-Real-Ip"
+	HEADER_X_FORWARDED_FOR = "X-Forwarded-For"
+	HEADER_FORWARDED       = "Forwarded"
+)
+
+// clientIPResolver works out which ip address a request comes from. requests from trusted reverse proxies carry the
+// client's address in a header; for everyone else, the header can't be trusted and the connecting address is used
+type clientIPResolver struct {
+	trusted []*net.IPNet
+	header  string
+	// warns once about a proxy that doesn't set the header, which makes all of its clients look like one
+	missingHeader sync.Once
+}
+
+func newClientIPResolver(config types.Config) (*clientIPResolver, error) {
+	r := &clientIPResolver{header: http.CanonicalHeaderKey(config.Network.ClientIPHeader)}
+	switch r.header {
+	case HEADER_X_REAL_IP, HEADER_X_FORWARDED_FOR, HEADER_FORWARDED:
+	default:
+		return nil, fmt.Errorf("client_ip_header must be one of %s, %s or %s; was %q", HEADER_X_REAL_IP, HEADER_X_FORWARDED_FOR, HEADER_FORWARDED, config.Network.ClientIPHeader)
+	}
+	for _, proxy := range config.Network.TrustedProxies {
+		// a single address is a network of one
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %w", err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// reports whether addr belongs to a trusted proxy. connections over a unix socket have no ip address; they can only
+// come from the same machine and are trusted
+func (r *clientIPResolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr == "" || addr == "@" || strings.HasPrefix(addr, "/")
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the addresses a request has been forwarded for, from the client to the last proxy
+func (r *clientIPResolver) forwardedFor(req *http.Request) []string {
+	var addrs []string
+	switch r.header {
+	case HEADER_X_REAL_IP:
+		if value := strings.TrimSpace(req.Header.Get(r.header)); value != "" {
+			addrs = append(addrs, value)
+		}
+	case HEADER_X_FORWARDED_FOR:
+		// proxies append to the list, and may send the header more than once
+		for _, value := range req.Header.Values(r.header) {
+			for _, addr := range strings.Split(value, ",") {
+				addrs = append(addrs, strings.TrimSpace(addr))
+			}
+		}
+	case HEADER_FORWARDED:
+		// rfc 7239, e.g. `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`
+		for _, value := range req.Header.Values(r.header) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						addrs = append(addrs, stripPort(strings.Trim(val, `"`)))
+					}
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// removes the port and the brackets around ipv6 addresses, as in "[2001:db8::1]:4711" and "192.0.2.60:80"
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// returns the client's ip address: the connecting address, unless that is a trusted proxy. then the forwarded
+// addresses are walked from the nearest proxy towards the client, and the first one that isn't a trusted proxy is the
+// client
+func (r *clientIPResolver) resolve(req *http.Request) string {
+	ip := util.PeerIP(req)
+	if !r.isTrusted(ip) {
+		return ip
+	}
+	addrs := r.forwardedFor(req)
+	if len(addrs) == 0 {
+		if !developing {
+			r.missingHeader.Do(func() {
+				log.Printf("request from trusted proxy %q without a %s header: all requests forwarded by it share one ip address, e.g. for rate limiting. see the [network] section of the config\n", ip, r.header)
+			})
+		}
+		return ip
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if net.ParseIP(addrs[i]) == nil {
+			// garbage in the header; don't trust anything further along
+			return ip
+		}
+		ip = addrs[i]
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// resolves the client ip of every request, making it available through util.ClientIP
+func (r *clientIPResolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(res, util.WithClientIP(req, r.resolve(req)))
+	})
+}
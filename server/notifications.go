@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gomod.cblgh.org/cerca/database"
+)
+
+const NOTIFICATIONS_PER_PAGE = 50
+
+type NotificationsData struct {
+	Notifications []database.Notification
+	Unread        int
+	ReadRoute     string
+	Pagination    Pagination
+}
+
+// the inbox of the logged in user, listing notifications about new posts in the threads they are subscribed to
+func (h RequestHandler) NotificationsRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if !loggedIn {
+		IndexRedirect(res, req)
+		return
+	}
+	isAdmin, _ := h.IsAdmin(req)
+	total, unread, err := h.db.CountNotifications(userid)
+	if err != nil {
+		dump(err)
+	}
+	pages := pageCount(total, NOTIFICATIONS_PER_PAGE)
+	page := getRequestedPage(req)
+	if page > pages {
+		page = pages
+	}
+	notifications, err := h.db.GetNotifications(userid, NOTIFICATIONS_PER_PAGE, (page-1)*NOTIFICATIONS_PER_PAGE)
+	if err != nil {
+		dump(err)
+	}
+	data := NotificationsData{Notifications: notifications, Unread: unread, ReadRoute: NOTIFICATIONS_READ_ROUTE}
+	data.Pagination = newPagination(page, pages, func(p int) string {
+		if p == 1 {
+			return NOTIFICATIONS_ROUTE
+		}
+		return fmt.Sprintf("%s?page=%d", NOTIFICATIONS_ROUTE, p)
+	})
+	view := TemplateData{Data: data, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Notifications")}
+	h.renderView(res, req, "notifications", view)
+}
+
+// marks the selected notifications as read, or all of them if the form says so
+func (h RequestHandler) NotificationsReadRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn {
+		IndexRedirect(res, req)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		dump(err)
+	}
+	var ids []int
+	if req.PostFormValue("all") != "true" {
+		for _, value := range req.PostForm["id"] {
+			if id, err := strconv.Atoi(value); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		// nothing was selected
+		if len(ids) == 0 {
+			http.Redirect(res, req, NOTIFICATIONS_ROUTE, http.StatusSeeOther)
+			return
+		}
+	}
+	if err := h.db.MarkNotificationsRead(userid, ids); err != nil {
+		dump(err)
+	}
+	http.Redirect(res, req, NOTIFICATIONS_ROUTE, http.StatusSeeOther)
+}
+
+// subscribes the logged in user to a thread, or unsubscribes them if the form's action is "unsubscribe"
+func (h RequestHandler) ThreadSubscribeRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn {
+		IndexRedirect(res, req)
+		return
+	}
+	threadid, err := strconv.Atoi(req.PostFormValue("threadid"))
+	if err != nil {
+		IndexRedirect(res, req)
+		return
+	}
+	// private threads are visible to every logged in user, so anyone logged in may subscribe to any thread
+	if exists, err := h.db.CheckThreadExists(threadid); err != nil || !exists {
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:   h.translator.Translate("ErrThread404"),
+			Message: h.translator.Translate("ErrThread404Message"),
+		})
+		return
+	}
+	if req.PostFormValue("action") == "unsubscribe" {
+		err = h.db.Unsubscribe(userid, threadid)
+	} else {
+		err = h.db.Subscribe(userid, threadid)
+	}
+	if err != nil {
+		dump(err)
+	}
+	http.Redirect(res, req, fmt.Sprintf("/thread/%d/", threadid), http.StatusSeeOther)
+}
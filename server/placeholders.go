@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/crypto"
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// the posts of threads imported with `cerca import-from` are written by placeholder accounts, which no one can log in to.
+// at ADMIN_PLACEHOLDERS_ROUTE, admins create claim links for them, and hand each link to the author the placeholder
+// stands in for, who opens it at CLAIM_ROUTE to choose a name and password for the account
+const CLAIM_LINK_VALIDITY = 7 * 24 * time.Hour
+
+type AdminPlaceholdersData struct {
+	ErrorMessage string
+	ClaimRoute   string
+	Placeholders []database.Placeholder
+	// the claim link that was just created, which is only ever shown this once, and the name of its placeholder
+	ClaimLink     string
+	ClaimLinkName string
+	ValidDays     int
+}
+
+func (h *RequestHandler) renderAdminPlaceholders(res http.ResponseWriter, req *http.Request, data AdminPlaceholdersData) {
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+	placeholders, err := h.db.GetPlaceholders()
+	if err != nil {
+		dump(err)
+		data.ErrorMessage = "Database had a problem when getting the placeholders"
+	}
+	data.Placeholders = placeholders
+	data.ClaimRoute = ADMIN_PLACEHOLDERS_CLAIM_ROUTE
+	data.ValidDays = int(CLAIM_LINK_VALIDITY / (24 * time.Hour))
+	view := TemplateData{Title: h.translator.Translate("AdminPlaceholders"), Data: &data, HasRSS: h.config.RSS.URL != "", IsAdmin: isAdmin, LoggedIn: loggedIn}
+	h.renderView(res, req, "admin-placeholders", view)
+}
+
+func (h *RequestHandler) AdminPlaceholdersRoute(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if !isAdmin || req.Method != "GET" {
+		IndexRedirect(res, req)
+		return
+	}
+	h.renderAdminPlaceholders(res, req, AdminPlaceholdersData{})
+}
+
+// creates a claim link for a placeholder, replacing the link it had, if any
+func (h *RequestHandler) AdminPlaceholdersClaimLink(res http.ResponseWriter, req *http.Request) {
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	userid, err := strconv.Atoi(req.PostFormValue("userid"))
+	if err != nil {
+		h.renderAdminPlaceholders(res, req, AdminPlaceholdersData{ErrorMessage: "Invalid user id"})
+		return
+	}
+	token, err := h.db.CreateClaimToken(userid, CLAIM_LINK_VALIDITY)
+	if err != nil {
+		dump(err)
+		h.renderAdminPlaceholders(res, req, AdminPlaceholdersData{ErrorMessage: "The placeholder was not found, or has been claimed already"})
+		return
+	}
+	if err = h.db.AddModerationLog(adminUserId, userid, constants.MODLOG_CREATE_CLAIM_LINK); err != nil {
+		dump(err)
+	}
+	link := fmt.Sprintf("%s?token=%s", CLAIM_ROUTE, token)
+	if h.config.RSS.URL != "" {
+		link = joinPath(h.config.RSS.URL, link)
+	}
+	name, _ := h.db.GetUsername(userid)
+	h.renderAdminPlaceholders(res, req, AdminPlaceholdersData{ClaimLink: link, ClaimLinkName: name})
+}
+
+type ClaimData struct {
+	ClaimRoute   string
+	Token        string
+	Username     string
+	ErrorMessage string
+}
+
+// lets the author of imported posts take over the placeholder that wrote them, with a link an admin gave them
+func (h *RequestHandler) ClaimRoute(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("claim placeholder")
+	title := h.translator.Translate("ClaimAccount")
+	render := func(data ClaimData) {
+		data.ClaimRoute = CLAIM_ROUTE
+		h.renderView(res, req, "claim", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", Title: title})
+	}
+	renderInvalid := func() {
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:   title,
+			Message: h.translator.Translate("ClaimInvalid"),
+		})
+	}
+
+	switch req.Method {
+	case "GET":
+		token := req.URL.Query().Get("token")
+		placeholder, err := h.db.GetPlaceholderByClaimToken(token)
+		if err != nil {
+			if err != database.ErrClaimTokenInvalid {
+				dump(err)
+			}
+			renderInvalid()
+			return
+		}
+		render(ClaimData{Token: token, Username: placeholder.Name})
+	case "POST":
+		token := req.PostFormValue("token")
+		placeholder, err := h.db.GetPlaceholderByClaimToken(token)
+		if err != nil {
+			if err != database.ErrClaimTokenInvalid {
+				dump(err)
+			}
+			renderInvalid()
+			return
+		}
+		username := strings.TrimSpace(req.PostFormValue("username"))
+		renderErr := func(message string) {
+			render(ClaimData{Token: token, Username: username, ErrorMessage: message})
+		}
+		if username == "" {
+			username = placeholder.Name
+		}
+		// the placeholder's own name is taken by the placeholder itself
+		if username != placeholder.Name {
+			exists, err := h.db.CheckUsernameExists(username)
+			if err != nil {
+				dump(ed.Eout(err, "check username"))
+				renderErr("Database had a problem when checking username")
+				return
+			} else if exists {
+				renderErr(h.translator.Translate("ClaimUsernameTaken"))
+				return
+			}
+		}
+		password := req.PostFormValue("password")
+		if len(password) < 9 {
+			renderErr(h.translator.Translate("PasswordResetTooShort"))
+			return
+		}
+		if password != req.PostFormValue("password-copy") {
+			renderErr(h.translator.Translate("PasswordResetMismatch"))
+			return
+		}
+		passwordHash, err := crypto.HashPassword(password)
+		if err != nil {
+			dump(ed.Eout(err, "hash password"))
+			renderErr(h.translator.Translate("PasswordResetNotSaved"))
+			return
+		}
+		userid, err := h.db.ClaimPlaceholder(token, username, passwordHash)
+		if err != nil {
+			if err != database.ErrClaimTokenInvalid {
+				dump(err)
+			}
+			renderInvalid()
+			return
+		}
+		// log the author in to their account
+		h.session.Save(req, res, userid)
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:       h.translator.Translate("ClaimSuccess"),
+			Message:     h.translator.Translate("ClaimSuccessMessage"),
+			LinkMessage: h.translator.Translate("ClaimSuccessLinkMessage"),
+			Link:        profileURL(username),
+			LinkText:    h.translator.Translate("ClaimSuccessLinkText"),
+		})
+	default:
+		IndexRedirect(res, req)
+	}
+}
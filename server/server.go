@@ -11,18 +11,21 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 	"io"
 
+	"gomod.cblgh.org/cerca/activitypub"
+	"gomod.cblgh.org/cerca/constants"
 	"gomod.cblgh.org/cerca/crypto"
 	"gomod.cblgh.org/cerca/database"
 	"gomod.cblgh.org/cerca/defaults"
 	cercaHTML "gomod.cblgh.org/cerca/html"
 	"gomod.cblgh.org/cerca/i18n"
 	"gomod.cblgh.org/cerca/limiter"
+	"gomod.cblgh.org/cerca/mail"
 	"gomod.cblgh.org/cerca/server/session"
 	"gomod.cblgh.org/cerca/types"
 	"gomod.cblgh.org/cerca/util"
@@ -34,8 +37,6 @@ import (
 	"github.com/cblgh/plain/rss"
 )
 
-/* TODO (2022-01-03): include csrf token via gorilla, or w/e, when rendering */
-
 type TemplateData struct {
 	Data        interface{}
 	SortByPosts bool
@@ -47,22 +48,69 @@ type TemplateData struct {
 	LoggedInID  int
 	ForumName   string
 	Title       string
+	// state-changing forms need to include the token, see server/csrf.go
+	CSRFToken string
+	// shown in the header of logged in users, see renderView
+	UnreadNotifications int
+	// the feed of the page's posts, if it has one; see server/feeds.go
+	FeedURL string
 }
 
+// the password reset view either asks for a username to email a reset link for, or, when opened from such a link,
+// for a new password
 type PasswordResetData struct {
-	Action   string
-	Username string
-	Payload  string
+	RequestRoute string
+	ConfirmRoute string
+	// the token from the reset link; empty when asking for a username
+	Token        string
+	Message      string
+	ErrorMessage string
 }
 
 type ChangePasswordData struct {
 	Action string
 }
 
+// used by paginated views; the links to the previous and next page are empty if there is no such page
+type Pagination struct {
+	Page      int
+	PageCount int
+	PrevURL   string
+	NextURL   string
+}
+
 type IndexData struct {
-	Threads              []database.Thread
-	Categories           []string
-	VisibleCategoriesMap map[string]bool
+	Threads          []database.Thread
+	Topics           []database.Topic
+	VisibleTopicsMap map[int]bool
+	Pagination       Pagination
+	MarkAllReadRoute string
+}
+
+type TopicData struct {
+	Topic            database.Topic
+	Threads          []database.Thread
+	Pagination       Pagination
+	MarkAllReadRoute string
+}
+
+type NewThreadData struct {
+	NewTitle string
+	TopicID  int
+	Topics   []database.Topic
+}
+
+type SearchData struct {
+	Enabled      bool
+	Query        string
+	Author       string
+	From         string
+	To           string
+	ErrorMessage string
+	Results      []database.SearchResult
+	// links to the previous and next page of results; empty if there is no such page
+	PrevPage string
+	NextPage string
 }
 
 type GenericMessageData struct {
@@ -85,18 +133,86 @@ type AccountData struct {
 	ChangePasswordRoute string
 	ChangeUsernameRoute string
 	DeleteAccountRoute  string
+	ChangeBioRoute      string
 	LoggedInUsername    string
+	Bio                 string
+	ProfileURL          string
+	IsAdmin             bool
+	CreateTokenRoute    string
+	RevokeTokenRoute    string
+	TokenScopes         []string
+	Tokens              []database.APIToken
+	// a newly created api token, only shown once
+	NewToken      string
+	SessionsRoute string
+	// two-factor authentication
+	TwoFactorEnabled       bool
+	TwoFactorRequired      bool
+	TwoFactorSecret        string // set while enrolment awaits confirmation
+	TwoFactorURI           string
+	RecoveryCodesLeft      int
+	NewRecoveryCodes       []string // only shown once
+	TwoFactorSetupRoute    string
+	TwoFactorEnableRoute   string
+	TwoFactorDisableRoute  string
+	TwoFactorRecoveryRoute string
+	// email is only offered if the forum can send it
+	EmailEnabled            bool
+	Email                   database.UserEmail
+	ChangeEmailRoute        string
+	EmailNotificationsRoute string
+}
+
+type SessionsData struct {
+	Sessions    []database.UserSession
+	RevokeRoute string
+	Message     string
 }
 
 type LoginData struct {
 	FailedAttempt bool
+	// set when too many failed attempts have been made
+	LockedOut string
+}
+
+type TwoFactorLoginData struct {
+	Action        string
+	FailedAttempt bool
+	// too many incorrect codes were entered
+	Locked bool
+	// set when too many failed attempts have been made from the client's ip address
+	LockedOut string
 }
 
 type ThreadData struct {
 	Title     string
 	Posts     []database.Post
 	ThreadURL string
+	ThreadID  int
 	Private   bool
+	Topic     database.Topic
+	// whether the logged in user is notified of new posts in the thread
+	Subscribed     bool
+	SubscribeRoute string
+	// Posts only contains the posts of the current page
+	Pagination Pagination
+	// only set for admins, who may move the thread to another topic
+	Topics           []database.Topic
+	AdminDeleteRoute string
+	AdminMoveRoute   string
+	AdminHideRoute   string
+	AdminUnhideRoute string
+}
+
+type ProfileData struct {
+	Profile database.UserProfile
+	// the page of the account that the posts of deleted users are attributed to
+	Deleted     bool
+	ThreadCount int
+	PostCount   int
+	// Posts only contains the posts of the current page
+	Posts      []database.ProfilePost
+	Pagination Pagination
 }
 
 type EditPostData struct {
@@ -112,6 +228,17 @@ type RequestHandler struct {
 	translator i18n.Translator
 	templates  *template.Template
 	rssFeed    string
+	guard      *loginGuard
+	// nil if email is turned off
+	mailer *mail.Mailer
+	// wakes up mailNotifications, see emailNotifications
+	mailWake chan struct{}
+	// limits how many password reset links each ip address can request
+	resets *limiter.TimedRateLimiter
+	// nil if activitypub is turned off
+	fed *federation
+	// wakes up deliverWebhooks, see queueWebhookEvent
+	webhookWake chan struct{}
 }
 
 var developing bool
@@ -122,6 +249,58 @@ func dump(err error) {
 	}
 }
 
+// returns the page requested with the url parameter page. pages are numbered from 1
+func getRequestedPage(req *http.Request) int {
+	page, err := strconv.Atoi(req.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// the number of pages needed to show itemCount items, pageSize items per page. there is always at least one page
+func pageCount(itemCount, pageSize int) int {
+	if itemCount <= 0 {
+		return 1
+	}
+	return (itemCount + pageSize - 1) / pageSize
+}
+
+// pageURL returns the url of the given page
+func newPagination(page, count int, pageURL func(page int) string) Pagination {
+	pagination := Pagination{Page: page, PageCount: count}
+	if page > 1 {
+		pagination.PrevURL = pageURL(page - 1)
+	}
+	if page < count {
+		pagination.NextURL = pageURL(page + 1)
+	}
+	return pagination
+}
+
+// returns the link to a thread's page, optionally pointing to one of its posts. the first page is linked to without
+// a page parameter, keeping links to it the same as before threads were paginated
+func threadPageURL(threadPath string, page, postid int) string {
+	link := threadPath
+	if page > 1 {
+		link += fmt.Sprintf("?page=%d", page)
+	}
+	if postid > 0 {
+		link += fmt.Sprintf("#%d", postid)
+	}
+	return link
+}
+
+// links to a post when its page is not known; ThreadRoute finds the page containing the post
+func postURL(threadid, postid int) string {
+	return fmt.Sprintf("/thread/%d/?post=%d#%d", threadid, postid, postid)
+}
+
+// returns the link to a user's profile page. usernames may contain any character, including slashes
+func profileURL(username string) string {
+	return USER_ROUTE + url.PathEscape(username)
+}
+
 type RateLimitingWare struct {
 	limiter *limiter.TimedRateLimiter
 }
@@ -137,19 +316,9 @@ func NewRateLimitingWare(routes []string) *RateLimitingWare {
 
 func (ware *RateLimitingWare) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		portIndex := strings.LastIndex(req.RemoteAddr, ":")
-		ip := req.RemoteAddr[:portIndex]
-		// specific fix in case of using a reverse proxy setup
-		if address, exists := req.Header["X-Real-Ip"]; ip == "127.0.0.1" && exists {
-			ip = address[0]
-		}
-		// rate limiting likely not working as intended on server;
-		// set a x-real-ip header: https://docs.nginx.com/nginx/admin-guide/web-server/reverse-proxy/
-		if !developing && ip == "127.0.0.1" {
-			next.ServeHTTP(res, req)
-			return
-		}
-		err := ware.limiter.BlockUntilAllowed(ip, req.URL.String(), req.Context())
+		// behind a reverse proxy, the client ip is only known if the proxy is configured in the [network] section
+		ip := util.ClientIP(req)
+		err := ware.limiter.BlockUntilAllowed(ip, req.URL.Path, req.Context())
 		if err != nil {
 			err = eout.Eout(err, "RateLimitingWare")
 			dump(err)
@@ -182,6 +351,44 @@ func (h RequestHandler) IsLoggedIn(req *http.Request) (bool, int) {
 	return true, userid
 }
 
+// returns the api token sent as "Authorization: Bearer <token>", if the request has one
+func bearerToken(req *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := req.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// the api's counterpart to IsLoggedIn and IsAdmin: returns true, and the token, if the request carries a valid api
+// token with the given scope. admin scopes are only granted while the token's owner is an admin
+func (h RequestHandler) IsTokenAuthorized(req *http.Request, scope string) (bool, database.APIToken) {
+	ed := eout.Describe("IsTokenAuthorized")
+	var token database.APIToken
+	bearer, ok := bearerToken(req)
+	if !ok {
+		return false, token
+	}
+	token, err := h.db.UseAPIToken(bearer)
+	if err != nil {
+		if !errors.Is(err, database.ErrTokenInvalid) {
+			dump(ed.Eout(err, "use token"))
+		}
+		return false, token
+	}
+	if !token.HasScope(scope) {
+		return false, token
+	}
+	if strings.HasPrefix(scope, "admin") {
+		isAdmin, err := h.db.IsUserAdmin(token.UserID)
+		if err != nil || !isAdmin || h.adminNeedsTwoFactor(token.UserID) {
+			return false, token
+		}
+	}
+	return true, token
+}
+
 // establish closure over config + translator so that it's present in templates during render
 func generateTemplates(config types.Config, files map[string][]byte, translator i18n.Translator) (*template.Template, error) {
 	templateFuncs := template.FuncMap{
@@ -231,10 +438,20 @@ func generateTemplates(config types.Config, files map[string][]byte, translator
 				Name: config.General.Name,
 				Link: config.General.ConductLink,
 			}
-			return translator.TranslateWithData(key, i18n.TranslationData{data})
+			return translator.TranslateWithData(key, i18n.TranslationData{Data: data})
+		},
+		// escapes a search snippet and highlights the matched terms
+		"highlight": func(snippet string) template.HTML {
+			escaped := template.HTMLEscapeString(snippet)
+			escaped = strings.ReplaceAll(escaped, database.SearchHighlightStart, "<mark>")
+			escaped = strings.ReplaceAll(escaped, database.SearchHighlightEnd, "</mark>")
+			return template.HTML(escaped)
 		},
-		"capitalize": util.Capitalize,
-		"markup":     util.Markup,
+		"capitalize":     util.Capitalize,
+		"markup":         util.Markup,
+		"markupMentions": util.MarkupMentions,
+		"profileURL":     profileURL,
+		"postURL":        postURL,
 		"tohtml": func(s string) template.HTML {
 			// use of this function is risky cause it interprets the passed in string and renders it as unescaped html.
 			// can allow for attacks!
@@ -255,16 +472,28 @@ func generateTemplates(config types.Config, files map[string][]byte, translator
 		"index",
 		"login",
 		"login-component",
+		"login-two-factor",
 		"new-thread",
+		"pagination",
+		"csrf",
 		"register",
 		"register-success",
+		"search",
+		"sessions",
 		"thread",
+		"topic",
 		"admin",
 		"admins-list",
 		"admin-add-user",
 		"admin-invites",
+		"admin-topics",
+		"admin-webhooks",
+		"admin-placeholders",
+		"claim",
 		"moderation-log",
+		"notifications",
 		"password-reset",
+		"profile",
 		"change-password",
 		"change-password-success",
 	}
@@ -282,7 +511,28 @@ func generateTemplates(config types.Config, files map[string][]byte, translator
 	return rootTemplate, nil
 }
 
-func (h RequestHandler) renderView(res http.ResponseWriter, viewName string, data TemplateData) {
+// renders one of the forum's documents, such as its rules, linking the mentions of users
+func (h *RequestHandler) markupDocument(name string) template.HTML {
+	md := string(h.files[name])
+	mentions, err := h.db.ResolveMentions(md, nil)
+	if err != nil {
+		dump(err)
+	}
+	return util.MarkupMentions(md, mentions)
+}
+
+func (h RequestHandler) renderView(res http.ResponseWriter, req *http.Request, viewName string, data TemplateData) {
+	// forms include the token issued by the csrf middleware, see server/csrf.go
+	data.CSRFToken = getCSRFToken(req)
+	if data.LoggedIn {
+		if _, userid := h.IsLoggedIn(req); userid >= 0 {
+			unread, err := h.db.CountUnreadNotifications(userid)
+			if err != nil {
+				dump(err)
+			}
+			data.UnreadNotifications = unread
+		}
+	}
 	if data.Title == "" {
 		data.Title = strings.ReplaceAll(viewName, "-", " ")
 	}
@@ -316,11 +566,15 @@ func (h RequestHandler) renderGenericMessage(res http.ResponseWriter, req *http.
 		LoggedIn: loggedIn,
 		IsAdmin:  isAdmin,
 	}
-	h.renderView(res, "generic-message", data)
+	h.renderView(res, req, "generic-message", data)
 	return
 }
 
 func (h *RequestHandler) ThreadRoute(res http.ResponseWriter, req *http.Request) {
+	if _, ok := feedPath(req, "/thread/"); ok {
+		h.ThreadFeedRoute(res, req)
+		return
+	}
 	threadid, ok := util.GetURLPortion(req, 2)
 	loggedIn, userid := h.IsLoggedIn(req)
 	isAdmin, _ := h.IsAdmin(req)
@@ -340,7 +594,10 @@ func (h *RequestHandler) ThreadRoute(res http.ResponseWriter, req *http.Request)
 		// TODO (2022-01-09): make sure rendered content won't be empty after sanitizing:
 		// * run sanitize step && strings.TrimSpace and check length **before** doing AddPost
 		// TODO(2022-01-09): send errors back to thread's posting view
-		_ = h.db.AddPost(content, threadid, userid)
+		postid := h.db.AddPost(content, threadid, userid)
+		h.emailNotifications()
+		h.federatePost(activitypub.CREATE, postid)
+		h.postWebhooks(WEBHOOK_POST_ADDED, postid)
 		// we want to effectively redirect to <#posts+1> to mark the thread as read in the thread index
 		// TODO(2022-01-30): find a solution for either:
 		// * scrolling to thread bottom (and maintaining the same slug, important for visited state in browser)
@@ -355,6 +612,11 @@ func (h *RequestHandler) ThreadRoute(res http.ResponseWriter, req *http.Request)
 		newSlug := util.GetThreadSlug(threadid, posts[0].ThreadTitle, len(posts))
 		// update the rss feed
 		h.rssFeed = GenerateRSS(h.db, h.config)
+		// the new post is the last one of the thread, so it is on its last page
+		lastPage := pageCount(len(posts), h.config.Pagination.PostsPerPage)
+		if lastPage > 1 {
+			newSlug = threadPageURL(newSlug, lastPage, postid)
+		}
 		http.Redirect(res, req, newSlug, http.StatusFound)
 		return
 	}
@@ -366,20 +628,73 @@ func (h *RequestHandler) ThreadRoute(res http.ResponseWriter, req *http.Request)
 		h.renderGenericMessage(res, req, threadMissingData)
 		return
 	}
-	thread, err := h.db.GetThread(threadid)
+	postCount, err := h.db.CountThreadPosts(threadid)
+	if err != nil {
+		h.renderGenericMessage(res, req, threadMissingData)
+		return
+	}
+	pageSize := h.config.Pagination.PostsPerPage
+	pages := pageCount(postCount, pageSize)
+	page := getRequestedPage(req)
+	// links to a post (see postURL) are resolved to the page the post is on
+	if postid, err := strconv.Atoi(req.URL.Query().Get("post")); err == nil && req.URL.Query().Get("page") == "" {
+		if postPage, err := h.db.GetPostPage(threadid, postid, pageSize); err == nil {
+			page = postPage
+		}
+	}
+	if page > pages {
+		page = pages
+	}
+	thread, err := h.db.GetThreadPage(threadid, page, pageSize)
 
 	if err != nil {
 		h.renderGenericMessage(res, req, threadMissingData)
 		return
 	}
+	// the reader has now seen the thread up to the last post of this page
+	if loggedIn && len(thread) > 0 {
+		lastSeen := 0
+		for _, post := range thread {
+			if post.ID > lastSeen {
+				lastSeen = post.ID
+			}
+		}
+		if err = h.db.MarkThreadRead(userid, threadid, lastSeen); err != nil {
+			dump(err)
+		}
+		if err = h.db.MarkThreadNotificationsRead(userid, threadid, lastSeen); err != nil {
+			dump(err)
+		}
+	}
 
-	data := ThreadData{Posts: thread, ThreadURL: req.URL.Path, Private: isPrivate}
+	data := ThreadData{Posts: thread, ThreadURL: req.URL.Path, ThreadID: threadid, Private: isPrivate}
+	data.Pagination = newPagination(page, pages, func(p int) string {
+		return threadPageURL(req.URL.Path, p, 0)
+	})
+	// a missing topic only means we can't show which topic the thread belongs to
+	data.Topic, _ = h.db.GetThreadTopic(threadid)
+	if loggedIn {
+		data.SubscribeRoute = THREAD_SUBSCRIBE_ROUTE
+		if data.Subscribed, err = h.db.IsSubscribed(userid, threadid); err != nil {
+			dump(err)
+		}
+	}
+	if isAdmin {
+		data.Topics = h.db.GetTopics()
+		data.AdminDeleteRoute = ADMIN_THREAD_DELETE_ROUTE
+		data.AdminMoveRoute = ADMIN_THREAD_MOVE_ROUTE
+		data.AdminHideRoute = ADMIN_POST_HIDE_ROUTE
+		data.AdminUnhideRoute = ADMIN_POST_UNHIDE_ROUTE
+	}
 	view := TemplateData{Data: &data, IsAdmin: isAdmin, QuickNav: loggedIn, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, LoggedInID: userid}
 	if len(thread) > 0 {
 		data.Title = thread[0].ThreadTitle
 		view.Title = data.Title
 	}
-	h.renderView(res, "thread", view)
+	if !isPrivate {
+		view.FeedURL = h.feedURL(fmt.Sprintf("/thread/%d/", threadid))
+	}
+	h.renderView(res, req, "thread", view)
 }
 
 func (h RequestHandler) ErrorRoute(res http.ResponseWriter, req *http.Request, status int) {
@@ -399,7 +714,7 @@ func (h RequestHandler) IndexRoute(res http.ResponseWriter, req *http.Request) {
 		h.ErrorRoute(res, req, http.StatusNotFound)
 		return
 	}
-	loggedIn, _ := h.IsLoggedIn(req)
+	loggedIn, userid := h.IsLoggedIn(req)
 	isAdmin, _ := h.IsAdmin(req)
 
 	// we store "session settings" for the index page by using the url.Values map.
@@ -444,38 +759,245 @@ func (h RequestHandler) IndexRoute(res http.ResponseWriter, req *http.Request) {
 
 	includePrivateThreads := loggedIn
 
+	// based on the stored session settings, only display the selected topics
+	var showAllTopics bool
+	_, showAllTopics = params["reset"]
+	topics := h.db.GetTopics()
+	visibleTopicsMap := make(map[int]bool)
+	var visibleTopicIDs []int
+	for _, topic := range topics {
+		if showAllTopics || !sessionParams.Has("show") || util.Contains(sessionParams["show"], strconv.Itoa(topic.ID)) {
+			visibleTopicsMap[topic.ID] = true
+			visibleTopicIDs = append(visibleTopicIDs, topic.ID)
+		}
+	}
+	// only filter the listing if some topics are hidden. if none of the stored topics exist anymore, show all of them
+	// rather than an empty index
+	if len(visibleTopicIDs) == 0 || len(visibleTopicIDs) == len(topics) {
+		visibleTopicIDs = nil
+		for _, topic := range topics {
+			visibleTopicsMap[topic.ID] = true
+		}
+	}
+
 	// show index listing
-	threads := h.db.ListThreads(mostRecentPost, includePrivateThreads)
+	options := database.ListThreadsOptions{SortByPost: mostRecentPost, IncludePrivate: includePrivateThreads, TopicIDs: visibleTopicIDs}
+	if loggedIn {
+		options.ReaderID = userid
+		options.ReaderIsAdmin = isAdmin
+	}
+	pagination := h.paginateThreads(req, &options, "/")
+	threads := h.db.ListThreads(options)
 
-	var showAllCategories bool
-	_, showAllCategories = params["reset"]
-	// based on the stored session settings, only display the selected categories
-	categoriesMap := make(map[string]bool)
-	for i, t := range threads {
-		category := strings.ToLower(t.GetCategory())
-		threads[i].Show = true
-		categoriesMap[category] = true
-		if !showAllCategories && sessionParams.Has("show") && !util.Contains(sessionParams["show"], category) {
-			threads[i].Show = false
-			categoriesMap[category] = false
+	view := TemplateData{Data: IndexData{Threads: threads, Topics: topics, VisibleTopicsMap: visibleTopicsMap, Pagination: pagination, MarkAllReadRoute: MARK_ALL_READ_ROUTE}, SortByPosts: mostRecentPost, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Threads")}
+	h.renderView(res, req, "index", view)
+}
+
+// lists the threads of a single topic, using the sort order stored for the index
+func (h RequestHandler) TopicRoute(res http.ResponseWriter, req *http.Request) {
+	if _, ok := feedPath(req, "/topic/"); ok {
+		h.TopicFeedRoute(res, req)
+		return
+	}
+	topicid, ok := util.GetURLPortion(req, 2)
+	loggedIn, userid := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+
+	topic, err := h.db.GetTopic(topicid)
+	if !ok || err != nil {
+		data := GenericMessageData{
+			Title:   h.translator.Translate("ErrTopic404"),
+			Message: h.translator.Translate("ErrTopic404Message"),
 		}
+		h.renderGenericMessage(res, req, data)
+		return
+	}
+
+	var mostRecentPost bool
+	paramsString, _ := h.session.GetIndexSettings(req)
+	if sessionParams, err := url.ParseQuery(paramsString); err == nil {
+		mostRecentPost = sessionParams.Get("sort") == "posts"
 	}
 
-	// populate a sorted array based on the set of index categories,
-	// to be used to create and display filter checkboxes
-	categories := make([]string, 0, len(categoriesMap))
-	for k, v := range categoriesMap {
-		categories = append(categories, k)
-		// remove the categories that are hidden. this way we can the length of the final map as a counter for how many
-		// categories are shown vs the total number
-		if !v {
-			delete(categoriesMap, k)
+	options := database.ListThreadsOptions{SortByPost: mostRecentPost, IncludePrivate: loggedIn, TopicIDs: []int{topic.ID}}
+	if loggedIn {
+		options.ReaderID = userid
+		options.ReaderIsAdmin = isAdmin
+	}
+	pagination := h.paginateThreads(req, &options, fmt.Sprintf("/topic/%d/", topic.ID))
+	threads := h.db.ListThreads(options)
+	view := TemplateData{Data: TopicData{Topic: topic, Threads: threads, Pagination: pagination, MarkAllReadRoute: MARK_ALL_READ_ROUTE}, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: topic.Name}
+	view.FeedURL = h.feedURL(fmt.Sprintf("/topic/%d/", topic.ID))
+	h.renderView(res, req, "topic", view)
+}
+
+// marks every thread as read for the logged in user, or only the threads of a topic if the form includes a topicid
+func (h RequestHandler) MarkAllReadRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, userid := h.IsLoggedIn(req)
+	if req.Method != "POST" || !loggedIn {
+		IndexRedirect(res, req)
+		return
+	}
+	var topicIDs []int
+	redirect := "/"
+	if topicid, err := strconv.Atoi(req.PostFormValue("topicid")); err == nil {
+		topicIDs = []int{topicid}
+		redirect = fmt.Sprintf("/topic/%d/", topicid)
+	}
+	if err := h.db.MarkAllRead(userid, topicIDs); err != nil {
+		dump(err)
+		h.renderGenericMessage(res, req, GenericMessageData{
+			Title:   h.translator.Translate("ErrMarkAllRead"),
+			Message: h.translator.Translate("ErrMarkAllReadMessage"),
+		})
+		return
+	}
+	http.Redirect(res, req, redirect, http.StatusSeeOther)
+}
+
+// limits options to the requested page of threads, and returns the pagination for a thread listing found at path
+func (h RequestHandler) paginateThreads(req *http.Request, options *database.ListThreadsOptions, path string) Pagination {
+	pageSize := h.config.Pagination.ThreadsPerPage
+	pages := pageCount(h.db.CountThreads(*options), pageSize)
+	page := getRequestedPage(req)
+	if page > pages {
+		page = pages
+	}
+	options.Limit = pageSize
+	options.Offset = (page - 1) * pageSize
+	return newPagination(page, pages, func(p int) string {
+		if p == 1 {
+			return path
 		}
+		return fmt.Sprintf("%s?page=%d", path, p)
+	})
+}
+
+const SEARCH_RESULTS_PER_PAGE = 20
+
+func (h RequestHandler) SearchRoute(res http.ResponseWriter, req *http.Request) {
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+
+	params := req.URL.Query()
+	data := SearchData{
+		Enabled: h.db.SearchEnabled(),
+		Query:   strings.TrimSpace(params.Get("q")),
+		Author:  strings.TrimSpace(params.Get("author")),
+		From:    params.Get("from"),
+		To:      params.Get("to"),
+	}
+	view := TemplateData{Data: &data, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Search")}
+	if !data.Enabled || data.Query == "" {
+		h.renderView(res, req, "search", view)
+		return
+	}
+
+	options := database.SearchOptions{Query: data.Query, Author: data.Author, IncludePrivate: loggedIn, PageSize: SEARCH_RESULTS_PER_PAGE}
+	// dates are given as yyyy-mm-dd, as sent by <input type="date">. the to date is inclusive
+	var err error
+	if data.From != "" {
+		options.From, err = time.ParseInLocation("2006-01-02", data.From, time.Local)
+	}
+	if err == nil && data.To != "" {
+		options.To, err = time.ParseInLocation("2006-01-02", data.To, time.Local)
+		options.To = options.To.AddDate(0, 0, 1)
+	}
+	if err != nil {
+		data.ErrorMessage = h.translator.Translate("SearchErrInvalidDate")
+		h.renderView(res, req, "search", view)
+		return
+	}
+	// pages are one-indexed in the url
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page > 1 {
+		options.Page = page - 1
+	}
+
+	results, hasMore, err := h.db.Search(options)
+	if err != nil {
+		dump(err)
+		data.ErrorMessage = h.translator.Translate("SearchErrGeneric")
+		h.renderView(res, req, "search", view)
+		return
 	}
-	sort.Strings(categories)
+	data.Results = results
 
-	view := TemplateData{Data: IndexData{Threads: threads, Categories: categories, VisibleCategoriesMap: categoriesMap}, SortByPosts: mostRecentPost, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Threads")}
-	h.renderView(res, "index", view)
+	pageLink := func(page int) string {
+		params.Set("page", strconv.Itoa(page))
+		return fmt.Sprintf("%s?%s", SEARCH_ROUTE, params.Encode())
+	}
+	if options.Page > 0 {
+		data.PrevPage = pageLink(options.Page)
+	}
+	if hasMore {
+		data.NextPage = pageLink(options.Page + 2)
+	}
+	h.renderView(res, req, "search", view)
+}
+
+// shows a user's profile: their bio, when they joined, and the posts they have written, latest first
+func (h RequestHandler) ProfileRoute(res http.ResponseWriter, req *http.Request) {
+	if _, ok := feedPath(req, USER_ROUTE); ok {
+		h.UserFeedRoute(res, req)
+		return
+	}
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+
+	userMissingData := GenericMessageData{
+		Title:   h.translator.Translate("ErrUser404"),
+		Message: h.translator.Translate("ErrUser404Message"),
+	}
+	// the path has already been unescaped, see profileURL
+	name := strings.TrimPrefix(req.URL.Path, USER_ROUTE)
+	if name == "" || name == database.SYSTEM_USER_NAME {
+		h.renderGenericMessage(res, req, userMissingData)
+		return
+	}
+	profile, err := h.db.GetUserProfile(name)
+	if err != nil {
+		h.renderGenericMessage(res, req, userMissingData)
+		return
+	}
+	data := ProfileData{Profile: profile}
+	view := TemplateData{Data: &data, IsAdmin: isAdmin, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: profile.Name}
+	// the posts of everyone who deleted their account end up here; they don't make up anyone's history
+	if name == database.DELETED_USER_NAME {
+		data.Deleted = true
+		h.renderView(res, req, "profile", view)
+		return
+	}
+	view.FeedURL = h.feedURL(profileURL(profile.Name))
+
+	// like on the index, private threads are only shown to logged in users
+	data.ThreadCount, data.PostCount, err = h.db.CountUserActivity(profile.ID, loggedIn)
+	if err != nil {
+		dump(err)
+	}
+	options := database.UserPostsOptions{IncludePrivate: loggedIn, IncludeHidden: isAdmin}
+	postCount, err := h.db.CountUserPosts(profile.ID, options)
+	if err != nil {
+		dump(err)
+	}
+	pageSize := h.config.Pagination.PostsPerPage
+	pages := pageCount(postCount, pageSize)
+	page := getRequestedPage(req)
+	if page > pages {
+		page = pages
+	}
+	options.Limit = pageSize
+	options.Offset = (page - 1) * pageSize
+	data.Posts, err = h.db.ListUserPosts(profile.ID, options)
+	if err != nil {
+		dump(err)
+	}
+	data.Pagination = newPagination(page, pages, func(p int) string {
+		if p == 1 {
+			return profileURL(profile.Name)
+		}
+		return fmt.Sprintf("%s?page=%d", profileURL(profile.Name), p)
+	})
+	h.renderView(res, req, "profile", view)
 }
 
 func IndexRedirect(res http.ResponseWriter, req *http.Request) {
@@ -496,14 +1018,13 @@ func GenerateRSS(db *database.DB, config types.Config) string {
 	}
 	// TODO (2022-12-08): augment ListThreads to choose getting author of latest post or thread creator (currently latest
 	// post always)
-	sortByPost := true
-	includePrivateThreads := false
-	threads := db.ListThreads(sortByPost, includePrivateThreads)
+	threads := db.ListThreads(database.ListThreadsOptions{SortByPost: true, IncludePrivate: false, ExcludeHiddenPosts: true})
 	entries := make([]string, len(threads))
 	for i, t := range threads {
 		fulltime := t.Publish.Format(rfc822RSS)
 		date := t.Publish.Format("2006-01-02")
-		posturl := joinPath(config.RSS.URL, fmt.Sprintf("%s#%d", t.Slug, t.PostID))
+		// ThreadRoute resolves the post parameter to the page the post is on
+		posturl := joinPath(config.RSS.URL, postURL(t.ID, t.PostID))
 		entry := rss.OutputRSSItem(fulltime, t.Title, fmt.Sprintf("[%s] %s posted", date, t.Author), posturl)
 		entries[i] = entry
 	}
@@ -542,10 +1063,15 @@ func (h RequestHandler) LoginRoute(res http.ResponseWriter, req *http.Request) {
 	loggedIn, _ := h.IsLoggedIn(req)
 	switch req.Method {
 	case "GET":
-		h.renderView(res, "login", TemplateData{Data: LoginData{}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Login")})
+		h.renderView(res, req, "login", TemplateData{Data: LoginData{}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Login")})
 	case "POST":
 		username := req.PostFormValue("username")
 		password := req.PostFormValue("password")
+		ip := util.ClientIP(req)
+		if wait := h.guard.lockedFor(ip, username); wait > 0 {
+			h.renderView(res, req, "login", TemplateData{Data: LoginData{LockedOut: lockedOutMessage(h.translator, wait)}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Login")})
+			return
+		}
 
 		// * hash received password and compare to stored hash
 		passwordHash, userid, err := h.db.GetPasswordHash(username)
@@ -555,9 +1081,20 @@ func (h RequestHandler) LoginRoute(res http.ResponseWriter, req *http.Request) {
 		}
 		if err != nil {
 			fmt.Println(err)
-			h.renderView(res, "login", TemplateData{Data: LoginData{FailedAttempt: true}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Login")})
+			h.guard.fail(ip, username, "/login")
+			h.renderView(res, req, "login", TemplateData{Data: LoginData{FailedAttempt: true}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("Login")})
+			return
+		}
+		// users with two-factor authentication enter a code before they are logged in
+		hasTwoFactor, err := h.db.HasTwoFactor(userid)
+		ed.Check(err, "checking two-factor")
+		if hasTwoFactor {
+			err = h.session.SavePendingLogin(req, res, userid)
+			ed.Check(err, "saving pending login")
+			http.Redirect(res, req, LOGIN_TWO_FACTOR_ROUTE, http.StatusSeeOther)
 			return
 		}
+		h.guard.succeed(ip, username)
 		// save user id in cookie
 		err = h.session.Save(req, res, userid)
 		ed.Check(err, "saving session cookie")
@@ -568,6 +1105,53 @@ func (h RequestHandler) LoginRoute(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// the second login step, for users with two-factor authentication. the password was entered on the login page, which
+// saved a pending login that is turned into a session once a valid code is entered
+func (h RequestHandler) LoginTwoFactorRoute(res http.ResponseWriter, req *http.Request) {
+	ed := eout.Describe("LoginTwoFactorRoute")
+	userid, err := h.session.GetPendingLogin(req)
+	if err != nil {
+		// the pending login expired, or the password was never entered
+		http.Redirect(res, req, "/login", http.StatusSeeOther)
+		return
+	}
+	render := func(data TwoFactorLoginData) {
+		data.Action = LOGIN_TWO_FACTOR_ROUTE
+		h.renderView(res, req, "login-two-factor", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", Title: h.translator.Translate("TwoFactor")})
+	}
+	switch req.Method {
+	case "GET":
+		render(TwoFactorLoginData{})
+	case "POST":
+		ip := util.ClientIP(req)
+		username, _ := h.db.GetUsername(userid)
+		if wait := h.guard.lockedFor(ip, username); wait > 0 {
+			render(TwoFactorLoginData{LockedOut: lockedOutMessage(h.translator, wait)})
+			return
+		}
+		valid, err := h.db.VerifyTwoFactor(userid, req.PostFormValue("code"))
+		if errors.Is(err, database.ErrTwoFactorLocked) {
+			render(TwoFactorLoginData{Locked: true})
+			return
+		} else if err != nil {
+			dump(err)
+		}
+		if !valid {
+			h.guard.fail(ip, username, LOGIN_TWO_FACTOR_ROUTE)
+			render(TwoFactorLoginData{FailedAttempt: true})
+			return
+		}
+		h.guard.succeed(ip, username)
+		err = h.session.ClearPendingLogin(req, res)
+		ed.Check(err, "clearing pending login")
+		err = h.session.Save(req, res, userid)
+		ed.Check(err, "saving session cookie")
+		IndexRedirect(res, req)
+	default:
+		IndexRedirect(res, req)
+	}
+}
+
 func (h RequestHandler) handleChangePassword(res http.ResponseWriter, req *http.Request) {
 	// TODO (2022-10-24): add translations for change password view
 	title := h.translator.Translate("ChangePassword")
@@ -589,7 +1173,7 @@ func (h RequestHandler) handleChangePassword(res http.ResponseWriter, req *http.
 	case "GET":
 		switch req.URL.Path {
 		default:
-			h.renderView(res, "change-password", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Data: ChangePasswordData{Action: "/reset/submit"}})
+			h.renderView(res, req, "change-password", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Data: ChangePasswordData{Action: "/reset/submit"}})
 		}
 	case "POST":
 		switch req.URL.Path {
@@ -611,8 +1195,12 @@ func (h RequestHandler) handleChangePassword(res http.ResponseWriter, req *http.
 			}
 			// then save the hash
 			h.db.UpdateUserPasswordHash(uid, pwhashNew)
+			// log out any sessions that used the old password
+			if err = h.db.DeleteUserSessions(uid, ""); err != nil {
+				dump(err)
+			}
 			// render a success message & show a link to the login page :')
-			h.renderView(res, "change-password-success", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Data: ChangePasswordData{}})
+			h.renderView(res, req, "change-password-success", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Data: ChangePasswordData{}})
 		default:
 			fmt.Printf("unsupported POST route (%s), redirecting to /\n", req.URL.Path)
 			IndexRedirect(res, req)
@@ -633,6 +1221,12 @@ func (h RequestHandler) ResetPasswordRoute(res http.ResponseWriter, req *http.Re
 		return
 	}
 
+	// with email turned on, users with a verified address are emailed a reset link
+	if h.mailer.Enabled() {
+		h.handlePasswordResetEmail(res, req)
+		return
+	}
+
 	renderPlaceholder := func(errFmt string, args ...interface{}) {
 		errMessage := fmt.Sprintf(errFmt, args...)
 		fmt.Println(errMessage)
@@ -642,7 +1236,7 @@ func (h RequestHandler) ResetPasswordRoute(res http.ResponseWriter, req *http.Re
 			Link:     "/",
 			LinkText: h.translator.Translate("GoBack"),
 		}
-		h.renderView(res, "generic-message", TemplateData{Data: data, Title: title})
+		h.renderView(res, req, "generic-message", TemplateData{Data: data, Title: title})
 	}
 	renderPlaceholder("Password reset under construction: please contact admin if you need help resetting yr pw :)")
 	return
@@ -664,8 +1258,8 @@ func (h RequestHandler) RegisterRoute(res http.ResponseWriter, req *http.Request
 		return
 	}
 
-	rules := util.Markup(string(h.files["rules"]))
-	registration := util.Markup(string(h.files["registration"]))
+	rules := h.markupDocument("rules")
+	registration := h.markupDocument("registration")
 	conduct := h.config.General.ConductLink
 
 	// how this works: an invite code is provided by the user. this is provided either by clicking a register link that has a prefilled query parameter:
@@ -680,18 +1274,23 @@ func (h RequestHandler) RegisterRoute(res http.ResponseWriter, req *http.Request
 	renderErr := func(errFmt string, args ...interface{}) {
 		errMessage := fmt.Sprintf(errFmt, args...)
 		fmt.Println(errMessage)
-		h.renderView(res, "register", TemplateData{Data: RegisterData{inviteCode, errMessage, rules, registration, conduct}})
+		h.renderView(res, req, "register", TemplateData{Data: RegisterData{inviteCode, errMessage, rules, registration, conduct}})
 	}
 
 	var err error
 	switch req.Method {
 	case "GET":
-		h.renderView(res, "register", TemplateData{Data: RegisterData{inviteCode, "", rules, registration, conduct}})
+		h.renderView(res, req, "register", TemplateData{Data: RegisterData{inviteCode, "", rules, registration, conduct}})
 	case "POST":
 		username := req.PostFormValue("username")
 		password := req.PostFormValue("password")
 		// read submitted invite code from form
 		inviteCode = req.PostFormValue("invite")
+		ip := util.ClientIP(req)
+		if wait := h.guard.lockedFor(ip, ""); wait > 0 {
+			renderErr("%s", lockedOutMessage(h.translator, wait))
+			return
+		}
 
 		// make sure username is not registered already
 		var exists bool
@@ -718,6 +1317,7 @@ func (h RequestHandler) RegisterRoute(res http.ResponseWriter, req *http.Request
 			return
 		}
 		if !developing && !inviteRedeemed {
+			h.guard.fail(ip, "", "/register")
 			renderErr("The invite was not valid and could not be used to create an account.")
 			return
 		}
@@ -730,13 +1330,14 @@ func (h RequestHandler) RegisterRoute(res http.ResponseWriter, req *http.Request
 		// log the new user in
 		h.session.Save(req, res, userID)
 		// log where the registration is coming from, in the case of indirect invites && for curiosity
+		h.userWebhooks(WEBHOOK_USER_REGISTERED, userID)
 
 		// save which invite batchid was used to register, so we can at least trace which invite code is bringing people in
 		err = h.db.AddRegistration(userID, inviteBatchId)
 		if err = ed.Eout(err, "add registration"); err != nil {
 			dump(err)
 		}
-		h.renderView(res, "register-success", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Title: h.translator.Translate("RegisterSuccess")})
+		h.renderView(res, req, "register-success", TemplateData{HasRSS: h.config.RSS.URL != "", LoggedIn: true, Title: h.translator.Translate("RegisterSuccess")})
 	default:
 		fmt.Println("non get/post method, redirecting to index")
 		IndexRedirect(res, req)
@@ -757,18 +1358,17 @@ func (h RequestHandler) GenericRoute(res http.ResponseWriter, req *http.Request)
 
 func (h RequestHandler) AboutRoute(res http.ResponseWriter, req *http.Request) {
 	loggedIn, _ := h.IsLoggedIn(req)
-	input := util.Markup(string(h.files["about"]))
-	h.renderView(res, "about-template", TemplateData{Data: input, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("About")})
+	input := h.markupDocument("about")
+	h.renderView(res, req, "about-template", TemplateData{Data: input, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("About")})
 }
 
 func (h RequestHandler) AccountRoute(res http.ResponseWriter, req *http.Request) {
 	loggedIn, userid := h.IsLoggedIn(req)
-	username, err := h.db.GetUsername(userid)
-	var errMessage string
-	if err != nil {
-		errMessage = "Could not get the username for the logged-in user"
+	data := h.accountData(userid)
+	if data.LoggedInUsername == "" {
+		data.ErrorMessage = "Could not get the username for the logged-in user"
 	}
-	h.renderView(res, "account", TemplateData{Data: AccountData{LoggedInUsername: username, ErrorMessage: errMessage, DeleteAccountRoute: ACCOUNT_DELETE_ROUTE, ChangeUsernameRoute: ACCOUNT_CHANGE_USERNAME_ROUTE, ChangePasswordRoute: ACCOUNT_CHANGE_PASSWORD_ROUTE}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
+	h.renderView(res, req, "account", TemplateData{Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: "Account"})
 }
 
 func (h RequestHandler) RobotsRoute(res http.ResponseWriter, req *http.Request) {
@@ -799,9 +1399,14 @@ func (h *RequestHandler) NewThreadRoute(res http.ResponseWriter, req *http.Reque
 		if _, exists := params["title"]; exists {
 			newTitle = params["title"][0]
 		}
-		// note: for Data we pass and initialize an anonymous struct that only contains NewTitle. this makes newTitle accessible in html/new-thread.html as .Data.NewTitle
-		h.renderView(res, "new-thread", TemplateData{
-			Data: struct{ NewTitle string }{newTitle}, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("ThreadNew")})
+		// preselect a topic, e.g. when arriving from a topic's thread listing
+		topicid, err := strconv.Atoi(params.Get("topic"))
+		if err != nil {
+			topicid = h.db.GetDefaultTopicID()
+		}
+		data := NewThreadData{NewTitle: newTitle, TopicID: topicid, Topics: h.db.GetTopics()}
+		h.renderView(res, req, "new-thread", TemplateData{
+			Data: data, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, Title: h.translator.Translate("ThreadNew")})
 	case "POST":
 		// Handle POST (=>
 		title := req.PostFormValue("title")
@@ -827,9 +1432,21 @@ func (h *RequestHandler) NewThreadRoute(res http.ResponseWriter, req *http.Reque
 			return
 		}
 
-		// TODO (2022-01-10): unstub topicid, once we have other topics :)
+		// put the thread in the default topic if the chosen topic is missing or doesn't exist
+		topicid, err := strconv.Atoi(req.PostFormValue("topic"))
+		if err == nil {
+			var exists bool
+			exists, err = h.db.CheckTopicExists(topicid)
+			if err == nil && !exists {
+				err = fmt.Errorf("topic %d does not exist", topicid)
+			}
+		}
+		if err != nil {
+			dump(eout.Eout(err, "new thread: choose topic"))
+			topicid = h.db.GetDefaultTopicID()
+		}
 		// the new thread was created: forward info to database
-		threadid, err := h.db.CreateThread(title, content, userid, 1, isPrivate)
+		threadid, err := h.db.CreateThread(title, content, userid, topicid, isPrivate)
 		if err != nil {
 			data := GenericMessageData{
 				Title:   h.translator.Translate("NewThreadCreateError"),
@@ -838,6 +1455,9 @@ func (h *RequestHandler) NewThreadRoute(res http.ResponseWriter, req *http.Reque
 			h.renderGenericMessage(res, req, data)
 			return
 		}
+		h.emailNotifications()
+		h.federateThread(threadid)
+		h.threadWebhooks(threadid)
 		// update the rss feed
 		h.rssFeed = GenerateRSS(h.db, h.config)
 		// when data has been stored => redirect to thread
@@ -857,6 +1477,7 @@ func (h *RequestHandler) DeletePostRoute(res http.ResponseWriter, req *http.Requ
 	threadURL := req.PostFormValue("thread")
 	postid, ok := util.GetURLPortion(req, 3)
 	loggedIn, userid := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
 
 	// generic error message base, with specifics being swapped out depending on the error
 	genericErr := GenericMessageData{
@@ -884,16 +1505,35 @@ func (h *RequestHandler) DeletePostRoute(res http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	authorized := post.AuthorID == userid
+	// admins may delete anyone's post, which is recorded in the moderation log
+	authorized := post.AuthorID == userid || isAdmin
 	switch req.Method {
 	case "POST":
 		if authorized {
+			// federating the deletion, and sending it to webhooks, needs to know where the post was
+			federated, federates := h.federatedPost(postid)
+			deleted, webhookErr := h.postDeletedEvent(postid, userid)
 			err = h.db.DeletePost(postid)
 			if err != nil {
 				dump(err)
 				renderErr("Error happened while deleting the post")
 				return
 			}
+			if post.AuthorID != userid {
+				// the post is gone, so the log entry refers to the thread it was deleted from
+				modlogErr := h.db.AddModerationLogWithSubject(userid, post.AuthorID, constants.MODLOG_DELETE_POST, post.ThreadID, post.ThreadTitle)
+				if modlogErr != nil {
+					dump(eout.Eout(modlogErr, "error adding moderation log"))
+				}
+			}
+			if federates {
+				h.federate(activitypub.DELETE, federated)
+			}
+			if webhookErr == nil {
+				h.sendWebhooks(deleted)
+			} else {
+				dump(webhookErr)
+			}
 		} else {
 			renderErr("That's not your post to delete? Sorry buddy!")
 			return
@@ -907,6 +1547,7 @@ func (h *RequestHandler) DeletePostRoute(res http.ResponseWriter, req *http.Requ
 func (h *RequestHandler) EditPostRoute(res http.ResponseWriter, req *http.Request) {
 	postid, ok := util.GetURLPortion(req, 3)
 	loggedIn, userid := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
 	post, err := h.db.GetPost(postid)
 
 	if !ok || errors.Is(err, sql.ErrNoRows) {
@@ -918,7 +1559,8 @@ func (h *RequestHandler) EditPostRoute(res http.ResponseWriter, req *http.Reques
 		h.renderGenericMessage(res, req, data)
 		return
 	}
-	if !loggedIn || userid != post.AuthorID {
+	// admins may edit anyone's post, which is recorded in the moderation log
+	if !loggedIn || (userid != post.AuthorID && !isAdmin) {
 		res.WriteHeader(401)
 		title := h.translator.Translate("ErrGeneric401")
 		data := GenericMessageData{
@@ -935,10 +1577,22 @@ func (h *RequestHandler) EditPostRoute(res http.ResponseWriter, req *http.Reques
 			title = post.ThreadTitle
 		}
 		h.db.EditPost(content, title, postid, post.ThreadID)
+		h.emailNotifications()
+		h.federatePost(activitypub.UPDATE, postid)
+		h.postWebhooks(WEBHOOK_POST_EDITED, postid)
+		if userid != post.AuthorID {
+			modlogErr := h.db.AddModerationLogWithSubject(userid, post.AuthorID, constants.MODLOG_EDIT_POST, postid, "")
+			if modlogErr != nil {
+				dump(eout.Eout(modlogErr, "error adding moderation log"))
+			}
+		}
 		post.Content = content
 		post.ThreadTitle = title
+		if post.Mentions, err = h.db.ResolveMentions(content, post.Mentions); err != nil {
+			dump(err)
+		}
 	}
-	view := TemplateData{Data: post, QuickNav: loggedIn, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, LoggedInID: userid}
+	view := TemplateData{Data: post, IsAdmin: isAdmin, QuickNav: loggedIn, HasRSS: h.config.RSS.URL != "", LoggedIn: loggedIn, LoggedInID: userid}
 	params := req.URL.Query()
 	posts, err := h.db.GetThread(post.ThreadID)
 	if _, exists := params["op"]; exists {
@@ -946,7 +1600,7 @@ func (h *RequestHandler) EditPostRoute(res http.ResponseWriter, req *http.Reques
 			view.IsOP = true
 		}
 	}
-	h.renderView(res, "edit-post", view)
+	h.renderView(res, req, "edit-post", view)
 }
 
 func Serve(port int, isdev bool, conf types.Config) {
@@ -979,6 +1633,16 @@ func Serve(port int, isdev bool, conf types.Config) {
 type CercaForum struct {
 	http.ServeMux
 	Directory string
+	// wraps the ServeMux with middleware, see NewServer
+	handler http.Handler
+}
+
+func (u *CercaForum) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if u.handler == nil {
+		u.ServeMux.ServeHTTP(res, req)
+		return
+	}
+	u.handler.ServeHTTP(res, req)
 }
 
 func (u *CercaForum) directory() string {
@@ -996,10 +1660,59 @@ func (u *CercaForum) directory() string {
 const INVITES_ROUTE = "/invites"
 const INVITES_CREATE_ROUTE = "/invites/create"
 const INVITES_DELETE_ROUTE = "/invites/delete"
+const INVITES_EMAIL_ROUTE = "/invites/email"
+
+const ADMIN_TOPICS_ROUTE = "/admin/topics"
+const ADMIN_TOPICS_CREATE_ROUTE = "/admin/topics/create"
+const ADMIN_TOPICS_UPDATE_ROUTE = "/admin/topics/update"
+const ADMIN_TOPICS_DELETE_ROUTE = "/admin/topics/delete"
+
+const ADMIN_WEBHOOKS_ROUTE = "/admin/webhooks"
+const ADMIN_WEBHOOKS_CREATE_ROUTE = "/admin/webhooks/create"
+const ADMIN_WEBHOOKS_UPDATE_ROUTE = "/admin/webhooks/update"
+const ADMIN_WEBHOOKS_SECRET_ROUTE = "/admin/webhooks/secret"
+const ADMIN_WEBHOOKS_TEST_ROUTE = "/admin/webhooks/test"
+const ADMIN_WEBHOOKS_DELETE_ROUTE = "/admin/webhooks/delete"
+
+const ADMIN_PLACEHOLDERS_ROUTE = "/admin/placeholders"
+const ADMIN_PLACEHOLDERS_CLAIM_ROUTE = "/admin/placeholders/claim-link"
+const CLAIM_ROUTE = "/claim"
+
+const ADMIN_THREAD_DELETE_ROUTE = "/admin/thread/delete"
+const ADMIN_THREAD_MOVE_ROUTE = "/admin/thread/move"
+const ADMIN_POST_HIDE_ROUTE = "/admin/post/hide"
+const ADMIN_POST_UNHIDE_ROUTE = "/admin/post/unhide"
+
+const SEARCH_ROUTE = "/search"
+
+const MARK_ALL_READ_ROUTE = "/threads/mark-read"
+const THREAD_SUBSCRIBE_ROUTE = "/thread/subscribe"
+
+const NOTIFICATIONS_ROUTE = "/notifications"
+const NOTIFICATIONS_READ_ROUTE = "/notifications/read"
+
+const USER_ROUTE = "/user/"
+
+const LOGIN_TWO_FACTOR_ROUTE = "/login/two-factor"
+
+const PASSWORD_RESET_REQUEST_ROUTE = "/reset/request"
+const PASSWORD_RESET_CONFIRM_ROUTE = "/reset/confirm"
 
 const ACCOUNT_CHANGE_PASSWORD_ROUTE = "/account/change-password"
 const ACCOUNT_CHANGE_USERNAME_ROUTE = "/account/change-username"
 const ACCOUNT_DELETE_ROUTE = "/account/delete"
+const ACCOUNT_BIO_ROUTE = "/account/bio"
+const ACCOUNT_EMAIL_ROUTE = "/account/email"
+const ACCOUNT_EMAIL_VERIFY_ROUTE = "/account/email/verify"
+const ACCOUNT_EMAIL_NOTIFICATIONS_ROUTE = "/account/email/notifications"
+const ACCOUNT_TOKENS_CREATE_ROUTE = "/account/tokens/create"
+const ACCOUNT_TOKENS_REVOKE_ROUTE = "/account/tokens/revoke"
+const ACCOUNT_SESSIONS_ROUTE = "/account/sessions"
+const ACCOUNT_SESSIONS_REVOKE_ROUTE = "/account/sessions/revoke"
+const ACCOUNT_TWO_FACTOR_SETUP_ROUTE = "/account/two-factor/setup"
+const ACCOUNT_TWO_FACTOR_ENABLE_ROUTE = "/account/two-factor/enable"
+const ACCOUNT_TWO_FACTOR_DISABLE_ROUTE = "/account/two-factor/disable"
+const ACCOUNT_TWO_FACTOR_RECOVERY_ROUTE = "/account/two-factor/recovery-codes"
 
 // NewServer sets up a new CercaForum object. Always use this to initialize
 // new CercaForum objects. Pass the result to http.Serve() with your choice
@@ -1011,12 +1724,21 @@ func NewServer(authKey string, dir string, config types.Config) (*CercaForum, er
 	}
 
 	config.EnsureDefaultPaths()
+	config.EnsureDefaultPagination()
+	config.EnsureDefaultSecurity()
+	config.EnsureDefaultNetwork()
+	config.EnsureDefaultNotifications()
+	config.EnsureDefaultEmail()
 
 	dbPath := filepath.Join(s.directory(), "forum.db")
 	docsPath := filepath.Join(s.directory(), "docs")
 	assetsPath := filepath.Join(s.directory(), "assets")
 
 	db := database.InitDB(dbPath)
+	db.SetSubscriptionOptions(database.SubscriptionOptions{
+		ThreadAuthors: *config.Notifications.SubscribeOwnThreads,
+		Repliers:      *config.Notifications.SubscribeOnReply,
+	})
 
 	// load the documents specified in the config
 	// iff document doesn't exist, dump a default document where it should be and read that
@@ -1043,7 +1765,24 @@ func NewServer(authKey string, dir string, config types.Config) (*CercaForum, er
 	translator := i18n.Init(config.General.Language)
 	templates := template.Must(generateTemplates(config, files, translator))
 	feed := GenerateRSS(&db, config)
-	handler := RequestHandler{&db, session.New(authKey, developing), files, config, translator, templates, feed}
+	mailer, err := mail.New(config, translator)
+	if err != nil {
+		return nil, eout.Eout(err, "read [email] config")
+	}
+	fed, err := newFederation(config)
+	if err != nil {
+		return nil, eout.Eout(err, "read [activitypub] config")
+	}
+	handler := RequestHandler{&db, session.New(authKey, developing, &db), files, config, translator, templates, feed, newLoginGuard(config), mailer, make(chan struct{}, 1), newPasswordResetLimiter(), fed, make(chan struct{}, 1)}
+	// every entry added to the moderation log is sent to the webhooks that want it
+	db.SetModerationHook(handler.moderationWebhooks)
+	go handler.deliverWebhooks()
+	if mailer.Enabled() {
+		go handler.mailNotifications()
+	}
+	if fed != nil {
+		go handler.deliverActivities()
+	}
 
 	/* note: be careful with trailing slashes; go's default handler is a bit sensitive */
 	// TODO (2022-01-10): introduce middleware to make sure there is never an issue with trailing slashes
@@ -1056,6 +1795,23 @@ func NewServer(authKey string, dir string, config types.Config) (*CercaForum, er
 	s.ServeMux.HandleFunc(INVITES_ROUTE, handler.AdminInvitesRoute)
 	s.ServeMux.HandleFunc(INVITES_CREATE_ROUTE, handler.AdminInvitesCreateBatch)
 	s.ServeMux.HandleFunc(INVITES_DELETE_ROUTE, handler.AdminInvitesDeleteBatch)
+	s.ServeMux.HandleFunc(INVITES_EMAIL_ROUTE, handler.AdminInvitesEmail)
+	s.ServeMux.HandleFunc(ADMIN_TOPICS_ROUTE, handler.AdminTopicsRoute)
+	s.ServeMux.HandleFunc(ADMIN_TOPICS_CREATE_ROUTE, handler.AdminTopicsCreate)
+	s.ServeMux.HandleFunc(ADMIN_TOPICS_UPDATE_ROUTE, handler.AdminTopicsUpdate)
+	s.ServeMux.HandleFunc(ADMIN_TOPICS_DELETE_ROUTE, handler.AdminTopicsDelete)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_ROUTE, handler.AdminWebhooksRoute)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_CREATE_ROUTE, handler.AdminWebhooksCreate)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_UPDATE_ROUTE, handler.AdminWebhooksUpdate)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_SECRET_ROUTE, handler.AdminWebhooksSecret)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_TEST_ROUTE, handler.AdminWebhooksTest)
+	s.ServeMux.HandleFunc(ADMIN_WEBHOOKS_DELETE_ROUTE, handler.AdminWebhooksDelete)
+	s.ServeMux.HandleFunc(ADMIN_PLACEHOLDERS_ROUTE, handler.AdminPlaceholdersRoute)
+	s.ServeMux.HandleFunc(ADMIN_PLACEHOLDERS_CLAIM_ROUTE, handler.AdminPlaceholdersClaimLink)
+	s.ServeMux.HandleFunc(ADMIN_THREAD_DELETE_ROUTE, handler.AdminThreadDelete)
+	s.ServeMux.HandleFunc(ADMIN_THREAD_MOVE_ROUTE, handler.AdminThreadMove)
+	s.ServeMux.HandleFunc(ADMIN_POST_HIDE_ROUTE, handler.AdminPostHide)
+	s.ServeMux.HandleFunc(ADMIN_POST_UNHIDE_ROUTE, handler.AdminPostUnhide)
 	s.ServeMux.HandleFunc("/moderations", handler.ModerationLogRoute)
 	s.ServeMux.HandleFunc("/proposal-veto", handler.VetoProposal)
 	s.ServeMux.HandleFunc("/proposal-confirm", handler.ConfirmProposal)
@@ -1063,23 +1819,58 @@ func NewServer(authKey string, dir string, config types.Config) (*CercaForum, er
 	s.ServeMux.HandleFunc(ACCOUNT_CHANGE_PASSWORD_ROUTE, handler.AccountChangePassword)
 	s.ServeMux.HandleFunc(ACCOUNT_CHANGE_USERNAME_ROUTE, handler.AccountChangeUsername)
 	s.ServeMux.HandleFunc(ACCOUNT_DELETE_ROUTE, handler.AccountSelfServiceDelete)
+	s.ServeMux.HandleFunc(ACCOUNT_BIO_ROUTE, handler.AccountChangeBio)
+	s.ServeMux.HandleFunc(ACCOUNT_EMAIL_ROUTE, handler.AccountChangeEmail)
+	s.ServeMux.HandleFunc(ACCOUNT_EMAIL_VERIFY_ROUTE, handler.AccountVerifyEmail)
+	s.ServeMux.HandleFunc(ACCOUNT_EMAIL_NOTIFICATIONS_ROUTE, handler.AccountEmailNotifications)
+	s.ServeMux.HandleFunc(ACCOUNT_TOKENS_CREATE_ROUTE, handler.AccountCreateToken)
+	s.ServeMux.HandleFunc(ACCOUNT_TOKENS_REVOKE_ROUTE, handler.AccountRevokeToken)
+	s.ServeMux.HandleFunc(ACCOUNT_SESSIONS_ROUTE, handler.AccountSessionsRoute)
+	s.ServeMux.HandleFunc(ACCOUNT_SESSIONS_REVOKE_ROUTE, handler.AccountRevokeSessions)
+	s.ServeMux.HandleFunc(ACCOUNT_TWO_FACTOR_SETUP_ROUTE, handler.AccountTwoFactorSetup)
+	s.ServeMux.HandleFunc(ACCOUNT_TWO_FACTOR_ENABLE_ROUTE, handler.AccountTwoFactorEnable)
+	s.ServeMux.HandleFunc(ACCOUNT_TWO_FACTOR_DISABLE_ROUTE, handler.AccountTwoFactorDisable)
+	s.ServeMux.HandleFunc(ACCOUNT_TWO_FACTOR_RECOVERY_ROUTE, handler.AccountTwoFactorRecoveryCodes)
 	// regular ol forum routes
 	s.ServeMux.HandleFunc("/about", handler.AboutRoute)
 	s.ServeMux.HandleFunc("/account", handler.AccountRoute)
 	s.ServeMux.HandleFunc("/logout", handler.LogoutRoute)
 	s.ServeMux.HandleFunc("/login", handler.LoginRoute)
+	s.ServeMux.HandleFunc(LOGIN_TWO_FACTOR_ROUTE, handler.LoginTwoFactorRoute)
 	s.ServeMux.HandleFunc("/register", handler.RegisterRoute)
+	s.ServeMux.HandleFunc(CLAIM_ROUTE, handler.ClaimRoute)
 	s.ServeMux.HandleFunc("/post/delete/", handler.DeletePostRoute)
 	s.ServeMux.HandleFunc("/post/edit/", handler.EditPostRoute)
 	s.ServeMux.HandleFunc("/thread/new/", handler.NewThreadRoute)
 	s.ServeMux.HandleFunc("/thread/", handler.ThreadRoute)
+	s.ServeMux.HandleFunc("/topic/", handler.TopicRoute)
+	s.ServeMux.HandleFunc(SEARCH_ROUTE, handler.SearchRoute)
+	s.ServeMux.HandleFunc(MARK_ALL_READ_ROUTE, handler.MarkAllReadRoute)
+	s.ServeMux.HandleFunc(THREAD_SUBSCRIBE_ROUTE, handler.ThreadSubscribeRoute)
+	s.ServeMux.HandleFunc(NOTIFICATIONS_ROUTE, handler.NotificationsRoute)
+	s.ServeMux.HandleFunc(NOTIFICATIONS_READ_ROUTE, handler.NotificationsReadRoute)
+	s.ServeMux.HandleFunc(USER_ROUTE, handler.ProfileRoute)
 	s.ServeMux.HandleFunc("/robots.txt", handler.RobotsRoute)
 	s.ServeMux.HandleFunc("/", handler.IndexRoute)
 	s.ServeMux.HandleFunc("/rss/", handler.RSSRoute)
 	s.ServeMux.HandleFunc("/rss.xml", handler.RSSRoute)
 
+	// federation, for contents see file server/activitypub.go
+	s.ServeMux.HandleFunc(ACTIVITYPUB_ROUTE, handler.ActivityPubRoute)
+	s.ServeMux.HandleFunc(WEBFINGER_ROUTE, handler.WebFingerRoute)
+
+	// json api, for contents see file server/api.go
+	s.ServeMux.Handle(API_ROUTE, NewAPIRateLimitingWare().Handler(handler.apiMux()))
+
 	fileserver := http.FileServer(http.Dir(assetsPath))
 	s.ServeMux.Handle("/assets/", http.StripPrefix("/assets/", fileserver))
 
+	// every state-changing form submission needs to carry the session's csrf token
+	resolver, err := newClientIPResolver(config)
+	if err != nil {
+		return nil, eout.Eout(err, "read [network] config")
+	}
+	s.handler = resolver.Handler(handler.csrfProtection(&s.ServeMux))
+
 	return s, nil
 }
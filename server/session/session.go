@@ -28,32 +28,53 @@ USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 import (
 	"gomod.cblgh.org/cerca/util/eout"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/gorilla/sessions"
+	"gomod.cblgh.org/cerca/util"
 )
 
 const cookieName = "cerca"
 
+// logins waiting for their second factor are kept in a separate, short-lived cookie
+const pendingCookieName = "cerca-pending"
+
 const INDEX_SETTINGS = "IndexSettings"
-const USER_ID = "userid"
+const CSRF_TOKEN = "csrf"
+const SESSION_TOKEN = "session"
+const PENDING_LOGIN = "pendinglogin"
+
+// Store keeps track of logged in sessions on the server, so that they can be listed and revoked. the cookie only
+// carries a token identifying the session
+type Store interface {
+	CreateSession(userid int, userAgent, ip string) (string, error)
+	// returns the user the session belongs to, or an error if the session was revoked or has expired
+	CheckSession(token string) (int, error)
+	DeleteSession(token string) error
+}
 
 type Session struct {
 	Store           *sessions.CookieStore
 	ShortLivedStore *sessions.CookieStore
+	sessions        Store
 }
 
-func New(authKey string, developing bool) *Session {
+func New(authKey string, developing bool, sessionStore Store) *Session {
 	store := sessions.NewCookieStore([]byte(authKey))
 	store.Options = &sessions.Options{
+		Path:     "/",
 		HttpOnly: true,
 		Secure:   !developing,
 		MaxAge:   86400 * 30,
 	}
 	short := sessions.NewCookieStore([]byte(authKey))
 	short.Options = &sessions.Options{
+		Path:     "/",
 		HttpOnly: true,
 		Secure:   !developing,
 		MaxAge: 600, // 10 minutes
@@ -61,11 +82,17 @@ func New(authKey string, developing bool) *Session {
 	return &Session{
 		Store:           store,
 		ShortLivedStore: short,
+		sessions:        sessionStore,
 	}
 }
 
 func (s *Session) Delete(res http.ResponseWriter, req *http.Request) error {
 	ed := eout.Describe("delete session cookie")
+	if token, err := s.GetToken(req); err == nil {
+		if err = s.sessions.DeleteSession(token); err != nil {
+			return ed.Eout(err, "delete stored session")
+		}
+	}
 	clearSession := func(store *sessions.CookieStore) error {
 		session, err := store.Get(req, cookieName)
 		if err != nil {
@@ -83,8 +110,8 @@ func (s *Session) Delete(res http.ResponseWriter, req *http.Request) error {
 	return err
 }
 
-func getValueFromSession(req *http.Request, store *sessions.CookieStore, key string) (interface{}, error) {
-	session, err := store.Get(req, cookieName)
+func getValueFromSession(req *http.Request, store *sessions.CookieStore, name, key string) (interface{}, error) {
+	session, err := store.Get(req, name)
 	if err != nil {
 		return nil, err
 	}
@@ -96,17 +123,32 @@ func getValueFromSession(req *http.Request, store *sessions.CookieStore, key str
 	return value, nil
 }
 
+// returns the logged in user. the session's token has to match a session in the store, which means sessions revoked
+// on the server are logged out even while their cookie remains
 func (s *Session) Get(req *http.Request) (int, error) {
-	val, err := getValueFromSession(req, s.Store, USER_ID)
-	if val == nil || err != nil {
+	token, err := s.GetToken(req)
+	if err != nil {
 		return -1, err
 	}
-	return val.(int), err
+	return s.sessions.CheckSession(token)
+}
+
+// returns the token identifying the request's session in the store
+func (s *Session) GetToken(req *http.Request) (string, error) {
+	val, err := getValueFromSession(req, s.Store, cookieName, SESSION_TOKEN)
+	if val == nil || err != nil {
+		return "", err
+	}
+	token, ok := val.(string)
+	if !ok || token == "" {
+		return "", errors.New("get session: malformed session token")
+	}
+	return token, nil
 }
 
 /* TODO (2024-11-20): revamp structure of this file to something less repetitive and using enum-like things instead */
 func (s *Session) GetIndexSettings(req *http.Request) (string, error) {
-	val, err := getValueFromSession(req, s.Store, INDEX_SETTINGS)
+	val, err := getValueFromSession(req, s.Store, cookieName, INDEX_SETTINGS)
 	if val == nil || err != nil {
 		return "", err
 	}
@@ -123,10 +165,79 @@ func (s *Session) genericSave(req *http.Request, res http.ResponseWriter, shortL
 	return session.Save(req, res)
 }
 
+// logs the user in by creating a new session in the store, recording the client's user agent and ip address so the user
+// can recognize the session later. any session the request already had is replaced
 func (s *Session) Save(req *http.Request, res http.ResponseWriter, userid int) error {
-	return s.genericSave(req, res, false, USER_ID, userid)
+	ed := eout.Describe("save session")
+	if previous, err := s.GetToken(req); err == nil {
+		if err = s.sessions.DeleteSession(previous); err != nil {
+			return ed.Eout(err, "delete previous session")
+		}
+	}
+	token, err := s.sessions.CreateSession(userid, req.UserAgent(), util.ClientIP(req))
+	if err != nil {
+		return ed.Eout(err, "create session")
+	}
+	return s.genericSave(req, res, false, SESSION_TOKEN, token)
 }
 
 func (s *Session) SaveIndexSettings(req *http.Request, res http.ResponseWriter, params string) error {
 	return s.genericSave(req, res, false, INDEX_SETTINGS, params)
 }
+
+// returns the session's csrf token, creating and saving a new one if the session has none. the token is stored in the
+// session cookie, so it changes whenever the session does, e.g. after logging out
+func (s *Session) CSRFToken(req *http.Request, res http.ResponseWriter) (string, error) {
+	ed := eout.Describe("csrf token")
+	val, err := getValueFromSession(req, s.Store, cookieName, CSRF_TOKEN)
+	if token, ok := val.(string); err == nil && ok && token != "" {
+		return token, nil
+	}
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", ed.Eout(err, "generate token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	err = s.genericSave(req, res, false, CSRF_TOKEN, token)
+	return token, ed.Eout(err, "save token")
+}
+
+// reports whether token matches the session's csrf token
+func (s *Session) ValidCSRFToken(req *http.Request, token string) bool {
+	val, err := getValueFromSession(req, s.Store, cookieName, CSRF_TOKEN)
+	expected, ok := val.(string)
+	if err != nil || !ok || expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// remembers that the user has entered the correct password, but still has to enter a two-factor code to be logged in.
+// the pending login expires with the short-lived store's cookie
+func (s *Session) SavePendingLogin(req *http.Request, res http.ResponseWriter, userid int) error {
+	session, _ := s.ShortLivedStore.Get(req, pendingCookieName)
+	session.Values[PENDING_LOGIN] = userid
+	return eout.Eout(session.Save(req, res), "save pending login")
+}
+
+// returns the user whose login is waiting for a two-factor code
+func (s *Session) GetPendingLogin(req *http.Request) (int, error) {
+	val, err := getValueFromSession(req, s.ShortLivedStore, pendingCookieName, PENDING_LOGIN)
+	if err != nil {
+		return -1, eout.Eout(err, "get pending login")
+	}
+	userid, ok := val.(int)
+	if !ok {
+		return -1, errors.New("get pending login: malformed user id")
+	}
+	return userid, nil
+}
+
+func (s *Session) ClearPendingLogin(req *http.Request, res http.ResponseWriter) error {
+	session, err := s.ShortLivedStore.Get(req, pendingCookieName)
+	if err != nil {
+		return eout.Eout(err, "get pending login")
+	}
+	session.Options.MaxAge = -1
+	return eout.Eout(session.Save(req, res), "clear pending login")
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomod.cblgh.org/cerca/constants"
+	"gomod.cblgh.org/cerca/database"
+	"gomod.cblgh.org/cerca/util/eout"
+)
+
+// webhooks are managed by admins at ADMIN_WEBHOOKS_ROUTE. each webhook has a url, the events it wants, and a secret
+// shared with whatever receives it. an event is posted to the url as json:
+//
+//	{"event": "post.added", "time": "2025-01-01T12:00:00Z", "forum": "https://example.org", "data": {...}}
+//
+// with the headers X-Cerca-Event, X-Cerca-Delivery (the delivery's id, which stays the same when a delivery is retried)
+// and X-Cerca-Signature: "sha256=" followed by the hex encoded hmac-sha256 of the body, keyed with the webhook's
+// secret. events in private threads are only sent to the webhooks that have opted into them
+const (
+	WEBHOOK_THREAD_CREATED  = "thread.created"
+	WEBHOOK_POST_ADDED      = "post.added"
+	WEBHOOK_POST_EDITED     = "post.edited"
+	WEBHOOK_POST_DELETED    = "post.deleted"
+	WEBHOOK_USER_REGISTERED = "user.registered"
+	WEBHOOK_MODERATION      = "moderation.action"
+	// sent from the admin page to try a webhook out, whatever events the webhook wants
+	WEBHOOK_PING = "ping"
+)
+
+// the events admins can choose from, in the order they are listed
+var webhookEvents = []string{WEBHOOK_THREAD_CREATED, WEBHOOK_POST_ADDED, WEBHOOK_POST_EDITED, WEBHOOK_POST_DELETED, WEBHOOK_USER_REGISTERED, WEBHOOK_MODERATION}
+
+const WEBHOOK_SIGNATURE_HEADER = "X-Cerca-Signature"
+
+// queued deliveries are attempted every WEBHOOK_INTERVAL, or as soon as something is queued. a delivery that fails is
+// retried after WEBHOOK_RETRY_BASE, and then after twice as long every time it fails again, up to WEBHOOK_RETRY_MAX.
+// after WEBHOOK_MAX_ATTEMPTS attempts, over about 15 hours, it is marked as failed
+const WEBHOOK_INTERVAL = time.Minute
+const WEBHOOK_BATCH_SIZE = 50
+const WEBHOOK_TIMEOUT = 10 * time.Second
+const WEBHOOK_RETRY_BASE = 30 * time.Second
+const WEBHOOK_RETRY_MAX = 6 * time.Hour
+const WEBHOOK_MAX_ATTEMPTS = 12
+
+// how long the delivery log keeps deliveries that were made or given up on, and how many of them the admin page shows
+const WEBHOOK_LOG_RETENTION = 30 * 24 * time.Hour
+const WEBHOOK_LOG_LENGTH = 20
+
+// the names of moderation actions in the payload of WEBHOOK_MODERATION
+var moderationActionNames = map[int]string{
+	constants.MODLOG_RESETPW:                    "reset_password",
+	constants.MODLOG_ADMIN_VETO:                 "veto",
+	constants.MODLOG_ADMIN_MAKE:                 "make_admin",
+	constants.MODLOG_REMOVE_USER:                "remove_user",
+	constants.MODLOG_ADMIN_ADD_USER:             "add_user",
+	constants.MODLOG_ADMIN_DEMOTE:               "demote_admin",
+	constants.MODLOG_ADMIN_CONFIRM:              "confirm",
+	constants.MODLOG_ADMIN_PROPOSE_DEMOTE_ADMIN: "propose_demote_admin",
+	constants.MODLOG_ADMIN_PROPOSE_MAKE_ADMIN:   "propose_make_admin",
+	constants.MODLOG_ADMIN_PROPOSE_REMOVE_USER:  "propose_remove_user",
+	constants.MODLOG_CREATE_INVITE_BATCH:        "create_invites",
+	constants.MODLOG_DELETE_INVITE_BATCH:        "delete_invites",
+	constants.MODLOG_DELETE_THREAD:              "delete_thread",
+	constants.MODLOG_MOVE_THREAD:                "move_thread",
+	constants.MODLOG_HIDE_POST:                  "hide_post",
+	constants.MODLOG_UNHIDE_POST:                "unhide_post",
+	constants.MODLOG_DELETE_POST:                "delete_post",
+	constants.MODLOG_EDIT_POST:                  "edit_post",
+	constants.MODLOG_CLEAR_TWO_FACTOR:           "clear_two_factor",
+	constants.MODLOG_CREATE_CLAIM_LINK:          "create_claim_link",
+}
+
+type webhookPayload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// the forum's url, if it is configured
+	Forum string      `json:"forum,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+type webhookThread struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	TopicID int    `json:"topic_id"`
+	Private bool   `json:"private"`
+	URL     string `json:"url,omitempty"`
+}
+
+type webhookPost struct {
+	database.Post
+	URL string `json:"url,omitempty"`
+}
+
+type webhookUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type webhookPostData struct {
+	Thread webhookThread `json:"thread"`
+	Post   webhookPost   `json:"post"`
+	// who deleted the post, for WEBHOOK_POST_DELETED
+	DeletedBy *webhookUser `json:"deleted_by,omitempty"`
+}
+
+type webhookModerationData struct {
+	Action string      `json:"action"`
+	Admin  webhookUser `json:"admin"`
+	// the user the action was taken on, if any
+	User *webhookUser `json:"user,omitempty"`
+	// the thread or post the action was taken on, if any. a deleted post's subject is the thread it was in
+	SubjectType string `json:"subject_type,omitempty"`
+	SubjectID   int    `json:"subject_id,omitempty"`
+	Note        string `json:"note,omitempty"`
+	// the admin who confirmed or vetoed a proposed action
+	Quorum *webhookQuorum `json:"quorum,omitempty"`
+}
+
+type webhookQuorum struct {
+	Admin     webhookUser `json:"admin"`
+	Confirmed bool        `json:"confirmed"`
+}
+
+// an event waiting to be sent to the webhooks that want it
+type webhookEvent struct {
+	Event string
+	// whether the event happened in a private thread
+	Private bool
+	Data    interface{}
+}
+
+// returns an absolute link to a page on the forum, or "" if the forum's url isn't configured
+func (h RequestHandler) webhookURL(path string) string {
+	if h.config.RSS.URL == "" {
+		return ""
+	}
+	return joinPath(h.config.RSS.URL, path)
+}
+
+func (h RequestHandler) webhookUser(userid int) webhookUser {
+	name, err := h.db.GetUsername(userid)
+	if err != nil {
+		dump(err)
+		return webhookUser{ID: userid}
+	}
+	return webhookUser{ID: userid, Name: name, URL: h.webhookURL(profileURL(name))}
+}
+
+// describes the post for an event. the event has to be sent with sendWebhooks, which, for a deleted post, has to
+// happen after it has been looked up here
+func (h RequestHandler) postEvent(event string, postid int) (webhookEvent, error) {
+	ed := eout.Describe("webhook post event")
+	post, err := h.db.GetPost(postid)
+	if err != nil {
+		return webhookEvent{}, ed.Eout(err, "get post %d", postid)
+	}
+	private, err := h.db.IsThreadPrivate(post.ThreadID)
+	if err != nil {
+		return webhookEvent{}, ed.Eout(err, "get privacy of thread %d", post.ThreadID)
+	}
+	topic, err := h.db.GetThreadTopic(post.ThreadID)
+	if err != nil {
+		return webhookEvent{}, ed.Eout(err, "get topic of thread %d", post.ThreadID)
+	}
+	thread := webhookThread{
+		ID:      post.ThreadID,
+		Title:   post.ThreadTitle,
+		TopicID: topic.ID,
+		Private: private,
+		URL:     h.webhookURL(fmt.Sprintf("/thread/%d/", post.ThreadID)),
+	}
+	data := webhookPostData{Thread: thread, Post: webhookPost{Post: post, URL: h.webhookURL(postURL(post.ThreadID, post.ID))}}
+	return webhookEvent{Event: event, Private: private, Data: data}, nil
+}
+
+// sends an event about a post that still exists to the webhooks
+func (h *RequestHandler) postWebhooks(event string, postid int) {
+	e, err := h.postEvent(event, postid)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	h.sendWebhooks(e)
+}
+
+// describes the deletion of a post by the user, to be sent with sendWebhooks once the post has been deleted
+func (h RequestHandler) postDeletedEvent(postid, userid int) (webhookEvent, error) {
+	e, err := h.postEvent(WEBHOOK_POST_DELETED, postid)
+	if err != nil {
+		return e, err
+	}
+	data := e.Data.(webhookPostData)
+	deletedBy := h.webhookUser(userid)
+	data.DeletedBy = &deletedBy
+	e.Data = data
+	return e, nil
+}
+
+// sends an event about a user to the webhooks
+func (h *RequestHandler) userWebhooks(event string, userid int) {
+	data := struct {
+		User webhookUser `json:"user"`
+	}{h.webhookUser(userid)}
+	h.sendWebhooks(webhookEvent{Event: event, Data: data})
+}
+
+// sends an event about a thread that was just created, with its first post, to the webhooks
+func (h *RequestHandler) threadWebhooks(threadid int) {
+	posts, err := h.db.GetThread(threadid)
+	if err != nil || len(posts) == 0 {
+		log.Println(eout.Eout(err, "thread webhooks: get thread %d", threadid))
+		return
+	}
+	h.postWebhooks(WEBHOOK_THREAD_CREATED, posts[0].ID)
+}
+
+// sends the entry that was just added to the moderation log to the webhooks. set with database.SetModerationHook
+func (h *RequestHandler) moderationWebhooks(entry database.ModerationEvent) {
+	data := webhookModerationData{
+		Action:    moderationActionNames[entry.Action],
+		Admin:     h.webhookUser(entry.ActingID),
+		SubjectID: entry.SubjectID,
+		Note:      entry.Note,
+	}
+	if entry.RecipientID > 0 {
+		recipient := h.webhookUser(entry.RecipientID)
+		data.User = &recipient
+	}
+	if entry.QuorumID > 0 {
+		data.Quorum = &webhookQuorum{Admin: h.webhookUser(entry.QuorumID), Confirmed: entry.QuorumDecision}
+	}
+	if entry.SubjectID > 0 {
+		switch entry.Action {
+		case constants.MODLOG_DELETE_THREAD, constants.MODLOG_MOVE_THREAD, constants.MODLOG_DELETE_POST:
+			data.SubjectType = "thread"
+		case constants.MODLOG_HIDE_POST, constants.MODLOG_UNHIDE_POST, constants.MODLOG_EDIT_POST:
+			data.SubjectType = "post"
+		}
+	}
+	// actions on threads and posts are private if they happened in a private thread
+	private := data.SubjectType != "" && h.modlogSubjectPrivate(entry.Action, entry.SubjectID)
+	h.sendWebhooks(webhookEvent{Event: WEBHOOK_MODERATION, Private: private, Data: data})
+}
+
+// queues the event for delivery to the webhooks that want it
+func (h *RequestHandler) sendWebhooks(e webhookEvent) {
+	webhooks, err := h.db.GetWebhooks()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var ids []int
+	for _, webhook := range webhooks {
+		if webhook.Wants(e.Event) && (webhook.Private || !e.Private) {
+			ids = append(ids, webhook.ID)
+		}
+	}
+	h.queueWebhookEvent(e, ids)
+}
+
+func (h *RequestHandler) queueWebhookEvent(e webhookEvent, webhookids []int) {
+	if len(webhookids) == 0 {
+		return
+	}
+	payload, err := json.Marshal(webhookPayload{Event: e.Event, Time: time.Now().UTC(), Forum: h.config.RSS.URL, Data: e.Data})
+	if err == nil {
+		err = h.db.QueueWebhookDeliveries(e.Event, payload, webhookids)
+	}
+	if err != nil {
+		log.Println(eout.Eout(err, "queue webhook event %s", e.Event))
+		return
+	}
+	select {
+	case h.webhookWake <- struct{}{}:
+	default:
+		// deliverWebhooks has already been woken up
+	}
+}
+
+// the value of the WEBHOOK_SIGNATURE_HEADER of a delivery
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivers the queued webhook events, whenever woken up by queueWebhookEvent and every WEBHOOK_INTERVAL. runs for as
+// long as the forum does
+func (h *RequestHandler) deliverWebhooks() {
+	ed := eout.Describe("deliver webhooks")
+	client := &http.Client{
+		Timeout: WEBHOOK_TIMEOUT,
+		// a redirect counts as a failed delivery, as the webhook's url is the one its secret is shared with
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	ticker := time.NewTicker(WEBHOOK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.webhookWake:
+		case <-ticker.C:
+			if err := h.db.PruneWebhookDeliveries(time.Now().Add(-WEBHOOK_LOG_RETENTION)); err != nil {
+				log.Println(err)
+			}
+		}
+		for {
+			deliveries, err := h.db.GetDueWebhookDeliveries(WEBHOOK_BATCH_SIZE)
+			if err != nil {
+				log.Println(ed.Eout(err, "get due deliveries"))
+				break
+			}
+			for _, delivery := range deliveries {
+				h.deliverWebhook(client, delivery)
+			}
+			if len(deliveries) < WEBHOOK_BATCH_SIZE {
+				break
+			}
+		}
+	}
+}
+
+func (h *RequestHandler) deliverWebhook(client *http.Client, delivery database.WebhookDelivery) {
+	response, err := postWebhook(client, delivery)
+	status := database.WEBHOOK_DELIVERED
+	var next time.Time
+	if err != nil {
+		response = err.Error()
+		status = database.WEBHOOK_FAILED
+		if delivery.Attempts+1 < WEBHOOK_MAX_ATTEMPTS {
+			status = database.WEBHOOK_PENDING
+			wait := WEBHOOK_RETRY_BASE << delivery.Attempts
+			if wait > WEBHOOK_RETRY_MAX || wait <= 0 {
+				wait = WEBHOOK_RETRY_MAX
+			}
+			next = time.Now().Add(wait)
+		}
+	}
+	if err := h.db.RecordWebhookAttempt(delivery.ID, status, next, response); err != nil {
+		log.Println(err)
+	}
+}
+
+// posts the delivery's payload to its webhook, returning the status of the response
+func postWebhook(client *http.Client, delivery database.WebhookDelivery) (string, error) {
+	ed := eout.Describe("post webhook")
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return "", ed.Eout(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cerca webhooks")
+	req.Header.Set("X-Cerca-Event", delivery.Event)
+	req.Header.Set("X-Cerca-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, signWebhookPayload(delivery.Secret, delivery.Payload))
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("answered %s", res.Status)
+	}
+	return res.Status, nil
+}
+
+func generateWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type AdminWebhooksData struct {
+	ErrorMessage string
+	CreateRoute  string
+	UpdateRoute  string
+	SecretRoute  string
+	TestRoute    string
+	DeleteRoute  string
+	// the events that can be chosen
+	Events   []string
+	Webhooks []AdminWebhook
+}
+
+type AdminWebhook struct {
+	database.Webhook
+	Log []database.WebhookDelivery
+}
+
+func (h *RequestHandler) renderAdminWebhooks(res http.ResponseWriter, req *http.Request, errMessage string) {
+	loggedIn, _ := h.IsLoggedIn(req)
+	isAdmin, _ := h.IsAdmin(req)
+	webhooks, err := h.db.GetWebhooks()
+	if err != nil {
+		dump(err)
+		errMessage = "Database had a problem when getting the webhooks"
+	}
+	data := AdminWebhooksData{
+		ErrorMessage: errMessage,
+		CreateRoute:  ADMIN_WEBHOOKS_CREATE_ROUTE,
+		UpdateRoute:  ADMIN_WEBHOOKS_UPDATE_ROUTE,
+		SecretRoute:  ADMIN_WEBHOOKS_SECRET_ROUTE,
+		TestRoute:    ADMIN_WEBHOOKS_TEST_ROUTE,
+		DeleteRoute:  ADMIN_WEBHOOKS_DELETE_ROUTE,
+		Events:       webhookEvents,
+	}
+	for _, webhook := range webhooks {
+		deliveries, err := h.db.GetWebhookDeliveries(webhook.ID, WEBHOOK_LOG_LENGTH)
+		if err != nil {
+			dump(err)
+		}
+		data.Webhooks = append(data.Webhooks, AdminWebhook{Webhook: webhook, Log: deliveries})
+	}
+	view := TemplateData{Title: h.translator.Translate("AdminWebhooks"), Data: &data, HasRSS: h.config.RSS.URL != "", IsAdmin: isAdmin, LoggedIn: loggedIn}
+	h.renderView(res, req, "admin-webhooks", view)
+}
+
+func (h *RequestHandler) AdminWebhooksRoute(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if !isAdmin || req.Method != "GET" {
+		IndexRedirect(res, req)
+		return
+	}
+	h.renderAdminWebhooks(res, req, "")
+}
+
+// reads a webhook's url, events and whether it wants events in private threads from the submitted form
+func parseWebhookForm(req *http.Request) (string, []string, bool, error) {
+	rawURL := strings.TrimSpace(req.PostFormValue("url"))
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", nil, false, fmt.Errorf("%q is not an http or https url", rawURL)
+	}
+	var events []string
+	for _, event := range webhookEvents {
+		if req.PostFormValue("event-"+event) != "" {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return "", nil, false, errors.New("A webhook needs at least one event")
+	}
+	return rawURL, events, req.PostFormValue("private") != "", nil
+}
+
+// returns the webhook whose id was submitted
+func (h *RequestHandler) submittedWebhook(req *http.Request) (database.Webhook, error) {
+	id, err := strconv.Atoi(req.PostFormValue("webhookid"))
+	if err != nil {
+		return database.Webhook{}, errors.New("Invalid webhook id")
+	}
+	webhook, err := h.db.GetWebhook(id)
+	if err != nil {
+		dump(err)
+		return database.Webhook{}, errors.New("The webhook was not found")
+	}
+	return webhook, nil
+}
+
+func (h *RequestHandler) AdminWebhooksCreate(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	webhookURL, events, private, err := parseWebhookForm(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	if _, err = h.db.CreateWebhook(webhookURL, generateWebhookSecret(), events, private); err != nil {
+		dump(err)
+		h.renderAdminWebhooks(res, req, "Database had a problem when creating the webhook")
+		return
+	}
+	http.Redirect(res, req, ADMIN_WEBHOOKS_ROUTE, http.StatusFound)
+}
+
+// changes a webhook's url and the events it is sent
+func (h *RequestHandler) AdminWebhooksUpdate(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	webhook, err := h.submittedWebhook(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	webhookURL, events, private, err := parseWebhookForm(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	if err = h.db.UpdateWebhook(webhook.ID, webhookURL, events, private); err != nil {
+		dump(err)
+		h.renderAdminWebhooks(res, req, "Database had a problem when updating the webhook")
+		return
+	}
+	http.Redirect(res, req, ADMIN_WEBHOOKS_ROUTE, http.StatusFound)
+}
+
+// replaces a webhook's secret with a new one, e.g. when the old one has leaked
+func (h *RequestHandler) AdminWebhooksSecret(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	webhook, err := h.submittedWebhook(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	if err = h.db.UpdateWebhookSecret(webhook.ID, generateWebhookSecret()); err != nil {
+		dump(err)
+		h.renderAdminWebhooks(res, req, "Database had a problem when changing the webhook's secret")
+		return
+	}
+	http.Redirect(res, req, ADMIN_WEBHOOKS_ROUTE, http.StatusFound)
+}
+
+// sends a WEBHOOK_PING event to a webhook
+func (h *RequestHandler) AdminWebhooksTest(res http.ResponseWriter, req *http.Request) {
+	isAdmin, adminUserId := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	webhook, err := h.submittedWebhook(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	data := struct {
+		Admin webhookUser `json:"admin"`
+	}{h.webhookUser(adminUserId)}
+	h.queueWebhookEvent(webhookEvent{Event: WEBHOOK_PING, Data: data}, []int{webhook.ID})
+	http.Redirect(res, req, ADMIN_WEBHOOKS_ROUTE, http.StatusFound)
+}
+
+// deletes a webhook, along with its delivery log and the deliveries still pending
+func (h *RequestHandler) AdminWebhooksDelete(res http.ResponseWriter, req *http.Request) {
+	isAdmin, _ := h.IsAdmin(req)
+	if req.Method == "GET" || !isAdmin {
+		IndexRedirect(res, req)
+		return
+	}
+	webhook, err := h.submittedWebhook(req)
+	if err != nil {
+		h.renderAdminWebhooks(res, req, err.Error())
+		return
+	}
+	if err = h.db.DeleteWebhook(webhook.ID); err != nil {
+		dump(err)
+		h.renderAdminWebhooks(res, req, "Database had a problem when deleting the webhook")
+		return
+	}
+	http.Redirect(res, req, ADMIN_WEBHOOKS_ROUTE, http.StatusFound)
+}
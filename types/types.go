@@ -20,6 +20,140 @@ type Config struct {
 		Description string `json:"feed_description"`
 		URL         string `json:"forum_url"`
 	} `json:"rss"`
+
+	Pagination struct {
+		PostsPerPage   int `json:"posts_per_page"`
+		ThreadsPerPage int `json:"threads_per_page"`
+	} `json:"pagination"`
+
+	Security struct {
+		RequireAdmin2FA bool `json:"require_admin_2fa"`
+		// failed logins or registrations allowed per ip address and per username; the next failure starts a lockout
+		LoginAttempts int `json:"login_attempts"`
+		// one more attempt is allowed every this many minutes
+		LoginAttemptRefillMinutes int `json:"login_attempt_refill_minutes"`
+		// the first lockout lasts this long, and every following one twice as long as the previous, up to the maximum
+		LockoutMinutes    int `json:"lockout_minutes"`
+		MaxLockoutMinutes int `json:"max_lockout_minutes"`
+	} `json:"security"`
+
+	Network struct {
+		// addresses or cidr ranges of reverse proxies, whose client ip header is trusted
+		TrustedProxies []string `json:"trusted_proxies"`
+		// X-Real-Ip, X-Forwarded-For or Forwarded
+		ClientIPHeader string `json:"client_ip_header"`
+	} `json:"network"`
+
+	Notifications struct {
+		// subscribe users to the threads they start, and to the threads they reply to. both default to true
+		SubscribeOwnThreads *bool `json:"subscribe_own_threads"`
+		SubscribeOnReply    *bool `json:"subscribe_on_reply"`
+	} `json:"notifications"`
+
+	// optional; without a transport, no emails are sent
+	Email struct {
+		// smtp or maildir
+		Transport string `json:"transport"`
+		// the sender of every email, e.g. "Merveilles Forum <forum@example.org>"
+		From         string `json:"from"`
+		SMTPHost     string `json:"smtp_host"`
+		SMTPPort     int    `json:"smtp_port"`
+		SMTPUsername string `json:"smtp_username"`
+		SMTPPassword string `json:"smtp_password"`
+		// starttls, tls or none
+		SMTPSecurity string `json:"smtp_security"`
+		// the maildir transport writes each email as a file into this directory, instead of sending it
+		Maildir string `json:"maildir"`
+	} `json:"email"`
+
+	// optional; federates the forum's public threads to the fediverse
+	ActivityPub struct {
+		Enabled bool `json:"enabled"`
+		// lets remote actors live at http:// urls and local addresses, for testing against a stub inbox
+		AllowLocal bool `json:"allow_local"`
+	} `json:"activitypub"`
+}
+
+const DEFAULT_POSTS_PER_PAGE = 50
+const DEFAULT_THREADS_PER_PAGE = 50
+
+// Use the default page sizes for any page size missing from the config.
+func (c *Config) EnsureDefaultPagination() {
+	if c.Pagination.PostsPerPage <= 0 {
+		c.Pagination.PostsPerPage = DEFAULT_POSTS_PER_PAGE
+	}
+	if c.Pagination.ThreadsPerPage <= 0 {
+		c.Pagination.ThreadsPerPage = DEFAULT_THREADS_PER_PAGE
+	}
+}
+
+const DEFAULT_LOGIN_ATTEMPTS = 5
+const DEFAULT_LOGIN_ATTEMPT_REFILL_MINUTES = 15
+const DEFAULT_LOCKOUT_MINUTES = 5
+const DEFAULT_MAX_LOCKOUT_MINUTES = 24 * 60
+
+// Use the default brute-force protection limits for any limit missing from the config.
+func (c *Config) EnsureDefaultSecurity() {
+	if c.Security.LoginAttempts <= 0 {
+		c.Security.LoginAttempts = DEFAULT_LOGIN_ATTEMPTS
+	}
+	if c.Security.LoginAttemptRefillMinutes <= 0 {
+		c.Security.LoginAttemptRefillMinutes = DEFAULT_LOGIN_ATTEMPT_REFILL_MINUTES
+	}
+	if c.Security.LockoutMinutes <= 0 {
+		c.Security.LockoutMinutes = DEFAULT_LOCKOUT_MINUTES
+	}
+	if c.Security.MaxLockoutMinutes < c.Security.LockoutMinutes {
+		c.Security.MaxLockoutMinutes = DEFAULT_MAX_LOCKOUT_MINUTES
+	}
+}
+
+// by default, a reverse proxy on the same machine is trusted to set X-Real-Ip, as in contrib/nginx.conf
+var DEFAULT_TRUSTED_PROXIES = []string{"127.0.0.1", "::1"}
+
+const DEFAULT_CLIENT_IP_HEADER = "X-Real-Ip"
+
+// Use the default reverse proxy settings for any setting missing from the config. An empty list of trusted proxies is
+// kept, as it means trusting no proxy.
+func (c *Config) EnsureDefaultNetwork() {
+	if c.Network.TrustedProxies == nil {
+		c.Network.TrustedProxies = DEFAULT_TRUSTED_PROXIES
+	}
+	if c.Network.ClientIPHeader == "" {
+		c.Network.ClientIPHeader = DEFAULT_CLIENT_IP_HEADER
+	}
+}
+
+// Subscribe users to the threads they start and reply to, unless the config says otherwise.
+func (c *Config) EnsureDefaultNotifications() {
+	if c.Notifications.SubscribeOwnThreads == nil {
+		subscribe := true
+		c.Notifications.SubscribeOwnThreads = &subscribe
+	}
+	if c.Notifications.SubscribeOnReply == nil {
+		subscribe := true
+		c.Notifications.SubscribeOnReply = &subscribe
+	}
+}
+
+const DEFAULT_SMTP_SECURITY = "starttls"
+
+// Use the default email settings for any setting missing from the config. The defaults don't turn email on.
+func (c *Config) EnsureDefaultEmail() {
+	if c.Email.SMTPSecurity == "" {
+		c.Email.SMTPSecurity = DEFAULT_SMTP_SECURITY
+	}
+	if c.Email.SMTPPort <= 0 {
+		// the ports for submission, with and without implicit tls
+		if c.Email.SMTPSecurity == "tls" {
+			c.Email.SMTPPort = 465
+		} else {
+			c.Email.SMTPPort = 587
+		}
+	}
+	if c.Email.Maildir == "" {
+		c.Email.Maildir = filepath.Join(c.General.DataDir, "maildir")
+	}
 }
 
 // Ensure that, at the very least, default paths exist for each expected document path.
@@ -48,4 +182,37 @@ feed_name = "Merveilles Forum"
 feed_description = "marvellous happenings and introspective wanderings"
 forum_url = "https://forum.merveilles.town"
 
+[pagination]
+posts_per_page = 50
+threads_per_page = 50
+
+[security]
+require_admin_2fa = false
+login_attempts = 5
+login_attempt_refill_minutes = 15
+lockout_minutes = 5
+max_lockout_minutes = 1440
+
+[network]
+trusted_proxies = ["127.0.0.1", "::1"]
+client_ip_header = "X-Real-Ip"
+
+[notifications]
+subscribe_own_threads = true
+subscribe_on_reply = true
+
+[email]
+transport = "smtp"
+from = "Merveilles Forum <forum@example.org>"
+smtp_host = "smtp.example.org"
+smtp_port = 587
+smtp_username = "forum@example.org"
+smtp_password = "hunter2"
+smtp_security = "starttls"
+maildir = "/var/lib/cerca/maildir"
+
+[activitypub]
+enabled = true
+allow_local = false
+
 */
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// @name, where the name runs until whitespace or punctuation that rarely ends up in usernames. an @ directly after a
+// letter or digit, as in an email address, is not a mention
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_@])@([^\s@<>()\[\]{},;:!?"'` + "`" + `*]+)`)
+
+// a sentence ending right after a mention shouldn't make the full stop part of the name
+func trimMention(name string) string {
+	return strings.TrimRight(name, ".")
+}
+
+// returns the positions of the names mentioned in text, as [start, end) pairs that exclude the @
+func findMentions(text string) [][2]int {
+	var positions [][2]int
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[4], match[5]
+		end = start + len(trimMention(text[start:end]))
+		if end > start {
+			positions = append(positions, [2]int{start, end})
+		}
+	}
+	return positions
+}
+
+// joins adjacent text nodes, which the parser splits at characters such as _ that might have started emphasis, so that
+// mentions of names containing them are found
+func mergeTextNodes(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		if node.AsContainer() == nil {
+			return ast.GoToNext
+		}
+		var merged []ast.Node
+		for _, child := range node.GetChildren() {
+			text, isText := child.(*ast.Text)
+			if isText && len(merged) > 0 {
+				if previous, ok := merged[len(merged)-1].(*ast.Text); ok {
+					previous.Literal = append(previous.Literal, text.Literal...)
+					continue
+				}
+			}
+			merged = append(merged, child)
+		}
+		node.SetChildren(merged)
+		return ast.GoToNext
+	})
+}
+
+// calls fn for every text node that isn't part of a link. code spans and blocks are not text nodes, so mentions in code
+// are ignored too
+func walkMentionableText(doc ast.Node, fn func(text *ast.Text)) {
+	var texts []*ast.Text
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if _, isLink := node.(*ast.Link); isLink {
+			return ast.SkipChildren
+		}
+		if text, ok := node.(*ast.Text); ok && entering {
+			texts = append(texts, text)
+		}
+		return ast.GoToNext
+	})
+	// fn may change the tree, so it's only called once the walk is done
+	for _, text := range texts {
+		fn(text)
+	}
+}
+
+func parseMarkdown(md string) ast.Node {
+	mdBytes := markdown.NormalizeNewlines([]byte(md))
+	mdParser := parser.NewWithExtensions(parser.CommonExtensions ^ parser.MathJax)
+	doc := mdParser.Parse(mdBytes)
+	mergeTextNodes(doc)
+	return doc
+}
+
+// returns the names mentioned in a post's markdown, each name once
+func ParseMentions(md string) []string {
+	var names []string
+	walkMentionableText(parseMarkdown(md), func(text *ast.Text) {
+		literal := string(text.Literal)
+		for _, position := range findMentions(literal) {
+			if name := literal[position[0]:position[1]]; !Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	})
+	return names
+}
+
+// turns mentions into links to the profiles of the users they mention. mentions maps the names mentioned to the current
+// names of the users; mentions of anyone else are left as plain text
+func linkMentions(doc ast.Node, mentions map[string]string) {
+	walkMentionableText(doc, func(text *ast.Text) {
+		literal := string(text.Literal)
+		var nodes []ast.Node
+		last := 0
+		for _, position := range findMentions(literal) {
+			name, ok := mentions[literal[position[0]:position[1]]]
+			if !ok {
+				continue
+			}
+			// the text before the mention, up to and excluding its @
+			nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: []byte(literal[last : position[0]-1])}})
+			link := &ast.Link{Destination: []byte("/user/" + url.PathEscape(name))}
+			ast.AppendChild(link, &ast.Text{Leaf: ast.Leaf{Literal: []byte("@" + name)}})
+			nodes = append(nodes, link)
+			last = position[1]
+		}
+		if len(nodes) == 0 {
+			return
+		}
+		nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: []byte(literal[last:])}})
+		parent := text.GetParent()
+		var children []ast.Node
+		for _, child := range parent.GetChildren() {
+			if child != ast.Node(text) {
+				children = append(children, child)
+				continue
+			}
+			for _, node := range nodes {
+				node.SetParent(parent)
+				children = append(children, node)
+			}
+		}
+		parent.SetChildren(children)
+	})
+}
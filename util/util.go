@@ -2,12 +2,14 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,7 +22,6 @@ import (
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
-	"github.com/gomarkdown/markdown/parser"
 	"github.com/google/uuid"
 	"github.com/komkom/toml"
 	"github.com/microcosm-cc/bluemonday"
@@ -61,12 +62,16 @@ func modifyAst(doc ast.Node) ast.Node {
 
 // Turns Markdown input into HTML
 func Markup(md string) template.HTML {
-	mdBytes := []byte(string(md))
-	// fix newlines
-	mdBytes = markdown.NormalizeNewlines(mdBytes)
-	mdParser := parser.NewWithExtensions(parser.CommonExtensions ^ parser.MathJax)
-	astDoc := mdParser.Parse(mdBytes)
+	return MarkupMentions(md, nil)
+}
+
+// Turns Markdown input into HTML, linking the mentions of users to their profiles. mentions maps the names mentioned to
+// the current names of the users, see database.Post.Mentions
+func MarkupMentions(md string, mentions map[string]string) template.HTML {
+	// parseMarkdown also normalizes newlines
+	astDoc := parseMarkdown(md)
 	modifyAst(astDoc)
+	linkMentions(astDoc, mentions)
 	renderer := html.NewRenderer(html.RendererOptions{Flags: html.CommonFlags})
 	maybeUnsafeHTML := markdown.Render(astDoc, renderer)
 	// guard against malicious code being embedded
@@ -115,6 +120,32 @@ func SanitizeURL(input string) string {
 	return strings.ToLower(output)
 }
 
+type clientIPKey struct{}
+
+// returns a shallow copy of req whose client ip address, as returned by ClientIP, is ip. the server resolves each
+// request's client ip once, taking trusted reverse proxies into account
+func WithClientIP(req *http.Request, ip string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientIPKey{}, ip))
+}
+
+// returns the ip address of the client making the request, as resolved by the server
+func ClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return PeerIP(req)
+}
+
+// returns the address of whoever connected to the server, without the port. for a unix socket there is no address and
+// the result is not an ip
+func PeerIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
 // returns an id from a url path, and a boolean. the boolean is true if we're returning what we expect; false if the
 // operation failed
 func GetURLPortion(req *http.Request, index int) (int, bool) {